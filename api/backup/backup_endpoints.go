@@ -1,14 +1,19 @@
 package backup
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"fp-dynamic-elements-manager-controller/api/util"
 	"fp-dynamic-elements-manager-controller/internal/backup"
 	"fp-dynamic-elements-manager-controller/internal/backup/structs"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
 	notificationfuncs "fp-dynamic-elements-manager-controller/internal/notification"
-	"github.com/rs/zerolog/log"
+	"github.com/gorilla/mux"
 	"github.com/spf13/viper"
 	"net/http"
+	"strconv"
 )
 
 // Handler handles requests to the backup and restore provider, it accepts PostedCommand's
@@ -21,14 +26,29 @@ func Handler(provider backup.Provider, ns notificationfuncs.Service) http.Handle
 			w.Header().Set("Access-Control-Allow-Methods", "OPTIONS,GET,POST,PUT")
 			w.WriteHeader(http.StatusNoContent)
 		case http.MethodGet:
+			limit := 10
+			if value := r.URL.Query().Get("limit"); value != "" {
+				var err error
+				limit, err = strconv.Atoi(value)
+				if err != nil || limit < 1 || limit > 500 {
+					util.ReturnHTTPStatus(w, http.StatusBadRequest, "limit must be a number from 1 to 500")
+					return
+				}
+			}
 			history, err := provider.List()
 			if err != nil {
-				log.Error().Err(err).Msg("error retrieving git history")
+				applog.System().WithContext(r.Context()).Error(err, "error retrieving git history")
 				util.ReturnHTTPStatus(w, http.StatusInternalServerError, "error retrieving git history")
 				return
 			}
-			if len(history) > 10 {
-				history = history[:10]
+			if len(history) > limit {
+				history = history[:limit]
+			}
+			storage, err := provider.Storage()
+			if err != nil {
+				applog.System().WithContext(r.Context()).Error(err, "error measuring backup storage")
+				util.ReturnHTTPStatus(w, http.StatusInternalServerError, "error measuring backup storage")
+				return
 			}
 			sch := backup.Schedule{
 				DayOfWeek: viper.GetString("dayofweek"),
@@ -37,6 +57,7 @@ func Handler(provider backup.Provider, ns notificationfuncs.Service) http.Handle
 			s := DetailResponse{
 				History:  history,
 				Schedule: sch,
+				Storage:  storage,
 			}
 			json.NewEncoder(w).Encode(s)
 		case http.MethodPost:
@@ -46,7 +67,7 @@ func Handler(provider backup.Provider, ns notificationfuncs.Service) http.Handle
 			sched := backup.Schedule{}
 			err := json.NewDecoder(r.Body).Decode(&sched)
 			if err != nil {
-				log.Error().Err(err).Msg("error decoding schedule wrapper")
+				applog.System().WithContext(r.Context()).Error(err, "error decoding schedule wrapper")
 				util.ReturnHTTPStatus(w, http.StatusNotAcceptable, "could not decode json into entity")
 				return
 			}
@@ -61,29 +82,97 @@ func handlePOST(r *http.Request, w http.ResponseWriter, provider backup.Provider
 	item := PostedCommand{}
 	err := json.NewDecoder(r.Body).Decode(&item)
 	if err != nil {
-		log.Error().Err(err).Msg("error decoding details wrapper")
+		applog.System().WithContext(r.Context()).Error(err, "error decoding details wrapper")
 		util.ReturnHTTPStatus(w, http.StatusNotAcceptable, "could not decode json into entity")
 		return
 	}
 	switch item.Cmd {
 	case backup.Backup:
 		if err := provider.Backup("Manual"); err != nil {
-			sendStatus(ns, notificationfuncs.Error, "Error running backup")
+			sendStatus(r.Context(), ns, notificationfuncs.Error, "Error running backup")
 			return
 		}
-		sendStatus(ns, notificationfuncs.Success, "Backed up successfully")
+		sendStatus(r.Context(), ns, notificationfuncs.Success, "Backed up successfully")
 	case backup.Restore:
 		if err := provider.Restore(item.Hash); err != nil {
-			sendStatus(ns, notificationfuncs.Error, "Error running restore")
+			sendStatus(r.Context(), ns, notificationfuncs.Error, "Error running restore")
 			return
 		}
-		sendStatus(ns, notificationfuncs.Success, "Restored successfully")
+		sendStatus(r.Context(), ns, notificationfuncs.Success, "Restored successfully")
 	default:
 		util.ReturnHTTPStatus(w, http.StatusBadRequest, "command not recognised")
 		return
 	}
 }
 
+// TargetsHandler returns the status of each off-site backup target
+func TargetsHandler(provider backup.Provider) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodOptions:
+			w.Header().Set("Access-Control-Allow-Methods", "OPTIONS,GET")
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(provider.Targets())
+		}
+		return
+	})
+}
+
+// TargetHandler lists the backups held by an off-site target, and accepts PostedCommand's which copy the newest
+// backup to the target (backup) or restore the backup with the ID in hash from the target (restore)
+func TargetHandler(provider backup.Provider, ns notificationfuncs.Service) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		switch r.Method {
+		case http.MethodOptions:
+			w.Header().Set("Access-Control-Allow-Methods", "OPTIONS,GET,POST")
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			backups, err := provider.ListTarget(r.Context(), name)
+			if errors.Is(err, backup.ErrTargetNotFound) {
+				util.ReturnHTTPStatus(w, http.StatusNotFound, err.Error())
+				return
+			}
+			if err != nil {
+				applog.System().WithContext(r.Context()).Error(err, fmt.Sprintf("error listing the backups held by target %s", name))
+				util.ReturnHTTPStatus(w, http.StatusBadGateway, fmt.Sprintf("could not list the backups held by %s", name))
+				return
+			}
+			json.NewEncoder(w).Encode(backups)
+		case http.MethodPost:
+			item := PostedCommand{}
+			if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+				util.ReturnHTTPStatus(w, http.StatusNotAcceptable, "could not decode json into entity")
+				return
+			}
+			var err error
+			switch item.Cmd {
+			case backup.Backup:
+				err = provider.SyncTarget(r.Context(), name)
+			case backup.Restore:
+				err = provider.RestoreFromTarget(r.Context(), name, item.Hash)
+			default:
+				util.ReturnHTTPStatus(w, http.StatusBadRequest, "command not recognised")
+				return
+			}
+			switch {
+			case err == nil:
+				sendStatus(r.Context(), ns, notificationfuncs.Success, fmt.Sprintf("Command %s on %s executed successfully", item.Cmd, name))
+				util.ReturnHTTPStatus(w, http.StatusOK, "command executed successfully")
+			case errors.Is(err, backup.ErrTargetNotFound):
+				util.ReturnHTTPStatus(w, http.StatusNotFound, err.Error())
+			case errors.Is(err, backup.ErrNoManifest):
+				util.ReturnHTTPStatus(w, http.StatusConflict, err.Error())
+			default:
+				sendStatus(r.Context(), ns, notificationfuncs.Error, fmt.Sprintf("Error running %s on %s", item.Cmd, name))
+				util.ReturnHTTPStatus(w, http.StatusBadGateway, err.Error())
+			}
+		}
+		return
+	})
+}
+
 type PostedCommand struct {
 	Cmd  backup.Command `json:"cmd"`
 	Hash string         `json:"hash"`
@@ -92,11 +181,12 @@ type PostedCommand struct {
 type DetailResponse struct {
 	History  []structs.History `json:"history"`
 	Schedule backup.Schedule   `json:"schedule"`
+	Storage  structs.Storage   `json:"storage"`
 }
 
-func sendStatus(ns notificationfuncs.Service, status notificationfuncs.EventType, msg string) {
+func sendStatus(ctx context.Context, ns notificationfuncs.Service, status notificationfuncs.EventType, msg string) {
 	ns.Send(notificationfuncs.Event{
 		EventType: status,
 		Value:     msg,
-	})
+	}.WithContext(ctx))
 }
@@ -6,8 +6,8 @@ import (
 	"fp-dynamic-elements-manager-controller/api/util"
 	"fp-dynamic-elements-manager-controller/internal/batch"
 	"fp-dynamic-elements-manager-controller/internal/db/persistence"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
 	"fp-dynamic-elements-manager-controller/internal/queue/structs"
-	"github.com/rs/zerolog/log"
 	"net/http"
 	"strconv"
 	"strings"
@@ -33,7 +33,7 @@ func Handler(repo *persistence.UpdateStatusRepo) http.Handler {
 			}
 			page, err := strconv.Atoi(pageString)
 			if err != nil {
-				log.Error().Err(err).Msg("error parsing string to int")
+				applog.System().WithContext(r.Context()).Error(err, "error parsing string to int")
 				util.ReturnHTTPStatus(w, http.StatusInternalServerError, "could not parse page number")
 				return
 			}
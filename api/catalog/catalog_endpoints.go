@@ -0,0 +1,29 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fp-dynamic-elements-manager-controller/api/util"
+	"fp-dynamic-elements-manager-controller/internal/catalog"
+	"net/http"
+)
+
+// Handler returns the modules available in the registry with their versions.
+// It takes 1 query parameter, Refresh (read the registry now rather than serving the cached catalog)
+func Handler(c *catalog.Catalog) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodOptions:
+			w.Header().Set("Access-Control-Allow-Methods", "OPTIONS,GET")
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			result, err := c.Get(r.Context(), r.URL.Query().Get("refresh") == "true")
+			if err != nil {
+				util.ReturnHTTPStatus(w, http.StatusBadGateway, "could not read the module catalog from the registry")
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(result)
+		}
+		return
+	})
+}
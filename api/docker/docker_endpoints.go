@@ -2,16 +2,19 @@ package docker
 
 import (
 	"encoding/json"
+	"errors"
 	"fp-dynamic-elements-manager-controller/api/util"
 	"fp-dynamic-elements-manager-controller/internal/docker"
 	"fp-dynamic-elements-manager-controller/internal/docker/structs"
-	notifications "fp-dynamic-elements-manager-controller/internal/notification"
-	"github.com/rs/zerolog/log"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
+	"github.com/docker/docker/client"
+	"github.com/gorilla/mux"
 	"net/http"
+	"strconv"
 	"time"
 )
 
-func Handler(handler *docker.CommandHandler, ns notifications.Service) http.Handler {
+func Handler(handler *docker.CommandHandler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodOptions:
@@ -24,38 +27,182 @@ func Handler(handler *docker.CommandHandler, ns notifications.Service) http.Hand
 			item := structs.ContainerDetailsWrapper{}
 			err := json.NewDecoder(r.Body).Decode(&item)
 			if err != nil {
-				log.Error().Err(err).Msg("error decoding details wrapper")
+				applog.System().WithContext(r.Context()).Error(err, "error decoding details wrapper")
 				util.ReturnHTTPStatus(w, http.StatusNotAcceptable, "could not decode json into entity")
 				return
 			}
-			go captureResultAsync(item, handler, ns)
-			util.ReturnHTTPStatus(w, http.StatusOK, "commands added to queue")
+			job, err := handler.Submit(r.Context(), item)
+			if err != nil {
+				applog.System().WithContext(r.Context()).Error(err, "error submitting docker job")
+				util.ReturnHTTPStatus(w, http.StatusInternalServerError, "could not queue commands")
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(job)
+		}
+		return
+	})
+}
+
+// JobHandler returns a docker job and the status of each of its steps
+func JobHandler(handler *docker.CommandHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodOptions:
+			w.Header().Set("Access-Control-Allow-Methods", "OPTIONS,GET")
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			job, err := handler.Job(mux.Vars(r)["id"])
+			if err == docker.ErrJobNotFound {
+				util.ReturnHTTPStatus(w, http.StatusNotFound, "job not found")
+				return
+			}
+			if err != nil {
+				util.ReturnHTTPStatus(w, http.StatusInternalServerError, "could not get job")
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(job)
+		}
+		return
+	})
+}
+
+// CancelJobHandler cancels a queued or running docker job, a running job stops once its current step has finished
+func CancelJobHandler(handler *docker.CommandHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodOptions:
+			w.Header().Set("Access-Control-Allow-Methods", "OPTIONS,POST")
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodPost:
+			job, err := handler.Cancel(mux.Vars(r)["id"])
+			switch err {
+			case nil:
+			case docker.ErrJobNotFound:
+				util.ReturnHTTPStatus(w, http.StatusNotFound, "job not found")
+				return
+			case docker.ErrJobFinished:
+				util.ReturnHTTPStatus(w, http.StatusConflict, "job has already finished")
+				return
+			default:
+				util.ReturnHTTPStatus(w, http.StatusInternalServerError, "could not cancel job")
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(job)
+		}
+		return
+	})
+}
+
+// InspectHandler returns the detail of a container, with the secrets in its environment redacted
+func InspectHandler(handler *docker.CommandHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodOptions:
+			w.Header().Set("Access-Control-Allow-Methods", "OPTIONS,GET")
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			inspect, err := handler.Inspect(r.Context(), mux.Vars(r)["container"])
+			if client.IsErrContainerNotFound(err) {
+				util.ReturnHTTPStatus(w, http.StatusNotFound, "container not found")
+				return
+			}
+			if err != nil {
+				util.ReturnHTTPStatus(w, http.StatusInternalServerError, "could not inspect container")
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(inspect)
 		}
 		return
 	})
 }
 
-func captureResultAsync(item structs.ContainerDetailsWrapper, handler *docker.CommandHandler, ns notifications.Service) {
-	doneCh, evtCh, errCh := handler.RunCommands(item)
-	for {
-		select {
-		case err := <-errCh:
+// LogsHandler streams the logs of a container as JSON lines, one per line written by the container.
+// It takes 4 query parameters, Follow (keep streaming new lines), Since and Until (an RFC 3339 time, a unix timestamp
+// or a duration before now such as 10m), and Tail (the number of lines from the end of the logs, or all).
+func LogsHandler(handler *docker.CommandHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodOptions:
+			w.Header().Set("Access-Control-Allow-Methods", "OPTIONS,GET")
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			container := mux.Vars(r)["container"]
+			opts, err := logOptions(r)
 			if err != nil {
-				log.Error().Err(err)
-				ns.Send(notifications.Event{
-					EventType: notifications.Error,
-					Value:     err.Error(),
-				})
-			}
-		case evt := <-evtCh:
-			// send done over socket with a slight delay for modules which are slow to startup
-			if evt.Context.State == notifications.Started || evt.Context.State == notifications.Created {
-				time.Sleep(1 * time.Second)
-			}
-			ns.Send(evt)
-		case <-doneCh:
-			log.Info().Msg("commands completed successfully")
-			return
+				util.ReturnHTTPStatus(w, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			// Inspect first so an unknown container is reported before the stream starts
+			if _, err := handler.Inspect(r.Context(), container); err != nil {
+				if client.IsErrContainerNotFound(err) {
+					util.ReturnHTTPStatus(w, http.StatusNotFound, "container not found")
+					return
+				}
+				util.ReturnHTTPStatus(w, http.StatusInternalServerError, "could not read container logs")
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			flusher, _ := w.(http.Flusher)
+			if flusher != nil {
+				flusher.Flush()
+			}
+
+			enc := json.NewEncoder(w)
+			err = handler.Logs(r.Context(), container, opts, func(line structs.LogLine) error {
+				if err := enc.Encode(line); err != nil {
+					return err
+				}
+				if flusher != nil {
+					flusher.Flush()
+				}
+				return nil
+			})
+			if err != nil {
+				applog.System().WithContext(r.Context()).WithFields(applog.Fields{"container": container}).Error(err, "error streaming container logs")
+			}
+		}
+		return
+	})
+}
+
+func logOptions(r *http.Request) (structs.LogOptions, error) {
+	query := r.URL.Query()
+	opts := structs.LogOptions{Tail: "100"}
+	now := time.Now()
+
+	if follow := query.Get("follow"); follow != "" {
+		f, err := strconv.ParseBool(follow)
+		if err != nil {
+			return opts, errors.New("follow must be true or false")
+		}
+		opts.Follow = f
+	}
+	if since := query.Get("since"); since != "" {
+		t, err := docker.ParseLogTime(since, now)
+		if err != nil {
+			return opts, errors.New("could not parse since")
+		}
+		opts.Since = t
+	}
+	if until := query.Get("until"); until != "" {
+		t, err := docker.ParseLogTime(until, now)
+		if err != nil {
+			return opts, errors.New("could not parse until")
+		}
+		opts.Until = t
+	}
+	if tail := query.Get("tail"); tail != "" {
+		if n, err := strconv.Atoi(tail); tail != "all" && (err != nil || n < 0) {
+			return opts, errors.New("tail must be a number or all")
 		}
+		opts.Tail = tail
 	}
+	return opts, nil
 }
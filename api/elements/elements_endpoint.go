@@ -5,11 +5,11 @@ import (
 	"fp-dynamic-elements-manager-controller/api/util"
 	"fp-dynamic-elements-manager-controller/internal/db/persistence"
 	"fp-dynamic-elements-manager-controller/internal/export"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
 	"fp-dynamic-elements-manager-controller/internal/logging/structs"
 	notificationfuncs "fp-dynamic-elements-manager-controller/internal/notification"
 	"fp-dynamic-elements-manager-controller/internal/queue"
 	structs2 "fp-dynamic-elements-manager-controller/internal/queue/structs"
-	"github.com/rs/zerolog/log"
 	"net/http"
 	"strconv"
 )
@@ -41,7 +41,7 @@ func Handler(pusher queue.Pusher, dao *persistence.DataAccessObject, logger *str
 			}
 			page, err := strconv.Atoi(pageParam)
 			if err != nil {
-				log.Error().Err(err).Msg("error parsing string to int")
+				applog.System().WithContext(r.Context()).Error(err, "error parsing string to int")
 				util.ReturnHTTPStatus(w, http.StatusInternalServerError, "could not parse page value")
 				return
 			}
@@ -54,11 +54,11 @@ func Handler(pusher queue.Pusher, dao *persistence.DataAccessObject, logger *str
 			item := structs2.ListElement{}
 			err := json.NewDecoder(r.Body).Decode(&item)
 			if err != nil {
-				log.Error().Err(err).Msg("error decoding json entity")
+				applog.System().WithContext(r.Context()).Error(err, "error decoding json entity")
 				util.ReturnHTTPStatus(w, http.StatusNotAcceptable, "could not decode json into entity")
 				return
 			}
-			err = queue.AddOne([]structs2.ListElement{item}, pusher, dao, logger)
+			err = queue.AddOne(r.Context(), []structs2.ListElement{item}, pusher, dao, logger)
 			if err == persistence.ErrDuplicateValue {
 				util.ReturnHTTPStatus(w, http.StatusConflict, "duplicate value")
 				return
@@ -72,7 +72,7 @@ func Handler(pusher queue.Pusher, dao *persistence.DataAccessObject, logger *str
 			item := structs2.ListElement{}
 			err := json.NewDecoder(r.Body).Decode(&item)
 			if err != nil {
-				log.Error().Err(err).Msg("error decoding json entity")
+				applog.System().WithContext(r.Context()).Error(err, "error decoding json entity")
 				util.ReturnHTTPStatus(w, http.StatusNotAcceptable, "could not decode json into entity")
 				return
 			}
@@ -81,7 +81,7 @@ func Handler(pusher queue.Pusher, dao *persistence.DataAccessObject, logger *str
 				logger.NotificationService.Send(notificationfuncs.Event{
 					EventType: notificationfuncs.Error,
 					Value:     "Cannot update, duplicate value",
-				})
+				}.WithContext(r.Context()))
 				util.ReturnHTTPStatus(w, http.StatusConflict, "duplicate value")
 				return
 			}
@@ -94,11 +94,11 @@ func Handler(pusher queue.Pusher, dao *persistence.DataAccessObject, logger *str
 			item := structs2.ListElement{}
 			err := json.NewDecoder(r.Body).Decode(&item)
 			if err != nil {
-				log.Error().Err(err).Msg("error decoding json entity")
+				applog.System().WithContext(r.Context()).Error(err, "error decoding json entity")
 				util.ReturnHTTPStatus(w, http.StatusNotAcceptable, "could not decode json into entity")
 				return
 			}
-			queue.Delete(item, pusher, dao)
+			queue.Delete(r.Context(), item, pusher, dao)
 			util.ReturnHTTPStatus(w, http.StatusOK, "success")
 		}
 		return
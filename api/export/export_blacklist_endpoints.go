@@ -5,7 +5,7 @@ import (
 	"fp-dynamic-elements-manager-controller/api/util"
 	"fp-dynamic-elements-manager-controller/internal/db/persistence"
 	"fp-dynamic-elements-manager-controller/internal/export/structs"
-	"github.com/rs/zerolog/log"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
 	"net/http"
 )
 
@@ -20,7 +20,7 @@ func Handler(repo *persistence.ListElementRepo) http.Handler {
 		case http.MethodGet:
 			res, err := repo.GetAll()
 			if err != nil {
-				log.Error().Err(err).Msg("error retrieving list elements")
+				applog.System().WithContext(r.Context()).Error(err, "error retrieving list elements")
 				util.ReturnHTTPStatus(w, http.StatusInternalServerError, "could not return requested resource")
 				return
 			}
@@ -44,7 +44,7 @@ func LookupHandler(repo *persistence.ListElementRepo) http.Handler {
 			}
 			res, err := repo.GetAllEquals(searchTerm)
 			if err != nil {
-				log.Error().Err(err).Msg("error retrieving list elements")
+				applog.System().WithContext(r.Context()).Error(err, "error retrieving list elements")
 				util.ReturnHTTPStatus(w, http.StatusInternalServerError, "could not return requested resource")
 				return
 			}
@@ -5,8 +5,8 @@ import (
 	"fp-dynamic-elements-manager-controller/api/util"
 	"fp-dynamic-elements-manager-controller/internal/db/persistence"
 	"fp-dynamic-elements-manager-controller/internal/logging"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
 	"fp-dynamic-elements-manager-controller/internal/logging/structs"
-	"github.com/rs/zerolog/log"
 	"net/http"
 	"strconv"
 )
@@ -15,8 +15,10 @@ import (
 var pageSize = 10
 
 // Handler returns the logs from the database based on a pagination system.
-// It takes 3 query parameters, Page (the offset used to query the database), Level (the log level filter), and Module Name.
-func Handler(repo *persistence.LogEntryRepo) http.Handler {
+// It takes 4 query parameters, Page (the offset used to query the database), Level (the log level filter), Module Name,
+// and Request ID which returns the entries logged while handling a single request.
+// Log events posted by modules are queued on the writer to be written to the database.
+func Handler(repo *persistence.LogEntryRepo, writer *logging.LogWriter) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodOptions:
@@ -26,27 +28,31 @@ func Handler(repo *persistence.LogEntryRepo) http.Handler {
 			param1 := r.URL.Query().Get("page")
 			logLevel := r.URL.Query().Get("level")
 			moduleName := r.URL.Query().Get("modulename")
+			requestID := r.URL.Query().Get("requestid")
 			if param1 == "" {
 				util.ReturnHTTPStatus(w, http.StatusBadRequest, "page number not specified")
 				return
 			}
 			page, err := strconv.Atoi(param1)
 			if err != nil {
-				log.Error().Err(err).Msg("error parsing string to int")
+				applog.System().WithContext(r.Context()).Error(err, "error parsing string to int")
 				util.ReturnHTTPStatus(w, http.StatusInternalServerError, "could not parse page number")
 				return
 			}
 			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(logging.BuildLogResults(page, pageSize, moduleName, logLevel, repo))
+			json.NewEncoder(w).Encode(logging.BuildLogResults(page, pageSize, moduleName, requestID, logLevel, repo))
 		case http.MethodPost:
 			item := structs.LogEntry{}
 			err := json.NewDecoder(r.Body).Decode(&item)
 			if err != nil {
-				log.Error().Err(err).Msg("error decoding json into entity")
+				applog.System().WithContext(r.Context()).Error(err, "error decoding json into entity")
 				util.ReturnHTTPStatus(w, http.StatusNotAcceptable, "could not decode json into entity")
 				return
 			}
-			go repo.InsertLogEntry(&item)
+			if !writer.Write(item) {
+				util.ReturnHTTPStatus(w, http.StatusServiceUnavailable, "log buffer full")
+				return
+			}
 		}
 		return
 	})
@@ -0,0 +1,123 @@
+package logging
+
+import (
+	"fp-dynamic-elements-manager-controller/api/util"
+	"fp-dynamic-elements-manager-controller/internal/logging"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
+	"fp-dynamic-elements-manager-controller/internal/logging/structs"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultBackfill = 100
+	maxBackfill     = 1000
+
+	writeWait  = 10 * time.Second
+	pongWait   = 60 * time.Second
+	pingPeriod = (pongWait * 9) / 10
+)
+
+// TailHandler upgrades the connection to a WebSocket and streams log entries to it as they are logged.
+// It takes 4 query parameters, Module Name, Level (the minimum level), Text (matched against the message) and
+// Backfill (the number of recent entries sent on connect). The client can send a new filter as JSON at any time.
+func TailHandler(upgrader websocket.Upgrader, tail *logging.Tail) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter := logging.TailFilter{
+			ModuleName: r.URL.Query().Get("modulename"),
+			Level:      r.URL.Query().Get("level"),
+			Text:       r.URL.Query().Get("text"),
+		}
+		if _, err := logrus.ParseLevel(filter.Level); filter.Level != "" && err != nil {
+			util.ReturnHTTPStatus(w, http.StatusBadRequest, "unknown log level")
+			return
+		}
+
+		backfill := defaultBackfill
+		if param := r.URL.Query().Get("backfill"); param != "" {
+			n, err := strconv.Atoi(param)
+			if err != nil || n < 0 {
+				util.ReturnHTTPStatus(w, http.StatusBadRequest, "could not parse backfill")
+				return
+			}
+			backfill = n
+		}
+		if backfill > maxBackfill {
+			backfill = maxBackfill
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			applog.System().WithContext(r.Context()).Error(err, "error upgrading connection")
+			return
+		}
+
+		sub, recent := tail.Subscribe(filter, backfill)
+		closed := make(chan struct{})
+		go readFilters(conn, sub, closed)
+		writeEntries(conn, sub, recent, closed)
+	})
+}
+
+// readFilters replaces the subscription filter with each one sent by the client until the connection is closed
+func readFilters(conn *websocket.Conn, sub *logging.Subscription, closed chan<- struct{}) {
+	defer close(closed)
+	conn.SetReadLimit(4 << 10)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error { conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
+	for {
+		filter := logging.TailFilter{}
+		if err := conn.ReadJSON(&filter); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				applog.System().Error(err, "log tail client closed unexpectedly")
+			}
+			return
+		}
+		sub.SetFilter(filter)
+	}
+}
+
+// writeEntries sends the backfill then every entry received by the subscription until the client goes away
+func writeEntries(conn *websocket.Conn, sub *logging.Subscription, recent []structs.LogEntry, closed <-chan struct{}) {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		sub.Unsubscribe()
+		conn.Close()
+	}()
+
+	send := func(event structs.TailEvent) error {
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		return conn.WriteJSON(event)
+	}
+
+	for i := range recent {
+		if err := send(structs.TailEvent{Type: structs.TailEntry, Entry: &recent[i]}); err != nil {
+			return
+		}
+	}
+
+	for {
+		select {
+		case entry := <-sub.Entries():
+			if n := sub.Dropped(); n > 0 {
+				if err := send(structs.TailEvent{Type: structs.TailDropped, Dropped: n}); err != nil {
+					return
+				}
+			}
+			if err := send(structs.TailEvent{Type: structs.TailEntry, Entry: &entry}); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
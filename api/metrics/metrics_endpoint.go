@@ -0,0 +1,12 @@
+package metrics
+
+import (
+	"fp-dynamic-elements-manager-controller/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+// Handler serves the controller metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{})
+}
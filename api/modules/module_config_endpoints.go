@@ -0,0 +1,200 @@
+package modules
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"fp-dynamic-elements-manager-controller/api/util"
+	"fp-dynamic-elements-manager-controller/internal/auth"
+	authstructs "fp-dynamic-elements-manager-controller/internal/auth/structs"
+	"fp-dynamic-elements-manager-controller/internal/db/persistence"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
+	"fp-dynamic-elements-manager-controller/internal/moduleconfig"
+	"fp-dynamic-elements-manager-controller/internal/modules/structs"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+)
+
+// moduleFromRequest returns the module named by the id in the path, the error response is written if it is not found
+func moduleFromRequest(w http.ResponseWriter, r *http.Request, dao *persistence.DataAccessObject) (structs.ModuleMetadata, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		util.ReturnHTTPStatus(w, http.StatusBadRequest, "module id must be a number")
+		return structs.ModuleMetadata{}, false
+	}
+
+	module, err := dao.ModuleMetadataRepo.GetByID(id)
+	if err == sql.ErrNoRows {
+		util.ReturnHTTPStatus(w, http.StatusNotFound, "module not found")
+		return module, false
+	}
+	if err != nil {
+		applog.System().WithContext(r.Context()).Error(err, "error retrieving module")
+		util.ReturnHTTPStatus(w, http.StatusInternalServerError, "could not retrieve requested resource")
+		return module, false
+	}
+	return module, true
+}
+
+// ConfigHistoryHandler returns the most recent versions of a module's config, newest first.
+// It takes 1 query parameter, Limit (the number of versions, default 20 and max 500)
+func ConfigHistoryHandler(dao *persistence.DataAccessObject) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodOptions:
+			w.Header().Set("Access-Control-Allow-Methods", "OPTIONS,GET")
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			limit := 20
+			if value := r.URL.Query().Get("limit"); value != "" {
+				var err error
+				limit, err = strconv.Atoi(value)
+				if err != nil || limit < 1 || limit > 500 {
+					util.ReturnHTTPStatus(w, http.StatusBadRequest, "limit must be a number from 1 to 500")
+					return
+				}
+			}
+
+			module, ok := moduleFromRequest(w, r, dao)
+			if !ok {
+				return
+			}
+
+			versions, err := dao.ModuleConfigRepo.GetConfigs(module.ModuleServiceName, limit)
+			if err != nil {
+				applog.System().WithContext(r.Context()).Error(err, "error retrieving module config history")
+				util.ReturnHTTPStatus(w, http.StatusInternalServerError, "could not retrieve requested resource")
+				return
+			}
+			json.NewEncoder(w).Encode(versions)
+		}
+		return
+	})
+}
+
+// ConfigVersionHandler returns a single version of a module's config
+func ConfigVersionHandler(dao *persistence.DataAccessObject) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodOptions:
+			w.Header().Set("Access-Control-Allow-Methods", "OPTIONS,GET")
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			version, err := strconv.Atoi(mux.Vars(r)["version"])
+			if err != nil {
+				util.ReturnHTTPStatus(w, http.StatusBadRequest, "version must be a number")
+				return
+			}
+
+			module, ok := moduleFromRequest(w, r, dao)
+			if !ok {
+				return
+			}
+
+			config, err := dao.ModuleConfigRepo.GetConfig(module.ModuleServiceName, version)
+			if err == sql.ErrNoRows {
+				util.ReturnHTTPStatus(w, http.StatusNotFound, "config version not found")
+				return
+			}
+			if err != nil {
+				applog.System().WithContext(r.Context()).Error(err, "error retrieving module config version")
+				util.ReturnHTTPStatus(w, http.StatusInternalServerError, "could not retrieve requested resource")
+				return
+			}
+			json.NewEncoder(w).Encode(config)
+		}
+		return
+	})
+}
+
+// ConfigDiffHandler returns the changes between two versions of a module's config.
+// It takes 2 query parameters, From (required) and To (the latest version when it is not set)
+func ConfigDiffHandler(dao *persistence.DataAccessObject, store *moduleconfig.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodOptions:
+			w.Header().Set("Access-Control-Allow-Methods", "OPTIONS,GET")
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			from, err := strconv.Atoi(r.URL.Query().Get("from"))
+			if err != nil || from < 1 {
+				util.ReturnHTTPStatus(w, http.StatusBadRequest, "from must be a version number")
+				return
+			}
+			to := 0
+			if value := r.URL.Query().Get("to"); value != "" {
+				to, err = strconv.Atoi(value)
+				if err != nil || to < 1 {
+					util.ReturnHTTPStatus(w, http.StatusBadRequest, "to must be a version number")
+					return
+				}
+			}
+
+			module, ok := moduleFromRequest(w, r, dao)
+			if !ok {
+				return
+			}
+
+			diff, err := store.Diff(module.ModuleServiceName, from, to)
+			if errors.Is(err, moduleconfig.ErrVersionNotFound) {
+				util.ReturnHTTPStatus(w, http.StatusNotFound, err.Error())
+				return
+			}
+			if err != nil {
+				applog.System().WithContext(r.Context()).Error(err, "error comparing module config versions")
+				util.ReturnHTTPStatus(w, http.StatusInternalServerError, "could not retrieve requested resource")
+				return
+			}
+			json.NewEncoder(w).Encode(diff)
+		}
+		return
+	})
+}
+
+type rollbackRequest struct {
+	Version int `json:"version"`
+}
+
+// ConfigRollbackHandler applies an earlier version of a module's config, only admins can roll back a config
+func ConfigRollbackHandler(dao *persistence.DataAccessObject, store *moduleconfig.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodOptions:
+			w.Header().Set("Access-Control-Allow-Methods", "OPTIONS,POST")
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodPost:
+			user, ok := auth.UserFromContext(r.Context())
+			if !ok || !user.Allows(authstructs.Admin) {
+				util.ReturnHTTPStatus(w, http.StatusForbidden, fmt.Sprintf("%s role required", authstructs.Admin))
+				return
+			}
+
+			var body rollbackRequest
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Version < 1 {
+				util.ReturnHTTPStatus(w, http.StatusBadRequest, "version must be a version number")
+				return
+			}
+
+			module, ok := moduleFromRequest(w, r, dao)
+			if !ok {
+				return
+			}
+
+			version, err := store.Rollback(r.Context(), module, body.Version, user)
+			switch {
+			case err == nil:
+				json.NewEncoder(w).Encode(version)
+			case errors.Is(err, moduleconfig.ErrVersionNotFound), errors.Is(err, moduleconfig.ErrNotConfigurable):
+				util.ReturnHTTPStatus(w, http.StatusNotFound, err.Error())
+			case errors.Is(err, moduleconfig.ErrInvalidConfig):
+				util.ReturnHTTPStatus(w, http.StatusConflict, err.Error())
+			default:
+				applog.System().WithContext(r.Context()).Error(err, "error rolling back module config")
+				util.ReturnHTTPStatus(w, http.StatusBadGateway, fmt.Sprintf("the config could not be applied to module %s", module.ModuleDisplayName))
+			}
+		}
+		return
+	})
+}
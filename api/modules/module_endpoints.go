@@ -1,16 +1,23 @@
 package modules
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fp-dynamic-elements-manager-controller/api/util"
 	"fp-dynamic-elements-manager-controller/internal/db/persistence"
+	"fp-dynamic-elements-manager-controller/internal/docker"
+	"fp-dynamic-elements-manager-controller/internal/health"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
 	"fp-dynamic-elements-manager-controller/internal/modules"
 	"fp-dynamic-elements-manager-controller/internal/modules/structs"
-	"github.com/rs/zerolog/log"
+	"fp-dynamic-elements-manager-controller/internal/routing"
+	"github.com/gorilla/mux"
 	"net/http"
+	"strconv"
+	"time"
 )
 
-func Handler(dao *persistence.DataAccessObject) http.Handler {
+func Handler(dao *persistence.DataAccessObject, monitor *health.Monitor, sampler *docker.StatsSampler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodOptions:
@@ -18,9 +25,9 @@ func Handler(dao *persistence.DataAccessObject) http.Handler {
 			w.WriteHeader(http.StatusNoContent)
 		case http.MethodGet:
 			moduleType := r.URL.Query().Get("moduleType")
-			mods, err := modules.GetModuleData(structs.ModuleType(moduleType), dao)
+			mods, err := modules.GetModuleData(structs.ModuleType(moduleType), dao, monitor, sampler)
 			if err != nil {
-				log.Error().Err(err).Msg("error retrieving module data")
+				applog.System().WithContext(r.Context()).Error(err, "error retrieving module data")
 				util.ReturnHTTPStatus(w, http.StatusInternalServerError, "could not retrieve requested resource")
 				return
 			}
@@ -29,3 +36,101 @@ func Handler(dao *persistence.DataAccessObject) http.Handler {
 		return
 	})
 }
+
+// RoutesHandler returns the module proxy routes which are currently being served by the controller
+func RoutesHandler(router *routing.ModuleRouter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodOptions:
+			w.Header().Set("Access-Control-Allow-Methods", "OPTIONS,GET")
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(router.Routes())
+		}
+		return
+	})
+}
+
+// HealthHistoryHandler returns the uptime of a module over the last 24 hours, 7 days and 30 days and its outages
+func HealthHistoryHandler(dao *persistence.DataAccessObject) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodOptions:
+			w.Header().Set("Access-Control-Allow-Methods", "OPTIONS,GET")
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+			if err != nil {
+				util.ReturnHTTPStatus(w, http.StatusBadRequest, "module id must be a number")
+				return
+			}
+
+			module, err := dao.ModuleMetadataRepo.GetByID(id)
+			if err == sql.ErrNoRows {
+				util.ReturnHTTPStatus(w, http.StatusNotFound, "module not found")
+				return
+			}
+			if err != nil {
+				applog.System().WithContext(r.Context()).Error(err, "error retrieving module for health history")
+				util.ReturnHTTPStatus(w, http.StatusInternalServerError, "could not retrieve requested resource")
+				return
+			}
+
+			history, err := health.GetHealthHistory(module.ModuleServiceName, dao.ModuleHealthRepo, time.Now())
+			if err != nil {
+				applog.System().WithContext(r.Context()).Error(err, "error retrieving module health history")
+				util.ReturnHTTPStatus(w, http.StatusInternalServerError, "could not retrieve requested resource")
+				return
+			}
+			json.NewEncoder(w).Encode(history)
+		}
+		return
+	})
+}
+
+// UpgradeHistoryHandler returns the most recent upgrades of a module, newest first.
+// It takes 1 query parameter, Limit (the number of upgrades, default 20 and max 500)
+func UpgradeHistoryHandler(dao *persistence.DataAccessObject) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodOptions:
+			w.Header().Set("Access-Control-Allow-Methods", "OPTIONS,GET")
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+			if err != nil {
+				util.ReturnHTTPStatus(w, http.StatusBadRequest, "module id must be a number")
+				return
+			}
+
+			limit := 20
+			if value := r.URL.Query().Get("limit"); value != "" {
+				limit, err = strconv.Atoi(value)
+				if err != nil || limit < 1 || limit > 500 {
+					util.ReturnHTTPStatus(w, http.StatusBadRequest, "limit must be a number from 1 to 500")
+					return
+				}
+			}
+
+			module, err := dao.ModuleMetadataRepo.GetByID(id)
+			if err == sql.ErrNoRows {
+				util.ReturnHTTPStatus(w, http.StatusNotFound, "module not found")
+				return
+			}
+			if err != nil {
+				applog.System().WithContext(r.Context()).Error(err, "error retrieving module for upgrade history")
+				util.ReturnHTTPStatus(w, http.StatusInternalServerError, "could not retrieve requested resource")
+				return
+			}
+
+			upgrades, err := dao.ModuleUpgradeRepo.GetUpgrades(module.ModuleServiceName, limit)
+			if err != nil {
+				applog.System().WithContext(r.Context()).Error(err, "error retrieving module upgrade history")
+				util.ReturnHTTPStatus(w, http.StatusInternalServerError, "could not retrieve requested resource")
+				return
+			}
+			json.NewEncoder(w).Encode(upgrades)
+		}
+		return
+	})
+}
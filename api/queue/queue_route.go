@@ -5,10 +5,11 @@ import (
 	"fmt"
 	"fp-dynamic-elements-manager-controller/api/util"
 	"fp-dynamic-elements-manager-controller/internal/db/persistence"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
 	structs2 "fp-dynamic-elements-manager-controller/internal/logging/structs"
 	"fp-dynamic-elements-manager-controller/internal/queue"
 	"fp-dynamic-elements-manager-controller/internal/queue/structs"
-	"github.com/rs/zerolog/log"
+	"fp-dynamic-elements-manager-controller/internal/tracing"
 	"net/http"
 )
 
@@ -23,11 +24,11 @@ func Handler(pusher queue.Pusher, dao *persistence.DataAccessObject, logger *str
 			items := structs.ProcessedItems{}
 			err := json.NewDecoder(r.Body).Decode(&items)
 			if err != nil {
-				log.Error().Err(err).Msg("error decoding json into entity")
+				applog.System().WithContext(r.Context()).Error(err, "error decoding json into entity")
 				util.ReturnHTTPStatus(w, http.StatusNotAcceptable, "could not decode json into entity")
 				return
 			}
-			go queue.AddToQueue(items.Items, pusher, dao, logger)
+			go queue.AddToQueue(tracing.Detach(r.Context()), items.Items, pusher, dao, logger)
 			util.ReturnHTTPStatus(w, http.StatusAccepted, fmt.Sprintf("Success: %d items uploaded", len(items.Items)))
 		}
 		return
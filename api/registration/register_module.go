@@ -3,14 +3,18 @@ package registration
 import (
 	"encoding/json"
 	"fp-dynamic-elements-manager-controller/api/util"
+	"fp-dynamic-elements-manager-controller/internal/db/persistence"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
+	"fp-dynamic-elements-manager-controller/internal/modules"
 	"fp-dynamic-elements-manager-controller/internal/modules/structs"
-	"github.com/rs/zerolog/log"
+	"github.com/pkg/errors"
 	"net/http"
 )
 
 // Handler takes ModuleMetadata via a POST request from a child module to register a new module
-// It passes the struct through a channel to a separately running goroutine that handles processing and adding that module
-func Handler(addRoute chan<- structs.ModuleMetadata) http.Handler {
+// The metadata is validated and checked for collisions with existing modules before it is passed through
+// a channel to a separately running goroutine that handles processing and adding that module
+func Handler(addRoute chan<- structs.ModuleMetadata, repo persistence.ModuleLookupRepo) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodOptions:
@@ -20,12 +24,26 @@ func Handler(addRoute chan<- structs.ModuleMetadata) http.Handler {
 			metadata := structs.ModuleMetadata{}
 			err := json.NewDecoder(r.Body).Decode(&metadata)
 			if err != nil {
-				log.Error().Err(err).Msg("error decoding json into entity")
+				applog.System().WithContext(r.Context()).Error(err, "error decoding json into entity")
 				util.ReturnHTTPStatus(w, http.StatusNotAcceptable, "could not decode json into entity")
 				return
 			}
+			err = modules.ValidateRegistration(&metadata, repo)
+			switch errors.Cause(err) {
+			case nil:
+			case modules.ErrInvalidRegistration:
+				util.ReturnHTTPStatus(w, http.StatusBadRequest, err.Error())
+				return
+			case modules.ErrRouteCollision, modules.ErrServiceCollision:
+				util.ReturnHTTPStatus(w, http.StatusConflict, err.Error())
+				return
+			default:
+				applog.System().WithContext(r.Context()).Error(err, "error validating module registration")
+				util.ReturnHTTPStatus(w, http.StatusInternalServerError, "could not validate registration")
+				return
+			}
 			addRoute <- metadata
-			w.WriteHeader(http.StatusAccepted)
+			util.ReturnHTTPStatus(w, http.StatusAccepted, "registration accepted")
 		}
 		return
 	})
@@ -0,0 +1,103 @@
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"fp-dynamic-elements-manager-controller/api/util"
+	"fp-dynamic-elements-manager-controller/internal/auth"
+	authstructs "fp-dynamic-elements-manager-controller/internal/auth/structs"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
+	"fp-dynamic-elements-manager-controller/internal/secrets"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// admin returns the user if they are an admin, otherwise the error response is written. Only admins can read or change
+// secrets, even though the values are never returned
+func admin(w http.ResponseWriter, r *http.Request) (*authstructs.Token, bool) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok || !user.Allows(authstructs.Admin) {
+		util.ReturnHTTPStatus(w, http.StatusForbidden, fmt.Sprintf("%s role required", authstructs.Admin))
+		return nil, false
+	}
+	return user, true
+}
+
+// Handler lists the secrets, the values are never part of the response
+func Handler(service *secrets.Service) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodOptions:
+			w.Header().Set("Access-Control-Allow-Methods", "OPTIONS,GET")
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			if _, ok := admin(w, r); !ok {
+				return
+			}
+			json.NewEncoder(w).Encode(&util.HttpResponse{
+				Items:   service.List(),
+				Status:  http.StatusOK,
+				Message: "ok",
+			})
+		}
+		return
+	})
+}
+
+type secretRequest struct {
+	Value string `json:"value"`
+}
+
+// SecretHandler stores a secret on PUT, with the value in the body, and deletes it on DELETE
+func SecretHandler(service *secrets.Service) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodOptions:
+			w.Header().Set("Access-Control-Allow-Methods", "OPTIONS,PUT,DELETE")
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodPut:
+			user, ok := admin(w, r)
+			if !ok {
+				return
+			}
+			var body secretRequest
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2*secrets.MaxValueBytes)).Decode(&body); err != nil {
+				util.ReturnHTTPStatus(w, http.StatusBadRequest, "bad request")
+				return
+			}
+
+			secret, err := service.Put(mux.Vars(r)["name"], body.Value, user.Email)
+			switch {
+			case err == nil:
+				applog.User().WithContext(r.Context()).Info(fmt.Sprintf("Secret %s was stored by %s", secret.Name, user.Email))
+				json.NewEncoder(w).Encode(secret)
+			case errors.Is(err, secrets.ErrInvalidName), errors.Is(err, secrets.ErrInvalidValue):
+				util.ReturnHTTPStatus(w, http.StatusBadRequest, err.Error())
+			case errors.Is(err, secrets.ErrNoMasterKey):
+				util.ReturnHTTPStatus(w, http.StatusServiceUnavailable, err.Error())
+			default:
+				applog.System().WithContext(r.Context()).Error(err, "error storing secret")
+				util.ReturnHTTPStatus(w, http.StatusInternalServerError, "the secret could not be stored")
+			}
+		case http.MethodDelete:
+			user, ok := admin(w, r)
+			if !ok {
+				return
+			}
+			name := mux.Vars(r)["name"]
+			err := service.Delete(name)
+			switch {
+			case err == nil:
+				applog.User().WithContext(r.Context()).Info(fmt.Sprintf("Secret %s was deleted by %s", name, user.Email))
+				util.ReturnHTTPStatus(w, http.StatusOK, "secret deleted successfully")
+			case errors.Is(err, secrets.ErrSecretNotFound):
+				util.ReturnHTTPStatus(w, http.StatusNotFound, err.Error())
+			default:
+				applog.System().WithContext(r.Context()).Error(err, "error deleting secret")
+				util.ReturnHTTPStatus(w, http.StatusInternalServerError, "the secret could not be deleted")
+			}
+		}
+		return
+	})
+}
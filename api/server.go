@@ -1,31 +1,42 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"fp-dynamic-elements-manager-controller/api/auth"
 	"fp-dynamic-elements-manager-controller/api/backup"
 	"fp-dynamic-elements-manager-controller/api/batch"
+	"fp-dynamic-elements-manager-controller/api/catalog"
 	"fp-dynamic-elements-manager-controller/api/docker"
 	"fp-dynamic-elements-manager-controller/api/elements"
 	"fp-dynamic-elements-manager-controller/api/export"
 	"fp-dynamic-elements-manager-controller/api/health"
 	"fp-dynamic-elements-manager-controller/api/logging"
+	"fp-dynamic-elements-manager-controller/api/metrics"
 	"fp-dynamic-elements-manager-controller/api/modules"
 	"fp-dynamic-elements-manager-controller/api/notification"
 	"fp-dynamic-elements-manager-controller/api/queue"
 	"fp-dynamic-elements-manager-controller/api/registration"
+	"fp-dynamic-elements-manager-controller/api/secrets"
 	"fp-dynamic-elements-manager-controller/api/stats"
 	"fp-dynamic-elements-manager-controller/api/update"
 	"fp-dynamic-elements-manager-controller/api/user"
 	"fp-dynamic-elements-manager-controller/api/util"
 	authfuncs "fp-dynamic-elements-manager-controller/internal/auth"
 	backup2 "fp-dynamic-elements-manager-controller/internal/backup"
+	catalogfuncs "fp-dynamic-elements-manager-controller/internal/catalog"
 	"fp-dynamic-elements-manager-controller/internal/db/persistence"
 	docker2 "fp-dynamic-elements-manager-controller/internal/docker"
 	healthfuncs "fp-dynamic-elements-manager-controller/internal/health"
+	loggingfuncs "fp-dynamic-elements-manager-controller/internal/logging"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
 	logstructs "fp-dynamic-elements-manager-controller/internal/logging/structs"
+	"fp-dynamic-elements-manager-controller/internal/moduleconfig"
 	"fp-dynamic-elements-manager-controller/internal/modules/structs"
 	queuefuncs "fp-dynamic-elements-manager-controller/internal/queue"
+	"fp-dynamic-elements-manager-controller/internal/routing"
+	secretfuncs "fp-dynamic-elements-manager-controller/internal/secrets"
+	"fp-dynamic-elements-manager-controller/internal/tracing"
 	userfuncs "fp-dynamic-elements-manager-controller/internal/user"
 	"github.com/gammazero/workerpool"
 	"github.com/gorilla/handlers"
@@ -34,15 +45,20 @@ import (
 	"github.com/lithammer/shortuuid"
 	"github.com/spf13/viper"
 	"net/http"
-	"net/url"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 )
 
 const (
-	authPathPrefix     = "/api"
-	internalPathPrefix = "/internal"
-	ingressPathPrefix  = "/ingress"
+	AuthPathPrefix     = "/api"
+	InternalPathPrefix = "/internal"
+	IngressPathPrefix  = "/ingress"
+
+	// shutdownTimeout is how long requests in flight are given to finish once the controller is asked to stop
+	shutdownTimeout = 15 * time.Second
 )
 
 type server struct {
@@ -58,7 +74,16 @@ type server struct {
 	pusher         queuefuncs.Pusher
 	wp             *workerpool.WorkerPool
 	handler        *docker2.CommandHandler
+	upgrader       *docker2.Upgrader
+	catalog        *catalogfuncs.Catalog
 	provider       backup2.Provider
+	moduleRouter   *routing.ModuleRouter
+	monitor        *healthfuncs.Monitor
+	sampler        *docker2.StatsSampler
+	logWriter      *loggingfuncs.LogWriter
+	logTail        *loggingfuncs.Tail
+	configs        *moduleconfig.Store
+	secrets        *secretfuncs.Service
 }
 
 func NewServer(
@@ -67,15 +92,24 @@ func NewServer(
 	dao *persistence.DataAccessObject,
 	pusher queuefuncs.Pusher,
 	handler *docker2.CommandHandler,
+	upgrader *docker2.Upgrader,
+	catalog *catalogfuncs.Catalog,
 	provider backup2.Provider,
+	moduleRouter *routing.ModuleRouter,
+	monitor *healthfuncs.Monitor,
+	sampler *docker2.StatsSampler,
+	logWriter *loggingfuncs.LogWriter,
+	logTail *loggingfuncs.Tail,
+	configs *moduleconfig.Store,
+	secrets *secretfuncs.Service,
 ) *server {
 	router := mux.NewRouter().StrictSlash(true)
-	router.Use(util.AddHeaders)
-	authRouter := router.PathPrefix(authPathPrefix).Subrouter()
+	router.Use(util.RequestID, util.AddHeaders)
+	authRouter := router.PathPrefix(AuthPathPrefix).Subrouter()
 	authRouter.Use(authfuncs.JwtVerify)
-	internalRouter := router.PathPrefix(internalPathPrefix).Subrouter()
+	internalRouter := router.PathPrefix(InternalPathPrefix).Subrouter()
 	internalRouter.Use(authfuncs.InternalAuthVerify)
-	ingressRouter := router.PathPrefix(ingressPathPrefix).Subrouter()
+	ingressRouter := router.PathPrefix(IngressPathPrefix).Subrouter()
 	return &server{
 		logger:         logger,
 		dbReadyChan:    dbReadyChan,
@@ -88,12 +122,23 @@ func NewServer(
 		dao:            dao,
 		pusher:         pusher,
 		handler:        handler,
+		upgrader:       upgrader,
+		catalog:        catalog,
 		provider:       provider,
+		moduleRouter:   moduleRouter,
+		monitor:        monitor,
+		sampler:        sampler,
+		logWriter:      logWriter,
+		logTail:        logTail,
+		configs:        configs,
+		secrets:        secrets,
 	}
 }
 
-func (s *server) StartServer() {
-	defer func() { s.doneChan <- struct{}{} }()
+// StartServer serves the API until the controller receives SIGINT or SIGTERM, requests in flight are then given
+// shutdownTimeout to finish. It returns nil once shut down, or the error if the server could not be run
+func (s *server) StartServer() error {
+	defer close(s.doneChan)
 	upgrader := websocket.Upgrader{
 		ReadBufferSize:  8 << 10,
 		WriteBufferSize: 8 << 10,
@@ -107,35 +152,81 @@ func (s *server) StartServer() {
 	}
 
 	s.router.Use(handlers.CORS(
-		handlers.AllowedHeaders([]string{"Content-Type", "x-access-token"}),
+		handlers.AllowedHeaders([]string{"Content-Type", "x-access-token", applog.RequestIDHeader}),
+		handlers.ExposedHeaders([]string{applog.RequestIDHeader}),
 		handlers.AllowedMethods([]string{http.MethodPost, http.MethodGet, http.MethodOptions, http.MethodDelete, http.MethodPut}),
 		handlers.AllowedOrigins([]string{os.Getenv("HOST_DOMAIN")})))
 
 	s.router.Handle("/login", auth.Login(s.dao.UserRepo)).Methods(http.MethodPost)
+	s.router.Handle("/metrics", authfuncs.MetricsAuthVerify(metrics.Handler())).Methods(http.MethodGet)
 
 	s.authRouter.Handle("/ws", notification.Handler(upgrader, s.logger.NotificationService))
 
 	s.authRouter.Handle("/export", export.Handler(s.dao.ListElementRepo))
 	s.authRouter.Handle("/backup", backup.Handler(s.provider, s.logger.NotificationService))
+	s.authRouter.Handle("/backup/targets", backup.TargetsHandler(s.provider))
+	s.authRouter.Handle("/backup/targets/{name}", backup.TargetHandler(s.provider, s.logger.NotificationService))
 	s.authRouter.Handle("/keys", auth.GetRegistrationKey())
 	s.authRouter.Handle("/health", health.Handler(s.dao))
 	s.authRouter.Handle("/stats", stats.Handler(s.dao.ListElementRepo))
-	s.authRouter.Handle("/logs", logging.Handler(s.dao.LogEntryRepo))
-	s.authRouter.Handle("/modules", modules.Handler(s.dao))
-	s.authRouter.Handle("/docker", docker.Handler(s.handler, s.logger.NotificationService))
+	s.authRouter.Handle("/logs", logging.Handler(s.dao.LogEntryRepo, s.logWriter))
+	s.authRouter.Handle("/logs/tail", logging.TailHandler(upgrader, s.logTail))
+	s.authRouter.Handle("/modules", modules.Handler(s.dao, s.monitor, s.sampler))
+	s.authRouter.Handle("/modules/routes", modules.RoutesHandler(s.moduleRouter))
+	s.authRouter.Handle("/modules/{id:[0-9]+}/health/history", modules.HealthHistoryHandler(s.dao))
+	s.authRouter.Handle("/modules/{id:[0-9]+}/upgrades", modules.UpgradeHistoryHandler(s.dao))
+	s.authRouter.Handle("/modules/{id:[0-9]+}/config/versions", modules.ConfigHistoryHandler(s.dao))
+	s.authRouter.Handle("/modules/{id:[0-9]+}/config/versions/{version:[0-9]+}", modules.ConfigVersionHandler(s.dao))
+	s.authRouter.Handle("/modules/{id:[0-9]+}/config/diff", modules.ConfigDiffHandler(s.dao, s.configs))
+	s.authRouter.Handle("/modules/{id:[0-9]+}/config/rollback", modules.ConfigRollbackHandler(s.dao, s.configs))
+	s.authRouter.Handle("/secrets", secrets.Handler(s.secrets))
+	s.authRouter.Handle("/secrets/{name}", secrets.SecretHandler(s.secrets))
+	s.authRouter.Handle("/catalog", catalog.Handler(s.catalog))
+	s.authRouter.Handle("/docker", docker.Handler(s.handler))
+	s.authRouter.Handle("/docker/jobs/{id}", docker.JobHandler(s.handler))
+	s.authRouter.Handle("/docker/jobs/{id}/cancel", docker.CancelJobHandler(s.handler))
+	s.authRouter.Handle("/docker/{container:[A-Za-z0-9][A-Za-z0-9_.-]*}", docker.InspectHandler(s.handler))
+	s.authRouter.Handle("/docker/{container:[A-Za-z0-9][A-Za-z0-9_.-]*}/logs", docker.LogsHandler(s.handler))
 	s.authRouter.Handle("/batch", batch.Handler(s.dao.UpdateStatusRepo))
 	s.authRouter.Handle("/user", user.Handler(s.dao.UserRepo, s.logger))
 	s.authRouter.Handle("/elements", elements.Handler(s.pusher, s.dao, s.logger))
 
-	s.internalRouter.Handle("/register", registration.Handler(s.addRoutesChan))
-	s.internalRouter.Handle("/queue", queue.Handler(s.pusher, s.dao, s.logger))
-	s.internalRouter.Handle("/update", update.Handler(s.dao.UpdateStatusRepo))
-	s.internalRouter.Handle("/logevent", logging.Handler(s.dao.LogEntryRepo))
+	s.internalRouter.Handle("/register", registration.Handler(s.addRoutesChan, s.dao.ModuleMetadataRepo))
+	s.internalRouter.Handle("/queue", tracing.Middleware("queue.ingest", queue.Handler(s.pusher, s.dao, s.logger)))
+	s.internalRouter.Handle("/update", tracing.Middleware("update.acknowledge", update.Handler(s.dao.UpdateStatusRepo)))
+	s.internalRouter.Handle("/logevent", logging.Handler(s.dao.LogEntryRepo, s.logWriter))
 	s.internalRouter.Handle("/lookup", export.LookupHandler(s.dao.ListElementRepo))
 
+	// Module routes are served from a table that is swapped at runtime, these catch-alls must be added
+	// last so that the controller routes above always take precedence
+	s.authRouter.PathPrefix("/").Handler(s.moduleRouter.SecureHandler())
+	s.ingressRouter.PathPrefix("/").Handler(s.moduleRouter.IngressHandler())
+
 	s.startDynamicRouteHandler()
 
-	s.logger.SystemLogger.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", os.Getenv("CONTROLLER_PORT")), handlers.CompressHandler(s.router)), "error running server")
+	httpServer := &http.Server{
+		Addr:    fmt.Sprintf(":%s", os.Getenv("CONTROLLER_PORT")),
+		Handler: handlers.CompressHandler(s.router),
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.ListenAndServe()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-serveErr:
+		return err
+	case sig := <-signals:
+		s.logger.SystemLogger.Info(fmt.Sprintf("received %s, shutting down", sig))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return httpServer.Shutdown(ctx)
 }
 
 func (s *server) startDynamicRouteHandler() {
@@ -145,25 +236,12 @@ func (s *server) startDynamicRouteHandler() {
 			case data := <-s.addRoutesChan:
 				s.logger.UserLogger.Info(fmt.Sprintf("Adding new module: %s", data.ModuleDisplayName))
 				s.dao.ModuleMetadataRepo.UpsertModuleMetadata(data)
-				s.addModuleRoutes(data)
+				s.moduleRouter.Upsert(data)
+				s.upgrader.Registered(data.ModuleServiceName)
+				s.configs.Registered(data)
+				s.monitor.Trigger()
 			case <-s.dbReadyChan:
-				s.logger.UserLogger.Info("Adding module routes from persistence...")
-				err := userfuncs.CreateAdminUserIfNotExists(s.dao.UserRepo)
-				if err != nil {
-					s.logger.SystemLogger.Error(err, "error creating admin user")
-					return
-				}
-				metadata, err := s.dao.ModuleMetadataRepo.GetAllModuleMetadata()
-
-				if err != nil {
-					s.logger.SystemLogger.Error(err, "error retrieving module metadata to add routes")
-					return
-				}
-
-				for _, dbModule := range metadata {
-					s.addModuleRoutes(dbModule)
-				}
-				s.createAndSetRegistrationToken()
+				s.dbReady()
 			case <-s.doneChan:
 				return
 			}
@@ -171,20 +249,33 @@ func (s *server) startDynamicRouteHandler() {
 	}()
 }
 
-func (s *server) addModuleRoutes(data structs.ModuleMetadata) {
-	if !healthfuncs.IsUp(data.ModuleServiceName, data.InternalPort) {
+// dbReady loads the module routes and starts the background jobs which need the DB, once it is ready
+func (s *server) dbReady() {
+	s.logger.UserLogger.Info("Adding module routes from persistence...")
+	err := userfuncs.CreateAdminUserIfNotExists(s.dao.UserRepo)
+	if err != nil {
+		s.logger.SystemLogger.Error(err, "error creating admin user")
 		return
 	}
-	for _, v := range data.ModuleEndpoints {
-		inboundRoute := data.InboundRoute + v.Endpoint
-		parsedUrl, _ := url.Parse(fmt.Sprintf("http://%s:%s%s", data.ModuleServiceName, data.InternalPort, v.Endpoint))
-
-		if v.Secure {
-			s.authRouter.Handle(inboundRoute, util.NewReverseProxy(parsedUrl))
-		} else {
-			s.ingressRouter.Handle(inboundRoute, util.NewReverseProxy(parsedUrl))
-		}
+	// Secrets are loaded before any module is created or configured, as both resolve them
+	if err := s.secrets.Load(); err != nil {
+		s.logger.SystemLogger.Fatal(err, "error loading secrets")
 	}
+	metadata, err := s.dao.ModuleMetadataRepo.GetAllModuleMetadata()
+
+	if err != nil {
+		s.logger.SystemLogger.Error(err, "error retrieving module metadata to add routes")
+		return
+	}
+
+	s.moduleRouter.Reconcile(metadata)
+	s.handler.FailInterruptedJobs()
+	s.upgrader.FailInterruptedUpgrades()
+	s.monitor.Start()
+	s.sampler.Start()
+	// off-site backup targets are started once the secrets their credentials reference are loaded
+	s.provider.StartTargets(context.Background())
+	s.createAndSetRegistrationToken()
 }
 
 func (s *server) createAndSetRegistrationToken() {
@@ -4,8 +4,11 @@ import (
 	"encoding/json"
 	"fp-dynamic-elements-manager-controller/api/util"
 	"fp-dynamic-elements-manager-controller/internal/db/persistence"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
 	"fp-dynamic-elements-manager-controller/internal/queue/structs"
-	"github.com/rs/zerolog/log"
+	"fp-dynamic-elements-manager-controller/internal/tracing"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
 	"net/http"
 )
 
@@ -21,10 +24,15 @@ func Handler(repo *persistence.UpdateStatusRepo) http.Handler {
 			item := structs.UpdateStatus{}
 			err := json.NewDecoder(r.Body).Decode(&item)
 			if err != nil {
-				log.Error().Err(err).Msg("error decoding json into entity")
+				applog.System().WithContext(r.Context()).Error(err, "error decoding json into entity")
 				util.ReturnHTTPStatus(w, http.StatusNotAcceptable, "could not decode json into entity")
 				return
 			}
+			trace.SpanFromContext(r.Context()).SetAttributes(
+				tracing.Module(item.ServiceName),
+				label.Int64("dem.batch_id", item.UpdateBatchId),
+				label.String("dem.status", string(item.Status)),
+			)
 			repo.UpdateUpdateStatus(item)
 			w.WriteHeader(http.StatusAccepted)
 			json.NewEncoder(w).Encode(item)
@@ -21,7 +21,7 @@ func Handler(repo *persistence.UserRepo, logger *structs.AppLogger) http.Handler
 		case http.MethodGet:
 			users, err := user.GetAllUsers(repo)
 			if err != nil {
-				logger.SystemLogger.Error(err, "error getting all users")
+				logger.SystemLogger.WithContext(r.Context()).Error(err, "error getting all users")
 				util.ReturnHTTPStatus(w, http.StatusInternalServerError, "error retrieving resource")
 				return
 			}
@@ -50,7 +50,7 @@ func Handler(repo *persistence.UserRepo, logger *structs.AppLogger) http.Handler
 			usr := structs2.User{}
 			err := json.NewDecoder(r.Body).Decode(&usr)
 			if err != nil {
-				logger.SystemLogger.Error(err, "error decoding json into entity")
+				logger.SystemLogger.WithContext(r.Context()).Error(err, "error decoding json into entity")
 				return
 			}
 			err = user.UpdateUserPassword(usr, repo, logger)
@@ -74,7 +74,7 @@ func Handler(repo *persistence.UserRepo, logger *structs.AppLogger) http.Handler
 			logger.NotificationService.Send(notification.Event{
 				EventType: notification.Success,
 				Value:     "User deleted successfully",
-			})
+			}.WithContext(r.Context()))
 			util.ReturnHTTPStatus(w, http.StatusOK, "user deleted successfully")
 		}
 		return
@@ -1,11 +1,17 @@
 package util
 
 import (
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
+	"github.com/lithammer/shortuuid"
 	"net/http"
 	"os"
+	"regexp"
 	"strconv"
 )
 
+// validRequestID limits the request IDs accepted from callers to values which are safe to log and store
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
 // AddHeaders adds the content-type header for each request and the CORS header if in debug mode
 func AddHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -20,6 +26,20 @@ func AddHeaders(next http.Handler) http.Handler {
 	})
 }
 
+// RequestID gives each request an ID which is added to the request context, the response headers and every log entry,
+// notification and push made while handling it. A valid X-Request-Id sent by the caller is used instead of a new ID
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(applog.RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = shortuuid.New()
+		}
+
+		w.Header().Set(applog.RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(applog.ContextWithRequestID(r.Context(), id)))
+	})
+}
+
 func getEnvDebugMode() bool {
 	val := os.Getenv("DEBUG_MODE")
 	if val == "" {
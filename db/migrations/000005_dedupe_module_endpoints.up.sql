@@ -0,0 +1,6 @@
+DELETE older
+FROM module_endpoints older
+         INNER JOIN module_endpoints newer
+                    ON older.module_metadata_id = newer.module_metadata_id
+                        AND older.endpoint = newer.endpoint
+                        AND older.id < newer.id;
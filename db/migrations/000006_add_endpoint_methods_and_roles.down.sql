@@ -0,0 +1,3 @@
+alter table module_endpoints
+    drop column http_methods,
+    drop column required_role;
\ No newline at end of file
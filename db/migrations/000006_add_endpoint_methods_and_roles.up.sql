@@ -0,0 +1,3 @@
+alter table module_endpoints
+    add http_methods varchar(255) not null default '',
+    add required_role varchar(25) not null default '';
@@ -0,0 +1,13 @@
+create table IF NOT EXISTS module_health_transitions
+(
+    id                  bigint unsigned auto_increment
+        primary key,
+    created_at          datetime(3)  null,
+    module_service_name varchar(191) not null,
+    from_status         int          not null,
+    to_status           int          not null,
+    status_code         int          not null default 0
+);
+
+create index IF NOT EXISTS idx_module_health_transitions_svcname
+    on module_health_transitions (module_service_name, created_at);
@@ -0,0 +1,2 @@
+alter table log_entries
+    drop column request_id;
@@ -0,0 +1,5 @@
+alter table log_entries
+    add request_id varchar(64) not null default '';
+
+create index IF NOT EXISTS idx_log_entries_request_id
+    on log_entries (request_id);
@@ -0,0 +1 @@
+drop index IF EXISTS idx_log_entries_created_at on log_entries;
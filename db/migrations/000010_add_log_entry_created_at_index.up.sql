@@ -0,0 +1,2 @@
+create index IF NOT EXISTS idx_log_entries_created_at
+    on log_entries (created_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS docker_job_steps;
+DROP TABLE IF EXISTS docker_jobs;
@@ -0,0 +1,35 @@
+create table IF NOT EXISTS docker_jobs
+(
+    id          varchar(64)  not null
+        primary key,
+    created_at  datetime(3)  null,
+    started_at  datetime(3)  null,
+    finished_at datetime(3)  null,
+    status      varchar(16)  not null,
+    error       varchar(1024) not null default '',
+    request_id  varchar(64)  not null default ''
+);
+
+create index IF NOT EXISTS idx_docker_jobs_created_at
+    on docker_jobs (created_at);
+
+create table IF NOT EXISTS docker_job_steps
+(
+    id          bigint unsigned auto_increment
+        primary key,
+    job_id      varchar(64)  not null,
+    position    int          not null,
+    command     varchar(32)  not null,
+    container   varchar(191) not null,
+    image_ref   varchar(255) not null default '',
+    status      varchar(16)  not null,
+    error       varchar(1024) not null default '',
+    started_at  datetime(3)  null,
+    finished_at datetime(3)  null,
+    constraint fk_docker_job_steps_job
+        foreign key (job_id) references docker_jobs (id)
+            on delete cascade
+);
+
+create index IF NOT EXISTS idx_docker_job_steps_job_id
+    on docker_job_steps (job_id, position);
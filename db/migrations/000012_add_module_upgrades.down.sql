@@ -0,0 +1 @@
+DROP TABLE IF EXISTS module_upgrades;
\ No newline at end of file
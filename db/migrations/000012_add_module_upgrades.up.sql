@@ -0,0 +1,18 @@
+create table IF NOT EXISTS module_upgrades
+(
+    id                  bigint unsigned auto_increment
+        primary key,
+    module_service_name varchar(191)  not null,
+    from_image          varchar(255)  not null default '',
+    from_digest         varchar(255)  not null default '',
+    to_image            varchar(255)  not null default '',
+    to_digest           varchar(255)  not null default '',
+    status              varchar(16)   not null,
+    error               varchar(1024) not null default '',
+    request_id          varchar(64)   not null default '',
+    started_at          datetime(3)   not null,
+    finished_at         datetime(3)   null
+);
+
+create index IF NOT EXISTS idx_module_upgrades_service_name
+    on module_upgrades (module_service_name, started_at);
@@ -0,0 +1,2 @@
+alter table docker_job_steps
+    drop column IF EXISTS digest;
@@ -0,0 +1,2 @@
+alter table docker_job_steps
+    add column IF NOT EXISTS digest varchar(255) not null default '' after image_ref;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS module_configs;
\ No newline at end of file
@@ -0,0 +1,17 @@
+create table IF NOT EXISTS module_configs
+(
+    id                  bigint unsigned auto_increment
+        primary key,
+    module_service_name varchar(191) not null,
+    version             int unsigned not null,
+    config              mediumtext   not null,
+    action              varchar(16)  not null,
+    rollback_of         int unsigned not null default 0,
+    author_id           int unsigned not null default 0,
+    author_email        varchar(255) not null default '',
+    author_name         varchar(255) not null default '',
+    request_id          varchar(64)  not null default '',
+    created_at          datetime(3)  not null,
+    constraint uq_module_configs_version
+        unique (module_service_name, version)
+);
@@ -0,0 +1,14 @@
+create table IF NOT EXISTS secrets
+(
+    id         bigint unsigned auto_increment
+        primary key,
+    name       varchar(128)   not null,
+    ciphertext varbinary(8192) not null,
+    data_key   varbinary(128) not null,
+    key_id     varchar(64)    not null,
+    updated_by varchar(255)   not null default '',
+    created_at datetime(3)    not null,
+    updated_at datetime(3)    not null,
+    constraint uq_secrets_name
+        unique (name)
+);
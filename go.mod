@@ -3,6 +3,7 @@ module fp-dynamic-elements-manager-controller
 go 1.14
 
 require (
+	filippo.io/age v1.0.0-beta5
 	github.com/antonfisher/nested-logrus-formatter v1.0.3
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/docker/distribution v2.7.1+incompatible // indirect
@@ -19,13 +20,23 @@ require (
 	github.com/gorilla/mux v1.7.4
 	github.com/gorilla/websocket v1.4.2
 	github.com/jmoiron/sqlx v1.2.0
+	github.com/klauspost/compress v1.11.3
 	github.com/lithammer/shortuuid v3.0.0+incompatible
 	github.com/mattn/go-sqlite3 v1.14.0 // indirect
+	github.com/minio/minio-go/v7 v7.0.5
 	github.com/pkg/errors v0.9.1
+	github.com/pkg/sftp v1.12.0
+	github.com/prometheus/client_golang v1.7.1
 	github.com/rs/zerolog v1.19.0
 	github.com/sirupsen/logrus v1.4.2
 	github.com/spf13/viper v1.6.2
 	github.com/stretchr/testify v1.6.1
 	github.com/thoas/go-funk v0.7.0
-	golang.org/x/crypto v0.0.0-20200709230013-948cd5f35899
+	go.opentelemetry.io/otel v0.13.0
+	go.opentelemetry.io/otel/exporters/otlp v0.13.0
+	go.opentelemetry.io/otel/sdk v0.13.0
+	golang.org/x/crypto v0.0.0-20200820211705-5c72a883971a
+	k8s.io/api v0.19.2
+	k8s.io/apimachinery v0.19.2
+	k8s.io/client-go v0.19.2
 )
@@ -33,6 +33,7 @@ func FindUserAndCreateToken(email, password string, repo *persistence.UserRepo)
 		UserID: user.ID,
 		Name:   user.Name,
 		Email:  user.Email,
+		Admin:  user.Admin,
 		StandardClaims: &jwt.StandardClaims{
 			ExpiresAt: expiresAt,
 		},
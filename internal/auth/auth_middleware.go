@@ -2,6 +2,7 @@ package auth
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fp-dynamic-elements-manager-controller/api/util"
 	"fp-dynamic-elements-manager-controller/internal/auth/structs"
@@ -12,6 +13,14 @@ import (
 	"strings"
 )
 
+const userContextKey = "user"
+
+// UserFromContext returns the token claims of the user authenticated by JwtVerify
+func UserFromContext(ctx context.Context) (*structs.Token, bool) {
+	tk, ok := ctx.Value(userContextKey).(*structs.Token)
+	return tk, ok
+}
+
 func JwtVerify(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var header = r.Header.Get("x-access-token") //Grab the token from the header
@@ -49,7 +58,7 @@ func JwtVerify(next http.Handler) http.Handler {
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), "user", tk)
+		ctx := context.WithValue(r.Context(), userContextKey, tk)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -79,3 +88,42 @@ func InternalAuthVerify(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// MetricsAuthVerify protects the metrics endpoint using the auth mode set in METRICS_AUTH. The default, token, expects
+// an Authorization: Bearer header holding METRICS_TOKEN (or the internal token if that is not set) so Prometheus can
+// scrape without logging in, jwt uses the same auth as the /api endpoints and none leaves the endpoint open
+func MetricsAuthVerify(next http.Handler) http.Handler {
+	jwtVerified := JwtVerify(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch strings.ToLower(os.Getenv("METRICS_AUTH")) {
+		case "none":
+			next.ServeHTTP(w, r)
+			return
+		case "jwt":
+			jwtVerified.ServeHTTP(w, r)
+			return
+		}
+
+		header := strings.TrimSpace(r.Header.Get("Authorization"))
+
+		if !strings.HasPrefix(header, "Bearer ") {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(util.HttpResponse{Status: http.StatusForbidden, Message: "Missing auth token"})
+			return
+		}
+
+		token := os.Getenv("METRICS_TOKEN")
+		if token == "" {
+			token = viper.GetString("internaltoken")
+		}
+
+		given := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+		if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(util.HttpResponse{Status: http.StatusUnauthorized, Message: "Unauthorized: Incorrect credentials"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
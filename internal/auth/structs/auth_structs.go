@@ -5,6 +5,15 @@ import (
 	"time"
 )
 
+type Role string
+
+const (
+	// AnyRole is used where any authenticated user is allowed
+	AnyRole Role = ""
+	Viewer  Role = "viewer"
+	Admin   Role = "admin"
+)
+
 type User struct {
 	ID        uint       `json:"id"`
 	CreatedAt time.Time  `json:"created_at" db:"created_at"`
@@ -16,6 +25,25 @@ type User struct {
 	Admin     bool   `json:"admin"`
 }
 
+// Role returns the role granted to the holder of the token
+func (t *Token) Role() Role {
+	if t.Admin {
+		return Admin
+	}
+	return Viewer
+}
+
+// Allows reports whether the token grants at least the required role
+func (t *Token) Allows(required Role) bool {
+	switch required {
+	case AnyRole, Viewer:
+		return true
+	case Admin:
+		return t.Admin
+	}
+	return false
+}
+
 type ApiUser struct {
 	ID        uint       `json:"-"`
 	CreatedAt time.Time  `json:"-" db:"created_at"`
@@ -31,5 +59,6 @@ type Token struct {
 	UserID              uint   `json:"user_id"`
 	Name                string `json:"name"`
 	Email               string `json:"email"`
+	Admin               bool   `json:"admin"`
 	*jwt.StandardClaims `json:"standard_claims"`
 }
@@ -1,15 +1,25 @@
 package backup
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	structs2 "fp-dynamic-elements-manager-controller/internal/backup/structs"
 	"fp-dynamic-elements-manager-controller/internal/db/persistence"
-	"fp-dynamic-elements-manager-controller/internal/docker"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
 	"fp-dynamic-elements-manager-controller/internal/logging/structs"
+	"fp-dynamic-elements-manager-controller/internal/notification"
+	"fp-dynamic-elements-manager-controller/internal/orchestrator"
+	"fp-dynamic-elements-manager-controller/internal/secrets"
 	"github.com/go-co-op/gocron"
-	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -25,28 +35,67 @@ type Provider interface {
 	Backup(string) error
 	Restore(string) error
 	List() ([]structs2.History, error)
+	Storage() (structs2.Storage, error)
+	StartTargets(context.Context)
+	Targets() []structs2.TargetStatus
+	ListTarget(context.Context, string) ([]structs2.RemoteBackup, error)
+	SyncTarget(context.Context, string) error
+	RestoreFromTarget(context.Context, string, string) error
 }
 
+var (
+	// ErrNoKey is returned when an encrypted backup is written or restored without the key it needs
+	ErrNoKey = errors.New("no key is configured for the backup encryption")
+	// ErrChecksumMismatch is returned when a backup does not match the checksums in its manifest
+	ErrChecksumMismatch = errors.New("the backup does not match its manifest checksum")
+	// ErrSchemaMismatch is returned when a backup was taken at another schema migration version than the DB is at
+	ErrSchemaMismatch = errors.New("the backup was taken at another schema version")
+	// ErrInvalidManifest is returned when a manifest names a dump which is not a plain file name, so a manifest read
+	// from a target cannot point a restore outside of the backup's directory
+	ErrInvalidManifest = errors.New("the backup manifest names an invalid file")
+)
+
+// manifestFile is the name of the manifest written next to the dump
+const manifestFile = "manifest.json"
+
+// DatabaseBackupProvider backs up the DB by exporting its tables over the controller's own connection, the dump is
+// compressed, encrypted and committed to the backup repository with a manifest describing it. Backups holding a
+// mysqldump script, taken by earlier versions, are restored by running the mysql client in the database service
+// through the orchestrator. Backups are copied to the off-site targets, the lock keeps backups and restores from
+// running over each other and over a backup being copied
 type DatabaseBackupProvider struct {
-	repo      persistence.ElementRepo
-	docker    docker.Dockers
+	mu        sync.Mutex
+	cfg       Config
+	repo      persistence.DatabaseDump
+	orch      orchestrator.Orchestrator
+	service   string
+	keyring   *secrets.Keyring
 	logger    *structs.AppLogger
 	committer HistoryCommitter
 	scheduler *gocron.Scheduler
+	targets   []*Target
 }
 
 func NewDatabaseBackupProvider(
-	docker docker.Dockers,
+	cfg Config,
+	orch orchestrator.Orchestrator,
+	service string,
 	committer HistoryCommitter,
+	keyring *secrets.Keyring,
 	logger *structs.AppLogger,
-	repo persistence.ElementRepo,
+	repo persistence.DatabaseDump,
+	targets []*Target,
 ) *DatabaseBackupProvider {
 	p := &DatabaseBackupProvider{
+		cfg:       cfg,
 		repo:      repo,
-		docker:    docker,
+		orch:      orch,
+		service:   service,
+		keyring:   keyring,
 		logger:    logger,
 		committer: committer,
 		scheduler: gocron.NewScheduler(time.UTC),
+		targets:   targets,
 	}
 
 	return p
@@ -66,41 +115,382 @@ func (d *DatabaseBackupProvider) StartAutoBackup(schedule Schedule) error {
 }
 
 func (d *DatabaseBackupProvider) Backup(message string) error {
-	err := d.docker.RunDatabaseDump()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	manifest, err := d.dump()
 	if err != nil {
 		return err
 	}
-	count, err := d.repo.GetTotalElementCount()
-	if err != nil {
-		return err
+	var count int64
+	for _, table := range manifest.Tables {
+		if table.Name == persistence.ElementsTable {
+			count = table.Rows
+		}
 	}
 	err = d.committer.Commit(message, count)
 	if err != nil {
 		return err
 	}
+	d.prune()
 	return nil
 }
 
+// prune applies the retention policy to the history. The backup has been taken by the time it runs, so a failure is
+// logged rather than failing the backup
+func (d *DatabaseBackupProvider) prune() {
+	if !d.cfg.Retention.Enabled() {
+		return
+	}
+	history, err := d.committer.ListHistory()
+	if err != nil {
+		d.logger.SystemLogger.Error(err, "error listing the backups to prune")
+		return
+	}
+	kept := d.cfg.Retention.keep(history, time.Now())
+	if len(kept) == len(history) {
+		return
+	}
+	var keep []string
+	for _, h := range history {
+		if kept[h.Hash] {
+			keep = append(keep, h.Hash)
+		}
+	}
+	removed, err := d.committer.Compact(keep)
+	if err != nil {
+		d.logger.SystemLogger.Error(err, "error pruning the backup history")
+		return
+	}
+	d.logger.SystemLogger.Info(fmt.Sprintf("pruned %d backups, %d are kept", removed, len(keep)))
+}
+
+// Restore checks out the backup, verifies it against its manifest and loads it into the DB. Nothing is loaded unless
+// the checksums match and the backup was taken at the schema version the DB is at. Backups taken before manifests
+// were written are loaded as they are
 func (d *DatabaseBackupProvider) Restore(commitHash string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	err := d.committer.RestoreToPoint(commitHash)
 	if err != nil {
 		d.logger.SystemLogger.Error(err, fmt.Sprintf("error rolling back to commit: %s", commitHash))
 		return err
 	}
-	d.docker.RunDatabaseRestore()
+
+	manifest, err := readManifest(backupDir())
+	if os.IsNotExist(err) {
+		err = d.restoreLegacy()
+		if err != nil {
+			d.logger.SystemLogger.Error(err, fmt.Sprintf("error restoring the database to commit: %s", commitHash))
+		}
+		return err
+	}
+	if err == nil {
+		err = d.verify(backupDir(), manifest)
+	}
+	if err == nil {
+		err = d.restore(backupDir(), manifest)
+	}
+	if err != nil {
+		d.logger.SystemLogger.Error(err, fmt.Sprintf("error restoring the database to commit: %s", commitHash))
+		return err
+	}
+	d.checkRows(manifest)
+	return nil
+}
+
+// tables returns the tables backed up by the profile
+func (d *DatabaseBackupProvider) tables(profile structs2.Profile) ([]string, error) {
+	if profile == structs2.FullProfile {
+		return d.repo.GetTables()
+	}
+	return []string{persistence.ElementsTable}, nil
+}
+
+// dump writes a dump of the profile's tables to the backup file and writes its manifest, a failed dump leaves the
+// previous backup as it was
+func (d *DatabaseBackupProvider) dump() (structs2.Manifest, error) {
+	tables, err := d.tables(d.cfg.Profile)
+	if err != nil {
+		return structs2.Manifest{}, err
+	}
+	version, err := d.repo.GetSchemaVersion()
+	if err != nil {
+		return structs2.Manifest{}, err
+	}
+	manifest := structs2.Manifest{
+		Version:       structs2.ManifestVersion,
+		CreatedAt:     time.Now().UTC(),
+		Profile:       d.cfg.Profile,
+		Compression:   d.cfg.Compression,
+		Encryption:    d.cfg.Encryption,
+		Format:        structs2.NativeFormat,
+		SchemaVersion: version,
+		File:          os.Getenv("DB_BACKUP_FILE") + extension(d.cfg.Compression, d.cfg.Encryption),
+	}
+
+	path := filepath.Join(backupDir(), manifest.File)
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return structs2.Manifest{}, err
+	}
+	defer os.Remove(path + ".tmp")
+
+	err = d.write(file, &manifest, tables)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		d.logger.SystemLogger.Error(err, "error dumping the database")
+		return structs2.Manifest{}, err
+	}
+	if err = os.Rename(path+".tmp", path); err != nil {
+		return structs2.Manifest{}, err
+	}
+
+	// a dump written with another compression or encryption is no longer part of the backup
+	for _, ext := range extensions() {
+		if name := os.Getenv("DB_BACKUP_FILE") + ext; name != manifest.File {
+			os.Remove(filepath.Join(backupDir(), name))
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return structs2.Manifest{}, err
+	}
+	if err = ioutil.WriteFile(filepath.Join(backupDir(), manifestFile), data, 0644); err != nil {
+		return structs2.Manifest{}, err
+	}
+	return manifest, nil
+}
+
+// write streams the dump through the compressor and the encrypter to the file, recording the rows of each table and
+// the checksums of the dump and the file
+func (d *DatabaseBackupProvider) write(file io.Writer, manifest *structs2.Manifest, tables []string) error {
+	fileSum, dumpSum := newChecksum(), newChecksum()
+	encrypter, err := d.encrypter(io.MultiWriter(file, fileSum), manifest)
+	if err != nil {
+		return err
+	}
+	compressor, err := compressor(encrypter, d.cfg.Compression)
+	if err != nil {
+		return err
+	}
+
+	done := 0
+	manifest.Tables, err = exportTables(context.Background(), d.repo, tables, io.MultiWriter(compressor, dumpSum), func(table structs2.TableRows) {
+		done++
+		d.progress(notification.BackupProgress, fmt.Sprintf("Backed up table %s (%d of %d), %d rows", table.Name, done, len(tables), table.Rows), table.Name)
+	})
+	if err != nil {
+		return err
+	}
+	if err = compressor.Close(); err != nil {
+		return err
+	}
+	if err = encrypter.Close(); err != nil {
+		return err
+	}
+
+	manifest.Size = fileSum.size
+	manifest.SHA256 = fileSum.Sum()
+	manifest.DumpSHA256 = dumpSum.Sum()
+	return nil
+}
+
+// progress reports how far a backup or restore has got to the client
+func (d *DatabaseBackupProvider) progress(state notification.State, msg, table string) {
+	d.logger.SystemLogger.Debug(msg)
+	d.logger.NotificationService.Send(notification.Event{
+		EventType: notification.Info,
+		Value:     msg,
+		Context: notification.EventContext{
+			Type:       notification.Backup,
+			Identifier: table,
+			State:      state,
+		},
+	})
+}
+
+// verify checks the backup file in dir and the dump it holds against the manifest, and that the DB is at the schema
+// version the backup was taken at
+func (d *DatabaseBackupProvider) verify(dir string, manifest structs2.Manifest) error {
+	version, err := d.repo.GetSchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version != manifest.SchemaVersion {
+		return fmt.Errorf("%w: the backup is at version %d and the database at %d", ErrSchemaMismatch, manifest.SchemaVersion, version)
+	}
+
+	file, err := os.Open(filepath.Join(dir, manifest.File))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	fileSum := newChecksum()
+	if _, err = io.Copy(fileSum, file); err != nil {
+		return err
+	}
+	if fileSum.Sum() != manifest.SHA256 {
+		return fmt.Errorf("%w: %s", ErrChecksumMismatch, manifest.File)
+	}
+
+	dump, err := d.open(dir, manifest)
+	if err != nil {
+		return err
+	}
+	defer dump.Close()
+	dumpSum := newChecksum()
+	if _, err = io.Copy(dumpSum, dump); err != nil {
+		return err
+	}
+	if dumpSum.Sum() != manifest.DumpSHA256 {
+		return fmt.Errorf("%w: the dump in %s", ErrChecksumMismatch, manifest.File)
+	}
 	return nil
 }
 
+// open returns the dump held in the backup file in dir, decrypted and decompressed
+func (d *DatabaseBackupProvider) open(dir string, manifest structs2.Manifest) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.Join(dir, manifest.File))
+	if err != nil {
+		return nil, err
+	}
+	decrypted, err := d.decrypter(file, manifest)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	dump, err := decompressor(decrypted, manifest.Compression)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &dumpReader{ReadCloser: dump, file: file}, nil
+}
+
+// restore loads the dump of the backup in dir into the database
+func (d *DatabaseBackupProvider) restore(dir string, manifest structs2.Manifest) error {
+	dump, err := d.open(dir, manifest)
+	if err != nil {
+		return err
+	}
+	defer dump.Close()
+	if manifest.Format != structs2.NativeFormat {
+		return d.load(dump)
+	}
+
+	// only the tables of the backup's profile which the DB already has can be restored
+	tables, err := d.tables(manifest.Profile)
+	if err != nil {
+		return err
+	}
+	done := 0
+	_, err = importTables(context.Background(), d.repo, dump, tables, func(table structs2.TableRows) {
+		done++
+		d.progress(notification.RestoreProgress, fmt.Sprintf("Restored table %s (%d of %d), %d rows", table.Name, done, len(manifest.Tables), table.Rows), table.Name)
+	})
+	return err
+}
+
+// restoreLegacy loads a mysqldump script written before backups had a manifest
+func (d *DatabaseBackupProvider) restoreLegacy() error {
+	file, err := os.Open(filepath.Join(backupDir(), os.Getenv("DB_BACKUP_FILE")))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return d.load(file)
+}
+
+// load runs a mysqldump script with the mysql client in the database service. The credentials are expanded from the
+// service's own environment, so the password is neither sent by the controller nor on the client's command line
+func (d *DatabaseBackupProvider) load(dump io.Reader) error {
+	cmd := `MYSQL_PWD="$MYSQL_PASSWORD" mysql -u "$MYSQL_USER" "$MYSQL_DATABASE"`
+	return d.orch.Exec(context.Background(), d.service, []string{"/bin/sh", "-c", cmd}, dump, ioutil.Discard)
+}
+
+// checkRows warns about any table which does not hold the rows the manifest recorded. The rows are counted just
+// before the dump is taken, so a table written to in between can differ without the restore having gone wrong
+func (d *DatabaseBackupProvider) checkRows(manifest structs2.Manifest) {
+	for _, table := range manifest.Tables {
+		rows, err := d.repo.CountRows(table.Name)
+		if err != nil {
+			d.logger.SystemLogger.Error(err, fmt.Sprintf("error counting the rows of %s", table.Name))
+			continue
+		}
+		if rows != table.Rows {
+			d.logger.SystemLogger.Warn(fmt.Sprintf("table %s holds %d rows after the restore, the backup recorded %d", table.Name, rows, table.Rows))
+		}
+	}
+}
+
+func readManifest(dir string) (manifest structs2.Manifest, err error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return
+	}
+	if !plainFileName(manifest.File) {
+		err = fmt.Errorf("%w: '%s'", ErrInvalidManifest, manifest.File)
+	}
+	return
+}
+
+// plainFileName is true when name is a single path element other than the manifest itself
+func plainFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && name != manifestFile && !strings.ContainsAny(name, "/\\\x00")
+}
+
+func backupDir() string {
+	return os.Getenv("DB_BACKUP_DIR")
+}
+
+type dumpReader struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (r *dumpReader) Close() error {
+	r.ReadCloser.Close()
+	return r.file.Close()
+}
+
 func (d *DatabaseBackupProvider) List() ([]structs2.History, error) {
 	return d.committer.ListHistory()
 }
 
+// Storage returns the disk space used by the backup directory and the number of backups held in its history
+func (d *DatabaseBackupProvider) Storage() (storage structs2.Storage, err error) {
+	history, err := d.committer.ListHistory()
+	if err != nil {
+		return
+	}
+	storage.Backups = len(history)
+
+	dir := filepath.Clean(backupDir())
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		storage.TotalBytes += info.Size()
+		if strings.HasPrefix(path, filepath.Join(dir, ".git")+string(filepath.Separator)) {
+			storage.HistoryBytes += info.Size()
+		}
+		return nil
+	})
+	return
+}
+
 func writeSchedule(schedule Schedule) {
 	viper.Set("dayofweek", schedule.DayOfWeek)
 	viper.Set("timeofday", schedule.TimeOfDay)
 
 	if err := viper.WriteConfig(); err != nil {
-		log.Error().Err(err).Msg("error writing schedule")
+		applog.System().Error(err, "error writing schedule")
 	}
 }
 
@@ -1,106 +1,491 @@
 package backup
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"filippo.io/age"
+	"fmt"
 	"fp-dynamic-elements-manager-controller/internal/backup/mocks"
+	structs2 "fp-dynamic-elements-manager-controller/internal/backup/structs"
+	"fp-dynamic-elements-manager-controller/internal/db/persistence"
 	"fp-dynamic-elements-manager-controller/internal/logging/structs"
+	"fp-dynamic-elements-manager-controller/internal/secrets"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
+const dump = "CREATE TABLE `list_elements` (`id` int);\nINSERT INTO `list_elements` VALUES (1),(2),(3),(4),(5);\n"
+
+var elements = mocks.TableData{
+	Table: persistence.Table{Name: "list_elements", Create: "CREATE TABLE `list_elements` (`id` int, `value` varchar(255))", Columns: []string{"id", "value"}},
+	Rows: [][]interface{}{
+		{[]byte("1"), []byte("example.com")},
+		{[]byte("2"), []byte("10.0.0.1")},
+		{[]byte("3"), nil},
+		{[]byte("4"), []byte("évian.fr")},
+		{[]byte("5"), []byte("<script>")},
+	},
+}
+
 type BackupRestoreTestSuite struct {
 	suite.Suite
+	logger    *structs.AppLogger
+	loggerObj *mocks.LoggerMock
+	backupDir string
 }
 
-func (b *BackupRestoreTestSuite) TestDatabaseBackupProvider_Backup() {
-	loggerObj := new(mocks.LoggerMock)
+func (b *BackupRestoreTestSuite) SetupTest() {
+	b.loggerObj = new(mocks.LoggerMock)
+	b.loggerObj.On("Error", mock.Anything, mock.Anything)
+	b.loggerObj.On("Warn", mock.Anything)
+	b.loggerObj.On("Debug", mock.Anything)
 	nsObj := new(mocks.NSMock)
-	logger := &structs.AppLogger{
-		UserLogger:          loggerObj,
-		SystemLogger:        loggerObj,
+	nsObj.On("Send")
+	b.logger = &structs.AppLogger{
+		UserLogger:          b.loggerObj,
+		SystemLogger:        b.loggerObj,
 		NotificationService: nsObj,
 	}
+	var err error
+	b.backupDir, err = ioutil.TempDir("", "db-backup")
+	b.Require().Nil(err)
+	os.Setenv("DB_BACKUP_DIR", b.backupDir+"/")
+	os.Setenv("DB_BACKUP_FILE", "elements.sql")
+}
+
+func (b *BackupRestoreTestSuite) TearDownTest() {
+	os.RemoveAll(b.backupDir)
+	os.Unsetenv("DB_BACKUP_DIR")
+	os.Unsetenv("DB_BACKUP_FILE")
+}
+
+func (b *BackupRestoreTestSuite) repo() *mocks.RepoMock {
+	repoObj := &mocks.RepoMock{Exported: []mocks.TableData{elements}}
+	repoObj.On("GetSchemaVersion").Return(15, nil)
+	repoObj.On("CountRows", "list_elements").Return(5, nil)
+	repoObj.On("ExportTables", []string{"list_elements"}).Return(nil)
+	repoObj.On("ImportTables").Return(nil)
+	return repoObj
+}
+
+func (b *BackupRestoreTestSuite) TestDatabaseBackupProvider_Backup() {
 	b.T().Run("Test Database Backup Provider Run (Backup) - No Errors", func(t *testing.T) {
-		dockerObj := new(mocks.DockerMock)
-		repoObj := new(mocks.RepoMock)
+		orchObj := new(mocks.OrchestratorMock)
+		repoObj := b.repo()
 		committerObj := new(mocks.CommitterMock)
 		// setup expectations
-		dockerObj.On("RunDatabaseDump").Times(1).Return(nil)
-		repoObj.On("GetTotalElementCount").Times(1).Return(5, nil)
-		committerObj.On("Commit", int64(5)).Times(1).Return(nil)
+		committerObj.On("Commit", "Manual", int64(5)).Times(1).Return(nil)
 
-		provider := NewDatabaseBackupProvider(dockerObj, committerObj, logger, repoObj)
+		provider := NewDatabaseBackupProvider(DefaultConfig(), orchObj, "mariadb", committerObj, nil, b.logger, repoObj, nil)
 
-		assert.Nil(b.T(), provider.Backup("Manual"))
+		assert.Nil(t, provider.Backup("Manual"))
 
-		dockerObj.AssertCalled(b.T(), "RunDatabaseDump")
-		repoObj.AssertCalled(b.T(), "GetTotalElementCount")
-		committerObj.AssertCalled(b.T(), "Commit", int64(5))
+		// the tables are exported over the controller's connection, nothing is run in the database service
+		orchObj.AssertNotCalled(t, "Exec", mock.Anything, mock.Anything, mock.Anything)
+		repoObj.AssertCalled(t, "ExportTables", []string{"list_elements"})
+		committerObj.AssertCalled(t, "Commit", "Manual", int64(5))
+
+		manifest, err := readManifest(b.backupDir)
+		assert.Nil(t, err)
+		assert.Equal(t, structs2.ManifestVersion, manifest.Version)
+		assert.Equal(t, structs2.NativeFormat, manifest.Format)
+		assert.Equal(t, "elements.sql.gz", manifest.File)
+		assert.Equal(t, uint(15), manifest.SchemaVersion)
+		assert.Equal(t, []structs2.TableRows{{Name: "list_elements", Rows: 5}}, manifest.Tables)
+		assert.NotEmpty(t, manifest.SHA256)
+		assert.NotEqual(t, manifest.SHA256, manifest.DumpSHA256)
 
 		// assert that the expectations were met
-		repoObj.AssertExpectations(b.T())
-		repoObj.AssertExpectations(b.T())
-		committerObj.AssertExpectations(b.T())
+		committerObj.AssertExpectations(t)
 	})
 
-	b.T().Run("Test Database Backup Provider Run (Backup) - Docker Error", func(t *testing.T) {
-		dockerObj := new(mocks.DockerMock)
-		repoObj := new(mocks.RepoMock)
+	b.T().Run("Test Database Backup Provider Run (Backup) - Export Error", func(t *testing.T) {
+		orchObj := new(mocks.OrchestratorMock)
+		repoObj := &mocks.RepoMock{Exported: []mocks.TableData{elements}}
 		committerObj := new(mocks.CommitterMock)
 		// setup expectations
-		dockerObj.On("RunDatabaseDump").Times(1).Return(errors.New("docker error"))
-
-		provider := NewDatabaseBackupProvider(dockerObj, committerObj, logger, repoObj)
+		repoObj.On("GetSchemaVersion").Return(15, nil)
+		repoObj.On("ExportTables", []string{"list_elements"}).Times(1).Return(errors.New("export error"))
 
-		assert.NotNil(b.T(), provider.Backup("Manual"))
+		provider := NewDatabaseBackupProvider(DefaultConfig(), orchObj, "mariadb", committerObj, nil, b.logger, repoObj, nil)
 
-		repoObj.AssertNotCalled(b.T(), "GetTotalElementCount", int64(5))
-		committerObj.AssertNotCalled(b.T(), "Commit")
+		assert.NotNil(t, provider.Backup("Manual"))
 
-		// assert that the expectations were met
-		repoObj.AssertExpectations(b.T())
-		committerObj.AssertExpectations(b.T())
+		committerObj.AssertNotCalled(t, "Commit", mock.Anything, mock.Anything)
+		// the backup written by the previous test is left as it was
+		manifest, err := readManifest(b.backupDir)
+		assert.Nil(t, err)
+		assert.Nil(t, provider.verify(b.backupDir, manifest))
 	})
 
 	b.T().Run("Test Database Backup Provider (Backup) - Commit Error", func(t *testing.T) {
-		dockerObj := new(mocks.DockerMock)
-		repoObj := new(mocks.RepoMock)
+		orchObj := new(mocks.OrchestratorMock)
+		repoObj := b.repo()
 		committerObj := new(mocks.CommitterMock)
 		// setup expectations
-		dockerObj.On("RunDatabaseDump").Times(1).Return(nil)
-		repoObj.On("GetTotalElementCount").Times(1).Return(5, nil)
-		committerObj.On("Commit", int64(5)).Times(1).Return(errors.New("commit error"))
-
-		provider := NewDatabaseBackupProvider(dockerObj, committerObj, logger, repoObj)
+		committerObj.On("Commit", "Manual", int64(5)).Times(1).Return(errors.New("commit error"))
 
-		assert.NotNil(b.T(), provider.Backup("Manual"))
+		provider := NewDatabaseBackupProvider(DefaultConfig(), orchObj, "mariadb", committerObj, nil, b.logger, repoObj, nil)
 
-		committerObj.AssertNotCalled(b.T(), "Commit")
+		assert.NotNil(t, provider.Backup("Manual"))
 
 		// assert that the expectations were met
-		committerObj.AssertExpectations(b.T())
+		committerObj.AssertExpectations(t)
 	})
 
 	b.T().Run("Test Database Backup Provider (Backup) - Repo Error", func(t *testing.T) {
-		dockerObj := new(mocks.DockerMock)
+		orchObj := new(mocks.OrchestratorMock)
 		repoObj := new(mocks.RepoMock)
 		committerObj := new(mocks.CommitterMock)
 
 		// setup expectations
-		dockerObj.On("RunDatabaseDump").Times(1).Return(nil)
-		repoObj.On("GetTotalElementCount").Times(1).Return(5, errors.New("repo error"))
+		repoObj.On("GetSchemaVersion").Return(0, errors.New("repo error"))
 
-		provider := NewDatabaseBackupProvider(dockerObj, committerObj, logger, repoObj)
+		provider := NewDatabaseBackupProvider(DefaultConfig(), orchObj, "mariadb", committerObj, nil, b.logger, repoObj, nil)
 
-		assert.NotNil(b.T(), provider.Backup("Manual"))
+		assert.NotNil(t, provider.Backup("Manual"))
 
-		repoObj.AssertCalled(b.T(), "GetTotalElementCount")
-		committerObj.AssertNotCalled(b.T(), "Commit", int64(5))
+		repoObj.AssertNotCalled(t, "ExportTables", mock.Anything)
+		committerObj.AssertNotCalled(t, "Commit", mock.Anything, mock.Anything)
+	})
+}
 
-		// assert that the expectations were met
-		committerObj.AssertExpectations(b.T())
+func (b *BackupRestoreTestSuite) TestDatabaseBackupProvider_FullProfile() {
+	orchObj := new(mocks.OrchestratorMock)
+	repoObj := &mocks.RepoMock{Exported: []mocks.TableData{
+		elements,
+		{Table: persistence.Table{Name: "modules", Create: "CREATE TABLE `modules` (`id` int)", Columns: []string{"id"}}, Rows: [][]interface{}{{int64(1)}, {int64(2)}}},
+		{Table: persistence.Table{Name: "users", Create: "CREATE TABLE `users` (`id` int)", Columns: []string{"id"}}, Rows: [][]interface{}{{int64(1)}}},
+	}}
+	committerObj := new(mocks.CommitterMock)
+	repoObj.On("GetSchemaVersion").Return(15, nil)
+	repoObj.On("GetTables").Return([]string{"list_elements", "modules", "users"}, nil)
+	repoObj.On("ExportTables", []string{"list_elements", "modules", "users"}).Return(nil)
+	committerObj.On("Commit", "Manual", int64(5)).Return(nil)
+
+	cfg := DefaultConfig()
+	cfg.Profile = structs2.FullProfile
+	cfg.Compression = structs2.Zstd
+	provider := NewDatabaseBackupProvider(cfg, orchObj, "mariadb", committerObj, nil, b.logger, repoObj, nil)
+
+	b.Nil(provider.Backup("Manual"))
+
+	manifest, err := readManifest(b.backupDir)
+	b.Nil(err)
+	b.Equal("elements.sql.zst", manifest.File)
+	b.Equal([]structs2.TableRows{{Name: "list_elements", Rows: 5}, {Name: "modules", Rows: 2}, {Name: "users", Rows: 1}}, manifest.Tables)
+	committerObj.AssertCalled(b.T(), "Commit", "Manual", int64(5))
+}
+
+func (b *BackupRestoreTestSuite) TestDatabaseBackupProvider_RoundTrip() {
+	keyring, err := secrets.NewKeyring(bytes.Repeat([]byte{7}, 32))
+	b.Require().Nil(err)
+	identity, err := age.GenerateX25519Identity()
+	b.Require().Nil(err)
+	identityFile := b.backupDir + "/identity.txt"
+	b.Require().Nil(ioutil.WriteFile(identityFile, []byte(identity.String()+"\n"), 0600))
+
+	// a dump of several segments, so the aes-gcm stream is sealed in more than one
+	now := time.Date(2020, 7, 1, 12, 30, 15, 250000000, time.Local)
+	large := mocks.TableData{Table: persistence.Table{Name: "list_elements", Create: "CREATE TABLE `list_elements` (`id` int, `value` varchar(255), `key` varbinary(32), `updated_at` datetime(3))", Columns: []string{"id", "value", "key", "updated_at"}}}
+	for i := 0; i < 4000; i++ {
+		large.Rows = append(large.Rows, []interface{}{int64(i), []byte(fmt.Sprintf("element-%d.example.com", i)), []byte{0xff, byte(i)}, now})
+	}
+
+	for _, compression := range []structs2.Compression{structs2.NoCompression, structs2.Gzip, structs2.Zstd} {
+		for _, encryption := range []structs2.Encryption{structs2.NoEncryption, structs2.AESGCM, structs2.Age} {
+			b.T().Run(fmt.Sprintf("%s %s", compression, encryption), func(t *testing.T) {
+				orchObj := new(mocks.OrchestratorMock)
+				repoObj := b.repo()
+				repoObj.Exported = []mocks.TableData{large}
+				committerObj := new(mocks.CommitterMock)
+				committerObj.On("Commit", "Manual", int64(4000)).Return(nil)
+				committerObj.On("RestoreToPoint", "abc").Return(nil)
+
+				cfg := Config{
+					Profile:         structs2.ElementsProfile,
+					Compression:     compression,
+					Encryption:      encryption,
+					AgeRecipients:   []string{identity.Recipient().String()},
+					AgeIdentityFile: identityFile,
+				}
+				assert.Nil(t, cfg.Validate(keyring))
+				provider := NewDatabaseBackupProvider(cfg, orchObj, "mariadb", committerObj, keyring, b.logger, repoObj, nil)
+
+				assert.Nil(t, provider.Backup("Manual"))
+				manifest, err := readManifest(b.backupDir)
+				assert.Nil(t, err)
+				assert.Equal(t, "elements.sql"+extension(compression, encryption), manifest.File)
+				stored, err := ioutil.ReadFile(b.backupDir + "/" + manifest.File)
+				assert.Nil(t, err)
+				if encryption != structs2.NoEncryption {
+					assert.NotContains(t, string(stored), "element-1")
+				}
+				// only the dump written by this backup is left
+				files, _ := ioutil.ReadDir(b.backupDir)
+				dumps := 0
+				for _, f := range files {
+					if strings.HasPrefix(f.Name(), "elements.sql") {
+						dumps++
+					}
+				}
+				assert.Equal(t, 1, dumps)
+
+				assert.Nil(t, provider.Restore("abc"))
+				orchObj.AssertNotCalled(t, "Exec", mock.Anything, mock.Anything, mock.Anything)
+				if assert.Len(t, repoObj.Imported, 1) {
+					assert.Equal(t, large.Table, repoObj.Imported[0].Table)
+					assert.Len(t, repoObj.Imported[0].Rows, 4000)
+					// UTF-8 text and numbers are loaded as strings, binary values as bytes and times in the connection's zone
+					assert.Equal(t, []interface{}{"7", "element-7.example.com", []byte{0xff, 7}, "2020-07-01 12:30:15.25"}, repoObj.Imported[0].Rows[7])
+				}
+			})
+		}
+	}
+}
+
+func (b *BackupRestoreTestSuite) TestDatabaseBackupProvider_RestoreVerification() {
+	keyring, err := secrets.NewKeyring(bytes.Repeat([]byte{7}, 32))
+	b.Require().Nil(err)
+	cfg := DefaultConfig()
+	cfg.Encryption = structs2.AESGCM
+
+	backup := func() *DatabaseBackupProvider {
+		orchObj := new(mocks.OrchestratorMock)
+		committerObj := new(mocks.CommitterMock)
+		committerObj.On("Commit", "Manual", int64(5)).Return(nil)
+		provider := NewDatabaseBackupProvider(cfg, orchObj, "mariadb", committerObj, keyring, b.logger, b.repo(), nil)
+		b.Require().Nil(provider.Backup("Manual"))
+		return provider
+	}
+
+	b.T().Run("Tampered file", func(t *testing.T) {
+		backup()
+		manifest, _ := readManifest(b.backupDir)
+		data, _ := ioutil.ReadFile(b.backupDir + "/" + manifest.File)
+		data[len(data)-1] ^= 1
+		ioutil.WriteFile(b.backupDir+"/"+manifest.File, data, 0644)
+
+		repoObj := b.repo()
+		committerObj := new(mocks.CommitterMock)
+		committerObj.On("RestoreToPoint", "abc").Return(nil)
+		provider := NewDatabaseBackupProvider(cfg, new(mocks.OrchestratorMock), "mariadb", committerObj, keyring, b.logger, repoObj, nil)
+
+		assert.True(t, errors.Is(provider.Restore("abc"), ErrChecksumMismatch))
+		repoObj.AssertNotCalled(t, "ImportTables")
 	})
+
+	b.T().Run("Schema mismatch", func(t *testing.T) {
+		backup()
+		repoObj := new(mocks.RepoMock)
+		committerObj := new(mocks.CommitterMock)
+		repoObj.On("GetSchemaVersion").Return(16, nil)
+		committerObj.On("RestoreToPoint", "abc").Return(nil)
+		provider := NewDatabaseBackupProvider(cfg, new(mocks.OrchestratorMock), "mariadb", committerObj, keyring, b.logger, repoObj, nil)
+
+		assert.True(t, errors.Is(provider.Restore("abc"), ErrSchemaMismatch))
+		repoObj.AssertNotCalled(t, "ImportTables")
+	})
+
+	b.T().Run("Other master key", func(t *testing.T) {
+		backup()
+		other, _ := secrets.NewKeyring(bytes.Repeat([]byte{8}, 32))
+		repoObj := b.repo()
+		committerObj := new(mocks.CommitterMock)
+		committerObj.On("RestoreToPoint", "abc").Return(nil)
+		provider := NewDatabaseBackupProvider(cfg, new(mocks.OrchestratorMock), "mariadb", committerObj, other, b.logger, repoObj, nil)
+
+		assert.True(t, errors.Is(provider.Restore("abc"), secrets.ErrWrongMasterKey))
+		repoObj.AssertNotCalled(t, "ImportTables")
+	})
+
+	b.T().Run("Row count differs", func(t *testing.T) {
+		backup()
+		repoObj := new(mocks.RepoMock)
+		committerObj := new(mocks.CommitterMock)
+		repoObj.On("GetSchemaVersion").Return(15, nil)
+		repoObj.On("CountRows", "list_elements").Return(4, nil)
+		repoObj.On("ImportTables").Return(nil)
+		committerObj.On("RestoreToPoint", "abc").Return(nil)
+		provider := NewDatabaseBackupProvider(cfg, new(mocks.OrchestratorMock), "mariadb", committerObj, keyring, b.logger, repoObj, nil)
+
+		assert.Nil(t, provider.Restore("abc"))
+		b.loggerObj.AssertCalled(t, "Warn", "table list_elements holds 4 rows after the restore, the backup recorded 5")
+	})
+}
+
+func (b *BackupRestoreTestSuite) TestDatabaseBackupProvider_RestoreLegacy() {
+	b.Require().Nil(ioutil.WriteFile(b.backupDir+"/elements.sql", []byte(dump), 0644))
+	orchObj := new(mocks.OrchestratorMock)
+	committerObj := new(mocks.CommitterMock)
+	orchObj.On("Exec", mock.Anything, "mariadb", mock.Anything).Return(nil)
+	committerObj.On("RestoreToPoint", "abc").Return(nil)
+	provider := NewDatabaseBackupProvider(DefaultConfig(), orchObj, "mariadb", committerObj, nil, b.logger, new(mocks.RepoMock), nil)
+
+	os.Setenv("MYSQL_PASSWORD", "hunter2")
+	defer os.Unsetenv("MYSQL_PASSWORD")
+
+	b.Nil(provider.Restore("abc"))
+	b.Equal(dump, string(orchObj.Input))
+	// the password is expanded in the database service, not by the controller
+	cmd := orchObj.Calls[0].Arguments.Get(2).([]string)
+	b.NotContains(cmd[2], "hunter2")
+}
+
+func (b *BackupRestoreTestSuite) TestSegmentTruncated() {
+	key, _ := secrets.NewDataKey()
+	var sealed bytes.Buffer
+	w, err := newSegmentWriter(&sealed, key)
+	b.Require().Nil(err)
+	w.Write(bytes.Repeat([]byte("x"), 2*segmentSize+10))
+	b.Nil(w.Close())
+
+	// dropping the last segment leaves a stream which ends on a segment not sealed as the last
+	r, _ := newSegmentReader(bytes.NewReader(sealed.Bytes()[:2*(segmentSize+16)]), key)
+	_, err = ioutil.ReadAll(r)
+	b.Equal(errTruncated, err)
+
+	r, _ = newSegmentReader(bytes.NewReader(sealed.Bytes()), key)
+	plain, err := ioutil.ReadAll(r)
+	b.Nil(err)
+	b.Equal(2*segmentSize+10, len(plain))
+}
+
+func (b *BackupRestoreTestSuite) TestImportTables_OutsideProfile() {
+	users := mocks.TableData{Table: persistence.Table{Name: "users", Create: "CREATE TABLE `users` (`id` int)", Columns: []string{"id"}}}
+	repoObj := &mocks.RepoMock{Exported: []mocks.TableData{elements, users}}
+	repoObj.On("ExportTables", mock.Anything).Return(nil)
+	repoObj.On("ImportTables").Return(nil)
+
+	var dumped bytes.Buffer
+	_, err := exportTables(context.Background(), repoObj, []string{"list_elements", "users"}, &dumped, func(structs2.TableRows) {})
+	b.Require().Nil(err)
+
+	// an elements backup cannot recreate the users table
+	_, err = importTables(context.Background(), repoObj, bytes.NewReader(dumped.Bytes()), []string{"list_elements"}, func(structs2.TableRows) {})
+	b.True(errors.Is(err, ErrUnexpectedTable))
+	if b.Len(repoObj.Imported, 1) {
+		b.Equal("list_elements", repoObj.Imported[0].Table.Name)
+	}
+}
+
+func (b *BackupRestoreTestSuite) TestConfigValidate() {
+	cfg := DefaultConfig()
+	b.Nil(cfg.Validate(nil))
+
+	cfg.Encryption = structs2.AESGCM
+	b.True(errors.Is(cfg.Validate(nil), secrets.ErrNoMasterKey))
+
+	cfg.Encryption = structs2.Age
+	b.NotNil(cfg.Validate(nil))
+	cfg.AgeRecipients = []string{"not-a-recipient"}
+	b.NotNil(cfg.Validate(nil))
+
+	cfg = DefaultConfig()
+	cfg.Compression = "lz4"
+	b.NotNil(cfg.Validate(nil))
+}
+
+func (b *BackupRestoreTestSuite) TestRetention() {
+	at := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2020, month, day, hour, 0, 0, 0, time.Local)
+	}
+	// newest first, the 15th of July 2020 is a Wednesday
+	history := []structs2.History{
+		{Hash: "h0", Time: at(time.July, 15, 10)},
+		{Hash: "h1", Time: at(time.July, 15, 8)},
+		{Hash: "h2", Time: at(time.July, 14, 22)},
+		{Hash: "h3", Time: at(time.July, 14, 10)},
+		{Hash: "h4", Time: at(time.July, 10, 10)},
+		{Hash: "h5", Time: at(time.July, 1, 10)},
+		{Hash: "h6", Time: at(time.June, 10, 10)},
+		{Hash: "h7", Time: at(time.May, 5, 10)},
+		{Hash: "h8", Time: at(time.March, 1, 10)},
+	}
+	now := at(time.July, 15, 12)
+
+	kept := Retention{Daily: 2, Weekly: 2, Monthly: 3}.keep(history, now)
+	b.Equal(map[string]bool{"h0": true, "h2": true, "h4": true, "h6": true, "h7": true}, kept)
+
+	kept = Retention{Last: 2, Daily: 2, Weekly: 2, Monthly: 3}.keep(history, now)
+	b.True(kept["h1"])
+	b.Len(kept, 6)
+
+	// the newest backup is kept by any policy, and a policy of zeros keeps everything
+	b.Equal(map[string]bool{"h0": true}, Retention{Monthly: 1}.keep(history, at(time.August, 1, 0)))
+	b.Len(Retention{}.keep(history, now), len(history))
+}
+
+func (b *BackupRestoreTestSuite) TestDatabaseBackupProvider_Prune() {
+	history := []structs2.History{{Hash: "h0", Time: time.Now()}, {Hash: "h1", Time: time.Now().Add(-time.Minute)}, {Hash: "h2", Time: time.Now().Add(-time.Hour)}}
+	committerObj := new(mocks.CommitterMock)
+	committerObj.On("Commit", "Manual", int64(5)).Return(nil)
+	committerObj.On("ListHistory").Return(history, nil)
+	committerObj.On("Compact", []string{"h0", "h1"}).Return(1, nil)
+	b.loggerObj.On("Info", mock.Anything)
+
+	cfg := DefaultConfig()
+	cfg.Retention = Retention{Last: 2}
+	provider := NewDatabaseBackupProvider(cfg, new(mocks.OrchestratorMock), "mariadb", committerObj, nil, b.logger, b.repo(), nil)
+
+	b.Nil(provider.Backup("Manual"))
+	committerObj.AssertCalled(b.T(), "Compact", []string{"h0", "h1"})
+
+	storage, err := provider.Storage()
+	b.Nil(err)
+	b.Equal(3, storage.Backups)
+	b.True(storage.TotalBytes > 0)
+	b.Equal(int64(0), storage.HistoryBytes)
+}
+
+func (b *BackupRestoreTestSuite) TestGitController_Compact() {
+	controller := NewGitController(b.logger)
+	b.Require().NotNil(controller.repo)
+
+	for i := 0; i < 4; i++ {
+		b.Require().Nil(ioutil.WriteFile(b.backupDir+"/elements.sql", []byte(fmt.Sprintf("backup %d", i)), 0644))
+		b.Require().Nil(ioutil.WriteFile(b.backupDir+"/"+manifestFile, []byte(fmt.Sprintf(`{"version": %d}`, i)), 0644))
+		b.Require().Nil(controller.Commit(fmt.Sprintf("Backup %d", i), int64(i)))
+	}
+	history, err := controller.ListHistory()
+	b.Require().Nil(err)
+	b.Require().Len(history, 4)
+
+	// the blob of a dropped backup, which is deleted once no commit refers to it
+	dropped, err := controller.repo.CommitObject(plumbing.NewHash(history[1].Hash))
+	b.Require().Nil(err)
+	file, err := dropped.File("elements.sql")
+	b.Require().Nil(err)
+
+	_, err = controller.Compact([]string{history[1].Hash})
+	b.NotNil(err, "HEAD must be kept")
+
+	removed, err := controller.Compact([]string{history[0].Hash, history[2].Hash})
+	b.Nil(err)
+	b.Equal(2, removed)
+
+	compacted, err := controller.ListHistory()
+	b.Require().Nil(err)
+	b.Require().Len(compacted, 2)
+	b.True(strings.HasPrefix(compacted[0].Message, "Backup 3"))
+	b.True(strings.HasPrefix(compacted[1].Message, "Backup 1"))
+	b.True(compacted[1].Time.Equal(history[2].Time))
+	_, err = controller.repo.BlobObject(file.Hash)
+	b.NotNil(err)
+
+	// the kept backups hold the files they did before
+	b.Nil(controller.RestoreToPoint(compacted[1].Hash))
+	data, err := ioutil.ReadFile(b.backupDir + "/elements.sql")
+	b.Nil(err)
+	b.Equal("backup 1", string(data))
 }
 
 func TestDatabaseBackupProvider(t *testing.T) {
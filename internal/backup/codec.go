@@ -0,0 +1,326 @@
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"filippo.io/age"
+	"fmt"
+	"fp-dynamic-elements-manager-controller/internal/backup/structs"
+	"fp-dynamic-elements-manager-controller/internal/secrets"
+	"github.com/klauspost/compress/zstd"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// dataKeyLabel is authenticated with the data key of an aes-gcm backup when it is wrapped with the master key
+const dataKeyLabel = "backup"
+
+// segmentSize is the size of the plaintext sealed in each segment of an aes-gcm backup
+const segmentSize = 64 << 10
+
+var errTruncated = errors.New("the encrypted backup is truncated or has been tampered with")
+
+// extension returns the file extension of a dump with the compression and encryption
+func extension(c structs.Compression, e structs.Encryption) string {
+	ext := ""
+	switch c {
+	case structs.Gzip:
+		ext += ".gz"
+	case structs.Zstd:
+		ext += ".zst"
+	}
+	switch e {
+	case structs.AESGCM:
+		ext += ".enc"
+	case structs.Age:
+		ext += ".age"
+	}
+	return ext
+}
+
+// extensions returns the extension of every combination of compression and encryption
+func extensions() []string {
+	var all []string
+	for _, c := range []structs.Compression{structs.NoCompression, structs.Gzip, structs.Zstd} {
+		for _, e := range []structs.Encryption{structs.NoEncryption, structs.AESGCM, structs.Age} {
+			all = append(all, extension(c, e))
+		}
+	}
+	return all
+}
+
+func compressor(w io.Writer, c structs.Compression) (io.WriteCloser, error) {
+	switch c {
+	case structs.NoCompression:
+		return nopWriteCloser{w}, nil
+	case structs.Gzip:
+		return gzip.NewWriter(w), nil
+	case structs.Zstd:
+		return zstd.NewWriter(w)
+	}
+	return nil, fmt.Errorf("invalid backup compression '%s'", c)
+}
+
+func decompressor(r io.Reader, c structs.Compression) (io.ReadCloser, error) {
+	switch c {
+	case structs.NoCompression:
+		return ioutil.NopCloser(r), nil
+	case structs.Gzip:
+		return gzip.NewReader(r)
+	case structs.Zstd:
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zstdReadCloser{d}, nil
+	}
+	return nil, fmt.Errorf("invalid backup compression '%s'", c)
+}
+
+// encrypter returns a writer which encrypts to w as the config says, the key or recipients are recorded in the manifest
+func (d *DatabaseBackupProvider) encrypter(w io.Writer, manifest *structs.Manifest) (io.WriteCloser, error) {
+	switch d.cfg.Encryption {
+	case structs.NoEncryption:
+		return nopWriteCloser{w}, nil
+	case structs.AESGCM:
+		if d.keyring == nil {
+			return nil, fmt.Errorf("aes-gcm backups: %w", ErrNoKey)
+		}
+		key, err := secrets.NewDataKey()
+		if err != nil {
+			return nil, err
+		}
+		wrapped, err := d.keyring.WrapKey(dataKeyLabel, key)
+		if err != nil {
+			return nil, err
+		}
+		manifest.KeyID, manifest.DataKey = d.keyring.ID(), wrapped
+		return newSegmentWriter(w, key)
+	case structs.Age:
+		recipients, err := parseRecipients(d.cfg.AgeRecipients)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Recipients = d.cfg.AgeRecipients
+		return age.Encrypt(w, recipients...)
+	}
+	return nil, fmt.Errorf("invalid backup encryption '%s'", d.cfg.Encryption)
+}
+
+// decrypter returns a reader which decrypts r with the key recorded in the manifest
+func (d *DatabaseBackupProvider) decrypter(r io.Reader, manifest structs.Manifest) (io.Reader, error) {
+	switch manifest.Encryption {
+	case structs.NoEncryption:
+		return r, nil
+	case structs.AESGCM:
+		if d.keyring == nil {
+			return nil, fmt.Errorf("aes-gcm backups: %w", ErrNoKey)
+		}
+		key, err := d.keyring.UnwrapKey(dataKeyLabel, manifest.KeyID, manifest.DataKey)
+		if err != nil {
+			return nil, fmt.Errorf("the backup data key could not be decrypted: %w", err)
+		}
+		return newSegmentReader(r, key)
+	case structs.Age:
+		if d.cfg.AgeIdentityFile == "" {
+			return nil, fmt.Errorf("age backups: %w", ErrNoKey)
+		}
+		file, err := os.Open(d.cfg.AgeIdentityFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		identities, err := age.ParseIdentities(file)
+		if err != nil {
+			return nil, err
+		}
+		return age.Decrypt(r, identities...)
+	}
+	return nil, fmt.Errorf("invalid backup encryption '%s'", manifest.Encryption)
+}
+
+func parseRecipients(keys []string) ([]age.Recipient, error) {
+	recipients := make([]age.Recipient, 0, len(keys))
+	for _, key := range keys {
+		r, err := age.ParseX25519Recipient(strings.TrimSpace(key))
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipient '%s': %w", key, err)
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, nil
+}
+
+// segmentWriter encrypts a stream with AES-256-GCM in segments of segmentSize, so a backup is never held in memory.
+// The nonce of each segment is its counter and a flag set on the last segment, so segments cannot be reordered,
+// dropped or truncated without the restore failing
+type segmentWriter struct {
+	aead    cipher.AEAD
+	w       io.Writer
+	buf     []byte
+	counter uint64
+	closed  bool
+}
+
+func newSegmentWriter(w io.Writer, key []byte) (*segmentWriter, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &segmentWriter{aead: aead, w: w, buf: make([]byte, 0, segmentSize)}, nil
+}
+
+func (s *segmentWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errors.New("write to a closed segment writer")
+	}
+	written := 0
+	for len(p) > 0 {
+		// a full segment is only sealed once more data arrives, the last one is sealed by Close
+		if len(s.buf) == segmentSize {
+			if err := s.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(s.buf[len(s.buf):segmentSize], p)
+		s.buf = s.buf[:len(s.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (s *segmentWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.seal(true)
+}
+
+func (s *segmentWriter) seal(last bool) error {
+	sealed := s.aead.Seal(nil, segmentNonce(s.counter, last), s.buf, nil)
+	s.counter++
+	s.buf = s.buf[:0]
+	_, err := s.w.Write(sealed)
+	return err
+}
+
+type segmentReader struct {
+	aead    cipher.AEAD
+	r       *bufio.Reader
+	buf     []byte
+	plain   []byte
+	counter uint64
+	done    bool
+}
+
+func newSegmentReader(r io.Reader, key []byte) (*segmentReader, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &segmentReader{aead: aead, r: bufio.NewReader(r), buf: make([]byte, segmentSize+aead.Overhead())}, nil
+}
+
+func (s *segmentReader) Read(p []byte) (int, error) {
+	for len(s.plain) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		if err := s.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.plain)
+	s.plain = s.plain[n:]
+	return n, nil
+}
+
+// open reads and decrypts the next segment, a short segment or one followed by the end of the stream is the last
+func (s *segmentReader) open() error {
+	n, err := io.ReadFull(s.r, s.buf)
+	last := false
+	switch err {
+	case nil:
+		if _, err := s.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	case io.EOF, io.ErrUnexpectedEOF:
+		last = true
+	default:
+		return err
+	}
+	plain, err := s.aead.Open(s.buf[:0], segmentNonce(s.counter, last), s.buf[:n], nil)
+	if err != nil {
+		return errTruncated
+	}
+	s.counter++
+	s.plain = plain
+	s.done = last
+	return nil
+}
+
+func segmentNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// checksum counts and hashes everything written to it
+type checksum struct {
+	hash hash.Hash
+	size int64
+}
+
+func newChecksum() *checksum {
+	return &checksum{hash: sha256.New()}
+}
+
+func (c *checksum) Write(p []byte) (int, error) {
+	c.size += int64(len(p))
+	return c.hash.Write(p)
+}
+
+func (c *checksum) Sum() string {
+	return hex.EncodeToString(c.hash.Sum(nil))
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+func (z zstdReadCloser) Close() error {
+	z.Decoder.Close()
+	return nil
+}
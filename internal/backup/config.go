@@ -0,0 +1,106 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"fp-dynamic-elements-manager-controller/internal/backup/structs"
+	"fp-dynamic-elements-manager-controller/internal/secrets"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type Config struct {
+	// Profile is the set of tables backed up
+	Profile structs.Profile
+	// Compression is applied to the dump before it is encrypted
+	Compression structs.Compression
+	// Encryption is applied to the compressed dump, aes-gcm needs the secrets master key and age needs recipients
+	Encryption structs.Encryption
+	// AgeRecipients are the public keys age backups are encrypted to
+	AgeRecipients []string
+	// AgeIdentityFile is the file holding the age identities age backups are decrypted with when they are restored
+	AgeIdentityFile string
+	// Retention is applied to the history after each backup
+	Retention Retention
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Profile:     structs.ElementsProfile,
+		Compression: structs.Gzip,
+		Encryption:  structs.NoEncryption,
+	}
+}
+
+// ConfigFromEnv reads BACKUP_PROFILE, BACKUP_COMPRESSION, BACKUP_ENCRYPTION and the BACKUP_AGE_* keys
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+
+	if v := strings.ToLower(os.Getenv("BACKUP_PROFILE")); v != "" {
+		cfg.Profile = structs.Profile(v)
+	}
+
+	if v := strings.ToLower(os.Getenv("BACKUP_COMPRESSION")); v != "" {
+		cfg.Compression = structs.Compression(v)
+	}
+
+	if v := strings.ToLower(os.Getenv("BACKUP_ENCRYPTION")); v != "" {
+		cfg.Encryption = structs.Encryption(v)
+	}
+
+	for _, r := range strings.Split(os.Getenv("BACKUP_AGE_RECIPIENTS"), ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			cfg.AgeRecipients = append(cfg.AgeRecipients, r)
+		}
+	}
+
+	cfg.AgeIdentityFile = os.Getenv("BACKUP_AGE_IDENTITY_FILE")
+
+	for env, count := range map[string]*int{
+		"BACKUP_KEEP_LAST":    &cfg.Retention.Last,
+		"BACKUP_KEEP_DAILY":   &cfg.Retention.Daily,
+		"BACKUP_KEEP_WEEKLY":  &cfg.Retention.Weekly,
+		"BACKUP_KEEP_MONTHLY": &cfg.Retention.Monthly,
+	} {
+		if n, err := strconv.Atoi(os.Getenv(env)); err == nil {
+			*count = n
+		}
+	}
+
+	return cfg
+}
+
+// Validate checks the config can be used to write a backup, the keyring is the secrets master key which may be nil
+func (c Config) Validate(keyring *secrets.Keyring) error {
+	switch c.Profile {
+	case structs.ElementsProfile, structs.FullProfile:
+	default:
+		return fmt.Errorf("invalid backup profile '%s'", c.Profile)
+	}
+
+	switch c.Compression {
+	case structs.NoCompression, structs.Gzip, structs.Zstd:
+	default:
+		return fmt.Errorf("invalid backup compression '%s'", c.Compression)
+	}
+
+	switch c.Encryption {
+	case structs.NoEncryption:
+	case structs.AESGCM:
+		if keyring == nil {
+			return fmt.Errorf("aes-gcm backups: %w", secrets.ErrNoMasterKey)
+		}
+	case structs.Age:
+		if len(c.AgeRecipients) == 0 {
+			return errors.New("age backups need at least one recipient in BACKUP_AGE_RECIPIENTS")
+		}
+		if _, err := parseRecipients(c.AgeRecipients); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid backup encryption '%s'", c.Encryption)
+	}
+
+	return c.Retention.validate()
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/storage/filesystem"
 	"os"
+	"sync"
 	"time"
 )
 
@@ -19,9 +20,13 @@ type HistoryCommitter interface {
 	Commit(string, int64) error
 	RestoreToPoint(string) error
 	ListHistory() ([]structs2.History, error)
+	Compact([]string) (int, error)
 }
 
+// GitController keeps each backup as a commit, the lock keeps backups, restores and compactions from running over
+// each other
 type GitController struct {
+	mu     sync.Mutex
 	repo   *git.Repository
 	logger *structs.AppLogger
 }
@@ -55,6 +60,8 @@ func initRepo(logger *structs.AppLogger) *git.Repository {
 }
 
 func (g *GitController) Commit(msg string, elementCount int64) (err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
 	if g.repo == nil {
 		g.logger.SystemLogger.Error(errors.New("git repository is nil"), "error getting instance of git repository")
 		return
@@ -65,6 +72,14 @@ func (g *GitController) Commit(msg string, elementCount int64) (err error) {
 		return
 	}
 
+	// the dump's file name changes with its compression and encryption, so new files are added before committing
+	for _, pattern := range []string{os.Getenv("DB_BACKUP_FILE") + "*", manifestFile} {
+		if err = w.AddGlob(pattern); err != nil {
+			g.logger.SystemLogger.Error(err, "error adding files to worktree")
+			return
+		}
+	}
+
 	now := time.Now()
 	_, err = w.Commit(fmt.Sprintf("%s %s : %d Elements", msg, now.Format(time.RFC822), elementCount), &git.CommitOptions{
 		All: true,
@@ -84,6 +99,8 @@ func (g *GitController) Commit(msg string, elementCount int64) (err error) {
 }
 
 func (g *GitController) RestoreToPoint(commitHash string) (err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
 	wt, err := g.repo.Worktree()
 
 	if err != nil {
@@ -120,9 +137,114 @@ func (g *GitController) ListHistory() (commits []structs2.History, err error) {
 		commits = append(commits, structs2.History{
 			Hash:    c.Hash.String(),
 			Message: c.Message,
+			Time:    c.Author.When,
 		})
 		return nil
 	})
 
 	return
 }
+
+// Compact rewrites the history of HEAD to hold only the kept commits, with their trees, messages and authors as they
+// were, and deletes the objects no longer reachable. HEAD must be kept, so the worktree is left as it is. Nothing is
+// deleted unless the rewritten history ends on the tree HEAD has. It returns the number of commits removed
+func (g *GitController) Compact(keep []string) (removed int, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.repo == nil {
+		return 0, errors.New("git repository is nil")
+	}
+	removed, err = compactHistory(g.repo, keep)
+	if err != nil {
+		g.logger.SystemLogger.Error(err, "error compacting the backup history")
+	}
+	return
+}
+
+// compactHistory rewrites the history of HEAD in the repository to hold only the kept commits, see Compact
+func compactHistory(repo *git.Repository, keep []string) (removed int, err error) {
+	head, err := repo.Head()
+	if err != nil {
+		return 0, err
+	}
+	kept := make(map[plumbing.Hash]bool, len(keep))
+	for _, hash := range keep {
+		kept[plumbing.NewHash(hash)] = true
+	}
+	if !kept[head.Hash()] {
+		return 0, errors.New("the history cannot be compacted without keeping HEAD")
+	}
+
+	var commits []*object.Commit
+	iter, err := repo.Log(&git.LogOptions{From: head.Hash()})
+	if err != nil {
+		return 0, err
+	}
+	err = iter.ForEach(func(c *object.Commit) error {
+		if c.NumParents() > 1 {
+			return fmt.Errorf("commit %s is a merge, only a linear history can be compacted", c.Hash)
+		}
+		commits = append(commits, c)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// commits are rewritten oldest first, each on the last commit kept, once a commit has been dropped
+	var parent plumbing.Hash
+	var tree plumbing.Hash
+	for i := len(commits) - 1; i >= 0; i-- {
+		c := commits[i]
+		if !kept[c.Hash] {
+			removed++
+			continue
+		}
+		tree = c.TreeHash
+		if removed == 0 {
+			parent = c.Hash
+			continue
+		}
+		rewritten := &object.Commit{
+			Author:    c.Author,
+			Committer: c.Committer,
+			Message:   c.Message,
+			TreeHash:  c.TreeHash,
+		}
+		if !parent.IsZero() {
+			rewritten.ParentHashes = []plumbing.Hash{parent}
+		}
+		obj := repo.Storer.NewEncodedObject()
+		if err = rewritten.Encode(obj); err != nil {
+			return 0, err
+		}
+		if parent, err = repo.Storer.SetEncodedObject(obj); err != nil {
+			return 0, err
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+
+	headCommit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return 0, err
+	}
+	if tree != headCommit.TreeHash {
+		return 0, errors.New("the compacted history does not end on the tree of HEAD")
+	}
+
+	// a detached HEAD is moved itself, otherwise the branch it points to
+	name := plumbing.HEAD
+	if ref, err := repo.Storer.Reference(plumbing.HEAD); err == nil && ref.Type() == plumbing.SymbolicReference {
+		name = ref.Target()
+	}
+	if err = repo.Storer.CheckAndSetReference(plumbing.NewHashReference(name, parent), plumbing.NewHashReference(name, head.Hash())); err != nil {
+		return 0, err
+	}
+
+	if err = repo.Prune(git.PruneOptions{Handler: repo.DeleteObject}); err != nil {
+		return removed, err
+	}
+	return removed, nil
+}
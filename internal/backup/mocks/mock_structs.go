@@ -1,10 +1,16 @@
 package mocks
 
 import (
+	"context"
 	"fp-dynamic-elements-manager-controller/internal/backup/structs"
+	"fp-dynamic-elements-manager-controller/internal/db/persistence"
+	dockerstructs "fp-dynamic-elements-manager-controller/internal/docker/structs"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
 	"fp-dynamic-elements-manager-controller/internal/notification"
-	"github.com/docker/docker/api/types"
+	orchestratorstructs "fp-dynamic-elements-manager-controller/internal/orchestrator/structs"
 	"github.com/stretchr/testify/mock"
+	"io"
+	"io/ioutil"
 )
 
 type LoggerMock struct {
@@ -39,66 +45,137 @@ func (l *LoggerMock) Trace(s string) {
 	l.Called(s)
 }
 
+func (l *LoggerMock) WithFields(fields applog.Fields) applog.Logger {
+	return l
+}
+
+func (l *LoggerMock) WithContext(ctx context.Context) applog.Logger {
+	return l
+}
+
+// TableData is a table and its rows, as exported from or imported into a RepoMock
+type TableData struct {
+	Table persistence.Table
+	Rows  [][]interface{}
+}
+
 type RepoMock struct {
 	mock.Mock
+	// Exported is the data ExportTables exports, Imported holds the data of the last ImportTables
+	Exported []TableData
+	Imported []TableData
+}
+
+func (r *RepoMock) ExportTables(ctx context.Context, tables []string, table func(persistence.Table) error, row func([]interface{}) error) error {
+	args := r.Called(tables)
+	for _, data := range r.Exported {
+		if err := table(data.Table); err != nil {
+			return err
+		}
+		for _, values := range data.Rows {
+			if err := row(values); err != nil {
+				return err
+			}
+		}
+	}
+	return args.Error(0)
 }
 
-func (r *RepoMock) GetTotalElementCount() (int64, error) {
+func (r *RepoMock) ImportTables(ctx context.Context, load func(persistence.TableImporter) error) error {
 	args := r.Called()
+	r.Imported = nil
+	if err := load(r); err != nil {
+		return err
+	}
+	return args.Error(0)
+}
+
+func (r *RepoMock) CreateTable(table persistence.Table) error {
+	r.Imported = append(r.Imported, TableData{Table: table})
+	return nil
+}
+
+func (r *RepoMock) InsertRow(values []interface{}) error {
+	r.Imported[len(r.Imported)-1].Rows = append(r.Imported[len(r.Imported)-1].Rows, values)
+	return nil
+}
+
+func (r *RepoMock) GetSchemaVersion() (uint, error) {
+	args := r.Called()
+	return uint(args.Int(0)), args.Error(1)
+}
+
+func (r *RepoMock) GetTables() ([]string, error) {
+	args := r.Called()
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (r *RepoMock) CountRows(s string) (int64, error) {
+	args := r.Called(s)
 	return int64(args.Int(0)), args.Error(1)
 }
 
-type DockerMock struct {
+type OrchestratorMock struct {
 	mock.Mock
+	// Output is written to the stdout of each Exec, Input holds the stdin of the last Exec given one
+	Output []byte
+	Input  []byte
 }
 
-func (d *DockerMock) PullAndStart(a, b string) error {
-	args := d.Called(a, b)
-	return args.Error(0)
+func (o *OrchestratorMock) ModuleNetwork(ctx context.Context) (string, error) {
+	args := o.Called(ctx)
+	return args.String(0), args.Error(1)
 }
 
-func (d *DockerMock) Create(a, b, c string, arr1 []string, arr2 []string) error {
-	args := d.Called(a, b, c, arr1, arr2)
-	return args.Error(1)
+func (o *OrchestratorMock) ResolveImage(ctx context.Context, ref string) (string, error) {
+	args := o.Called(ctx, ref)
+	return args.String(0), args.Error(1)
 }
 
-func (d *DockerMock) Start(s string) error {
-	args := d.Called(s)
+func (o *OrchestratorMock) Create(ctx context.Context, workload orchestratorstructs.Workload) error {
+	args := o.Called(ctx, workload)
 	return args.Error(0)
 }
 
-func (d *DockerMock) Stop(s string) error {
-	args := d.Called(s)
+func (o *OrchestratorMock) Start(ctx context.Context, s string) error {
+	args := o.Called(ctx, s)
 	return args.Error(0)
 }
 
-func (d *DockerMock) Restart(s string) error {
-	args := d.Called(s)
+func (o *OrchestratorMock) Stop(ctx context.Context, s string) error {
+	args := o.Called(ctx, s)
 	return args.Error(0)
 }
 
-func (d *DockerMock) Remove(s string) error {
-	args := d.Called(s)
+func (o *OrchestratorMock) Restart(ctx context.Context, s string) error {
+	args := o.Called(ctx, s)
 	return args.Error(0)
 }
 
-func (d *DockerMock) ListNetworks() []types.NetworkResource {
-	args := d.Called()
-	return args.Get(0).([]types.NetworkResource)
+func (o *OrchestratorMock) Remove(ctx context.Context, s string) error {
+	args := o.Called(ctx, s)
+	return args.Error(0)
 }
 
-func (d *DockerMock) ListContainers(options types.ContainerListOptions) []types.Container {
-	args := d.Called(options)
-	return args.Get(0).([]types.Container)
+func (o *OrchestratorMock) Logs(ctx context.Context, id string, opts dockerstructs.LogOptions, out func(dockerstructs.LogLine) error) error {
+	args := o.Called(ctx, id, opts)
+	return args.Error(0)
 }
 
-func (d *DockerMock) RunDatabaseDump() error {
-	args := d.Called()
-	return args.Error(0)
+func (o *OrchestratorMock) Inspect(ctx context.Context, id string) (dockerstructs.ContainerInspect, error) {
+	args := o.Called(ctx, id)
+	return args.Get(0).(dockerstructs.ContainerInspect), args.Error(1)
 }
 
-func (d *DockerMock) RunDatabaseRestore() {
-	d.Called()
+func (o *OrchestratorMock) Exec(ctx context.Context, service string, cmd []string, stdin io.Reader, stdout io.Writer) error {
+	args := o.Called(ctx, service, cmd)
+	if stdin != nil {
+		o.Input, _ = ioutil.ReadAll(stdin)
+	}
+	if stdout != nil {
+		stdout.Write(o.Output)
+	}
+	return args.Error(0)
 }
 
 type NSMock struct {
@@ -136,3 +213,8 @@ func (c *CommitterMock) ListHistory() ([]structs.History, error) {
 	args := c.Called()
 	return args.Get(0).([]structs.History), args.Error(1)
 }
+
+func (c *CommitterMock) Compact(keep []string) (int, error) {
+	args := c.Called(keep)
+	return args.Int(0), args.Error(1)
+}
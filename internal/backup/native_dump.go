@@ -0,0 +1,150 @@
+package backup
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	structs2 "fp-dynamic-elements-manager-controller/internal/backup/structs"
+	"fp-dynamic-elements-manager-controller/internal/db/persistence"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+// timeFormat is the format DATETIME and TIMESTAMP values are written in, in the connection's time zone so they are read
+// back as the same value
+const timeFormat = "2006-01-02 15:04:05.999999"
+
+// record is a line of a native dump. A table record holds the table's name, the statement which creates it and its
+// columns, and is followed by a row record for each of its rows. A value is null, a string, a number, or an object
+// holding base64 for binary values which are not UTF-8
+type record struct {
+	Table   string        `json:"table,omitempty"`
+	Create  string        `json:"create,omitempty"`
+	Columns []string      `json:"columns,omitempty"`
+	Row     []interface{} `json:"row,omitempty"`
+}
+
+// ErrUnexpectedTable is returned when a dump holds a table outside of the tables being restored
+var ErrUnexpectedTable = errors.New("the backup holds a table which is not in its profile")
+
+type binaryValue struct {
+	Base64 []byte `json:"base64"`
+}
+
+// exportTables writes a native dump of the tables to w, progress is called as each table is finished
+func exportTables(ctx context.Context, repo persistence.DatabaseDump, tables []string, w io.Writer, progress func(structs2.TableRows)) ([]structs2.TableRows, error) {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	var counts []structs2.TableRows
+
+	err := repo.ExportTables(ctx, tables, func(table persistence.Table) error {
+		if len(counts) > 0 {
+			progress(counts[len(counts)-1])
+		}
+		counts = append(counts, structs2.TableRows{Name: table.Name})
+		return encoder.Encode(record{Table: table.Name, Create: table.Create, Columns: table.Columns})
+	}, func(row []interface{}) error {
+		counts[len(counts)-1].Rows++
+		values := make([]interface{}, len(row))
+		for i, v := range row {
+			values[i] = encodeValue(v)
+		}
+		return encoder.Encode(record{Row: values})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(counts) > 0 {
+		progress(counts[len(counts)-1])
+	}
+	return counts, nil
+}
+
+// importTables loads a native dump of some of the tables into the DB, progress is called as each table is finished
+func importTables(ctx context.Context, repo persistence.DatabaseDump, r io.Reader, tables []string, progress func(structs2.TableRows)) ([]structs2.TableRows, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	var counts []structs2.TableRows
+
+	err := repo.ImportTables(ctx, func(importer persistence.TableImporter) error {
+		for {
+			var rec record
+			if err := decoder.Decode(&rec); err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+
+			if rec.Table != "" {
+				if !contains(tables, rec.Table) {
+					return fmt.Errorf("%w: %s", ErrUnexpectedTable, rec.Table)
+				}
+				if len(counts) > 0 {
+					progress(counts[len(counts)-1])
+				}
+				counts = append(counts, structs2.TableRows{Name: rec.Table})
+				if err := importer.CreateTable(persistence.Table{Name: rec.Table, Create: rec.Create, Columns: rec.Columns}); err != nil {
+					return err
+				}
+				continue
+			}
+
+			row := make([]interface{}, len(rec.Row))
+			for i, v := range rec.Row {
+				value, err := decodeValue(v)
+				if err != nil {
+					return err
+				}
+				row[i] = value
+			}
+			if err := importer.InsertRow(row); err != nil {
+				return err
+			}
+			counts[len(counts)-1].Rows++
+		}
+		if len(counts) > 0 {
+			progress(counts[len(counts)-1])
+		}
+		return nil
+	})
+	return counts, err
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func encodeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		if utf8.Valid(v) {
+			return string(v)
+		}
+		return binaryValue{Base64: v}
+	case time.Time:
+		return v.In(time.Local).Format(timeFormat)
+	}
+	return v
+}
+
+func decodeValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case nil, string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case map[string]interface{}:
+		if encoded, ok := v["base64"].(string); ok {
+			return base64.StdEncoding.DecodeString(encoded)
+		}
+	}
+	return nil, fmt.Errorf("invalid value %v in the dump", v)
+}
@@ -0,0 +1,93 @@
+package backup
+
+import (
+	"fmt"
+	structs2 "fp-dynamic-elements-manager-controller/internal/backup/structs"
+	"time"
+)
+
+// Retention is the policy which decides the backups kept when the history is pruned after each backup. A backup is
+// kept if any rule keeps it, the rules which are 0 keep nothing, and a policy of only zeros keeps every backup
+type Retention struct {
+	// Last keeps the newest backups
+	Last int `json:"last"`
+	// Daily keeps the newest backup of each of the last days, including today
+	Daily int `json:"daily"`
+	// Weekly keeps the newest backup of each of the last weeks, which start on Monday
+	Weekly int `json:"weekly"`
+	// Monthly keeps the newest backup of each of the last calendar months
+	Monthly int `json:"monthly"`
+}
+
+// Enabled reports whether the policy prunes any backups
+func (r Retention) Enabled() bool {
+	return r.Last > 0 || r.Daily > 0 || r.Weekly > 0 || r.Monthly > 0
+}
+
+func (r Retention) validate() error {
+	if r.Last < 0 || r.Daily < 0 || r.Weekly < 0 || r.Monthly < 0 {
+		return fmt.Errorf("backup retention counts cannot be negative")
+	}
+	return nil
+}
+
+// keep returns the hashes of the backups the policy keeps, the history is newest first. The newest backup is always
+// kept, it is what the backup directory holds
+func (r Retention) keep(history []structs2.History, now time.Time) map[string]bool {
+	kept := make(map[string]bool)
+	if !r.Enabled() {
+		for _, h := range history {
+			kept[h.Hash] = true
+		}
+		return kept
+	}
+	if len(history) > 0 {
+		kept[history[0].Hash] = true
+	}
+
+	rules := []struct {
+		count  int
+		period func(time.Time) int
+	}{
+		{r.Daily, day},
+		{r.Weekly, week},
+		{r.Monthly, month},
+	}
+	for i, h := range history {
+		if i < r.Last {
+			kept[h.Hash] = true
+		}
+	}
+	for _, rule := range rules {
+		if rule.count == 0 {
+			continue
+		}
+		current := rule.period(now)
+		seen := make(map[int]bool)
+		for _, h := range history {
+			p := rule.period(h.Time)
+			if p <= current-rule.count || seen[p] {
+				continue
+			}
+			seen[p] = true
+			kept[h.Hash] = true
+		}
+	}
+	return kept
+}
+
+// day returns the number of the local calendar day the time falls on
+func day(t time.Time) int {
+	y, m, d := t.In(time.Local).Date()
+	return int(time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
+
+// week returns the number of the week, starting on Monday, the time falls in. Day 0 was a Thursday
+func week(t time.Time) int {
+	return (day(t) + 3) / 7
+}
+
+func month(t time.Time) int {
+	y, m, _ := t.In(time.Local).Date()
+	return y*12 + int(m) - 1
+}
@@ -1,6 +1,110 @@
 package structs
 
+import "time"
+
 type History struct {
-	Hash    string `json:"hash"`
-	Message string `json:"message"`
+	Hash    string    `json:"hash"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// Storage is the disk space used by the backup directory
+type Storage struct {
+	// TotalBytes is the size of the backup directory, HistoryBytes the part of it which is the git history
+	TotalBytes   int64 `json:"total_bytes"`
+	HistoryBytes int64 `json:"history_bytes"`
+	Backups      int   `json:"backups"`
+}
+
+// Profile is the set of tables a backup holds
+type Profile string
+
+const (
+	// ElementsProfile backs up the list elements only
+	ElementsProfile Profile = "elements"
+	// FullProfile backs up every table of the controller's DB: users, modules, element types, update statuses,
+	// module configs, secrets and the rest
+	FullProfile Profile = "full"
+)
+
+type Compression string
+
+const (
+	NoCompression Compression = "none"
+	Gzip          Compression = "gzip"
+	Zstd          Compression = "zstd"
+)
+
+type Encryption string
+
+const (
+	NoEncryption Encryption = "none"
+	// AESGCM encrypts the backup with a data key of its own, the data key is encrypted with the secrets master key
+	AESGCM Encryption = "aes-gcm"
+	// Age encrypts the backup to age recipients, the matching identity is needed to restore it
+	Age Encryption = "age"
+)
+
+// Format is the format of the dump inside a backup
+type Format string
+
+const (
+	// SQLFormat is a mysqldump script, it is loaded by running the mysql client in the database service
+	SQLFormat Format = "sql"
+	// NativeFormat is the controller's own format, JSON lines of tables and their rows, it is loaded over the
+	// controller's DB connection
+	NativeFormat Format = "native"
+)
+
+// ManifestVersion is the version of the manifest format written by this controller
+const ManifestVersion = 1
+
+// Manifest describes a backup, it is written next to the dump and committed with it
+type Manifest struct {
+	Version     int         `json:"version"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Profile     Profile     `json:"profile"`
+	Compression Compression `json:"compression"`
+	Encryption  Encryption  `json:"encryption"`
+	// Format is empty in manifests written before native dumps, which hold SQL dumps
+	Format Format `json:"format,omitempty"`
+	// KeyID is the master key the data key was encrypted with, for aes-gcm backups
+	KeyID string `json:"key_id,omitempty"`
+	// DataKey is the encrypted data key of an aes-gcm backup
+	DataKey []byte `json:"data_key,omitempty"`
+	// Recipients are the age recipients an age backup was encrypted to
+	Recipients []string `json:"recipients,omitempty"`
+	// SchemaVersion is the version of the last migration applied to the DB, a backup is only restored to the same version
+	SchemaVersion uint        `json:"schema_version"`
+	Tables        []TableRows `json:"tables"`
+	// File is the name of the dump, compressed and encrypted
+	File string `json:"file"`
+	Size int64  `json:"size"`
+	// SHA256 is the checksum of the file, DumpSHA256 of the dump before it was compressed and encrypted
+	SHA256     string `json:"sha256"`
+	DumpSHA256 string `json:"dump_sha256"`
+}
+
+// TableRows is the number of rows a table held when it was backed up
+type TableRows struct {
+	Name string `json:"name"`
+	Rows int64  `json:"rows"`
+}
+
+// RemoteBackup is a backup held by an off-site target
+type RemoteBackup struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TargetStatus is the state of an off-site target as of its last sync
+type TargetStatus struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Interval string `json:"interval"`
+	// LastSync is when the target was last synced, successfully or not
+	LastSync *time.Time `json:"last_sync,omitempty"`
+	// LastBackup is the ID of the newest backup copied to the target
+	LastBackup string `json:"last_backup,omitempty"`
+	LastError  string `json:"last_error,omitempty"`
 }
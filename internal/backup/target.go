@@ -0,0 +1,407 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	structs2 "fp-dynamic-elements-manager-controller/internal/backup/structs"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TargetType string
+
+const (
+	S3Target   TargetType = "s3"
+	SFTPTarget TargetType = "sftp"
+	GitTarget  TargetType = "git"
+)
+
+// idFormat is the format of the ID of a backup held by a target, the time the backup was taken in UTC
+const idFormat = "20060102T150405.000Z"
+
+// minTargetInterval is the shortest interval a target can be synced at
+const minTargetInterval = time.Minute
+
+var (
+	ErrTargetNotFound = errors.New("backup target not found")
+	// ErrUploadMismatch is returned when a backup read back from a target does not match the backup uploaded
+	ErrUploadMismatch = errors.New("the backup read back from the target does not match its manifest")
+)
+
+// BackupTarget is a place off the host which backups are copied to and restored from
+type BackupTarget interface {
+	// Upload copies the backup in dir, its manifest and dump, to the target and checks the copy against the manifest
+	Upload(ctx context.Context, dir string, manifest structs2.Manifest) error
+	// List returns the backups held by the target, newest first
+	List(ctx context.Context) ([]structs2.RemoteBackup, error)
+	// Download copies the manifest and dump of the backup to dir
+	Download(ctx context.Context, id, dir string) error
+	Delete(ctx context.Context, ids []string) error
+}
+
+// Resolver resolves references to secrets in a target's credentials
+type Resolver func(string) (string, error)
+
+// TargetConfig is an off-site target, the credentials of each type can reference secrets, e.g. ${secret:s3-secret-key}
+type TargetConfig struct {
+	Name string     `json:"name"`
+	Type TargetType `json:"type"`
+	// Interval is how often the newest backup is copied to the target, e.g. "6h"
+	Interval string `json:"interval"`
+	// Retention is applied to the backups held by the target after each sync
+	Retention Retention        `json:"retention"`
+	S3        *S3Config        `json:"s3,omitempty"`
+	SFTP      *SFTPConfig      `json:"sftp,omitempty"`
+	Git       *GitRemoteConfig `json:"git,omitempty"`
+}
+
+// TargetsFromEnv reads the target configs from the JSON file named by BACKUP_TARGETS_FILE, there are none if it is
+// not set
+func TargetsFromEnv() ([]TargetConfig, error) {
+	file := os.Getenv("BACKUP_TARGETS_FILE")
+	if file == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var cfgs []TargetConfig
+	if err = json.Unmarshal(data, &cfgs); err != nil {
+		return nil, fmt.Errorf("%s is not a list of backup targets: %w", file, err)
+	}
+	return cfgs, nil
+}
+
+// Target copies backups to a BackupTarget on a schedule of its own and prunes them with a retention policy of its own
+type Target struct {
+	cfg      TargetConfig
+	interval time.Duration
+
+	// connect builds the backend the first time it is used, once the secrets its credentials reference are loaded
+	connect   func() (BackupTarget, error)
+	backendMu sync.Mutex
+	backend   BackupTarget
+
+	mu     sync.Mutex
+	status structs2.TargetStatus
+}
+
+// NewTargets validates the configs and returns a target for each. The credentials are resolved when a target is first
+// used, so the secrets they reference only need to be loaded by then
+func NewTargets(cfgs []TargetConfig, resolve Resolver) ([]*Target, error) {
+	var targets []*Target
+	names := make(map[string]bool)
+	for _, cfg := range cfgs {
+		if !validTargetName(cfg.Name) || names[cfg.Name] {
+			return nil, fmt.Errorf("backup target names must be unique letters, digits, '_' or '-', '%s' is not", cfg.Name)
+		}
+		names[cfg.Name] = true
+
+		interval, err := time.ParseDuration(cfg.Interval)
+		if err != nil || interval < minTargetInterval {
+			return nil, fmt.Errorf("backup target %s: the interval must be a duration of at least %s", cfg.Name, minTargetInterval)
+		}
+		if err = cfg.Retention.validate(); err != nil {
+			return nil, fmt.Errorf("backup target %s: %w", cfg.Name, err)
+		}
+
+		cfg := cfg
+		connect := func(resolve Resolver) (BackupTarget, error) {
+			switch {
+			case cfg.Type == S3Target && cfg.S3 != nil:
+				return newS3Target(*cfg.S3, resolve)
+			case cfg.Type == SFTPTarget && cfg.SFTP != nil:
+				return newSFTPTarget(*cfg.SFTP, resolve)
+			case cfg.Type == GitTarget && cfg.Git != nil:
+				return newGitTarget(cfg.Name, *cfg.Git, resolve)
+			}
+			return nil, errors.New("the type must be s3, sftp or git with the settings of that type")
+		}
+		// the settings are checked now with the references left as they are
+		if _, err = connect(func(v string) (string, error) { return v, nil }); err != nil {
+			return nil, fmt.Errorf("backup target %s: %w", cfg.Name, err)
+		}
+
+		t := NewTarget(cfg, interval, nil)
+		t.connect = func() (BackupTarget, error) { return connect(resolve) }
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// NewTarget returns a target which syncs to the backend
+func NewTarget(cfg TargetConfig, interval time.Duration, backend BackupTarget) *Target {
+	return &Target{
+		cfg:      cfg,
+		interval: interval,
+		backend:  backend,
+		status:   structs2.TargetStatus{Name: cfg.Name, Type: string(cfg.Type), Interval: interval.String()},
+	}
+}
+
+// open returns the backend, building it if it has not been yet
+func (t *Target) open() (BackupTarget, error) {
+	t.backendMu.Lock()
+	defer t.backendMu.Unlock()
+	if t.backend == nil {
+		backend, err := t.connect()
+		if err != nil {
+			return nil, fmt.Errorf("backup target %s: %w", t.cfg.Name, err)
+		}
+		t.backend = backend
+	}
+	return t.backend, nil
+}
+
+func validTargetName(name string) bool {
+	if name == "" || len(name) > 64 {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// sync copies the backup in dir to the target, unless the target already holds it, then applies the retention policy
+func (t *Target) sync(ctx context.Context, dir string, manifest structs2.Manifest) (err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer func() {
+		now := time.Now().UTC()
+		t.status.LastSync = &now
+		t.status.LastError = ""
+		if err != nil {
+			t.status.LastError = err.Error()
+		}
+	}()
+
+	backend, err := t.open()
+	if err != nil {
+		return err
+	}
+	id := backupID(manifest)
+	held, err := backend.List(ctx)
+	if err != nil {
+		return err
+	}
+	if !containsBackup(held, id) {
+		if err = backend.Upload(ctx, dir, manifest); err != nil {
+			return err
+		}
+		held = append([]structs2.RemoteBackup{{ID: id, CreatedAt: manifest.CreatedAt}}, held...)
+		sortBackups(held)
+	}
+	t.status.LastBackup = id
+
+	if !t.cfg.Retention.Enabled() {
+		return nil
+	}
+	history := make([]structs2.History, len(held))
+	for i, b := range held {
+		history[i] = structs2.History{Hash: b.ID, Time: b.CreatedAt}
+	}
+	kept := t.cfg.Retention.keep(history, time.Now())
+	var pruned []string
+	for _, b := range held {
+		if !kept[b.ID] {
+			pruned = append(pruned, b.ID)
+		}
+	}
+	if len(pruned) == 0 {
+		return nil
+	}
+	return backend.Delete(ctx, pruned)
+}
+
+func (t *Target) Status() structs2.TargetStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status
+}
+
+func containsBackup(backups []structs2.RemoteBackup, id string) bool {
+	for _, b := range backups {
+		if b.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func backupID(manifest structs2.Manifest) string {
+	return manifest.CreatedAt.UTC().Format(idFormat)
+}
+
+// parseBackupID returns the time the backup was taken, the ID is not valid if it cannot be parsed
+func parseBackupID(id string) (time.Time, bool) {
+	t, err := time.Parse(idFormat, id)
+	return t, err == nil
+}
+
+// sortBackups sorts the backups newest first
+func sortBackups(backups []structs2.RemoteBackup) {
+	sort.Slice(backups, func(i, j int) bool { return backups[i].CreatedAt.After(backups[j].CreatedAt) })
+}
+
+// objectStore is a store of files by key, keys are paths separated by '/'
+type objectStore interface {
+	put(ctx context.Context, key string, r io.Reader, size int64) error
+	get(ctx context.Context, key string) (io.ReadCloser, error)
+	// list returns every key under the prefix
+	list(ctx context.Context, prefix string) ([]string, error)
+	remove(ctx context.Context, key string) error
+}
+
+// objectTarget keeps each backup in an object store under its ID, the dump is uploaded before the manifest so a backup
+// is only listed once it is complete
+type objectTarget struct {
+	store  objectStore
+	prefix string
+}
+
+func (o *objectTarget) key(parts ...string) string {
+	return path.Join(append([]string{o.prefix}, parts...)...)
+}
+
+// dir returns the prefix of the keys under the parts
+func (o *objectTarget) dir(parts ...string) string {
+	if key := o.key(parts...); key != "" {
+		return key + "/"
+	}
+	return ""
+}
+
+func (o *objectTarget) Upload(ctx context.Context, dir string, manifest structs2.Manifest) error {
+	id := backupID(manifest)
+	manifestData, err := ioutil.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(filepath.Join(dir, manifest.File))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err = o.store.put(ctx, o.key(id, manifest.File), file, manifest.Size); err != nil {
+		return err
+	}
+	if err = o.verify(ctx, o.key(id, manifest.File), manifest.Size, manifest.SHA256); err != nil {
+		o.store.remove(ctx, o.key(id, manifest.File))
+		return err
+	}
+	if err = o.store.put(ctx, o.key(id, manifestFile), bytes.NewReader(manifestData), int64(len(manifestData))); err != nil {
+		return err
+	}
+	sum := newChecksum()
+	sum.Write(manifestData)
+	if err = o.verify(ctx, o.key(id, manifestFile), sum.size, sum.Sum()); err != nil {
+		o.Delete(ctx, []string{id})
+		return err
+	}
+	return nil
+}
+
+// verify reads the object back and checks its size and checksum
+func (o *objectTarget) verify(ctx context.Context, key string, size int64, sha256 string) error {
+	r, err := o.store.get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	sum := newChecksum()
+	if _, err = io.Copy(sum, r); err != nil {
+		return err
+	}
+	if sum.size != size || sum.Sum() != sha256 {
+		return fmt.Errorf("%w: %s", ErrUploadMismatch, key)
+	}
+	return nil
+}
+
+func (o *objectTarget) List(ctx context.Context) ([]structs2.RemoteBackup, error) {
+	keys, err := o.store.list(ctx, o.dir())
+	if err != nil {
+		return nil, err
+	}
+	var backups []structs2.RemoteBackup
+	for _, key := range keys {
+		dir, name := path.Split(strings.TrimPrefix(key, o.dir()))
+		id := strings.TrimSuffix(dir, "/")
+		if name != manifestFile || strings.Contains(id, "/") {
+			continue
+		}
+		if created, ok := parseBackupID(id); ok {
+			backups = append(backups, structs2.RemoteBackup{ID: id, CreatedAt: created})
+		}
+	}
+	sortBackups(backups)
+	return backups, nil
+}
+
+func (o *objectTarget) Download(ctx context.Context, id, dir string) error {
+	if _, ok := parseBackupID(id); !ok {
+		return fmt.Errorf("invalid backup ID '%s'", id)
+	}
+	if err := o.download(ctx, o.key(id, manifestFile), filepath.Join(dir, manifestFile)); err != nil {
+		return err
+	}
+	manifest, err := readManifest(dir)
+	if err != nil {
+		return err
+	}
+	return o.download(ctx, o.key(id, manifest.File), filepath.Join(dir, manifest.File))
+}
+
+func (o *objectTarget) download(ctx context.Context, key, file string) error {
+	r, err := o.store.get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Delete deletes the manifest of each backup first, so a backup which is partly deleted is no longer listed
+func (o *objectTarget) Delete(ctx context.Context, ids []string) error {
+	for _, id := range ids {
+		if _, ok := parseBackupID(id); !ok {
+			return fmt.Errorf("invalid backup ID '%s'", id)
+		}
+		if err := o.store.remove(ctx, o.key(id, manifestFile)); err != nil {
+			return err
+		}
+		keys, err := o.store.list(ctx, o.dir(id))
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err = o.store.remove(ctx, key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	structs2 "fp-dynamic-elements-manager-controller/internal/backup/structs"
+	"fp-dynamic-elements-manager-controller/internal/notification"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrNoManifest is returned when no backup has been taken yet, or the newest backup was taken before manifests were
+// written, there is nothing to copy to a target
+var ErrNoManifest = errors.New("the newest backup has no manifest")
+
+// StartTargets copies the newest backup to each target now and then at the target's interval, until ctx is done
+func (d *DatabaseBackupProvider) StartTargets(ctx context.Context) {
+	for _, t := range d.targets {
+		go func(t *Target) {
+			ticker := time.NewTicker(t.interval)
+			defer ticker.Stop()
+			for {
+				d.SyncTarget(ctx, t.cfg.Name)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(t)
+	}
+}
+
+func (d *DatabaseBackupProvider) Targets() []structs2.TargetStatus {
+	statuses := make([]structs2.TargetStatus, 0, len(d.targets))
+	for _, t := range d.targets {
+		statuses = append(statuses, t.Status())
+	}
+	return statuses
+}
+
+func (d *DatabaseBackupProvider) target(name string) (*Target, error) {
+	for _, t := range d.targets {
+		if t.cfg.Name == name {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrTargetNotFound, name)
+}
+
+// ListTarget returns the backups held by the target, newest first
+func (d *DatabaseBackupProvider) ListTarget(ctx context.Context, name string) ([]structs2.RemoteBackup, error) {
+	t, err := d.target(name)
+	if err != nil {
+		return nil, err
+	}
+	backend, err := t.open()
+	if err != nil {
+		return nil, err
+	}
+	return backend.List(ctx)
+}
+
+// SyncTarget copies the newest backup to the target and applies the target's retention policy. The backup is staged
+// in a temporary directory, so backups and restores are only held up while it is copied there
+func (d *DatabaseBackupProvider) SyncTarget(ctx context.Context, name string) error {
+	t, err := d.target(name)
+	if err != nil {
+		return err
+	}
+	dir, manifest, err := d.stage()
+	if errors.Is(err, ErrNoManifest) {
+		// Until the first backup is taken there is nothing to copy, this is not a failure of the target
+		d.logger.SystemLogger.Debug(fmt.Sprintf("skipped copying to target %s, %s", name, err))
+		return err
+	}
+	if err == nil {
+		defer os.RemoveAll(dir)
+		err = t.sync(ctx, dir, manifest)
+	}
+	if err != nil {
+		d.logger.SystemLogger.Error(err, fmt.Sprintf("error copying the backup to target %s", name))
+		d.notifyTarget(notification.Error, notification.TargetFailed, fmt.Sprintf("Error copying the backup to %s: %s", name, err), name)
+		return err
+	}
+	d.notifyTarget(notification.Info, notification.TargetSynced, fmt.Sprintf("Backup %s is held by %s", backupID(manifest), name), name)
+	return nil
+}
+
+// RestoreFromTarget downloads the backup from the target and loads it into the DB, once it is verified against its
+// manifest like a backup restored from the history
+func (d *DatabaseBackupProvider) RestoreFromTarget(ctx context.Context, name, id string) error {
+	t, err := d.target(name)
+	if err != nil {
+		return err
+	}
+	backend, err := t.open()
+	if err != nil {
+		return err
+	}
+	dir, err := ioutil.TempDir("", "backup-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	if err = backend.Download(ctx, id, dir); err != nil {
+		d.logger.SystemLogger.Error(err, fmt.Sprintf("error downloading backup %s from target %s", id, name))
+		return err
+	}
+	manifest, err := readManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err = d.verify(dir, manifest); err == nil {
+		err = d.restore(dir, manifest)
+	}
+	if err != nil {
+		d.logger.SystemLogger.Error(err, fmt.Sprintf("error restoring the database to backup %s from target %s", id, name))
+		return err
+	}
+	d.checkRows(manifest)
+	return nil
+}
+
+// stage copies the newest backup, its manifest and dump, to a temporary directory
+func (d *DatabaseBackupProvider) stage() (dir string, manifest structs2.Manifest, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	manifest, err = readManifest(backupDir())
+	if os.IsNotExist(err) {
+		return "", manifest, ErrNoManifest
+	} else if err != nil {
+		return "", manifest, err
+	}
+
+	dir, err = ioutil.TempDir("", "backup-")
+	if err != nil {
+		return "", manifest, err
+	}
+	for _, name := range []string{manifestFile, manifest.File} {
+		if err = copyFile(filepath.Join(backupDir(), name), filepath.Join(dir, name)); err != nil {
+			os.RemoveAll(dir)
+			return "", manifest, err
+		}
+	}
+	return dir, manifest, nil
+}
+
+func (d *DatabaseBackupProvider) notifyTarget(eventType notification.EventType, state notification.State, msg, name string) {
+	d.logger.NotificationService.Send(notification.Event{
+		EventType: eventType,
+		Value:     msg,
+		Context: notification.EventContext{
+			Type:       notification.Backup,
+			Identifier: name,
+			State:      state,
+		},
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,420 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	structs2 "fp-dynamic-elements-manager-controller/internal/backup/structs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// gitBackupMessage is the message of the commit which holds a backup, followed by the backup's ID
+const gitBackupMessage = "Backup "
+
+// GitRemoteConfig is a branch of a git repository, each backup is pushed as a commit holding its manifest and dump.
+// The repository is only written by the controller, backups are deleted by rewriting the branch and force pushing it
+type GitRemoteConfig struct {
+	// URL is the repository, e.g. https://git.example.com/backups.git
+	URL string `json:"url"`
+	// Branch defaults to master
+	Branch   string `json:"branch"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// gitTarget keeps a bare clone of the branch, it is brought up to date with the remote before each operation
+type gitTarget struct {
+	dir    string
+	url    string
+	branch plumbing.ReferenceName
+	auth   transport.AuthMethod
+
+	mu sync.Mutex
+}
+
+func newGitTarget(name string, cfg GitRemoteConfig, resolve Resolver) (BackupTarget, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("git targets need a url")
+	}
+	if cfg.Branch == "" {
+		cfg.Branch = "master"
+	}
+	branch := plumbing.NewBranchReferenceName(cfg.Branch)
+	if err := config.RefSpec(fmt.Sprintf("%s:%s", branch, branch)).Validate(); err != nil || strings.ContainsAny(cfg.Branch, " :*") {
+		return nil, fmt.Errorf("invalid branch '%s'", cfg.Branch)
+	}
+
+	var auth transport.AuthMethod
+	if cfg.Username != "" || cfg.Password != "" {
+		password, err := resolve(cfg.Password)
+		if err != nil {
+			return nil, err
+		}
+		auth = &http.BasicAuth{Username: cfg.Username, Password: password}
+	}
+	return &gitTarget{
+		dir:    filepath.Join(backupDir(), ".targets", name),
+		url:    cfg.URL,
+		branch: branch,
+		auth:   auth,
+	}, nil
+}
+
+// open opens the clone, creating it the first time
+func (g *gitTarget) open() (*git.Repository, error) {
+	repo, err := git.PlainOpen(g.dir)
+	if err == git.ErrRepositoryNotExists {
+		if repo, err = git.PlainInit(g.dir, true); err != nil {
+			return nil, err
+		}
+		if err = repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, g.branch)); err != nil {
+			return nil, err
+		}
+		_, err = repo.CreateRemote(&config.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{g.url}})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// pull opens the clone and resets the branch to the remote's, it returns false if the remote does not have the branch
+func (g *gitTarget) pull(ctx context.Context) (*git.Repository, bool, error) {
+	repo, err := g.open()
+	if err != nil {
+		return nil, false, err
+	}
+	remote, err := repo.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return nil, false, err
+	}
+
+	hash, err := g.remoteHash(remote)
+	if err != nil {
+		return nil, false, err
+	}
+	if hash.IsZero() {
+		if err = repo.Storer.RemoveReference(g.branch); err != nil {
+			return nil, false, err
+		}
+		return repo, false, nil
+	}
+
+	err = remote.FetchContext(ctx, &git.FetchOptions{
+		RefSpecs: []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", g.branch, g.branch))},
+		Auth:     g.auth,
+		Force:    true,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return nil, false, err
+	}
+	return repo, true, nil
+}
+
+// remoteHash returns the commit the remote's branch points to, which is zero if the remote does not have the branch
+func (g *gitTarget) remoteHash(remote *git.Remote) (plumbing.Hash, error) {
+	refs, err := remote.List(&git.ListOptions{Auth: g.auth})
+	if err == transport.ErrEmptyRemoteRepository {
+		return plumbing.ZeroHash, nil
+	} else if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	for _, ref := range refs {
+		if ref.Name() == g.branch {
+			return ref.Hash(), nil
+		}
+	}
+	return plumbing.ZeroHash, nil
+}
+
+func (g *gitTarget) Upload(ctx context.Context, dir string, manifest structs2.Manifest) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	repo, exists, err := g.pull(ctx)
+	if err != nil {
+		return err
+	}
+
+	var entries []object.TreeEntry
+	for _, name := range []string{manifestFile, manifest.File} {
+		hash, err := storeBlob(repo, filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		entries = append(entries, object.TreeEntry{Name: name, Mode: filemode.Regular, Hash: hash})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	tree, err := storeObject(repo, &object.Tree{Entries: entries})
+	if err != nil {
+		return err
+	}
+
+	signature := object.Signature{Name: os.Getenv("DB_BACKUP_NAME"), Email: os.Getenv("DB_BACKUP_EMAIL"), When: manifest.CreatedAt}
+	commit := &object.Commit{
+		Author:    signature,
+		Committer: signature,
+		Message:   gitBackupMessage + backupID(manifest),
+		TreeHash:  tree,
+	}
+	if exists {
+		head, err := repo.Reference(g.branch, false)
+		if err != nil {
+			return err
+		}
+		commit.ParentHashes = []plumbing.Hash{head.Hash()}
+	}
+	hash, err := storeObject(repo, commit)
+	if err != nil {
+		return err
+	}
+	if err = repo.Storer.SetReference(plumbing.NewHashReference(g.branch, hash)); err != nil {
+		return err
+	}
+	if err = g.verifyBlob(repo, entries, manifest); err != nil {
+		return err
+	}
+
+	err = repo.PushContext(ctx, &git.PushOptions{
+		RefSpecs: []config.RefSpec{config.RefSpec(fmt.Sprintf("%s:%s", g.branch, g.branch))},
+		Auth:     g.auth,
+	})
+	if err != nil {
+		return err
+	}
+	return g.verifyRemote(repo, hash)
+}
+
+// verifyBlob checks the dump stored in the clone against the manifest before it is pushed
+func (g *gitTarget) verifyBlob(repo *git.Repository, entries []object.TreeEntry, manifest structs2.Manifest) error {
+	for _, entry := range entries {
+		if entry.Name != manifest.File {
+			continue
+		}
+		blob, err := repo.BlobObject(entry.Hash)
+		if err != nil {
+			return err
+		}
+		r, err := blob.Reader()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		sum := newChecksum()
+		if _, err = io.Copy(sum, r); err != nil {
+			return err
+		}
+		if sum.size != manifest.Size || sum.Sum() != manifest.SHA256 {
+			return fmt.Errorf("%w: %s", ErrUploadMismatch, manifest.File)
+		}
+	}
+	return nil
+}
+
+// verifyRemote checks the remote's branch points to the commit, a commit's hash covers the content of its files
+func (g *gitTarget) verifyRemote(repo *git.Repository, hash plumbing.Hash) error {
+	remote, err := repo.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return err
+	}
+	pushed, err := g.remoteHash(remote)
+	if err != nil {
+		return err
+	}
+	if pushed != hash {
+		return fmt.Errorf("%w: the remote branch is at %s rather than %s", ErrUploadMismatch, pushed, hash)
+	}
+	return nil
+}
+
+func (g *gitTarget) List(ctx context.Context) ([]structs2.RemoteBackup, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	repo, exists, err := g.pull(ctx)
+	if err != nil || !exists {
+		return nil, err
+	}
+	commits, err := g.backups(repo)
+	if err != nil {
+		return nil, err
+	}
+	var backups []structs2.RemoteBackup
+	for id := range commits {
+		created, _ := parseBackupID(id)
+		backups = append(backups, structs2.RemoteBackup{ID: id, CreatedAt: created})
+	}
+	sortBackups(backups)
+	return backups, nil
+}
+
+// backups returns the commit holding each backup on the branch by the backup's ID
+func (g *gitTarget) backups(repo *git.Repository) (map[string]*object.Commit, error) {
+	head, err := repo.Reference(g.branch, false)
+	if err != nil {
+		return nil, err
+	}
+	iter, err := repo.Log(&git.LogOptions{From: head.Hash()})
+	if err != nil {
+		return nil, err
+	}
+	commits := make(map[string]*object.Commit)
+	err = iter.ForEach(func(c *object.Commit) error {
+		id := strings.TrimPrefix(strings.TrimSpace(c.Message), gitBackupMessage)
+		if _, ok := parseBackupID(id); ok && strings.HasPrefix(c.Message, gitBackupMessage) {
+			commits[id] = c
+		}
+		return nil
+	})
+	return commits, err
+}
+
+func (g *gitTarget) Download(ctx context.Context, id, dir string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	repo, exists, err := g.pull(ctx)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("backup %s not found", id)
+	}
+	commits, err := g.backups(repo)
+	if err != nil {
+		return err
+	}
+	commit, ok := commits[id]
+	if !ok {
+		return fmt.Errorf("backup %s not found", id)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+	if err = writeBlob(tree, manifestFile, dir); err != nil {
+		return err
+	}
+	manifest, err := readManifest(dir)
+	if err != nil {
+		return err
+	}
+	return writeBlob(tree, manifest.File, dir)
+}
+
+// Delete rewrites the branch without the backups' commits and force pushes it, the newest backup cannot be deleted
+func (g *gitTarget) Delete(ctx context.Context, ids []string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	repo, exists, err := g.pull(ctx)
+	if err != nil || !exists {
+		return err
+	}
+	head, err := repo.Reference(g.branch, false)
+	if err != nil {
+		return err
+	}
+
+	deleted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		deleted[id] = true
+	}
+	iter, err := repo.Log(&git.LogOptions{From: head.Hash()})
+	if err != nil {
+		return err
+	}
+	var keep []string
+	err = iter.ForEach(func(c *object.Commit) error {
+		if id := strings.TrimPrefix(strings.TrimSpace(c.Message), gitBackupMessage); !deleted[id] {
+			keep = append(keep, c.Hash.String())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if removed, err := compactHistory(repo, keep); err != nil || removed == 0 {
+		return err
+	}
+	compacted, err := repo.Reference(g.branch, false)
+	if err != nil {
+		return err
+	}
+	err = repo.PushContext(ctx, &git.PushOptions{
+		RefSpecs: []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", g.branch, g.branch))},
+		Auth:     g.auth,
+	})
+	if err != nil {
+		return err
+	}
+	return g.verifyRemote(repo, compacted.Hash())
+}
+
+func storeBlob(repo *git.Repository, file string) (plumbing.Hash, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	obj := repo.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	obj.SetSize(info.Size())
+	w, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if _, err = io.Copy(w, f); err != nil {
+		w.Close()
+		return plumbing.ZeroHash, err
+	}
+	if err = w.Close(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return repo.Storer.SetEncodedObject(obj)
+}
+
+func storeObject(repo *git.Repository, o interface {
+	Encode(plumbing.EncodedObject) error
+}) (plumbing.Hash, error) {
+	obj := repo.Storer.NewEncodedObject()
+	if err := o.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return repo.Storer.SetEncodedObject(obj)
+}
+
+func writeBlob(tree *object.Tree, name, dir string) error {
+	file, err := tree.File(name)
+	if err != nil {
+		return err
+	}
+	r, err := file.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,88 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"io"
+	"net/http"
+)
+
+// S3Config is a bucket on S3 or a service compatible with it, e.g. MinIO
+type S3Config struct {
+	// Endpoint is the host and port of the service, e.g. s3.amazonaws.com
+	Endpoint string `json:"endpoint"`
+	Bucket   string `json:"bucket"`
+	// Prefix is the path in the bucket the backups are kept under
+	Prefix    string `json:"prefix"`
+	Region    string `json:"region"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	// Insecure connects over HTTP rather than HTTPS
+	Insecure bool `json:"insecure"`
+	// transport replaces the HTTP transport in tests
+	transport http.RoundTripper
+}
+
+type s3Store struct {
+	client *minio.Client
+	bucket string
+}
+
+func newS3Target(cfg S3Config, resolve Resolver) (BackupTarget, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("s3 targets need an endpoint and a bucket")
+	}
+	accessKey, err := resolve(cfg.AccessKey)
+	if err != nil {
+		return nil, err
+	}
+	secretKey, err := resolve(cfg.SecretKey)
+	if err != nil {
+		return nil, err
+	}
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:     credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure:    !cfg.Insecure,
+		Region:    cfg.Region,
+		Transport: cfg.transport,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &objectTarget{store: &s3Store{client: client, bucket: cfg.Bucket}, prefix: cfg.Prefix}, nil
+}
+
+func (s *s3Store) put(ctx context.Context, key string, r io.Reader, size int64) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	return err
+}
+
+func (s *s3Store) get(ctx context.Context, key string) (io.ReadCloser, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// the object is not requested until it is read, stat makes a missing object an error here
+	if _, err = object.Stat(); err != nil {
+		object.Close()
+		return nil, err
+	}
+	return object, nil
+}
+
+func (s *s3Store) list(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		keys = append(keys, object.Key)
+	}
+	return keys, nil
+}
+
+func (s *s3Store) remove(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
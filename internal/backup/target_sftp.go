@@ -0,0 +1,205 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sftpTimeout is how long connecting to an SFTP server can take
+const sftpTimeout = 30 * time.Second
+
+// SFTPConfig is a directory on an SFTP server, the server is authenticated by its host key and the user by a password
+// or a private key
+type SFTPConfig struct {
+	// Address is the host and port of the server, e.g. backups.example.com:22
+	Address  string `json:"address"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	// PrivateKeyFile is a file holding an unencrypted private key in PEM format
+	PrivateKeyFile string `json:"private_key_file"`
+	// HostKey is the server's public key in authorized_keys format
+	HostKey string `json:"host_key"`
+	// Path is the directory the backups are kept in
+	Path string `json:"path"`
+}
+
+// sftpStore keeps files under a directory of an SFTP server, the connection is opened when it is first needed and
+// reopened after an error
+type sftpStore struct {
+	dial func() (*sftp.Client, error)
+	root string
+
+	mu     sync.Mutex
+	client *sftp.Client
+}
+
+func newSFTPTarget(cfg SFTPConfig, resolve Resolver) (BackupTarget, error) {
+	if cfg.Address == "" || cfg.User == "" || cfg.Path == "" {
+		return nil, errors.New("sftp targets need an address, a user and a path")
+	}
+	if cfg.HostKey == "" {
+		return nil, errors.New("sftp targets need the server's host key")
+	}
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(cfg.HostKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid host key: %w", err)
+	}
+
+	var auth []ssh.AuthMethod
+	if cfg.PrivateKeyFile != "" {
+		data, err := ioutil.ReadFile(cfg.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if cfg.Password != "" {
+		password, err := resolve(cfg.Password)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.Password(password))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("sftp targets need a password or a private key")
+	}
+
+	sshConfig := &ssh.ClientConfig{
+		User:            cfg.User,
+		Auth:            auth,
+		HostKeyCallback: ssh.FixedHostKey(hostKey),
+		Timeout:         sftpTimeout,
+	}
+	dial := func() (*sftp.Client, error) {
+		conn, err := ssh.Dial("tcp", cfg.Address, sshConfig)
+		if err != nil {
+			return nil, err
+		}
+		client, err := sftp.NewClient(conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return client, nil
+	}
+	return &objectTarget{store: &sftpStore{dial: dial, root: cfg.Path}}, nil
+}
+
+// do runs op with the connection, which is closed if op fails so the next operation reconnects
+func (s *sftpStore) do(op func(*sftp.Client) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		client, err := s.dial()
+		if err != nil {
+			return err
+		}
+		s.client = client
+	}
+	err := op(s.client)
+	if err != nil && !os.IsNotExist(err) {
+		s.client.Close()
+		s.client = nil
+	}
+	return err
+}
+
+func (s *sftpStore) path(key string) string {
+	return path.Join(s.root, key)
+}
+
+// put writes the file under a temporary name and renames it, so a file which is partly written is never read
+func (s *sftpStore) put(_ context.Context, key string, r io.Reader, _ int64) error {
+	file := s.path(key)
+	return s.do(func(c *sftp.Client) error {
+		if err := c.MkdirAll(path.Dir(file)); err != nil {
+			return err
+		}
+		f, err := c.Create(file + ".tmp")
+		if err != nil {
+			return err
+		}
+		if _, err = f.ReadFrom(r); err != nil {
+			f.Close()
+			return err
+		}
+		if err = f.Close(); err != nil {
+			return err
+		}
+		c.Remove(file)
+		return c.Rename(file+".tmp", file)
+	})
+}
+
+// get reads the whole file, so the connection is not held while the caller reads it
+func (s *sftpStore) get(_ context.Context, key string) (io.ReadCloser, error) {
+	var data []byte
+	err := s.do(func(c *sftp.Client) error {
+		f, err := c.Open(s.path(key))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		data, err = ioutil.ReadAll(f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *sftpStore) list(_ context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := s.do(func(c *sftp.Client) error {
+		walker := c.Walk(s.root)
+		for walker.Step() {
+			if err := walker.Err(); err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if walker.Stat().IsDir() {
+				continue
+			}
+			key := strings.TrimPrefix(walker.Path(), path.Clean(s.root)+"/")
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// remove deletes the file, and its directory once the directory is empty
+func (s *sftpStore) remove(_ context.Context, key string) error {
+	file := s.path(key)
+	return s.do(func(c *sftp.Client) error {
+		if err := c.Remove(file); err != nil {
+			return err
+		}
+		if dir := path.Dir(file); dir != path.Clean(s.root) {
+			if entries, err := c.ReadDir(dir); err == nil && len(entries) == 0 {
+				c.RemoveDirectory(dir)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,397 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"fp-dynamic-elements-manager-controller/internal/backup/mocks"
+	structs2 "fp-dynamic-elements-manager-controller/internal/backup/structs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStore is an object store held in memory, corrupt changes the objects read back
+type memStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	corrupt bool
+}
+
+func newMemStore() *memStore {
+	return &memStore{objects: make(map[string][]byte)}
+}
+
+func (m *memStore) put(_ context.Context, key string, r io.Reader, _ int64) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = data
+	return nil
+}
+
+func (m *memStore) get(_ context.Context, key string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	if m.corrupt {
+		data = append([]byte{'x'}, data...)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memStore) list(_ context.Context, prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []string
+	for key := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *memStore) remove(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+// writeBackup writes a backup taken at the time to dir, as a backup would
+func writeBackup(t *testing.T, dir string, created time.Time) structs2.Manifest {
+	data := []byte("backup taken at " + created.String())
+	sum := newChecksum()
+	sum.Write(data)
+	manifest := structs2.Manifest{File: "elements.sql.gz", CreatedAt: created, Size: sum.size, SHA256: sum.Sum()}
+	encoded, err := json.Marshal(manifest)
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, manifest.File), data, 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, manifestFile), encoded, 0600))
+	return manifest
+}
+
+// testBackupTarget uploads, lists, downloads and deletes backups on the target
+func testBackupTarget(t *testing.T, target BackupTarget) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "target")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	backups, err := target.List(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, backups)
+
+	first := writeBackup(t, dir, time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC))
+	assert.Nil(t, target.Upload(ctx, dir, first))
+	second := writeBackup(t, dir, time.Date(2020, 7, 2, 12, 0, 0, 0, time.UTC))
+	assert.Nil(t, target.Upload(ctx, dir, second))
+
+	backups, err = target.List(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []structs2.RemoteBackup{
+		{ID: "20200702T120000.000Z", CreatedAt: second.CreatedAt},
+		{ID: "20200701T120000.000Z", CreatedAt: first.CreatedAt},
+	}, backups)
+
+	restored, err := ioutil.TempDir("", "restored")
+	assert.Nil(t, err)
+	defer os.RemoveAll(restored)
+	assert.Nil(t, target.Download(ctx, "20200701T120000.000Z", restored))
+	manifest, err := readManifest(restored)
+	assert.Nil(t, err)
+	assert.Equal(t, first.SHA256, manifest.SHA256)
+	data, err := ioutil.ReadFile(filepath.Join(restored, manifest.File))
+	assert.Nil(t, err)
+	assert.Equal(t, "backup taken at "+first.CreatedAt.String(), string(data))
+
+	assert.Nil(t, target.Delete(ctx, []string{"20200701T120000.000Z"}))
+	backups, err = target.List(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []structs2.RemoteBackup{{ID: "20200702T120000.000Z", CreatedAt: second.CreatedAt}}, backups)
+	assert.NotNil(t, target.Download(ctx, "20200701T120000.000Z", restored))
+	assert.NotNil(t, target.Download(ctx, "../20200702T120000.000Z", restored))
+}
+
+func (b *BackupRestoreTestSuite) TestObjectTarget() {
+	b.T().Run("Memory", func(t *testing.T) {
+		testBackupTarget(t, &objectTarget{store: newMemStore(), prefix: "backups"})
+	})
+
+	b.T().Run("Invalid Manifest", func(t *testing.T) {
+		store := newMemStore()
+		target := &objectTarget{store: store}
+		dir, err := ioutil.TempDir("", "restored")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+
+		for _, file := range []string{"..", ".", "../elements.sql", "", manifestFile} {
+			store.objects[target.key("20200701T120000.000Z", manifestFile)] = []byte(`{"file": "` + file + `"}`)
+			err := target.Download(context.Background(), "20200701T120000.000Z", dir)
+			assert.True(t, errors.Is(err, ErrInvalidManifest), file)
+		}
+	})
+
+	b.T().Run("Upload Mismatch", func(t *testing.T) {
+		store := newMemStore()
+		store.corrupt = true
+		manifest := writeBackup(t, b.backupDir, time.Now())
+		err := (&objectTarget{store: store}).Upload(context.Background(), b.backupDir, manifest)
+		assert.True(t, errors.Is(err, ErrUploadMismatch))
+		assert.Empty(t, store.objects)
+	})
+}
+
+func (b *BackupRestoreTestSuite) TestS3Target() {
+	server := httptest.NewTLSServer(newFakeS3("backups"))
+	defer server.Close()
+
+	target, err := newS3Target(S3Config{
+		Endpoint:  server.Listener.Addr().String(),
+		Bucket:    "backups",
+		Prefix:    "controller",
+		Region:    "us-east-1",
+		AccessKey: "${secret:s3-access-key}",
+		SecretKey: "secret",
+		transport: server.Client().Transport,
+	}, func(v string) (string, error) { return strings.Replace(v, "${secret:s3-access-key}", "access", 1), nil })
+	b.Require().Nil(err)
+	testBackupTarget(b.T(), target)
+}
+
+func (b *BackupRestoreTestSuite) TestSFTPTarget() {
+	handlers := sftp.InMemHandler()
+	dial := func() (*sftp.Client, error) {
+		client, server := net.Pipe()
+		go sftp.NewRequestServer(server, handlers).Serve()
+		return sftp.NewClientPipe(client, client)
+	}
+	testBackupTarget(b.T(), &objectTarget{store: &sftpStore{dial: dial, root: "/backups"}})
+}
+
+func (b *BackupRestoreTestSuite) TestGitTarget() {
+	remote, err := ioutil.TempDir("", "remote")
+	b.Require().Nil(err)
+	defer os.RemoveAll(remote)
+	_, err = git.PlainInit(remote, true)
+	b.Require().Nil(err)
+
+	target, err := newGitTarget("offsite", GitRemoteConfig{URL: remote, Branch: "backups"}, nil)
+	b.Require().Nil(err)
+	testBackupTarget(b.T(), target)
+
+	// the deleted backup is no longer in the remote's history
+	repo, err := git.PlainOpen(remote)
+	b.Require().Nil(err)
+	iter, err := repo.Log(&git.LogOptions{All: true})
+	b.Require().Nil(err)
+	var messages []string
+	iter.ForEach(func(c *object.Commit) error {
+		messages = append(messages, c.Message)
+		return nil
+	})
+	b.Equal([]string{"Backup 20200702T120000.000Z"}, messages)
+}
+
+func (b *BackupRestoreTestSuite) TestNewTargets() {
+	resolve := func(v string) (string, error) { return v, nil }
+	s3 := &S3Config{Endpoint: "s3.example.com", Bucket: "backups"}
+	for name, cfg := range map[string]TargetConfig{
+		"Name":         {Name: "off site", Type: S3Target, Interval: "1h", S3: s3},
+		"Interval":     {Name: "offsite", Type: S3Target, Interval: "1s", S3: s3},
+		"Retention":    {Name: "offsite", Type: S3Target, Interval: "1h", S3: s3, Retention: Retention{Daily: -1}},
+		"Type":         {Name: "offsite", Type: SFTPTarget, Interval: "1h", S3: s3},
+		"Host Key":     {Name: "offsite", Type: SFTPTarget, Interval: "1h", SFTP: &SFTPConfig{Address: "sftp.example.com:22", User: "backup", Password: "p", Path: "/backups"}},
+		"Git Settings": {Name: "offsite", Type: GitTarget, Interval: "1h", Git: &GitRemoteConfig{}},
+	} {
+		_, err := NewTargets([]TargetConfig{cfg}, resolve)
+		b.NotNil(err, name)
+	}
+
+	_, err := NewTargets([]TargetConfig{
+		{Name: "offsite", Type: S3Target, Interval: "1h", S3: s3},
+		{Name: "offsite", Type: GitTarget, Interval: "1h", Git: &GitRemoteConfig{URL: "https://git.example.com/backups.git"}},
+	}, resolve)
+	b.NotNil(err)
+
+	// secrets are resolved when the target is first used rather than when it is created
+	targets, err := NewTargets([]TargetConfig{
+		{Name: "offsite", Type: S3Target, Interval: "6h", S3: &S3Config{Endpoint: "s3.example.com", Bucket: "backups", SecretKey: "${secret:s3}"}},
+	}, func(string) (string, error) { return "", errors.New("secrets are not loaded") })
+	b.Nil(err)
+	b.Equal(structs2.TargetStatus{Name: "offsite", Type: "s3", Interval: "6h0m0s"}, targets[0].Status())
+	_, err = targets[0].open()
+	b.NotNil(err)
+}
+
+func (b *BackupRestoreTestSuite) TestDatabaseBackupProvider_Targets() {
+	b.loggerObj.On("Info", mock.Anything)
+	store := newMemStore()
+	target := NewTarget(TargetConfig{Name: "offsite", Type: S3Target, Retention: Retention{Last: 2}}, time.Hour, &objectTarget{store: store})
+	failing := NewTarget(TargetConfig{Name: "failing", Type: S3Target}, time.Hour, &objectTarget{store: &memStore{objects: map[string][]byte{}, corrupt: true}})
+
+	committerObj := new(mocks.CommitterMock)
+	committerObj.On("Commit", "Manual", int64(5)).Return(nil)
+	repoObj := b.repo()
+	provider := NewDatabaseBackupProvider(DefaultConfig(), new(mocks.OrchestratorMock), "mariadb", committerObj, nil, b.logger, repoObj, []*Target{target, failing})
+	ctx := context.Background()
+
+	// without a backup the copy is skipped rather than reported as failed
+	b.True(errors.Is(provider.SyncTarget(ctx, "offsite"), ErrNoManifest))
+	b.loggerObj.AssertNotCalled(b.T(), "Error", mock.Anything, mock.Anything)
+	b.logger.NotificationService.(*mocks.NSMock).AssertNotCalled(b.T(), "Send")
+	b.Nil(provider.Targets()[0].LastSync)
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		b.Require().Nil(provider.Backup("Manual"))
+		b.Require().Nil(provider.SyncTarget(ctx, "offsite"))
+		// syncing again leaves the target as it was
+		b.Require().Nil(provider.SyncTarget(ctx, "offsite"))
+		manifest, err := readManifest(b.backupDir)
+		b.Require().Nil(err)
+		ids = append(ids, backupID(manifest))
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	// the retention policy of the target keeps the newest two
+	backups, err := provider.ListTarget(ctx, "offsite")
+	b.Nil(err)
+	b.Equal(2, len(backups))
+	b.Equal(ids[2], backups[0].ID)
+	b.Equal(ids[1], backups[1].ID)
+
+	b.True(errors.Is(provider.SyncTarget(ctx, "failing"), ErrUploadMismatch))
+	statuses := provider.Targets()
+	b.Equal(ids[2], statuses[0].LastBackup)
+	b.Empty(statuses[0].LastError)
+	b.NotNil(statuses[1].LastSync)
+	b.Contains(statuses[1].LastError, ErrUploadMismatch.Error())
+
+	_, err = provider.ListTarget(ctx, "missing")
+	b.True(errors.Is(err, ErrTargetNotFound))
+
+	// the backup is verified and loaded from the target
+	b.Require().Nil(os.Remove(filepath.Join(b.backupDir, "elements.sql.gz")))
+	b.Nil(provider.RestoreFromTarget(ctx, "offsite", ids[1]))
+	if b.Len(repoObj.Imported, 1) {
+		b.Equal(elements.Table, repoObj.Imported[0].Table)
+		b.Len(repoObj.Imported[0].Rows, 5)
+	}
+	b.NotNil(provider.RestoreFromTarget(ctx, "offsite", ids[0]))
+}
+
+// fakeS3 serves the object requests the S3 target makes, from a single bucket held in memory
+type fakeS3 struct {
+	bucket string
+	mu     sync.Mutex
+	data   map[string][]byte
+}
+
+func newFakeS3(bucket string) *fakeS3 {
+	return &fakeS3{bucket: bucket, data: make(map[string][]byte)}
+}
+
+type s3ListResult struct {
+	XMLName     xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name        string
+	Prefix      string
+	KeyCount    int
+	MaxKeys     int
+	IsTruncated bool
+	Contents    []s3Object
+}
+
+type s3Object struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if !strings.HasPrefix(path+"/", f.bucket+"/") {
+		http.Error(w, "no such bucket", http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(path, f.bucket), "/")
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		result := s3ListResult{Name: f.bucket, Prefix: r.URL.Query().Get("prefix"), MaxKeys: 1000}
+		var keys []string
+		for k := range f.data {
+			if strings.HasPrefix(k, result.Prefix) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			result.Contents = append(result.Contents, s3Object{Key: k, Size: int64(len(f.data[k])), ETag: etag(f.data[k]), LastModified: "2020-07-01T12:00:00.000Z"})
+		}
+		result.KeyCount = len(keys)
+		w.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(w).Encode(result)
+	case r.Method == http.MethodPut:
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.data[key] = data
+		w.Header().Set("ETag", etag(data))
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := f.data[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				fmt.Fprintf(w, "<Error><Code>NoSuchKey</Code><Key>%s</Key></Error>", key)
+			}
+			return
+		}
+		w.Header().Set("ETag", etag(data))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, key, time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC), bytes.NewReader(data))
+	case r.Method == http.MethodDelete:
+		delete(f.data, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
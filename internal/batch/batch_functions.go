@@ -2,8 +2,8 @@ package batch
 
 import (
 	"fp-dynamic-elements-manager-controller/internal/db/persistence"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
 	"fp-dynamic-elements-manager-controller/internal/queue/structs"
-	"github.com/rs/zerolog/log"
 	"math"
 )
 
@@ -25,14 +25,14 @@ func GetPaginatedBatchResults(page, pageSize int, status structs.Status, repo *p
 	}
 
 	if err != nil {
-		log.Error().Err(err).Msg("Error retrieving paginated update statuses")
+		applog.System().Error(err, "Error retrieving paginated update statuses")
 		return items
 	}
 
 	totalRows, err := repo.GetTotalCount()
 
 	if err != nil {
-		log.Error().Err(err).Msg("Error getting total count of update statuses")
+		applog.System().Error(err, "Error getting total count of update statuses")
 		return items
 	}
 
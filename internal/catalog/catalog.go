@@ -0,0 +1,306 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"fp-dynamic-elements-manager-controller/internal/catalog/structs"
+	dockerstructs "fp-dynamic-elements-manager-controller/internal/docker/structs"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
+	"fp-dynamic-elements-manager-controller/internal/tracing"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// RegistryURL is the base URL of the registry, e.g. https://docker.frcpnt.com
+	RegistryURL string
+	Username    string
+	Password    string
+	// Namespace limits the catalog to the repositories under it, e.g. fp-dim/
+	Namespace string
+	// CacheTTL is how long the catalog is served from the cache before the registry is read again
+	CacheTTL time.Duration
+	// Timeout is the time allowed for each request to the registry
+	Timeout time.Duration
+	// ControllerVersion is compared to the minimum controller version of each module, modules are compatible when unset
+	ControllerVersion string
+}
+
+func DefaultConfig() Config {
+	return Config{
+		CacheTTL: 10 * time.Minute,
+		Timeout:  10 * time.Second,
+	}
+}
+
+// ConfigFromEnv reads the registry login used to pull module images, CATALOG_CACHE_TTL and CATALOG_TIMEOUT. The
+// namespace is the part of DOCKER_PREFIX after the registry
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+
+	registry := os.Getenv("DOCKER_REGISTRY")
+	cfg.RegistryURL = fmt.Sprintf("https://%s", registry)
+	cfg.Username = os.Getenv("DOCKER_USER")
+	cfg.Password = os.Getenv("DOCKER_PASSWORD")
+	cfg.Namespace = strings.TrimPrefix(os.Getenv("DOCKER_PREFIX"), registry+"/")
+	cfg.ControllerVersion = os.Getenv("CONTROLLER_VERSION")
+
+	if d, err := time.ParseDuration(os.Getenv("CATALOG_CACHE_TTL")); err == nil && d >= 0 {
+		cfg.CacheTTL = d
+	}
+
+	if d, err := time.ParseDuration(os.Getenv("CATALOG_TIMEOUT")); err == nil && d > 0 {
+		cfg.Timeout = d
+	}
+
+	return cfg
+}
+
+// Catalog lists the modules available in the registry, the catalog is cached and only read from the registry once
+// the cache has expired or a refresh is asked for
+type Catalog struct {
+	cfg      Config
+	registry *RegistryClient
+	host     string
+	now      func() time.Time
+
+	mu         sync.Mutex
+	cached     *structs.Catalog
+	fetchedAt  time.Time
+	refreshing *refresh
+}
+
+// refresh is a read of the registry in flight, every caller waiting on the catalog shares it
+type refresh struct {
+	done    chan struct{}
+	catalog structs.Catalog
+	err     error
+}
+
+func NewCatalog(cfg Config) *Catalog {
+	host := cfg.RegistryURL
+	if u, err := url.Parse(cfg.RegistryURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	return &Catalog{
+		cfg:      cfg,
+		registry: NewRegistryClient(cfg.RegistryURL, cfg.Username, cfg.Password, &http.Client{Timeout: cfg.Timeout}),
+		host:     host,
+		now:      time.Now,
+	}
+}
+
+// Get returns the catalog. If the registry cannot be read the last catalog is returned marked as stale, an error is
+// only returned if there is no catalog to fall back on. While the registry is being read the last catalog is returned,
+// only callers with nothing to fall back on wait for the read
+func (c *Catalog) Get(ctx context.Context, refresh bool) (structs.Catalog, error) {
+	c.mu.Lock()
+	if !refresh && c.cached != nil && c.now().Sub(c.fetchedAt) < c.cfg.CacheTTL {
+		cached := *c.cached
+		c.mu.Unlock()
+		return cached, nil
+	}
+
+	call := c.refreshing
+	if call == nil {
+		call = c.startRefresh(ctx)
+	} else if c.cached != nil {
+		cached := *c.cached
+		c.mu.Unlock()
+		return cached, nil
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return structs.Catalog{}, ctx.Err()
+	}
+
+	if call.err != nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.cached == nil {
+			return structs.Catalog{}, call.err
+		}
+		stale := *c.cached
+		stale.Stale = true
+		return stale, nil
+	}
+	return call.catalog, nil
+}
+
+// startRefresh reads the registry in the background, the read is not cancelled with ctx as other callers may be
+// waiting on it. c.mu must be held
+func (c *Catalog) startRefresh(ctx context.Context) *refresh {
+	call := &refresh{done: make(chan struct{})}
+	c.refreshing = call
+
+	go func() {
+		ctx := tracing.Detach(ctx)
+		catalog, err := c.fetch(ctx)
+		if err != nil {
+			applog.System().WithContext(ctx).WithFields(applog.Fields{"module": "catalog"}).Error(err, "error reading the module catalog from the registry")
+		}
+
+		c.mu.Lock()
+		if err == nil {
+			c.cached, c.fetchedAt = &catalog, c.now()
+		}
+		c.refreshing = nil
+		c.mu.Unlock()
+
+		call.catalog, call.err = catalog, err
+		close(call.done)
+	}()
+	return call
+}
+
+func (c *Catalog) fetch(ctx context.Context) (structs.Catalog, error) {
+	repos, err := c.registry.Repositories(ctx)
+	if err != nil {
+		return structs.Catalog{}, err
+	}
+
+	catalog := structs.Catalog{Modules: []structs.Module{}, UpdatedAt: c.now()}
+	for _, repo := range repos {
+		if !strings.HasPrefix(repo, c.cfg.Namespace) {
+			continue
+		}
+		module, err := c.module(ctx, repo)
+		if err != nil {
+			if ctx.Err() != nil {
+				return structs.Catalog{}, ctx.Err()
+			}
+			// A single broken repository is reported on the module rather than failing the whole catalog
+			module.Error = err.Error()
+		}
+		catalog.Modules = append(catalog.Modules, module)
+	}
+	return catalog, nil
+}
+
+func (c *Catalog) module(ctx context.Context, repo string) (structs.Module, error) {
+	module := structs.Module{
+		Repository:        repo,
+		Name:              strings.TrimPrefix(repo, c.cfg.Namespace),
+		AcceptedDataTypes: []dockerstructs.ElementType{},
+		Compatible:        true,
+		Versions:          []string{},
+	}
+
+	tags, err := c.registry.Tags(ctx, repo)
+	if err != nil {
+		return module, err
+	}
+	module.Versions = SortVersions(tags)
+	if len(module.Versions) == 0 {
+		return module, nil
+	}
+	module.LatestVersion = LatestVersion(module.Versions)
+	module.ImageRef = fmt.Sprintf("%s/%s:%s", c.host, repo, module.LatestVersion)
+
+	labels, err := c.registry.Labels(ctx, repo, module.LatestVersion)
+	if err != nil {
+		return module, err
+	}
+	if name := labels[structs.LabelName]; name != "" {
+		module.Name = name
+	}
+	module.Type = dockerstructs.ModuleType(labels[structs.LabelType])
+	module.Description = labels[structs.LabelDescription]
+	module.IconURL = labels[structs.LabelIconURL]
+	module.MinControllerVersion = labels[structs.LabelMinControllerVersion]
+	for _, t := range strings.Split(labels[structs.LabelAcceptedDataTypes], ",") {
+		if t = strings.ToUpper(strings.TrimSpace(t)); t != "" {
+			module.AcceptedDataTypes = append(module.AcceptedDataTypes, dockerstructs.ElementType(t))
+		}
+	}
+	module.Compatible = Compatible(c.cfg.ControllerVersion, module.MinControllerVersion)
+
+	return module, nil
+}
+
+var semver = regexp.MustCompile(`^v?(\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z.-]+))?$`)
+
+type version struct {
+	parts      [3]int
+	prerelease string
+}
+
+func parseVersion(tag string) (version, bool) {
+	m := semver.FindStringSubmatch(tag)
+	if m == nil {
+		return version{}, false
+	}
+	v := version{prerelease: m[4]}
+	for i := range v.parts {
+		v.parts[i], _ = strconv.Atoi(m[i+1])
+	}
+	return v, true
+}
+
+// less is true when a is an older version than b, a pre-release is older than its release
+func (a version) less(b version) bool {
+	for i := range a.parts {
+		if a.parts[i] != b.parts[i] {
+			return a.parts[i] < b.parts[i]
+		}
+	}
+	if a.prerelease == "" || b.prerelease == "" {
+		return a.prerelease != "" && b.prerelease == ""
+	}
+	return a.prerelease < b.prerelease
+}
+
+// SortVersions sorts tags newest first, semantic versions come first followed by any other tags by name
+func SortVersions(tags []string) []string {
+	sorted := append([]string{}, tags...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, aok := parseVersion(sorted[i])
+		b, bok := parseVersion(sorted[j])
+		switch {
+		case aok && bok:
+			return b.less(a)
+		case aok != bok:
+			return aok
+		}
+		return sorted[i] < sorted[j]
+	})
+	return sorted
+}
+
+// LatestVersion returns the newest release in the sorted tags, falling back to latest and then the first tag
+func LatestVersion(sorted []string) string {
+	for _, tag := range sorted {
+		if v, ok := parseVersion(tag); ok && v.prerelease == "" {
+			return tag
+		}
+	}
+	for _, tag := range sorted {
+		if tag == "latest" {
+			return tag
+		}
+	}
+	return sorted[0]
+}
+
+// Compatible is true unless both versions are known and the controller is older than the minimum
+func Compatible(controllerVersion, minVersion string) bool {
+	controller, ok := parseVersion(controllerVersion)
+	if !ok {
+		return true
+	}
+	min, ok := parseVersion(minVersion)
+	if !ok {
+		return true
+	}
+	return !controller.less(min)
+}
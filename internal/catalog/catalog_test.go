@@ -0,0 +1,240 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"fp-dynamic-elements-manager-controller/internal/catalog/structs"
+	dockerstructs "fp-dynamic-elements-manager-controller/internal/docker/structs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// registry is a stand-in for a docker registry which asks for a bearer token like a real one
+type registry struct {
+	server   *httptest.Server
+	tags     map[string][]string
+	labels   map[string]map[string]string
+	requests int32
+	down     int32
+	// block holds up every request until it is closed, when it is set
+	block chan struct{}
+}
+
+func newRegistry() *registry {
+	r := &registry{
+		tags: map[string][]string{
+			"fp-dim/fp-ngfw":  {"latest", "1.2.0", "1.10.0", "1.11.0-rc1"},
+			"fp-dim/fp-smc":   {"latest"},
+			"fp-dim/broken":   {"1.0.0"},
+			"other/fp-ignore": {"1.0.0"},
+		},
+		labels: map[string]map[string]string{
+			"fp-dim/fp-ngfw": {
+				structs.LabelName:                 "Forcepoint NGFW",
+				structs.LabelType:                 "egress",
+				structs.LabelDescription:          "Pushes elements to the NGFW",
+				structs.LabelAcceptedDataTypes:    "ip, range,url",
+				structs.LabelMinControllerVersion: "2.0.0",
+			},
+			"fp-dim/fp-smc": {structs.LabelType: "ingress"},
+		},
+	}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serve))
+	return r
+}
+
+func (r *registry) serve(w http.ResponseWriter, req *http.Request) {
+	atomic.AddInt32(&r.requests, 1)
+	if r.block != nil {
+		<-r.block
+	}
+	if atomic.LoadInt32(&r.down) == 1 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if req.URL.Path == "/token" {
+		if user, pass, ok := req.BasicAuth(); !ok || user != "user" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "token-for-" + req.URL.Query().Get("scope")})
+		return
+	}
+	if !strings.HasPrefix(req.Header.Get("Authorization"), "Bearer token-for-") {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry"`, r.server.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case path == "_catalog":
+		// Two pages so the Link header is followed
+		if req.URL.Query().Get("last") == "" {
+			w.Header().Set("Link", `</v2/_catalog?last=fp-dim%2Fbroken&n=100>; rel="next"`)
+			json.NewEncoder(w).Encode(map[string][]string{"repositories": {"fp-dim/fp-ngfw", "fp-dim/broken"}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string][]string{"repositories": {"fp-dim/fp-smc", "other/fp-ignore"}})
+	case strings.HasSuffix(path, "/tags/list"):
+		repo := strings.TrimSuffix(path, "/tags/list")
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": repo, "tags": r.tags[repo]})
+	case strings.Contains(path, "/manifests/"):
+		parts := strings.SplitN(path, "/manifests/", 2)
+		if _, ok := r.labels[parts[0]]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if !strings.HasPrefix(parts[1], "sha256:") {
+			// Tags point at a multi-platform index
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"mediaType": mediaTypeOCIIndex,
+				"manifests": []map[string]interface{}{
+					{"digest": "sha256:arm", "platform": map[string]string{"os": "linux", "architecture": "arm64"}},
+					{"digest": "sha256:amd", "platform": map[string]string{"os": "linux", "architecture": "amd64"}},
+				},
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"mediaType": mediaTypeManifest,
+			"config":    map[string]string{"digest": "sha256:config-" + parts[1]},
+		})
+	case strings.Contains(path, "/blobs/sha256:config-sha256:amd"):
+		repo := strings.SplitN(path, "/blobs/", 2)[0]
+		json.NewEncoder(w).Encode(map[string]interface{}{"config": map[string]interface{}{"Labels": r.labels[repo]}})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+type CatalogTestSuite struct {
+	suite.Suite
+	registry *registry
+	cfg      Config
+}
+
+func TestCatalog(t *testing.T) {
+	suite.Run(t, new(CatalogTestSuite))
+}
+
+func (c *CatalogTestSuite) SetupTest() {
+	c.registry = newRegistry()
+	c.cfg = DefaultConfig()
+	c.cfg.RegistryURL = c.registry.server.URL
+	c.cfg.Username, c.cfg.Password = "user", "secret"
+	c.cfg.Namespace = "fp-dim/"
+	c.cfg.ControllerVersion = "1.5.0"
+}
+
+func (c *CatalogTestSuite) TearDownTest() {
+	c.registry.server.Close()
+}
+
+func (c *CatalogTestSuite) TestGet() {
+	catalog, err := NewCatalog(c.cfg).Get(context.Background(), false)
+	c.Nil(err)
+	c.False(catalog.Stale)
+	c.Len(catalog.Modules, 3)
+
+	host := strings.TrimPrefix(c.registry.server.URL, "http://")
+
+	c.T().Run("Test module metadata is read from the labels of the latest release", func(t *testing.T) {
+		ngfw := catalog.Modules[0]
+		assert.Equal(t, "fp-dim/fp-ngfw", ngfw.Repository)
+		assert.Equal(t, "Forcepoint NGFW", ngfw.Name)
+		assert.Equal(t, dockerstructs.EGRESS, ngfw.Type)
+		assert.Equal(t, []dockerstructs.ElementType{dockerstructs.IP, dockerstructs.RANGE, dockerstructs.URL}, ngfw.AcceptedDataTypes)
+		assert.Equal(t, []string{"1.11.0-rc1", "1.10.0", "1.2.0", "latest"}, ngfw.Versions)
+		assert.Equal(t, "1.10.0", ngfw.LatestVersion)
+		assert.Equal(t, host+"/fp-dim/fp-ngfw:1.10.0", ngfw.ImageRef)
+		assert.Equal(t, "2.0.0", ngfw.MinControllerVersion)
+		assert.False(t, ngfw.Compatible)
+	})
+
+	c.T().Run("Test a broken repository is reported on its module", func(t *testing.T) {
+		broken := catalog.Modules[1]
+		assert.Equal(t, "broken", broken.Name)
+		assert.Equal(t, "1.0.0", broken.LatestVersion)
+		assert.NotEmpty(t, broken.Error)
+	})
+
+	c.T().Run("Test repositories outside the namespace are left out", func(t *testing.T) {
+		smc := catalog.Modules[2]
+		assert.Equal(t, "fp-smc", smc.Name)
+		assert.Equal(t, host+"/fp-dim/fp-smc:latest", smc.ImageRef)
+		assert.True(t, smc.Compatible)
+	})
+}
+
+func (c *CatalogTestSuite) TestGet_Cached() {
+	now := time.Date(2020, 6, 6, 12, 0, 0, 0, time.UTC)
+	catalog := NewCatalog(c.cfg)
+	catalog.now = func() time.Time { return now }
+
+	_, err := catalog.Get(context.Background(), false)
+	c.Nil(err)
+	requests := atomic.LoadInt32(&c.registry.requests)
+
+	c.T().Run("Test the cache is served until it expires", func(t *testing.T) {
+		now = now.Add(c.cfg.CacheTTL - time.Second)
+		_, err := catalog.Get(context.Background(), false)
+		assert.Nil(t, err)
+		assert.Equal(t, requests, atomic.LoadInt32(&c.registry.requests))
+	})
+
+	c.T().Run("Test the last catalog is served as stale when the registry is down", func(t *testing.T) {
+		atomic.StoreInt32(&c.registry.down, 1)
+		result, err := catalog.Get(context.Background(), true)
+		assert.Nil(t, err)
+		assert.True(t, result.Stale)
+		assert.Len(t, result.Modules, 3)
+
+		_, err = NewCatalog(c.cfg).Get(context.Background(), false)
+		assert.NotNil(t, err)
+	})
+
+	c.T().Run("Test the last catalog is served while a refresh is in flight", func(t *testing.T) {
+		atomic.StoreInt32(&c.registry.down, 0)
+		c.registry.block = make(chan struct{})
+		before := atomic.LoadInt32(&c.registry.requests)
+
+		refreshed := make(chan structs.Catalog)
+		go func() {
+			result, _ := catalog.Get(context.Background(), true)
+			refreshed <- result
+		}()
+		for atomic.LoadInt32(&c.registry.requests) == before {
+			time.Sleep(time.Millisecond)
+		}
+
+		result, err := catalog.Get(context.Background(), true)
+		assert.Nil(t, err)
+		assert.Len(t, result.Modules, 3)
+
+		close(c.registry.block)
+		result = <-refreshed
+		assert.False(t, result.Stale)
+		assert.Len(t, result.Modules, 3)
+	})
+}
+
+func (c *CatalogTestSuite) TestSortVersions() {
+	sorted := SortVersions([]string{"latest", "v1.0.0", "2.0.0-beta", "2.0.0", "1.9.3", "dev"})
+	c.Equal([]string{"2.0.0", "2.0.0-beta", "1.9.3", "v1.0.0", "dev", "latest"}, sorted)
+	c.Equal("2.0.0", LatestVersion(sorted))
+	c.Equal("latest", LatestVersion(SortVersions([]string{"dev", "latest", "1.0.0-rc1"})))
+
+	c.True(Compatible("", "2.0.0"))
+	c.True(Compatible("2.0.0", "2.0.0"))
+	c.True(Compatible("2.1.0", "v2.0.5"))
+	c.False(Compatible("2.0.0-rc1", "2.0.0"))
+}
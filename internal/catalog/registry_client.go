@@ -0,0 +1,290 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	mediaTypeManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIManifest  = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex     = "application/vnd.oci.image.index.v1+json"
+	// pageSize is the number of repositories asked for in each page of the registry catalog
+	pageSize = 100
+)
+
+var (
+	errNotFound = errors.New("not found in the registry")
+	// linkNext matches the Link header the registry sends when there is another page of results
+	linkNext = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+	// challengeParam matches a parameter of a WWW-Authenticate challenge, e.g. realm="https://auth.docker.io/token"
+	challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+)
+
+// RegistryClient reads repositories, tags and image labels from a docker registry through the registry v2 API.
+// It logs in with basic auth, or with a bearer token if the registry asks for one
+type RegistryClient struct {
+	baseURL  string
+	username string
+	password string
+	client   *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+func NewRegistryClient(baseURL, username, password string, client *http.Client) *RegistryClient {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &RegistryClient{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		username: username,
+		password: password,
+		client:   client,
+		tokens:   make(map[string]string),
+	}
+}
+
+// Repositories returns every repository in the registry
+func (r *RegistryClient) Repositories(ctx context.Context) ([]string, error) {
+	var repos []string
+	next := fmt.Sprintf("/v2/_catalog?n=%d", pageSize)
+	for next != "" {
+		var page struct {
+			Repositories []string `json:"repositories"`
+		}
+		resp, err := r.get(ctx, next, "registry:catalog:*", "")
+		if err != nil {
+			return nil, err
+		}
+		err = decode(resp, &page)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, page.Repositories...)
+
+		next = ""
+		if m := linkNext.FindStringSubmatch(resp.Header.Get("Link")); m != nil {
+			next = m[1]
+		}
+	}
+	return repos, nil
+}
+
+// Tags returns the tags of a repository
+func (r *RegistryClient) Tags(ctx context.Context, repo string) ([]string, error) {
+	var tags struct {
+		Tags []string `json:"tags"`
+	}
+	resp, err := r.get(ctx, fmt.Sprintf("/v2/%s/tags/list", repo), pullScope(repo), "")
+	if err != nil {
+		return nil, err
+	}
+	if err = decode(resp, &tags); err != nil {
+		return nil, err
+	}
+	return tags.Tags, nil
+}
+
+// Labels returns the labels of the image with the tag, for a multi-platform image the linux/amd64 image is read
+func (r *RegistryClient) Labels(ctx context.Context, repo, tag string) (map[string]string, error) {
+	var manifest struct {
+		MediaType string `json:"mediaType"`
+		Config    struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+		Manifests []struct {
+			Digest   string `json:"digest"`
+			Platform struct {
+				OS           string `json:"os"`
+				Architecture string `json:"architecture"`
+			} `json:"platform"`
+		} `json:"manifests"`
+	}
+
+	accept := strings.Join([]string{mediaTypeManifest, mediaTypeOCIManifest, mediaTypeManifestList, mediaTypeOCIIndex}, ", ")
+	resp, err := r.get(ctx, fmt.Sprintf("/v2/%s/manifests/%s", repo, tag), pullScope(repo), accept)
+	if err != nil {
+		return nil, err
+	}
+	if err = decode(resp, &manifest); err != nil {
+		return nil, err
+	}
+
+	if len(manifest.Manifests) > 0 {
+		digest := manifest.Manifests[0].Digest
+		for _, m := range manifest.Manifests {
+			if m.Platform.OS == "linux" && m.Platform.Architecture == "amd64" {
+				digest = m.Digest
+				break
+			}
+		}
+		return r.Labels(ctx, repo, digest)
+	}
+	if manifest.Config.Digest == "" {
+		return nil, fmt.Errorf("manifest of %s:%s has no image config", repo, tag)
+	}
+
+	var config struct {
+		Config struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"config"`
+	}
+	resp, err = r.get(ctx, fmt.Sprintf("/v2/%s/blobs/%s", repo, manifest.Config.Digest), pullScope(repo), "")
+	if err != nil {
+		return nil, err
+	}
+	if err = decode(resp, &config); err != nil {
+		return nil, err
+	}
+	return config.Config.Labels, nil
+}
+
+// Digest returns the digest the tag of the repository points to, as the registry reports it for a HEAD of the manifest
+func (r *RegistryClient) Digest(ctx context.Context, repo, tag string) (string, error) {
+	accept := strings.Join([]string{mediaTypeManifest, mediaTypeOCIManifest, mediaTypeManifestList, mediaTypeOCIIndex}, ", ")
+	resp, err := r.send(ctx, http.MethodHead, fmt.Sprintf("/v2/%s/manifests/%s", repo, tag), pullScope(repo), accept)
+	if err != nil {
+		return "", err
+	}
+	drain(resp)
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if !strings.HasPrefix(digest, "sha256:") {
+		return "", fmt.Errorf("the registry did not return the digest of %s:%s", repo, tag)
+	}
+	return digest, nil
+}
+
+func pullScope(repo string) string {
+	return fmt.Sprintf("repository:%s:pull", repo)
+}
+
+func (r *RegistryClient) get(ctx context.Context, path, scope, accept string) (*http.Response, error) {
+	return r.send(ctx, http.MethodGet, path, scope, accept)
+}
+
+// send sends a request to the registry. If the registry answers with a bearer challenge a token is fetched for the
+// scope and the request is sent again, tokens are kept for later requests with the same scope
+func (r *RegistryClient) send(ctx context.Context, method, path, scope, accept string) (*http.Response, error) {
+	target := path
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		target = r.baseURL + path
+	}
+
+	send := func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, method, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		r.mu.Lock()
+		token, ok := r.tokens[scope]
+		r.mu.Unlock()
+		if ok {
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else if r.username != "" {
+			req.SetBasicAuth(r.username, r.password)
+		}
+		return r.client.Do(req)
+	}
+
+	resp, err := send()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		drain(resp)
+		if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+			return nil, errors.New("the registry refused the credentials")
+		}
+		if err := r.fetchToken(ctx, challenge, scope); err != nil {
+			return nil, err
+		}
+		if resp, err = send(); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		drain(resp)
+		return nil, errNotFound
+	case resp.StatusCode != http.StatusOK:
+		drain(resp)
+		return nil, fmt.Errorf("the registry returned %d for %s", resp.StatusCode, path)
+	}
+	return resp, nil
+}
+
+// fetchToken gets a bearer token for the scope from the realm named in the challenge
+func (r *RegistryClient) fetchToken(ctx context.Context, challenge, scope string) error {
+	params := map[string]string{}
+	for _, m := range challengeParam.FindAllStringSubmatch(challenge, -1) {
+		params[strings.ToLower(m[1])] = m[2]
+	}
+	if params["realm"] == "" {
+		return errors.New("the registry asked for a token without a realm")
+	}
+
+	query := url.Values{}
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	query.Set("scope", scope)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, params["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		drain(resp)
+		return fmt.Errorf("the registry token service returned %d", resp.StatusCode)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := decode(resp, &token); err != nil {
+		return err
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+
+	r.mu.Lock()
+	r.tokens[scope] = token.Token
+	r.mu.Unlock()
+	return nil
+}
+
+func decode(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func drain(resp *http.Response) {
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
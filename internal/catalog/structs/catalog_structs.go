@@ -0,0 +1,41 @@
+package structs
+
+import (
+	"fp-dynamic-elements-manager-controller/internal/docker/structs"
+	"time"
+)
+
+// Labels set on module images which describe the module in the catalog
+const (
+	LabelName                 = "com.forcepoint.dem.module.name"
+	LabelType                 = "com.forcepoint.dem.module.type"
+	LabelDescription          = "com.forcepoint.dem.module.description"
+	LabelAcceptedDataTypes    = "com.forcepoint.dem.module.accepted-data-types"
+	LabelIconURL              = "com.forcepoint.dem.module.icon-url"
+	LabelMinControllerVersion = "com.forcepoint.dem.module.min-controller-version"
+)
+
+// Catalog is the modules available in the registry
+type Catalog struct {
+	Modules   []Module  `json:"modules"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Stale is true when the registry could not be reached and the last catalog read is returned
+	Stale bool `json:"stale"`
+}
+
+// Module is a module repository in the registry, the metadata is read from the labels of its latest version
+type Module struct {
+	Repository           string                `json:"repository"`
+	Name                 string                `json:"name"`
+	Type                 structs.ModuleType    `json:"type"`
+	Description          string                `json:"description"`
+	AcceptedDataTypes    []structs.ElementType `json:"accepted_data_types"`
+	IconURL              string                `json:"icon_url"`
+	MinControllerVersion string                `json:"min_controller_version"`
+	// Compatible is false when the controller is older than the module's minimum controller version
+	Compatible    bool     `json:"compatible"`
+	LatestVersion string   `json:"latest_version"`
+	ImageRef      string   `json:"image_ref"`
+	Versions      []string `json:"versions"`
+	Error         string   `json:"error,omitempty"`
+}
@@ -2,7 +2,7 @@ package config
 
 import (
 	"fmt"
-	"github.com/rs/zerolog/log"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
 	"github.com/spf13/viper"
 	"os"
 	"path/filepath"
@@ -30,10 +30,10 @@ func CheckConfigFile(path, filename string) {
 func fileExists(filename string) bool {
 	info, err := os.Stat(filename)
 	if os.IsNotExist(err) {
-		log.Info().Msg("File not found...")
+		applog.System().Info("File not found...")
 		return false
 	}
-	log.Info().Msg("File found...")
+	applog.System().Info("File found...")
 	return !info.IsDir()
 }
 
@@ -41,7 +41,7 @@ func CreateFile(filename string) {
 	f, err := os.Create(filename)
 	defer f.Close()
 	if err != nil {
-		log.Error().Err(err).Msg(fmt.Sprintf("There was an error while creating the file. %s", filename))
+		applog.System().Error(err, fmt.Sprintf("There was an error while creating the file. %s", filename))
 		return
 	}
 }
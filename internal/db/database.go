@@ -10,6 +10,9 @@ import (
 // AppDatabase holds a reference to our sqlx.Db instance which in turn wraps the standard sql.DB instance
 type AppDatabase struct {
 	SqlDatabase *sqlx.DB
+	// ImportDatabase connects without multiple statements, dumps are restored over it so a statement read from a
+	// dump cannot run another
+	ImportDatabase *sqlx.DB
 }
 
 func NewAppDatabase(ready chan struct{}) *AppDatabase {
@@ -30,10 +33,8 @@ func NewAppDatabase(ready chan struct{}) *AppDatabase {
 
 // openDatabase connects to the DB, sets the default values and sets the value of the sql DB in the AppDatabase struct
 func (a *AppDatabase) openDatabase() {
-	db, err := sqlx.Connect(
-		"mysql",
-		os.ExpandEnv("${MYSQL_USER}:${MYSQL_PASSWORD}@(mariadb)/${MYSQL_DATABASE}?charset=utf8&parseTime=True&loc=Local&multiStatements=true"),
-	)
+	dsn := os.ExpandEnv("${MYSQL_USER}:${MYSQL_PASSWORD}@(mariadb)/${MYSQL_DATABASE}?charset=utf8&parseTime=True&loc=Local")
+	db, err := sqlx.Connect("mysql", dsn+"&multiStatements=true")
 
 	if err != nil {
 		panic(err)
@@ -44,6 +45,16 @@ func (a *AppDatabase) openDatabase() {
 	db.SetConnMaxLifetime(5 * time.Minute)
 
 	a.SqlDatabase = db
+
+	// Restores are rare, the import connection is only opened when one is run
+	importDb, err := sqlx.Open("mysql", dsn)
+	if err != nil {
+		panic(err)
+	}
+	importDb.SetMaxOpenConns(1)
+	importDb.SetMaxIdleConns(0)
+
+	a.ImportDatabase = importDb
 }
 
 // IsHealthy simply pings the sql DB for a basic health check
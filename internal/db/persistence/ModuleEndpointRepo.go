@@ -23,7 +23,7 @@ func NewModuleEndpointRepo(appDb *sqlx.DB, logger *structs2.AppLogger) *ModuleEn
 }
 
 func (m *ModuleEndpointRepo) GetModuleEndpointsForModule(moduleId int64) (receiver []structs.ModuleEndpoint, err error) {
-	err = m.db.Select(&receiver, fmt.Sprintf("SELECT * FROM %s WHERE module_metadata_id = ? ORDER BY created_at DESC LIMIT 4", ModuleEndpointTablename), moduleId)
+	err = m.db.Select(&receiver, fmt.Sprintf("SELECT * FROM %s WHERE module_metadata_id = ? ORDER BY created_at DESC", ModuleEndpointTablename), moduleId)
 	return
 }
 
@@ -32,30 +32,7 @@ func (m *ModuleEndpointRepo) BatchInsertModuleEndpoints(endpoints []structs.Modu
 		return
 	}
 
-	now := time.Now()
-
-	for i, _ := range endpoints {
-		endpoints[i].ModuleMetadataId = uint(moduleId)
-		endpoints[i].CreatedAt = now
-		endpoints[i].UpdatedAt = now
-	}
-
-	var valueStrings []string
-	var valueArgs []interface{}
-	for _, ep := range endpoints {
-		valueStrings = append(valueStrings, "(?, ?, ?, ?, ?, ?, ?)")
-
-		valueArgs = append(valueArgs, ep.ID)
-		valueArgs = append(valueArgs, time.Now())
-		valueArgs = append(valueArgs, time.Now())
-		valueArgs = append(valueArgs, ep.DeletedAt)
-		valueArgs = append(valueArgs, ep.Secure)
-		valueArgs = append(valueArgs, ep.Endpoint)
-		valueArgs = append(valueArgs, ep.ModuleMetadataId)
-	}
-
-	smt := `INSERT INTO %s (id, created_at, updated_at, deleted_at, secure, endpoint, module_metadata_id) VALUES %s`
-	smt = fmt.Sprintf(smt, ModuleEndpointTablename, strings.Join(valueStrings, ","))
+	smt, valueArgs := buildEndpointInsert(endpoints, moduleId)
 	tx, err := m.db.Begin()
 	if err != nil {
 		m.log.SystemLogger.Error(err, "Error starting transaction to insert module endpoints")
@@ -77,3 +54,66 @@ func (m *ModuleEndpointRepo) BatchInsertModuleEndpoints(endpoints []structs.Modu
 
 	return
 }
+
+// ReplaceModuleEndpoints swaps the stored endpoints for a module with the given set in a single transaction,
+// so re-registering a module never leaves stale or duplicated endpoints behind
+func (m *ModuleEndpointRepo) ReplaceModuleEndpoints(endpoints []structs.ModuleEndpoint, moduleId int64) {
+	tx, err := m.db.Begin()
+	if err != nil {
+		m.log.SystemLogger.Error(err, "Error starting transaction to replace module endpoints")
+		return
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE module_metadata_id = ?", ModuleEndpointTablename), moduleId)
+	if err != nil {
+		m.log.SystemLogger.Error(err, "Error deleting module endpoints, rolling back")
+		tx.Rollback()
+		return
+	}
+
+	if len(endpoints) > 0 {
+		smt, args := buildEndpointInsert(endpoints, moduleId)
+		_, err = tx.Exec(smt, args...)
+		if err != nil {
+			m.log.SystemLogger.Error(err, "Error inserting module endpoints, rolling back")
+			tx.Rollback()
+			return
+		}
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		m.log.SystemLogger.Error(err, "Error committing replace module endpoints")
+		return
+	}
+}
+
+func buildEndpointInsert(endpoints []structs.ModuleEndpoint, moduleId int64) (string, []interface{}) {
+	now := time.Now()
+
+	for i := range endpoints {
+		endpoints[i].ModuleMetadataId = uint(moduleId)
+		endpoints[i].CreatedAt = now
+		endpoints[i].UpdatedAt = now
+	}
+
+	var valueStrings []string
+	var valueArgs []interface{}
+	for _, ep := range endpoints {
+		valueStrings = append(valueStrings, "(?, ?, ?, ?, ?, ?, ?, ?, ?)")
+
+		valueArgs = append(valueArgs, ep.ID)
+		valueArgs = append(valueArgs, ep.CreatedAt)
+		valueArgs = append(valueArgs, ep.UpdatedAt)
+		valueArgs = append(valueArgs, ep.DeletedAt)
+		valueArgs = append(valueArgs, ep.Secure)
+		valueArgs = append(valueArgs, ep.Endpoint)
+		valueArgs = append(valueArgs, ep.HttpMethods)
+		valueArgs = append(valueArgs, ep.RequiredRole)
+		valueArgs = append(valueArgs, ep.ModuleMetadataId)
+	}
+
+	smt := `INSERT INTO %s (id, created_at, updated_at, deleted_at, secure, endpoint, http_methods, required_role, module_metadata_id) VALUES %s`
+	return fmt.Sprintf(smt, ModuleEndpointTablename, strings.Join(valueStrings, ",")), valueArgs
+}
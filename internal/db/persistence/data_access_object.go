@@ -14,9 +14,16 @@ type DataAccessObject struct {
 	LogEntryRepo       *LogEntryRepo
 	ElementBatchRepo   *ElementBatchRepo
 	UpdateStatusRepo   *UpdateStatusRepo
+	ModuleHealthRepo   *ModuleHealthRepo
+	DockerJobRepo      *DockerJobRepo
+	ModuleUpgradeRepo  *ModuleUpgradeRepo
+	ModuleConfigRepo   *ModuleConfigRepo
+	SecretRepo         *SecretRepo
+	DatabaseInfoRepo   *DatabaseInfoRepo
 }
 
-func NewDataAccessObject(appDb *sqlx.DB, logger *structs.AppLogger) *DataAccessObject {
+// NewDataAccessObject returns the repositories of the DB, tables are imported over importDb and everything else over appDb
+func NewDataAccessObject(appDb, importDb *sqlx.DB, logger *structs.AppLogger) *DataAccessObject {
 	elementTypeRepo := NewElementTypeRepo(appDb, logger)
 	return &DataAccessObject{
 		HealthRepo:      NewHealthRepo(appDb),
@@ -24,9 +31,15 @@ func NewDataAccessObject(appDb *sqlx.DB, logger *structs.AppLogger) *DataAccessO
 		ListElementRepo: NewListElementRepo(appDb, logger),
 		ModuleMetadataRepo: NewModuleMetadataRepo(appDb, logger,
 			NewModuleEndpointRepo(appDb, logger), elementTypeRepo),
-		ElementTypeRepo:  elementTypeRepo,
-		LogEntryRepo:     NewLogEntryRepo(appDb, logger),
-		ElementBatchRepo: NewElementBatchRepo(appDb, logger),
-		UpdateStatusRepo: NewUpdateStatusRepo(appDb, logger),
+		ElementTypeRepo:   elementTypeRepo,
+		LogEntryRepo:      NewLogEntryRepo(appDb, logger),
+		ElementBatchRepo:  NewElementBatchRepo(appDb, logger),
+		UpdateStatusRepo:  NewUpdateStatusRepo(appDb, logger),
+		ModuleHealthRepo:  NewModuleHealthRepo(appDb, logger),
+		DockerJobRepo:     NewDockerJobRepo(appDb, logger),
+		ModuleUpgradeRepo: NewModuleUpgradeRepo(appDb, logger),
+		ModuleConfigRepo:  NewModuleConfigRepo(appDb, logger),
+		SecretRepo:        NewSecretRepo(appDb, logger),
+		DatabaseInfoRepo:  NewDatabaseInfoRepo(appDb, importDb, logger),
 	}
 }
@@ -0,0 +1,245 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"fp-dynamic-elements-manager-controller/internal/logging/structs"
+	"github.com/jmoiron/sqlx"
+	"regexp"
+	"strings"
+)
+
+// MigrationsTable is the table golang-migrate records the schema version in
+const MigrationsTable = "schema_migrations"
+
+// insertBatchRows is the most rows inserted by one statement when tables are imported
+const insertBatchRows = 500
+
+var tableName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// DatabaseInfo describes the tables of the controller's DB, it is recorded with every backup
+type DatabaseInfo interface {
+	GetSchemaVersion() (uint, error)
+	GetTables() ([]string, error)
+	CountRows(string) (int64, error)
+}
+
+// Table is a table exported from or imported into the DB
+type Table struct {
+	Name string
+	// Create is the statement which creates the table, as SHOW CREATE TABLE returns it
+	Create  string
+	Columns []string
+}
+
+// TableImporter recreates the tables passed to CreateTable and inserts the rows passed to InsertRow into the table
+// created last
+type TableImporter interface {
+	CreateTable(Table) error
+	InsertRow([]interface{}) error
+}
+
+// DatabaseDump exports and imports whole tables over the controller's own connection, backups are taken and restored
+// with it
+type DatabaseDump interface {
+	DatabaseInfo
+	ExportTables(ctx context.Context, tables []string, table func(Table) error, row func([]interface{}) error) error
+	ImportTables(ctx context.Context, load func(TableImporter) error) error
+}
+
+type DatabaseInfoRepo struct {
+	db *sqlx.DB
+	// importDb does not allow multiple statements, the statements which create the tables are read from the dump
+	importDb *sqlx.DB
+	log      *structs.AppLogger
+}
+
+func NewDatabaseInfoRepo(appDb, importDb *sqlx.DB, logger *structs.AppLogger) *DatabaseInfoRepo {
+	return &DatabaseInfoRepo{db: appDb, importDb: importDb, log: logger}
+}
+
+// GetSchemaVersion returns the version of the last migration applied, 0 if none have been
+func (d *DatabaseInfoRepo) GetSchemaVersion() (version uint, err error) {
+	err = d.db.Get(&version, fmt.Sprintf("SELECT version FROM %s LIMIT 1", MigrationsTable))
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return
+}
+
+// GetTables returns the controller's tables sorted by name, leaving out the migrations table
+func (d *DatabaseInfoRepo) GetTables() ([]string, error) {
+	tables := []string{}
+	if err := d.db.Select(&tables, "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE' AND table_name != ? ORDER BY table_name", MigrationsTable); err != nil {
+		d.log.SystemLogger.Error(err, "Error listing tables")
+		return nil, err
+	}
+	return tables, nil
+}
+
+// CountRows returns the number of rows in the table
+func (d *DatabaseInfoRepo) CountRows(table string) (count int64, err error) {
+	if !tableName.MatchString(table) {
+		return 0, fmt.Errorf("invalid table name %q", table)
+	}
+	err = d.db.Get(&count, fmt.Sprintf("SELECT COUNT(*) FROM `%s`", table))
+	return
+}
+
+// ExportTables reads the tables in a single read only transaction, so every table is read from the same snapshot. table
+// is called as each table is started and row with each of its rows, which are only valid until row returns
+func (d *DatabaseInfoRepo) ExportTables(ctx context.Context, tables []string, table func(Table) error, row func([]interface{}) error) error {
+	tx, err := d.db.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, name := range tables {
+		if !tableName.MatchString(name) {
+			return fmt.Errorf("invalid table name %q", name)
+		}
+		var create struct {
+			Table  string `db:"Table"`
+			Create string `db:"Create Table"`
+		}
+		if err = tx.GetContext(ctx, &create, fmt.Sprintf("SHOW CREATE TABLE `%s`", name)); err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryxContext(ctx, fmt.Sprintf("SELECT * FROM `%s`", name))
+		if err != nil {
+			return err
+		}
+		columns, err := rows.Columns()
+		if err == nil {
+			err = table(Table{Name: name, Create: create.Create, Columns: columns})
+		}
+		for err == nil && rows.Next() {
+			var values []interface{}
+			if values, err = rows.SliceScan(); err == nil {
+				err = row(values)
+			}
+		}
+		if err == nil {
+			err = rows.Err()
+		}
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportTables runs load with an importer which drops and recreates each table it is given. The tables are imported on
+// a single connection of the import DB with foreign key checks turned off, so they can be loaded in any order
+func (d *DatabaseInfoRepo) ImportTables(ctx context.Context, load func(TableImporter) error) error {
+	conn, err := d.importDb.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 0"); err != nil {
+		return err
+	}
+	// the connection goes back to the pool, so the checks are turned back on whatever happens
+	defer conn.ExecContext(context.Background(), "SET FOREIGN_KEY_CHECKS = 1")
+
+	importer := &tableImporter{ctx: ctx, conn: conn}
+	if err = load(importer); err != nil {
+		return err
+	}
+	return importer.flush()
+}
+
+type tableImporter struct {
+	ctx   context.Context
+	conn  *sql.Conn
+	table Table
+	rows  [][]interface{}
+}
+
+func (t *tableImporter) CreateTable(table Table) error {
+	if err := t.flush(); err != nil {
+		return err
+	}
+	if !tableName.MatchString(table.Name) {
+		return fmt.Errorf("invalid table name %q", table.Name)
+	}
+	if !strings.HasPrefix(table.Create, fmt.Sprintf("CREATE TABLE `%s` (", table.Name)) || !singleStatement(table.Create) {
+		return fmt.Errorf("the statement does not create table %s", table.Name)
+	}
+	for _, column := range table.Columns {
+		if strings.ContainsAny(column, "`\x00") || column == "" {
+			return fmt.Errorf("invalid column name %q in table %s", column, table.Name)
+		}
+	}
+	if _, err := t.conn.ExecContext(t.ctx, fmt.Sprintf("DROP TABLE IF EXISTS `%s`", table.Name)); err != nil {
+		return err
+	}
+	if _, err := t.conn.ExecContext(t.ctx, table.Create); err != nil {
+		return err
+	}
+	t.table = table
+	return nil
+}
+
+// singleStatement is true when the statement holds no statement separator or comment outside of its quoted
+// identifiers and strings, as SHOW CREATE TABLE writes them
+func singleStatement(statement string) bool {
+	var quote rune
+	escaped := false
+	for i, c := range statement {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				escaped = true
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '`' || c == '\'' || c == '"':
+			quote = c
+		case c == ';' || c == '#' || strings.HasPrefix(statement[i:], "--") || strings.HasPrefix(statement[i:], "/*"):
+			return false
+		}
+	}
+	return quote == 0
+}
+
+func (t *tableImporter) InsertRow(row []interface{}) error {
+	if t.table.Name == "" {
+		return fmt.Errorf("a row was given before any table")
+	}
+	if len(row) != len(t.table.Columns) {
+		return fmt.Errorf("a row of table %s has %d values for %d columns", t.table.Name, len(row), len(t.table.Columns))
+	}
+	t.rows = append(t.rows, row)
+	// MySQL takes at most 65535 placeholders in a statement
+	if len(t.rows) >= insertBatchRows || len(t.rows)*len(row) >= 65535-len(row) {
+		return t.flush()
+	}
+	return nil
+}
+
+// flush inserts the buffered rows in one statement
+func (t *tableImporter) flush() error {
+	if len(t.rows) == 0 {
+		return nil
+	}
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?,", len(t.table.Columns)), ",") + ")"
+	values := make([]string, len(t.rows))
+	args := make([]interface{}, 0, len(t.rows)*len(t.table.Columns))
+	for i, row := range t.rows {
+		values[i] = placeholders
+		args = append(args, row...)
+	}
+	smt := fmt.Sprintf("INSERT INTO `%s` (`%s`) VALUES %s", t.table.Name, strings.Join(t.table.Columns, "`,`"), strings.Join(values, ","))
+	t.rows = t.rows[:0]
+	_, err := t.conn.ExecContext(t.ctx, smt, args...)
+	return err
+}
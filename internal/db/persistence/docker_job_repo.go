@@ -0,0 +1,119 @@
+package persistence
+
+import (
+	"fmt"
+	"fp-dynamic-elements-manager-controller/internal/docker/structs"
+	structs2 "fp-dynamic-elements-manager-controller/internal/logging/structs"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+const (
+	DockerJobTable     = "docker_jobs"
+	DockerJobStepTable = "docker_job_steps"
+	// maxJobError is the length of the error column, longer errors are truncated
+	maxJobError = 1024
+)
+
+// JobRepo stores docker jobs and the status of each of their steps
+type JobRepo interface {
+	InsertJob(structs.Job) error
+	UpdateJob(structs.Job) error
+	UpdateJobStep(structs.JobStep) error
+	GetJob(string) (structs.Job, error)
+	FailUnfinishedJobs(string, time.Time) error
+}
+
+type DockerJobRepo struct {
+	db  *sqlx.DB
+	log *structs2.AppLogger
+}
+
+func NewDockerJobRepo(appDb *sqlx.DB, logger *structs2.AppLogger) *DockerJobRepo {
+	return &DockerJobRepo{db: appDb, log: logger}
+}
+
+func truncateJobError(msg string) string {
+	if len(msg) > maxJobError {
+		return msg[:maxJobError]
+	}
+	return msg
+}
+
+// InsertJob inserts the job along with all of its steps
+func (d *DockerJobRepo) InsertJob(job structs.Job) error {
+	tx, err := d.db.Beginx()
+	if err != nil {
+		d.log.SystemLogger.Error(err, "Error starting transaction inserting docker job")
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (id, created_at, started_at, finished_at, status, error, request_id) VALUES (?,?,?,?,?,?,?)", DockerJobTable),
+		job.ID, job.CreatedAt, job.StartedAt, job.FinishedAt, job.Status, truncateJobError(job.Error), job.RequestID)
+	if err != nil {
+		d.log.SystemLogger.Error(err, "Error inserting docker job, rolling back")
+		tx.Rollback()
+		return err
+	}
+
+	for _, step := range job.Steps {
+		_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (job_id, position, command, container, image_ref, status, error, started_at, finished_at) VALUES (?,?,?,?,?,?,?,?,?)", DockerJobStepTable),
+			job.ID, step.Position, step.Command, step.Container, step.ImageRef, step.Status, truncateJobError(step.Error), step.StartedAt, step.FinishedAt)
+		if err != nil {
+			d.log.SystemLogger.Error(err, "Error inserting docker job step, rolling back")
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		d.log.SystemLogger.Error(err, "Error committing docker job")
+	}
+	return err
+}
+
+func (d *DockerJobRepo) UpdateJob(job structs.Job) error {
+	_, err := d.db.Exec(fmt.Sprintf("UPDATE %s SET started_at = ?, finished_at = ?, status = ?, error = ? WHERE id = ?", DockerJobTable),
+		job.StartedAt, job.FinishedAt, job.Status, truncateJobError(job.Error), job.ID)
+	if err != nil {
+		d.log.SystemLogger.Error(err, "Error updating docker job")
+	}
+	return err
+}
+
+// UpdateJobStep updates the step of a job at the step's position
+func (d *DockerJobRepo) UpdateJobStep(step structs.JobStep) error {
+	_, err := d.db.Exec(fmt.Sprintf("UPDATE %s SET status = ?, error = ?, digest = ?, started_at = ?, finished_at = ? WHERE job_id = ? AND position = ?", DockerJobStepTable),
+		step.Status, truncateJobError(step.Error), step.Digest, step.StartedAt, step.FinishedAt, step.JobID, step.Position)
+	if err != nil {
+		d.log.SystemLogger.Error(err, "Error updating docker job step")
+	}
+	return err
+}
+
+// GetJob returns the job with its steps in order, sql.ErrNoRows is returned if there is no such job
+func (d *DockerJobRepo) GetJob(id string) (job structs.Job, err error) {
+	if err = d.db.Get(&job, fmt.Sprintf("SELECT * FROM %s WHERE id = ?", DockerJobTable), id); err != nil {
+		return
+	}
+	job.Steps = []structs.JobStep{}
+	err = d.db.Select(&job.Steps, fmt.Sprintf("SELECT * FROM %s WHERE job_id = ? ORDER BY position ASC", DockerJobStepTable), id)
+	return
+}
+
+// FailUnfinishedJobs fails every job and step which was queued or running, these were interrupted by a restart
+func (d *DockerJobRepo) FailUnfinishedJobs(reason string, at time.Time) error {
+	unfinished := []structs.JobStatus{structs.JobQueued, structs.JobRunning}
+	for _, table := range []string{DockerJobTable, DockerJobStepTable} {
+		query, args, err := sqlx.In(fmt.Sprintf("UPDATE %s SET status = ?, error = ?, finished_at = ? WHERE status IN (?)", table),
+			structs.JobFailed, reason, at, unfinished)
+		if err != nil {
+			return err
+		}
+		if _, err = d.db.Exec(d.db.Rebind(query), args...); err != nil {
+			d.log.SystemLogger.Error(err, "Error failing unfinished docker jobs")
+			return err
+		}
+	}
+	return nil
+}
@@ -303,6 +303,25 @@ func (l *ListElementRepo) GetStats(serviceName string) (result structs2.Stats) {
 	return stats
 }
 
+// CountByType returns the number of list elements of each type
+func (l *ListElementRepo) CountByType() (map[string]int64, error) {
+	var rows []struct {
+		Type  string `db:"type"`
+		Count int64  `db:"count"`
+	}
+
+	err := l.db.Select(&rows, fmt.Sprintf("SELECT type, count(1) AS count FROM %s GROUP BY type;", ElementsTable))
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Type] = row.Count
+	}
+	return counts, nil
+}
+
 func (l *ListElementRepo) exists(value string) bool {
 	var element structs.ListElement
 	return l.db.Get(&element, fmt.Sprintf("SELECT * FROM %s WHERE value = ? LIMIT 1;", ElementsTable), value) == nil
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"fp-dynamic-elements-manager-controller/internal/logging/structs"
 	"github.com/jmoiron/sqlx"
+	"strings"
 	"time"
 )
 
@@ -11,6 +12,14 @@ const (
 	LogTable = "log_entries"
 )
 
+// LogRetentionRepo finds and deletes the log entries which are past their retention limits
+type LogRetentionRepo interface {
+	GetLogGroups() ([]structs.LogGroup, error)
+	GetRowLimitID(moduleName, level string, maxRows int64) (int64, error)
+	GetForPurge(moduleName, level string, before time.Time, maxID int64, limit int) ([]structs.LogEntry, error)
+	DeleteByIDs([]uint) error
+}
+
 type LogEntryRepo struct {
 	db  *sqlx.DB
 	log *structs.AppLogger
@@ -23,13 +32,13 @@ func NewLogEntryRepo(appDb *sqlx.DB, logger *structs.AppLogger) *LogEntryRepo {
 func (l *LogEntryRepo) InsertLogEntry(item *structs.LogEntry) {
 	now := time.Now()
 
-	smt := fmt.Sprintf("INSERT INTO %s (id, created_at, updated_at, deleted_at, module_name, level, message, caller, time) VALUES (?,?,?,?,?,?,?,?,?)", LogTable)
+	smt := fmt.Sprintf("INSERT INTO %s (id, created_at, updated_at, deleted_at, module_name, level, message, caller, time, request_id) VALUES (?,?,?,?,?,?,?,?,?,?)", LogTable)
 	tx, err := l.db.Begin()
 	if err != nil {
 		l.log.SystemLogger.Error(err, "Error starting transaction to insert log entry")
 		return
 	}
-	_, err = tx.Exec(smt, item.ID, now, now, item.DeletedAt, item.ModuleName, item.Level, item.Message, item.Caller, item.Time)
+	_, err = tx.Exec(smt, item.ID, now, now, item.DeletedAt, item.ModuleName, item.Level, item.Message, item.Caller, item.Time, item.RequestID)
 	if err != nil {
 		l.log.SystemLogger.Error(err, "Error inserting log entry, rolling back")
 		tx.Rollback()
@@ -46,20 +55,57 @@ func (l *LogEntryRepo) InsertLogEntry(item *structs.LogEntry) {
 	return
 }
 
+// InsertLogEntries inserts the entries in a single statement
+func (l *LogEntryRepo) InsertLogEntries(items []structs.LogEntry) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	var valueStrings []string
+	var valueArgs []interface{}
+	for _, item := range items {
+		valueStrings = append(valueStrings, "(?,?,?,?,?,?,?,?,?,?)")
+		valueArgs = append(valueArgs, item.ID, now, now, item.DeletedAt, item.ModuleName, item.Level, item.Message, item.Caller, item.Time, item.RequestID)
+	}
+
+	smt := fmt.Sprintf("INSERT INTO %s (id, created_at, updated_at, deleted_at, module_name, level, message, caller, time, request_id) VALUES %s", LogTable, strings.Join(valueStrings, ","))
+	_, err := l.db.Exec(smt, valueArgs...)
+
+	if err != nil {
+		l.log.SystemLogger.Error(err, "Error inserting log entries")
+	}
+
+	return err
+}
+
 func (l *LogEntryRepo) GetAll() (receiver []structs.LogEntry, err error) {
 	err = l.db.Select(&receiver, fmt.Sprintf("SELECT * FROM %s ORDER BY created_at DESC;", LogTable))
 	return
 }
 
-func (l *LogEntryRepo) GetAllForLevels(moduleName string, pageSize, offset int, levels []string) (receiver []structs.LogEntry, err error) {
-	var smt string
-	if moduleName == "" {
-		smt = fmt.Sprintf("SELECT * FROM %s WHERE level IN (?) ORDER BY created_at DESC LIMIT ? OFFSET ?;", LogTable)
-	} else {
-		smt = fmt.Sprintf("SELECT * FROM %s WHERE level IN (?) AND module_name = ? ORDER BY created_at DESC LIMIT ? OFFSET ?;", LogTable)
+// GetAllForLevels returns a page of the log entries at the given levels, the module name, request ID and level filters
+// are only applied when they are not empty
+func (l *LogEntryRepo) GetAllForLevels(moduleName, requestID string, pageSize, offset int, levels []string) (receiver []structs.LogEntry, err error) {
+	where := "1 = 1"
+	var params []interface{}
+	if len(levels) > 0 {
+		where += " AND level IN (?)"
+		params = append(params, levels)
+	}
+	if moduleName != "" {
+		where += " AND module_name = ?"
+		params = append(params, moduleName)
+	}
+	if requestID != "" {
+		where += " AND request_id = ?"
+		params = append(params, requestID)
 	}
+	params = append(params, pageSize, offset)
 
-	query, args, err := sqlx.In(smt, levels, pageSize, offset)
+	smt := fmt.Sprintf("SELECT * FROM %s WHERE %s ORDER BY created_at DESC LIMIT ? OFFSET ?;", LogTable, where)
+
+	query, args, err := sqlx.In(smt, params...)
 	if err != nil {
 		l.log.SystemLogger.Error(err, "Error binding args to query")
 	}
@@ -75,3 +121,47 @@ func (l *LogEntryRepo) GetTotalCount() (total int64, err error) {
 	err = l.db.Get(&total, fmt.Sprintf("SELECT count(1) FROM %s", LogTable))
 	return
 }
+
+// GetLogGroups returns the number of entries stored for each module and level
+func (l *LogEntryRepo) GetLogGroups() (receiver []structs.LogGroup, err error) {
+	err = l.db.Select(&receiver, fmt.Sprintf("SELECT COALESCE(module_name, '') AS module_name, COALESCE(level, '') AS level, count(1) AS count FROM %s GROUP BY 1, 2;", LogTable))
+	return
+}
+
+// GetRowLimitID returns the ID of the newest entry of a module and level which is beyond the newest maxRows entries,
+// this entry and every older one are over the row limit. Zero is returned if there are not more than maxRows entries
+func (l *LogEntryRepo) GetRowLimitID(moduleName, level string, maxRows int64) (id int64, err error) {
+	var ids []int64
+	err = l.db.Select(&ids, fmt.Sprintf("SELECT id FROM %s WHERE COALESCE(module_name, '') = ? AND COALESCE(level, '') = ? ORDER BY id DESC LIMIT 1 OFFSET ?;", LogTable), moduleName, level, maxRows)
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+// GetForPurge returns up to limit of the oldest entries of a module and level which were created before the given
+// time or have an ID of at most maxID
+func (l *LogEntryRepo) GetForPurge(moduleName, level string, before time.Time, maxID int64, limit int) (receiver []structs.LogEntry, err error) {
+	err = l.db.Select(&receiver, fmt.Sprintf("SELECT * FROM %s WHERE COALESCE(module_name, '') = ? AND COALESCE(level, '') = ? AND (created_at < ? OR id <= ?) ORDER BY id ASC LIMIT ?;", LogTable), moduleName, level, before, maxID, limit)
+	return
+}
+
+func (l *LogEntryRepo) DeleteByIDs(ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query, args, err := sqlx.In(fmt.Sprintf("DELETE FROM %s WHERE id IN (?);", LogTable), ids)
+	if err != nil {
+		l.log.SystemLogger.Error(err, "Error binding args to query")
+		return err
+	}
+
+	_, err = l.db.Exec(l.db.Rebind(query), args...)
+
+	if err != nil {
+		l.log.SystemLogger.Error(err, "Error deleting log entries")
+	}
+
+	return err
+}
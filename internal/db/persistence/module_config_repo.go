@@ -0,0 +1,77 @@
+package persistence
+
+import (
+	"fmt"
+	structs2 "fp-dynamic-elements-manager-controller/internal/logging/structs"
+	"fp-dynamic-elements-manager-controller/internal/moduleconfig/structs"
+	"github.com/jmoiron/sqlx"
+)
+
+const ModuleConfigTable = "module_configs"
+
+// ConfigRepo stores the versions of each module's config
+type ConfigRepo interface {
+	InsertConfig(structs.ConfigVersion) (structs.ConfigVersion, error)
+	GetLatestConfig(string) (structs.ConfigVersion, error)
+	GetConfig(string, int) (structs.ConfigVersion, error)
+	GetConfigs(string, int) ([]structs.ConfigVersion, error)
+}
+
+type ModuleConfigRepo struct {
+	db  *sqlx.DB
+	log *structs2.AppLogger
+}
+
+func NewModuleConfigRepo(appDb *sqlx.DB, logger *structs2.AppLogger) *ModuleConfigRepo {
+	return &ModuleConfigRepo{db: appDb, log: logger}
+}
+
+// InsertConfig inserts the config as the next version of the module's config, it returns the config with its ID and
+// version set
+func (m *ModuleConfigRepo) InsertConfig(config structs.ConfigVersion) (structs.ConfigVersion, error) {
+	tx, err := m.db.Beginx()
+	if err != nil {
+		return config, err
+	}
+	defer tx.Rollback()
+
+	// Lock the module's versions so two changes cannot be given the same version
+	var latest int
+	err = tx.Get(&latest, fmt.Sprintf("SELECT COALESCE(MAX(version), 0) FROM %s WHERE module_service_name = ? FOR UPDATE", ModuleConfigTable), config.ModuleServiceName)
+	if err != nil {
+		m.log.SystemLogger.Error(err, "Error reading the latest module config version")
+		return config, err
+	}
+	config.Version = latest + 1
+
+	result, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (module_service_name, version, config, action, rollback_of, author_id, author_email, author_name, request_id, created_at) VALUES (?,?,?,?,?,?,?,?,?,?)", ModuleConfigTable),
+		config.ModuleServiceName, config.Version, string(config.Config), config.Action, config.RollbackOf, config.AuthorID,
+		config.AuthorEmail, config.AuthorName, config.RequestID, config.CreatedAt)
+	if err != nil {
+		m.log.SystemLogger.Error(err, "Error inserting module config")
+		return config, err
+	}
+	if config.ID, err = result.LastInsertId(); err != nil {
+		return config, err
+	}
+	return config, tx.Commit()
+}
+
+// GetLatestConfig returns the newest version of the module's config, sql.ErrNoRows is returned if it has none
+func (m *ModuleConfigRepo) GetLatestConfig(serviceName string) (receiver structs.ConfigVersion, err error) {
+	err = m.db.Get(&receiver, fmt.Sprintf("SELECT * FROM %s WHERE module_service_name = ? ORDER BY version DESC LIMIT 1", ModuleConfigTable), serviceName)
+	return
+}
+
+// GetConfig returns a version of the module's config, sql.ErrNoRows is returned if there is no such version
+func (m *ModuleConfigRepo) GetConfig(serviceName string, version int) (receiver structs.ConfigVersion, err error) {
+	err = m.db.Get(&receiver, fmt.Sprintf("SELECT * FROM %s WHERE module_service_name = ? AND version = ?", ModuleConfigTable), serviceName, version)
+	return
+}
+
+// GetConfigs returns the most recent versions of the module's config, newest first
+func (m *ModuleConfigRepo) GetConfigs(serviceName string, limit int) (receiver []structs.ConfigVersion, err error) {
+	receiver = []structs.ConfigVersion{}
+	err = m.db.Select(&receiver, fmt.Sprintf("SELECT * FROM %s WHERE module_service_name = ? ORDER BY version DESC LIMIT ?", ModuleConfigTable), serviceName, limit)
+	return
+}
@@ -0,0 +1,85 @@
+package persistence
+
+import (
+	"fmt"
+	"fp-dynamic-elements-manager-controller/internal/health/structs"
+	structs2 "fp-dynamic-elements-manager-controller/internal/logging/structs"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+const (
+	ModuleHealthTransitionTable = "module_health_transitions"
+	ModuleHealthCheckTable      = "module_health_checks"
+)
+
+// HealthHistoryRepo stores the result of every module health check and every change of module status
+type HealthHistoryRepo interface {
+	InsertTransition(structs.HealthTransition) error
+	GetLatestTransitions() ([]structs.HealthTransition, error)
+	InsertCheck(structs.HealthCheck) error
+	GetChecksSince(string, time.Time) ([]structs.HealthCheck, error)
+	DeleteChecksBefore(time.Time) error
+}
+
+type ModuleHealthRepo struct {
+	db  *sqlx.DB
+	log *structs2.AppLogger
+}
+
+func NewModuleHealthRepo(appDb *sqlx.DB, logger *structs2.AppLogger) *ModuleHealthRepo {
+	return &ModuleHealthRepo{db: appDb, log: logger}
+}
+
+func (m *ModuleHealthRepo) InsertTransition(item structs.HealthTransition) error {
+	if item.CreatedAt.IsZero() {
+		item.CreatedAt = time.Now()
+	}
+
+	smt := fmt.Sprintf(`INSERT INTO %s (created_at, module_service_name, from_status, to_status, status_code) VALUES (?,?,?,?,?)`, ModuleHealthTransitionTable)
+	_, err := m.db.Exec(smt, item.CreatedAt, item.ModuleServiceName, item.FromStatus, item.ToStatus, item.StatusCode)
+
+	if err != nil {
+		m.log.SystemLogger.Error(err, "Error inserting module health transition")
+	}
+
+	return err
+}
+
+// GetLatestTransitions returns the most recent transition of every module, this is the last known status of each module
+func (m *ModuleHealthRepo) GetLatestTransitions() (receiver []structs.HealthTransition, err error) {
+	err = m.db.Select(&receiver, fmt.Sprintf(`SELECT t.* FROM %[1]s t INNER JOIN
+(SELECT module_service_name, MAX(id) AS id FROM %[1]s GROUP BY module_service_name) latest ON t.id = latest.id;`, ModuleHealthTransitionTable))
+	return
+}
+
+func (m *ModuleHealthRepo) InsertCheck(item structs.HealthCheck) error {
+	if item.CreatedAt.IsZero() {
+		item.CreatedAt = time.Now()
+	}
+
+	smt := fmt.Sprintf(`INSERT INTO %s (created_at, module_service_name, status, status_code, latency_ms) VALUES (?,?,?,?,?)`, ModuleHealthCheckTable)
+	_, err := m.db.Exec(smt, item.CreatedAt, item.ModuleServiceName, item.Status, item.StatusCode, item.LatencyMs)
+
+	if err != nil {
+		m.log.SystemLogger.Error(err, "Error inserting module health check")
+	}
+
+	return err
+}
+
+// GetChecksSince returns the health checks of a module made after the given time, oldest first
+func (m *ModuleHealthRepo) GetChecksSince(serviceName string, since time.Time) (receiver []structs.HealthCheck, err error) {
+	err = m.db.Select(&receiver, fmt.Sprintf("SELECT * FROM %s WHERE module_service_name = ? AND created_at >= ? ORDER BY created_at ASC;", ModuleHealthCheckTable), serviceName, since)
+	return
+}
+
+func (m *ModuleHealthRepo) DeleteChecksBefore(before time.Time) error {
+	_, err := m.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE created_at < ?;", ModuleHealthCheckTable), before)
+
+	if err != nil {
+		m.log.SystemLogger.Error(err, "Error deleting old module health checks")
+	}
+
+	return err
+}
@@ -16,6 +16,22 @@ type ModuleRepo interface {
 	DeleteByServiceName(string) error
 }
 
+// MonitoredModuleRepo is used by the health monitor to find the modules to check and record successful checks
+type MonitoredModuleRepo interface {
+	GetAll() ([]structs.ModuleMetadata, error)
+	UpdateLastPing(string, time.Time) error
+}
+
+// ModuleListRepo is used by the container stats sampler to find the module containers to sample
+type ModuleListRepo interface {
+	GetAll() ([]structs.ModuleMetadata, error)
+}
+
+type ModuleLookupRepo interface {
+	GetByServiceName(string) (structs.ModuleMetadata, error)
+	GetByInboundRoute(string) (structs.ModuleMetadata, error)
+}
+
 type ModuleMetadataRepo struct {
 	db       *sqlx.DB
 	log      *structs2.AppLogger
@@ -51,7 +67,7 @@ func (m *ModuleMetadataRepo) UpsertModuleMetadata(item structs.ModuleMetadata) {
 id, created_at, updated_at, deleted_at, module_service_name, module_display_name, module_type,
 module_description, inbound_route, internal_ip, internal_port, icon_url, configured, configurable,
 last_ping) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?) 
-ON DUPLICATE KEY UPDATE updated_at = ?, module_description = ?, icon_url = ?, configured = ?, last_ping = ?
+ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), updated_at = ?, module_description = ?, icon_url = ?, configured = ?, last_ping = ?
 `, ModuleTable)
 
 	item.CreatedAt = now
@@ -88,7 +104,10 @@ ON DUPLICATE KEY UPDATE updated_at = ?, module_description = ?, icon_url = ?, co
 		return
 	}
 
-	m.epRepo.BatchInsertModuleEndpoints(item.ModuleEndpoints, moduleId)
+	// Endpoints are only sent on registration, a nil slice means the endpoints are not being changed
+	if item.ModuleEndpoints != nil {
+		m.epRepo.ReplaceModuleEndpoints(item.ModuleEndpoints, moduleId)
+	}
 
 	m.typeRepo.InsertElementTypes(item.AcceptedElementTypes, moduleId)
 
@@ -105,18 +124,45 @@ func (m *ModuleMetadataRepo) GetAllOfType(moduleType structs.ModuleType) (receiv
 	return
 }
 
+func (m *ModuleMetadataRepo) GetByID(id int64) (receiver structs.ModuleMetadata, err error) {
+	err = m.db.Get(&receiver, fmt.Sprintf("SELECT * FROM %s WHERE id = ? LIMIT 1;", ModuleTable), id)
+	return
+}
+
+func (m *ModuleMetadataRepo) GetByServiceName(serviceName string) (receiver structs.ModuleMetadata, err error) {
+	err = m.db.Get(&receiver, fmt.Sprintf("SELECT * FROM %s WHERE module_service_name = ? LIMIT 1;", ModuleTable), serviceName)
+	return
+}
+
+func (m *ModuleMetadataRepo) GetByInboundRoute(inboundRoute string) (receiver structs.ModuleMetadata, err error) {
+	err = m.db.Get(&receiver, fmt.Sprintf("SELECT * FROM %s WHERE inbound_route = ? LIMIT 1;", ModuleTable), inboundRoute)
+	return
+}
+
+func (m *ModuleMetadataRepo) UpdateLastPing(serviceName string, lastPing time.Time) error {
+	_, err := m.db.Exec(fmt.Sprintf("UPDATE %s SET last_ping = ? WHERE module_service_name = ?;", ModuleTable), lastPing, serviceName)
+	return err
+}
+
 func (m *ModuleMetadataRepo) DeleteByServiceName(serviceName string) error {
-	smt := fmt.Sprintf(`DELETE FROM %s WHERE module_service_name = ?`, ModuleTable)
+	childSmt := `DELETE FROM %s WHERE %s IN (SELECT id FROM %s WHERE module_service_name = ?)`
+	smts := []string{
+		fmt.Sprintf(childSmt, ModuleEndpointTablename, "module_metadata_id", ModuleTable),
+		fmt.Sprintf(childSmt, ElementTypeTable, "module_id", ModuleTable),
+		fmt.Sprintf(`DELETE FROM %s WHERE module_service_name = ?`, ModuleTable),
+	}
 	tx, err := m.db.Begin()
 	if err != nil {
 		m.log.SystemLogger.Error(err, "Error starting transaction to delete module_metadata")
 		return err
 	}
-	_, err = tx.Exec(smt, serviceName)
-	if err != nil {
-		m.log.SystemLogger.Error(err, "Error deleting module_metadata, rolling back")
-		err = tx.Rollback()
-		return err
+	for _, smt := range smts {
+		_, err = tx.Exec(smt, serviceName)
+		if err != nil {
+			m.log.SystemLogger.Error(err, "Error deleting module_metadata, rolling back")
+			tx.Rollback()
+			return err
+		}
 	}
 
 	err = tx.Commit()
@@ -0,0 +1,65 @@
+package persistence
+
+import (
+	"fmt"
+	"fp-dynamic-elements-manager-controller/internal/docker/structs"
+	structs2 "fp-dynamic-elements-manager-controller/internal/logging/structs"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+const ModuleUpgradeTable = "module_upgrades"
+
+// UpgradeRepo stores the upgrade history of each module
+type UpgradeRepo interface {
+	InsertUpgrade(structs.Upgrade) (int64, error)
+	UpdateUpgrade(structs.Upgrade) error
+	FailUnfinishedUpgrades(string, time.Time) error
+}
+
+type ModuleUpgradeRepo struct {
+	db  *sqlx.DB
+	log *structs2.AppLogger
+}
+
+func NewModuleUpgradeRepo(appDb *sqlx.DB, logger *structs2.AppLogger) *ModuleUpgradeRepo {
+	return &ModuleUpgradeRepo{db: appDb, log: logger}
+}
+
+// InsertUpgrade inserts the upgrade and returns its ID
+func (m *ModuleUpgradeRepo) InsertUpgrade(upgrade structs.Upgrade) (int64, error) {
+	result, err := m.db.Exec(fmt.Sprintf("INSERT INTO %s (module_service_name, from_image, from_digest, to_image, to_digest, status, error, request_id, started_at, finished_at) VALUES (?,?,?,?,?,?,?,?,?,?)", ModuleUpgradeTable),
+		upgrade.ModuleServiceName, upgrade.FromImage, upgrade.FromDigest, upgrade.ToImage, upgrade.ToDigest, upgrade.Status,
+		truncateJobError(upgrade.Error), upgrade.RequestID, upgrade.StartedAt, upgrade.FinishedAt)
+	if err != nil {
+		m.log.SystemLogger.Error(err, "Error inserting module upgrade")
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (m *ModuleUpgradeRepo) UpdateUpgrade(upgrade structs.Upgrade) error {
+	_, err := m.db.Exec(fmt.Sprintf("UPDATE %s SET from_image = ?, from_digest = ?, to_digest = ?, status = ?, error = ?, finished_at = ? WHERE id = ?", ModuleUpgradeTable),
+		upgrade.FromImage, upgrade.FromDigest, upgrade.ToDigest, upgrade.Status, truncateJobError(upgrade.Error), upgrade.FinishedAt, upgrade.ID)
+	if err != nil {
+		m.log.SystemLogger.Error(err, "Error updating module upgrade")
+	}
+	return err
+}
+
+// GetUpgrades returns the most recent upgrades of a module, newest first
+func (m *ModuleUpgradeRepo) GetUpgrades(serviceName string, limit int) (receiver []structs.Upgrade, err error) {
+	receiver = []structs.Upgrade{}
+	err = m.db.Select(&receiver, fmt.Sprintf("SELECT * FROM %s WHERE module_service_name = ? ORDER BY started_at DESC, id DESC LIMIT ?", ModuleUpgradeTable), serviceName, limit)
+	return
+}
+
+// FailUnfinishedUpgrades fails every upgrade which was still running, these were interrupted by a restart
+func (m *ModuleUpgradeRepo) FailUnfinishedUpgrades(reason string, at time.Time) error {
+	_, err := m.db.Exec(fmt.Sprintf("UPDATE %s SET status = ?, error = ?, finished_at = ? WHERE status = ?", ModuleUpgradeTable),
+		structs.UpgradeFailed, reason, at, structs.UpgradeRunning)
+	if err != nil {
+		m.log.SystemLogger.Error(err, "Error failing unfinished module upgrades")
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package persistence
+
+import (
+	"database/sql"
+	"fmt"
+	structs2 "fp-dynamic-elements-manager-controller/internal/logging/structs"
+	"fp-dynamic-elements-manager-controller/internal/secrets/structs"
+	"github.com/jmoiron/sqlx"
+)
+
+const SecretTable = "secrets"
+
+// SecretStore stores the encrypted secrets
+type SecretStore interface {
+	UpsertSecret(structs.Secret) error
+	GetSecrets() ([]structs.Secret, error)
+	DeleteSecret(string) error
+}
+
+type SecretRepo struct {
+	db  *sqlx.DB
+	log *structs2.AppLogger
+}
+
+func NewSecretRepo(appDb *sqlx.DB, logger *structs2.AppLogger) *SecretRepo {
+	return &SecretRepo{db: appDb, log: logger}
+}
+
+// UpsertSecret inserts the secret, or replaces the value and keys of the secret with the same name
+func (s *SecretRepo) UpsertSecret(secret structs.Secret) error {
+	_, err := s.db.Exec(fmt.Sprintf("INSERT INTO %s (name, ciphertext, data_key, key_id, updated_by, created_at, updated_at) VALUES (?,?,?,?,?,?,?) "+
+		"ON DUPLICATE KEY UPDATE ciphertext = VALUES(ciphertext), data_key = VALUES(data_key), key_id = VALUES(key_id), "+
+		"updated_by = VALUES(updated_by), updated_at = VALUES(updated_at)", SecretTable),
+		secret.Name, secret.Ciphertext, secret.DataKey, secret.KeyID, secret.UpdatedBy, secret.CreatedAt, secret.UpdatedAt)
+	if err != nil {
+		s.log.SystemLogger.Error(err, "Error storing secret")
+	}
+	return err
+}
+
+// GetSecrets returns every secret, sorted by name
+func (s *SecretRepo) GetSecrets() (receiver []structs.Secret, err error) {
+	receiver = []structs.Secret{}
+	err = s.db.Select(&receiver, fmt.Sprintf("SELECT * FROM %s ORDER BY name", SecretTable))
+	return
+}
+
+// DeleteSecret deletes the secret, sql.ErrNoRows is returned if there is no secret with the name
+func (s *SecretRepo) DeleteSecret(name string) error {
+	result, err := s.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE name = ?", SecretTable), name)
+	if err != nil {
+		s.log.SystemLogger.Error(err, "Error deleting secret")
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
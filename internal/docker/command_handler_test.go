@@ -1,13 +1,25 @@
 package docker
 
 import (
-	"fmt"
+	"context"
+	"database/sql"
+	"errors"
 	"fp-dynamic-elements-manager-controller/internal/docker/mocks"
 	"fp-dynamic-elements-manager-controller/internal/docker/structs"
-	"github.com/docker/docker/api/types"
+	"fp-dynamic-elements-manager-controller/internal/notification"
+	orchestratorstructs "fp-dynamic-elements-manager-controller/internal/orchestrator/structs"
+	"fp-dynamic-elements-manager-controller/internal/routing"
+	"fp-dynamic-elements-manager-controller/internal/secrets"
+	secretmocks "fp-dynamic-elements-manager-controller/internal/secrets/mocks"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 var testContainer = structs.ContainerDetails{
@@ -25,6 +37,17 @@ var testContainer = structs.ContainerDetails{
 	RegistrationToken: "123456",
 }
 
+// testDigest is the digest the test image ref resolves to when it is pulled
+const testDigest = "test.docker.io/fp-test/test@sha256:0123456789abcdef"
+
+// testWorkload matches the workload the test container is created with
+func testWorkload(runtime structs.RuntimeProfile) interface{} {
+	return mock.MatchedBy(func(w orchestratorstructs.Workload) bool {
+		return w.Name == testContainer.ID && w.Image == testDigest && w.Network == testContainer.Network &&
+			assert.ObjectsAreEqual(runtime, w.Runtime)
+	})
+}
+
 type CommandHandlerTestSuite struct {
 	suite.Suite
 }
@@ -33,254 +56,245 @@ func TestCommandHandler(t *testing.T) {
 	suite.Run(t, new(CommandHandlerTestSuite))
 }
 
+// testSecrets returns a secrets service holding fw-api-key
+func testSecrets() *secrets.Service {
+	keyring, _ := secrets.NewKeyring([]byte(strings.Repeat("k", 32)))
+	repo := new(secretmocks.MockSecretStore)
+	repo.On("UpsertSecret", mock.Anything).Return(nil)
+	service := secrets.NewService(keyring, repo)
+	service.Put("fw-api-key", "s3cr3t-api-key", "admin@example.com")
+	return service
+}
+
+func newTestHandler(docker *mocks.TestDocker, modRepo *mocks.MockModuleMetadataRepo, routes *mocks.MockRouteTable) (*CommandHandler, *mocks.MockJobRepo, *mocks.NSMock) {
+	jobs := new(mocks.MockJobRepo)
+	jobs.On("InsertJob", mock.Anything).Return(nil)
+	jobs.On("UpdateJob", mock.Anything).Return(nil)
+	jobs.On("UpdateJobStep", mock.Anything).Return(nil)
+	ns := new(mocks.NSMock)
+	ns.On("Send", mock.Anything)
+
+	handler := NewCommandHandler(docker, modRepo, routes, jobs, ns, nil, nil, DefaultRuntimeProfile(), testSecrets())
+	handler.startDelay = 0
+	return handler, jobs, ns
+}
+
+// runJob runs the containers as a job and fails the test if any of its steps did not succeed
+func (c *CommandHandlerTestSuite) runJob(docker *mocks.TestDocker, modRepo *mocks.MockModuleMetadataRepo, routes *mocks.MockRouteTable, containers ...structs.ContainerDetails) structs.Job {
+	handler, _, _ := newTestHandler(docker, modRepo, routes)
+
+	job, err := handler.Submit(context.Background(), structs.ContainerDetailsWrapper{Containers: containers})
+	c.Nil(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	job, err = handler.Wait(ctx, job.ID)
+	c.Nil(err)
+
+	c.Equal(structs.JobSucceeded, job.Status)
+	for _, step := range job.Steps {
+		c.Equal(structs.JobSucceeded, step.Status, step.Error)
+	}
+	return job
+}
+
 func (c *CommandHandlerTestSuite) TestCommandHandler_Create() {
 	modRepo := new(mocks.MockModuleMetadataRepo)
+	routes := new(mocks.MockRouteTable)
 	docker := new(mocks.TestDocker)
 
 	testContainer.Command = structs.Create
 
-	docker.On("ListNetworks").Return([]types.NetworkResource{{
-		Name: "module_net",
-		ID:   "module_net",
-	}})
+	docker.On("ModuleNetwork", mock.Anything).Return("module_net", nil)
 
+	docker.On("ResolveImage", mock.Anything, testContainer.ImageRef).Return(testDigest, nil)
 	docker.On(
 		"Create",
-		testContainer.ImageRef,
-		testContainer.ID,
-		testContainer.Network,
-		testContainer.Volumes,
 		mock.Anything,
+		testWorkload(DefaultRuntimeProfile()),
 	).Return(nil)
 
-	handler := NewCommandHandler(docker, modRepo)
-
-	doneCh, evtCh, errCh := handler.RunCommands(structs.ContainerDetailsWrapper{
-		Containers: []structs.ContainerDetails{
-			testContainer,
-		}})
-
-readChannel:
-	for {
-		select {
-		case err := <-errCh:
-			if err != nil {
-				c.T().Error("test failed", err)
-			}
-		case event := <-evtCh:
-			fmt.Println(event)
-		case <-doneCh:
-			break readChannel
-		}
-	}
+	job := c.runJob(docker, modRepo, routes, testContainer)
+	c.Equal(testDigest, job.Steps[0].Digest)
 
 	docker.AssertCalled(
 		c.T(),
 		"Create",
-		testContainer.ImageRef,
-		testContainer.ID,
-		testContainer.Network,
-		testContainer.Volumes,
 		mock.Anything,
+		testWorkload(DefaultRuntimeProfile()),
 	)
 
 	docker.AssertExpectations(c.T())
 }
 
-func (c *CommandHandlerTestSuite) TestCommandHandler_PullAndStart() {
+func (c *CommandHandlerTestSuite) TestCommandHandler_CreateWithSecret() {
 	modRepo := new(mocks.MockModuleMetadataRepo)
+	routes := new(mocks.MockRouteTable)
 	docker := new(mocks.TestDocker)
 
-	testContainer.Command = structs.PullAndStart
-
-	docker.On("ListNetworks").Return([]types.NetworkResource{{
-		Name: "module_net",
-		ID:   "module_net",
-	}})
-
-	docker.On("PullAndStart", testContainer.ImageRef, testContainer.ID).Return(nil)
-
-	handler := NewCommandHandler(docker, modRepo)
+	container := testContainer
+	container.Command = structs.Create
+	container.EnvVars = []string{"FW_API_KEY=${secret:fw-api-key}"}
+
+	docker.On("ModuleNetwork", mock.Anything).Return("module_net", nil)
+	docker.On("ResolveImage", mock.Anything, container.ImageRef).Return(testDigest, nil)
+	docker.On("Create", mock.Anything, mock.Anything).Return(nil)
+	docker.On("Inspect", mock.Anything, container.ID).Return(structs.ContainerInspect{Env: []string{"FW_API_KEY=s3cr3t-api-key", "LOCAL_PORT=8080"}}, nil)
+
+	handler, _, _ := newTestHandler(docker, modRepo, routes)
+	job, err := handler.Submit(context.Background(), structs.ContainerDetailsWrapper{Containers: []structs.ContainerDetails{container}})
+	c.Nil(err)
+	job, err = handler.Wait(context.Background(), job.ID)
+	c.Nil(err)
+	c.Equal(structs.JobSucceeded, job.Status)
+
+	docker.AssertCalled(c.T(), "Create", mock.Anything, mock.MatchedBy(func(w orchestratorstructs.Workload) bool {
+		return w.Env[0] == "FW_API_KEY=s3cr3t-api-key"
+	}))
+
+	inspect, err := handler.Inspect(context.Background(), container.ID)
+	c.Nil(err)
+	c.Equal([]string{"FW_API_KEY=********", "LOCAL_PORT=8080"}, inspect.Env)
+
+	c.T().Run("Test a missing secret fails the step", func(t *testing.T) {
+		container.EnvVars = []string{"FW_API_KEY=${secret:missing}"}
+		job, _ := handler.Submit(context.Background(), structs.ContainerDetailsWrapper{Containers: []structs.ContainerDetails{container}})
+		job, _ = handler.Wait(context.Background(), job.ID)
+		assert.Equal(t, structs.JobFailed, job.Status)
+		assert.Contains(t, job.Steps[0].Error, "secret not found: missing")
+	})
+}
 
-	doneCh, evtCh, errCh := handler.RunCommands(structs.ContainerDetailsWrapper{
-		Containers: []structs.ContainerDetails{
-			testContainer,
-		}})
+func (c *CommandHandlerTestSuite) TestCommandHandler_IdentitySigningKey() {
+	os.Setenv("PROXY_SIGNING_KEY", "0123456789abcdef0123456789abcdef")
+	defer os.Unsetenv("PROXY_SIGNING_KEY")
 
-readChannel:
-	for {
-		select {
-		case err := <-errCh:
-			if err != nil {
-				c.T().Error("test failed", err)
+	docker := new(mocks.TestDocker)
+	docker.On("ModuleNetwork", mock.Anything).Return("module_net", nil)
+	docker.On("ResolveImage", mock.Anything, testContainer.ImageRef).Return(testDigest, nil)
+	docker.On("Create", mock.Anything, mock.Anything).Return(nil)
+
+	container := testContainer
+	container.Command = structs.Create
+	handler, _, _ := newTestHandler(docker, new(mocks.MockModuleMetadataRepo), new(mocks.MockRouteTable))
+	job, err := handler.Submit(context.Background(), structs.ContainerDetailsWrapper{Containers: []structs.ContainerDetails{container}})
+	c.Nil(err)
+	_, err = handler.Wait(context.Background(), job.ID)
+	c.Nil(err)
+
+	var key string
+	for _, call := range docker.Calls {
+		if call.Method != "Create" {
+			continue
+		}
+		for _, env := range call.Arguments.Get(1).(orchestratorstructs.Workload).Env {
+			if strings.HasPrefix(env, routing.ModuleSigningKeyEnv+"=") {
+				key = strings.TrimPrefix(env, routing.ModuleSigningKeyEnv+"=")
 			}
-		case event := <-evtCh:
-			fmt.Println(event)
-		case <-doneCh:
-			break readChannel
 		}
 	}
+	c.Require().NotEmpty(key)
+
+	// the identity the proxy signs for the module's service verifies with the key the module was given
+	req := httptest.NewRequest(http.MethodGet, "/config", nil)
+	c.Nil(routing.SignRequest(req, container.ID, 7, "admin@example.com", "Admin", os.Getenv("PROXY_SIGNING_KEY")))
+	h := req.Header
+	c.Equal(routing.SignIdentity(key, h.Get(routing.UserIDHeader), h.Get(routing.UserEmailHeader), h.Get(routing.UserNameHeader),
+		h.Get(routing.TimestampHeader)), h.Get(routing.SignatureHeader))
+}
+
+func (c *CommandHandlerTestSuite) TestCommandHandler_PullAndStart() {
+	modRepo := new(mocks.MockModuleMetadataRepo)
+	routes := new(mocks.MockRouteTable)
+	docker := new(mocks.TestDocker)
+
+	testContainer.Command = structs.PullAndStart
+
+	docker.On("ModuleNetwork", mock.Anything).Return("module_net", nil)
+
+	docker.On("ResolveImage", mock.Anything, testContainer.ImageRef).Return(testDigest, nil)
+	docker.On("PullAndStart", testDigest, testContainer.ID).Return(nil)
+
+	c.runJob(docker, modRepo, routes, testContainer)
 
-	docker.AssertCalled(c.T(), "PullAndStart", testContainer.ImageRef, testContainer.ID)
+	docker.AssertCalled(c.T(), "PullAndStart", testDigest, testContainer.ID)
 
 	docker.AssertExpectations(c.T())
 }
 
 func (c *CommandHandlerTestSuite) TestCommandHandler_Start() {
 	modRepo := new(mocks.MockModuleMetadataRepo)
+	routes := new(mocks.MockRouteTable)
 	docker := new(mocks.TestDocker)
 
 	testContainer.Command = structs.Start
 
-	docker.On("ListNetworks").Return([]types.NetworkResource{{
-		Name: "module_net",
-		ID:   "module_net",
-	}})
+	docker.On("ModuleNetwork", mock.Anything).Return("module_net", nil)
 
-	docker.On("Start", testContainer.ID).Return(nil)
+	docker.On("Start", mock.Anything, testContainer.ID).Return(nil)
 
-	handler := NewCommandHandler(docker, modRepo)
+	c.runJob(docker, modRepo, routes, testContainer)
 
-	doneCh, evtCh, errCh := handler.RunCommands(structs.ContainerDetailsWrapper{
-		Containers: []structs.ContainerDetails{
-			testContainer,
-		}})
-
-readChannel:
-	for {
-		select {
-		case err := <-errCh:
-			if err != nil {
-				c.T().Error("test failed", err)
-			}
-		case event := <-evtCh:
-			fmt.Println(event)
-		case <-doneCh:
-			break readChannel
-		}
-	}
-
-	docker.AssertCalled(c.T(), "Start", testContainer.ID)
+	docker.AssertCalled(c.T(), "Start", mock.Anything, testContainer.ID)
 
 	docker.AssertExpectations(c.T())
 }
 
 func (c *CommandHandlerTestSuite) TestCommandHandler_Stop() {
 	modRepo := new(mocks.MockModuleMetadataRepo)
+	routes := new(mocks.MockRouteTable)
 	docker := new(mocks.TestDocker)
 
 	testContainer.Command = structs.Stop
 
-	docker.On("ListNetworks").Return([]types.NetworkResource{{
-		Name: "module_net",
-		ID:   "module_net",
-	}})
+	docker.On("ModuleNetwork", mock.Anything).Return("module_net", nil)
 
-	docker.On("Stop", testContainer.ID).Return(nil)
+	docker.On("Stop", mock.Anything, testContainer.ID).Return(nil)
 
-	handler := NewCommandHandler(docker, modRepo)
-
-	doneCh, evtCh, errCh := handler.RunCommands(structs.ContainerDetailsWrapper{
-		Containers: []structs.ContainerDetails{
-			testContainer,
-		}})
-
-readChannel:
-	for {
-		select {
-		case err := <-errCh:
-			if err != nil {
-				c.T().Error("test failed", err)
-			}
-		case event := <-evtCh:
-			fmt.Println(event)
-		case <-doneCh:
-			break readChannel
-		}
-	}
+	c.runJob(docker, modRepo, routes, testContainer)
 
-	docker.AssertCalled(c.T(), "Stop", testContainer.ID)
+	docker.AssertCalled(c.T(), "Stop", mock.Anything, testContainer.ID)
 
 	docker.AssertExpectations(c.T())
 }
 
 func (c *CommandHandlerTestSuite) TestCommandHandler_Restart() {
 	modRepo := new(mocks.MockModuleMetadataRepo)
+	routes := new(mocks.MockRouteTable)
 	docker := new(mocks.TestDocker)
 
 	testContainer.Command = structs.Restart
 
-	docker.On("ListNetworks").Return([]types.NetworkResource{{
-		Name: "module_net",
-		ID:   "module_net",
-	}})
-
-	docker.On("Restart", testContainer.ID).Return(nil)
+	docker.On("ModuleNetwork", mock.Anything).Return("module_net", nil)
 
-	handler := NewCommandHandler(docker, modRepo)
+	docker.On("Restart", mock.Anything, testContainer.ID).Return(nil)
 
-	doneCh, evtCh, errCh := handler.RunCommands(structs.ContainerDetailsWrapper{
-		Containers: []structs.ContainerDetails{
-			testContainer,
-		}})
+	c.runJob(docker, modRepo, routes, testContainer)
 
-readChannel:
-	for {
-		select {
-		case err := <-errCh:
-			if err != nil {
-				c.T().Error("test failed", err)
-			}
-		case event := <-evtCh:
-			fmt.Println(event)
-		case <-doneCh:
-			break readChannel
-		}
-	}
-
-	docker.AssertCalled(c.T(), "Restart", testContainer.ID)
+	docker.AssertCalled(c.T(), "Restart", mock.Anything, testContainer.ID)
 
 	docker.AssertExpectations(c.T())
 }
 
 func (c *CommandHandlerTestSuite) TestCommandHandler_Remove() {
 	modRepo := new(mocks.MockModuleMetadataRepo)
+	routes := new(mocks.MockRouteTable)
 	docker := new(mocks.TestDocker)
 
 	testContainer.Command = structs.Remove
 
-	docker.On("ListNetworks").Return([]types.NetworkResource{{
-		Name: "module_net",
-		ID:   "module_net",
-	}})
+	docker.On("ModuleNetwork", mock.Anything).Return("module_net", nil)
 
-	docker.On("Remove", testContainer.ID).Return(nil)
+	docker.On("Remove", mock.Anything, testContainer.ID).Return(nil)
 
 	modRepo.On("DeleteByServiceName", mock.Anything).Return(nil)
+	routes.On("Remove", testContainer.ID).Return(true)
 
-	handler := NewCommandHandler(docker, modRepo)
-
-	doneCh, evtCh, errCh := handler.RunCommands(structs.ContainerDetailsWrapper{
-		Containers: []structs.ContainerDetails{
-			testContainer,
-		}})
+	c.runJob(docker, modRepo, routes, testContainer)
 
-readChannel:
-	for {
-		select {
-		case err := <-errCh:
-			if err != nil {
-				c.T().Error("test failed", err)
-			}
-		case event := <-evtCh:
-			fmt.Println(event)
-		case <-doneCh:
-			break readChannel
-		}
-	}
-
-	docker.AssertCalled(c.T(), "Remove", testContainer.ID)
+	docker.AssertCalled(c.T(), "Remove", mock.Anything, testContainer.ID)
 	modRepo.AssertCalled(c.T(), "DeleteByServiceName", mock.Anything)
+	routes.AssertCalled(c.T(), "Remove", testContainer.ID)
 
 	docker.AssertExpectations(c.T())
 	modRepo.AssertExpectations(c.T())
@@ -288,6 +302,7 @@ readChannel:
 
 func (c *CommandHandlerTestSuite) TestCommandHandler_MultipleCommands() {
 	modRepo := new(mocks.MockModuleMetadataRepo)
+	routes := new(mocks.MockRouteTable)
 	docker := new(mocks.TestDocker)
 
 	testContainer.Command = structs.Create
@@ -302,75 +317,147 @@ func (c *CommandHandlerTestSuite) TestCommandHandler_MultipleCommands() {
 	testContainer6 := testContainer
 	testContainer.Command = structs.Remove
 
-	docker.On("ListNetworks").Times(6).Return([]types.NetworkResource{{
-		Name: "module_net",
-		ID:   "module_net",
-	}})
+	docker.On("ModuleNetwork", mock.Anything).Times(6).Return("module_net", nil)
 
+	docker.On("ResolveImage", mock.Anything, testContainer.ImageRef).Return(testDigest, nil)
 	docker.On(
 		"Create",
-		testContainer.ImageRef,
-		testContainer.ID,
-		testContainer.Network,
-		testContainer.Volumes,
 		mock.Anything,
+		testWorkload(DefaultRuntimeProfile()),
 	).Times(1).Return(nil)
 
-	docker.On("PullAndStart", testContainer.ImageRef, testContainer.ID).Times(1).Return(nil)
-	docker.On("Start", testContainer.ID).Times(1).Return(nil)
-	docker.On("Stop", testContainer.ID).Times(1).Return(nil)
-	docker.On("Restart", testContainer.ID).Times(1).Return(nil)
-	docker.On("Remove", testContainer.ID).Times(1).Return(nil)
+	docker.On("PullAndStart", testDigest, testContainer.ID).Times(1).Return(nil)
+	docker.On("Start", mock.Anything, testContainer.ID).Times(1).Return(nil)
+	docker.On("Stop", mock.Anything, testContainer.ID).Times(1).Return(nil)
+	docker.On("Restart", mock.Anything, testContainer.ID).Times(1).Return(nil)
+	docker.On("Remove", mock.Anything, testContainer.ID).Times(1).Return(nil)
 
 	modRepo.On("DeleteByServiceName", mock.Anything).Times(1).Return(nil)
+	routes.On("Remove", testContainer.ID).Times(1).Return(true)
 
-	handler := NewCommandHandler(docker, modRepo)
-
-	doneCh, evtCh, errCh := handler.RunCommands(structs.ContainerDetailsWrapper{
-		Containers: []structs.ContainerDetails{
-			testContainer,
-			testContainer2,
-			testContainer3,
-			testContainer4,
-			testContainer5,
-			testContainer6,
-		}})
-
-readChannel:
-	for {
-		select {
-		case err := <-errCh:
-			if err != nil {
-				c.T().Error("test failed", err)
-			}
-		case event := <-evtCh:
-			fmt.Println(event)
-		case <-doneCh:
-			break readChannel
-		}
-	}
+	c.runJob(docker, modRepo, routes, testContainer, testContainer2, testContainer3, testContainer4, testContainer5, testContainer6)
 
 	docker.AssertCalled(
 		c.T(),
 		"Create",
-		testContainer.ImageRef,
-		testContainer.ID,
-		testContainer.Network,
-		testContainer.Volumes,
 		mock.Anything,
+		testWorkload(DefaultRuntimeProfile()),
 	)
 
-	docker.AssertCalled(c.T(), "PullAndStart", testContainer.ImageRef, testContainer.ID)
+	docker.AssertCalled(c.T(), "PullAndStart", testDigest, testContainer.ID)
 
-	docker.AssertCalled(c.T(), "Start", testContainer.ID)
+	docker.AssertCalled(c.T(), "Start", mock.Anything, testContainer.ID)
 
-	docker.AssertCalled(c.T(), "Stop", testContainer.ID)
+	docker.AssertCalled(c.T(), "Stop", mock.Anything, testContainer.ID)
 
-	docker.AssertCalled(c.T(), "Restart", testContainer.ID)
+	docker.AssertCalled(c.T(), "Restart", mock.Anything, testContainer.ID)
 
-	docker.AssertCalled(c.T(), "Remove", testContainer.ID)
+	docker.AssertCalled(c.T(), "Remove", mock.Anything, testContainer.ID)
 	modRepo.AssertCalled(c.T(), "DeleteByServiceName", mock.Anything)
+	routes.AssertCalled(c.T(), "Remove", testContainer.ID)
 
 	docker.AssertExpectations(c.T())
 	modRepo.AssertExpectations(c.T())
 }
+
+func (c *CommandHandlerTestSuite) TestCommandHandler_FailedStep() {
+	modRepo := new(mocks.MockModuleMetadataRepo)
+	routes := new(mocks.MockRouteTable)
+	docker := new(mocks.TestDocker)
+
+	docker.On("ModuleNetwork", mock.Anything).Return("module_net", nil)
+	docker.On("Start", mock.Anything, testContainer.ID).Return(errors.New("no such image"))
+
+	start, stop, create := testContainer, testContainer, testContainer
+	start.Command, stop.Command, create.Command = structs.Start, structs.Stop, structs.Create
+	create.ImageRef = "docker.io/library/nginx:latest"
+	os.Setenv("DOCKER_PREFIX", "test.docker.io/")
+	defer os.Unsetenv("DOCKER_PREFIX")
+
+	handler, jobs, ns := newTestHandler(docker, modRepo, routes)
+	job, err := handler.Submit(context.Background(), structs.ContainerDetailsWrapper{
+		Containers: []structs.ContainerDetails{create, start, stop},
+	})
+	c.Nil(err)
+	c.Equal(structs.JobQueued, job.Status)
+	c.Equal(structs.JobSkipped, job.Steps[0].Status)
+
+	job, err = handler.Wait(context.Background(), job.ID)
+	c.Nil(err)
+
+	c.Equal(structs.JobFailed, job.Status)
+	c.Contains(job.Error, "no such image")
+	c.NotNil(job.StartedAt)
+	c.NotNil(job.FinishedAt)
+	c.Equal([]structs.JobStatus{structs.JobSkipped, structs.JobFailed, structs.JobSkipped},
+		[]structs.JobStatus{job.Steps[0].Status, job.Steps[1].Status, job.Steps[2].Status})
+	c.Equal("no such image", job.Steps[1].Error)
+	docker.AssertNotCalled(c.T(), "Stop", mock.Anything, mock.Anything)
+
+	c.T().Run("Test the final status is stored and sent with the job ID", func(t *testing.T) {
+		jobs.AssertCalled(t, "UpdateJob", mock.MatchedBy(func(j structs.Job) bool { return j.Status == structs.JobFailed }))
+		ns.AssertCalled(t, "Send", mock.MatchedBy(func(e notification.Event) bool {
+			return e.JobID == job.ID && e.Context.Type == notification.Job && e.Context.State == notification.State(structs.JobFailed)
+		}))
+	})
+
+	c.T().Run("Test a finished job is read from the repo", func(t *testing.T) {
+		jobs.On("GetJob", job.ID).Return(job, nil)
+		jobs.On("GetJob", "missing").Return(structs.Job{}, sql.ErrNoRows)
+
+		_, err := handler.Cancel(job.ID)
+		assert.Equal(t, ErrJobFinished, err)
+		_, err = handler.Job("missing")
+		assert.Equal(t, ErrJobNotFound, err)
+	})
+}
+
+func (c *CommandHandlerTestSuite) TestCommandHandler_Cancel() {
+	modRepo := new(mocks.MockModuleMetadataRepo)
+	routes := new(mocks.MockRouteTable)
+	docker := new(mocks.TestDocker)
+
+	release := make(chan struct{})
+	docker.On("ModuleNetwork", mock.Anything).Return("module_net", nil)
+	docker.On("Stop", mock.Anything, testContainer.ID).Run(func(mock.Arguments) { <-release }).Return(nil)
+
+	stop, start := testContainer, testContainer
+	stop.Command, start.Command = structs.Stop, structs.Start
+
+	handler, _, _ := newTestHandler(docker, modRepo, routes)
+	running, err := handler.Submit(context.Background(), structs.ContainerDetailsWrapper{
+		Containers: []structs.ContainerDetails{stop, start},
+	})
+	c.Nil(err)
+	queued, err := handler.Submit(context.Background(), structs.ContainerDetailsWrapper{
+		Containers: []structs.ContainerDetails{start},
+	})
+	c.Nil(err)
+
+	c.T().Run("Test a queued job is cancelled before it runs", func(t *testing.T) {
+		job, err := handler.Cancel(queued.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, structs.JobCancelled, job.Status)
+		assert.Equal(t, structs.JobCancelled, job.Steps[0].Status)
+	})
+
+	c.T().Run("Test a running job stops after its current step", func(t *testing.T) {
+		for {
+			if job, _ := handler.Job(running.ID); job.Steps[0].Status == structs.JobRunning {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		_, err := handler.Cancel(running.ID)
+		assert.Nil(t, err)
+		close(release)
+
+		job, err := handler.Wait(context.Background(), running.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, structs.JobCancelled, job.Status)
+		assert.Equal(t, structs.JobSucceeded, job.Steps[0].Status)
+		assert.Equal(t, structs.JobCancelled, job.Steps[1].Status)
+	})
+
+	docker.AssertNotCalled(c.T(), "Start", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,102 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"fp-dynamic-elements-manager-controller/internal/docker/utils"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/pkg/stdcopy"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// serviceContainer returns the ID of the container running the service, a compose service is found by its label and
+// anything else by its container name
+func (d *Docker) serviceContainer(ctx context.Context, service string) (string, error) {
+	args := filters.NewArgs()
+	args.Add("label", fmt.Sprintf("com.docker.compose.service=%s", service))
+	containers, err := d.cli.ContainerList(ctx, types.ContainerListOptions{Filters: args})
+	if err != nil {
+		return "", err
+	}
+	if len(containers) > 0 {
+		return containers[0].ID, nil
+	}
+	return service, nil
+}
+
+// Exec runs the command in the service's container and waits for it to exit, stdout and stderr are demultiplexed
+// and stderr is used as the error message if the command fails
+func (d *Docker) Exec(ctx context.Context, service string, cmd []string, stdin io.Reader, stdout io.Writer) error {
+	logger := dockerLog(applog.Fields{"service": service})
+
+	containerID, err := d.serviceContainer(ctx, service)
+	if err != nil {
+		logger.Error(err, "error finding the service container")
+		return err
+	}
+
+	config := types.ExecConfig{AttachStdin: stdin != nil, AttachStdout: true, AttachStderr: true, Cmd: cmd}
+	exec, err := d.cli.ContainerExecCreate(ctx, containerID, config)
+	if err != nil {
+		logger.Error(err, fmt.Sprintf("error creating exec to container: %s", containerID))
+		return err
+	}
+
+	// Attaching starts the command
+	resp, err := d.cli.ContainerExecAttach(ctx, exec.ID, config)
+	if err != nil {
+		logger.Error(err, fmt.Sprintf("error attaching exec to container: %s", containerID))
+		return err
+	}
+	defer resp.Close()
+
+	// Closing the connection ends the command's output when ctx is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			resp.Close()
+		case <-done:
+		}
+	}()
+
+	if stdin != nil {
+		go func() {
+			io.Copy(resp.Conn, stdin)
+			resp.CloseWrite()
+		}()
+	}
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+
+	stderr := &utils.LimitedBuffer{Max: 4096}
+	if _, err := stdcopy.StdCopy(stdout, stderr, resp.Reader); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		logger.Error(err, "error reading exec output")
+		return err
+	}
+
+	inspect, err := d.cli.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return err
+	}
+	if inspect.ExitCode != 0 {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = "no output"
+		}
+		err := errors.New(msg)
+		logger.Error(err, fmt.Sprintf("command exited with %d", inspect.ExitCode))
+		return fmt.Errorf("%s exited with %d: %v", cmd[0], inspect.ExitCode, err)
+	}
+	return nil
+}
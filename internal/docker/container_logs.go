@@ -0,0 +1,201 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"fp-dynamic-elements-manager-controller/internal/docker/structs"
+	"fp-dynamic-elements-manager-controller/internal/docker/utils"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
+	"github.com/docker/docker/api/types"
+	timetypes "github.com/docker/docker/api/types/time"
+	"github.com/docker/docker/pkg/stdcopy"
+	"io"
+	"strings"
+	"time"
+)
+
+// errUntilReached stops reading the logs once a line is written after the until time
+var errUntilReached = errors.New("until reached")
+
+// ParseLogTime parses a since or until value the same way the docker CLI does, it can be an RFC 3339 time, a unix
+// timestamp or a duration before now such as 10m
+func ParseLogTime(value string, now time.Time) (time.Time, error) {
+	ts, err := timetypes.GetTimestamp(value, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	sec, nsec, err := timetypes.ParseTimestamps(ts, 0)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(sec, nsec), nil
+}
+
+// Logs reads the logs of a container, calling out with each line in the order they were written. When following,
+// it returns once ctx is done or, if set, the until time has passed
+func (d *Docker) Logs(ctx context.Context, containerID string, opts structs.LogOptions, out func(structs.LogLine) error) error {
+	logger := dockerLog(applog.Fields{"containerID": containerID})
+
+	ctr, err := d.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		logger.Error(err, "error inspecting container")
+		return err
+	}
+
+	follow := opts.Follow
+	if follow && !opts.Until.IsZero() {
+		if !opts.Until.After(time.Now()) {
+			follow = false
+		} else {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, opts.Until)
+			defer cancel()
+		}
+	}
+
+	logOpts := types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Timestamps: true, Follow: follow, Tail: opts.Tail}
+	if !opts.Since.IsZero() {
+		logOpts.Since = fmt.Sprintf("%d.%09d", opts.Since.Unix(), opts.Since.Nanosecond())
+	}
+
+	reader, err := d.cli.ContainerLogs(ctx, containerID, logOpts)
+	if err != nil {
+		logger.Error(err, "error getting container logs")
+		return err
+	}
+	defer reader.Close()
+
+	err = readLogs(reader, ctr.Config != nil && ctr.Config.Tty, opts.Until, out)
+	if ctx.Err() != nil {
+		// The client went away or the until time passed while following
+		return nil
+	}
+	return err
+}
+
+// readLogs splits the log stream into lines. Unless the container has a TTY, stdout and stderr are multiplexed on
+// the stream and each line is tagged with the stream it was written to
+func readLogs(r io.Reader, tty bool, until time.Time, out func(structs.LogLine) error) error {
+	stdout := &lineWriter{stream: structs.Stdout, until: until, out: out}
+	stderr := &lineWriter{stream: structs.Stderr, until: until, out: out}
+
+	var err error
+	if tty {
+		_, err = io.Copy(stdout, r)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, r)
+	}
+	if err == nil {
+		if err = stdout.flush(); err == nil {
+			err = stderr.flush()
+		}
+	}
+	if err == errUntilReached {
+		return nil
+	}
+	return err
+}
+
+// lineWriter buffers the output of a stream and passes on each complete line, without its timestamp prefix
+type lineWriter struct {
+	stream string
+	until  time.Time
+	out    func(structs.LogLine) error
+	buf    bytes.Buffer
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := string(w.buf.Next(i + 1))
+		if err := w.send(line[:i]); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// flush sends a final line which did not end with a newline
+func (w *lineWriter) flush() error {
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	line := w.buf.String()
+	w.buf.Reset()
+	return w.send(line)
+}
+
+func (w *lineWriter) send(line string) error {
+	logLine := structs.LogLine{Stream: w.stream, Line: strings.TrimSuffix(line, "\r")}
+	if i := strings.IndexByte(line, ' '); i > 0 {
+		if t, err := time.Parse(time.RFC3339Nano, line[:i]); err == nil {
+			logLine.Time, logLine.Line = t, strings.TrimSuffix(line[i+1:], "\r")
+		}
+	}
+	if !w.until.IsZero() && logLine.Time.After(w.until) {
+		return errUntilReached
+	}
+	return w.out(logLine)
+}
+
+// Inspect returns the detail of a container with the secrets in its environment redacted
+func (d *Docker) Inspect(ctx context.Context, containerID string) (structs.ContainerInspect, error) {
+	ctr, err := d.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		dockerLog(applog.Fields{"containerID": containerID}).Error(err, "error inspecting container")
+		return structs.ContainerInspect{}, err
+	}
+
+	inspect := structs.ContainerInspect{
+		ID:           ctr.ID,
+		Name:         strings.TrimPrefix(ctr.Name, "/"),
+		ImageID:      ctr.Image,
+		ImageDigests: []string{},
+		Created:      ctr.Created,
+		RestartCount: ctr.RestartCount,
+		Mounts:       []structs.ContainerMount{},
+		Env:          []string{},
+	}
+	if ctr.Config != nil {
+		inspect.Image = ctr.Config.Image
+		inspect.Env = utils.RedactEnv(ctr.Config.Env)
+	}
+	if ctr.State != nil {
+		inspect.State = structs.ContainerState{
+			Status:     ctr.State.Status,
+			Running:    ctr.State.Running,
+			Restarting: ctr.State.Restarting,
+			OOMKilled:  ctr.State.OOMKilled,
+			ExitCode:   ctr.State.ExitCode,
+			Error:      ctr.State.Error,
+			StartedAt:  ctr.State.StartedAt,
+			FinishedAt: ctr.State.FinishedAt,
+		}
+		if ctr.State.Health != nil {
+			inspect.State.Health = ctr.State.Health.Status
+		}
+	}
+	for _, m := range ctr.Mounts {
+		inspect.Mounts = append(inspect.Mounts, structs.ContainerMount{
+			Type:        string(m.Type),
+			Source:      m.Source,
+			Destination: m.Destination,
+			ReadWrite:   m.RW,
+		})
+	}
+
+	// The digest is only known for images pulled from a registry, a locally built image has none
+	img, _, err := d.cli.ImageInspectWithRaw(ctx, ctr.Image)
+	if err != nil {
+		dockerLog(applog.Fields{"containerID": containerID}).Error(err, "error inspecting container image")
+	} else if img.RepoDigests != nil {
+		inspect.ImageDigests = img.RepoDigests
+	}
+
+	return inspect, nil
+}
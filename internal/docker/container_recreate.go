@@ -0,0 +1,187 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fp-dynamic-elements-manager-controller/internal/docker/structs"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Snapshot records the image and config of a container so it can be recreated
+func (d *Docker) Snapshot(ctx context.Context, containerName string) (structs.ContainerSnapshot, error) {
+	logger := dockerLog(applog.Fields{"containerID": containerName})
+
+	ctr, err := d.cli.ContainerInspect(ctx, containerName)
+	if err != nil {
+		logger.Error(err, "error inspecting container")
+		return structs.ContainerSnapshot{}, err
+	}
+	if ctr.Config == nil {
+		return structs.ContainerSnapshot{}, errors.New("container has no config")
+	}
+
+	snapshot := structs.ContainerSnapshot{
+		Name:       strings.TrimPrefix(ctr.Name, "/"),
+		Image:      ctr.Config.Image,
+		ImageID:    ctr.Image,
+		Config:     ctr.Config,
+		HostConfig: ctr.HostConfig,
+		Networks:   map[string]*network.EndpointSettings{},
+	}
+
+	// Docker sets the hostname and an alias to the short container ID, the new container gets its own
+	shortID := ctr.ID
+	if len(shortID) > 12 {
+		shortID = shortID[:12]
+	}
+	if snapshot.Config.Hostname == shortID {
+		snapshot.Config.Hostname = ""
+	}
+	if ctr.NetworkSettings != nil {
+		for name, settings := range ctr.NetworkSettings.Networks {
+			endpoint := &network.EndpointSettings{NetworkID: settings.NetworkID, IPAMConfig: settings.IPAMConfig, Links: settings.Links}
+			for _, alias := range settings.Aliases {
+				if alias != shortID {
+					endpoint.Aliases = append(endpoint.Aliases, alias)
+				}
+			}
+			snapshot.Networks[name] = endpoint
+		}
+	}
+
+	img, _, err := d.cli.ImageInspectWithRaw(ctx, ctr.Image)
+	if err != nil {
+		logger.Error(err, "error inspecting container image")
+		return structs.ContainerSnapshot{}, err
+	}
+	snapshot.Digest = digestFor(snapshot.Image, img.RepoDigests)
+
+	return snapshot, nil
+}
+
+// PullImage pulls an image from the registry and returns its image ID and repo digest
+func (d *Docker) PullImage(ctx context.Context, imageRef string) (string, string, error) {
+	logger := dockerLog(applog.Fields{"image": imageRef})
+
+	out, err := d.cli.ImagePull(ctx, imageRef, types.ImagePullOptions{RegistryAuth: d.authString})
+	if err != nil {
+		logger.Error(err, "error pulling image")
+		return "", "", err
+	}
+	defer out.Close()
+
+	if err := readPull(out); err != nil {
+		logger.Error(err, "error pulling image")
+		return "", "", err
+	}
+
+	img, _, err := d.cli.ImageInspectWithRaw(ctx, imageRef)
+	if err != nil {
+		logger.Error(err, "error inspecting pulled image")
+		return "", "", err
+	}
+	return img.ID, digestFor(imageRef, img.RepoDigests), nil
+}
+
+// Recreate replaces the container with a new one from image, using the config of the snapshot, and starts it
+func (d *Docker) Recreate(ctx context.Context, snapshot structs.ContainerSnapshot, image string) error {
+	logger := dockerLog(applog.Fields{"containerID": snapshot.Name, "image": image})
+
+	// The container is already gone if an earlier recreate failed part way through
+	if _, err := d.cli.ContainerInspect(ctx, snapshot.Name); err == nil {
+		timeout := 30 * time.Second
+		if err := d.cli.ContainerStop(ctx, snapshot.Name, &timeout); err != nil {
+			logger.Error(err, "error stopping container")
+			return err
+		}
+		if err := d.cli.ContainerRemove(ctx, snapshot.Name, types.ContainerRemoveOptions{}); err != nil {
+			logger.Error(err, "error removing container")
+			return err
+		}
+	} else if !client.IsErrContainerNotFound(err) {
+		logger.Error(err, "error inspecting container")
+		return err
+	}
+
+	config := *snapshot.Config
+	config.Image = image
+
+	// A container can only be created on one network, it is connected to the others before it starts
+	var names []string
+	for name := range snapshot.Networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	networking := &network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{}}
+	if len(names) > 0 {
+		networking.EndpointsConfig[names[0]] = snapshot.Networks[names[0]]
+	}
+
+	resp, err := d.cli.ContainerCreate(ctx, &config, snapshot.HostConfig, networking, snapshot.Name)
+	if err != nil {
+		logger.Error(err, "error creating container")
+		return err
+	}
+
+	for i, name := range names {
+		if i == 0 {
+			continue
+		}
+		if err := d.cli.NetworkConnect(ctx, name, resp.ID, snapshot.Networks[name]); err != nil {
+			logger.Error(err, "error connecting container to network")
+			return err
+		}
+	}
+
+	if err := d.cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		logger.Error(err, "error starting container")
+		return err
+	}
+	return nil
+}
+
+// readPull reads the progress of an image pull, the pull can fail part way through which is reported in the stream
+func readPull(r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	for {
+		var msg struct {
+			Error string `json:"error"`
+		}
+		if err := decoder.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Error != "" {
+			return errors.New(msg.Error)
+		}
+	}
+}
+
+// digestFor returns the repo digest of an image for the repository of imageRef, or the first digest if none match
+func digestFor(imageRef string, repoDigests []string) string {
+	repo := imageRef
+	if i := strings.Index(repo, "@"); i >= 0 {
+		repo = repo[:i]
+	} else if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo = repo[:i]
+	}
+
+	for _, digest := range repoDigests {
+		if strings.HasPrefix(digest, repo+"@") {
+			return digest
+		}
+	}
+	if len(repoDigests) > 0 {
+		return repoDigests[0]
+	}
+	return ""
+}
@@ -4,35 +4,36 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
-	"fmt"
-	"fp-dynamic-elements-manager-controller/internal/db/persistence"
+	structs2 "fp-dynamic-elements-manager-controller/internal/docker/structs"
+	"fp-dynamic-elements-manager-controller/internal/docker/utils"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
 	"fp-dynamic-elements-manager-controller/internal/logging/structs"
+	"fp-dynamic-elements-manager-controller/internal/orchestrator"
+	structs3 "fp-dynamic-elements-manager-controller/internal/orchestrator/structs"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/api/types/registry"
 	"github.com/docker/docker/client"
-	"github.com/rs/zerolog/log"
-	"io"
-	"os"
 	"regexp"
-	"strconv"
 	"time"
 )
 
+// Dockers is the docker orchestrator, along with running workloads it supports the commands which only docker has
 type Dockers interface {
+	orchestrator.Orchestrator
 	PullAndStart(string, string) error
-	PullAndRestart(string, string) error
-	Create(string, string, string, []string, []string) error
-	Start(string) error
-	Stop(string) error
-	Restart(string) error
-	Remove(string) error
 	ListNetworks() []types.NetworkResource
 	ListContainers(types.ContainerListOptions) []types.Container
-	RunDatabaseDump() error
-	RunDatabaseRestore()
+	Stats(context.Context, string) (structs2.ContainerSample, error)
+	Snapshot(context.Context, string) (structs2.ContainerSnapshot, error)
+	PullImage(context.Context, string) (string, string, error)
+	Recreate(context.Context, structs2.ContainerSnapshot, string) error
+}
+
+// dockerLog returns the system logger with the fields added to every docker log entry
+func dockerLog(fields applog.Fields) applog.Logger {
+	return applog.System().WithFields(applog.Fields{"module": "docker"}).WithFields(fields)
 }
 
 // Docker represents a docker client
@@ -50,7 +51,7 @@ func NewDocker(username, password, serverAddr string, logger *structs.AppLogger)
 		return nil, err
 	}
 	ctx := context.Background()
-	log.Info().Str("module", "docker").Msg("connected to the docker daemon")
+	dockerLog(nil).Info("connected to the docker daemon")
 
 	authConfig := types.AuthConfig{
 		Username:      username,
@@ -71,104 +72,15 @@ func (d *Docker) Ping() {
 	ping, err := d.cli.Ping(d.ctx)
 
 	if err != nil {
-		log.Error().Str("module", "docker").Err(err).Msg("error pinging docker")
-	}
-	log.Info().Str("module", "docker").Str("Api-version", ping.APIVersion).Msg("docker daemon health check")
-}
-
-func (d *Docker) RunDatabaseDump() (err error) {
-	args, err := filters.ParseFlag("label=com.docker.compose.service=mariadb", filters.NewArgs())
-
-	if err != nil {
-		d.logger.SystemLogger.Error(err, "error prepping args")
-		return
-	}
-
-	runningContainers := d.ListContainers(types.ContainerListOptions{All: true, Filters: args})
-
-	containerId := runningContainers[0].ID
-
-	c := types.ExecConfig{
-		AttachStdout: true,
-		AttachStderr: true,
-		Cmd: []string{"/bin/sh",
-			"-c",
-			fmt.Sprintf(
-				os.ExpandEnv(
-					"mysqldump -u ${MYSQL_USER} -p${MYSQL_PASSWORD} ${MYSQL_DATABASE} %s > ${DB_BACKUP_DIR}${DB_BACKUP_FILE}"),
-				persistence.ElementsTable,
-			),
-		},
-	}
-
-	id, err := d.cli.ContainerExecCreate(d.ctx, containerId, c)
-
-	if err != nil {
-		d.logger.SystemLogger.Error(err, fmt.Sprintf("error creating exec to container: %s", containerId))
-		return
-	}
-
-	resp, err := d.cli.ContainerExecAttach(d.ctx, id.ID, types.ExecConfig{})
-
-	if err != nil {
-		d.logger.SystemLogger.Error(err, fmt.Sprintf("error attaching exec to container: %s", containerId))
-		return
-	}
-
-	defer resp.Close()
-
-	err = d.cli.ContainerExecStart(d.ctx, id.ID, types.ExecStartCheck{})
-	if err != nil {
-		d.logger.SystemLogger.Error(err, fmt.Sprintf("error starting exec to container: %s", containerId))
-		return
-	}
-
-	return nil
-}
-
-func (d *Docker) RunDatabaseRestore() {
-	args, err := filters.ParseFlag("label=com.docker.compose.service=mariadb", filters.NewArgs())
-
-	if err != nil {
-		d.logger.SystemLogger.Error(err, "error prepping args")
-	}
-
-	runningContainers := d.ListContainers(types.ContainerListOptions{Filters: args})
-
-	containerId := runningContainers[0].ID
-
-	c := types.ExecConfig{
-		AttachStdout: true,
-		AttachStderr: true,
-		Cmd: []string{"/bin/sh",
-			"-c",
-			os.ExpandEnv("mysql -u ${MYSQL_USER} -p${MYSQL_PASSWORD} ${MYSQL_DATABASE} < ${DB_BACKUP_DIR}${DB_BACKUP_FILE}"),
-		}}
-
-	id, err := d.cli.ContainerExecCreate(d.ctx, containerId, c)
-
-	if err != nil {
-		d.logger.SystemLogger.Error(err, fmt.Sprintf("error creating exec to container: %s", containerId))
-	}
-
-	resp, err := d.cli.ContainerExecAttach(d.ctx, id.ID, types.ExecConfig{})
-
-	if err != nil {
-		d.logger.SystemLogger.Error(err, fmt.Sprintf("error attaching exec to container: %s", containerId))
-	}
-
-	defer resp.Close()
-
-	err = d.cli.ContainerExecStart(d.ctx, id.ID, types.ExecStartCheck{})
-	if err != nil {
-		d.logger.SystemLogger.Error(err, fmt.Sprintf("error starting exec to container: %s", containerId))
+		dockerLog(nil).Error(err, "error pinging docker")
 	}
+	dockerLog(applog.Fields{"Api-version": ping.APIVersion}).Info("docker daemon health check")
 }
 
 func (d *Docker) ListContainers(options types.ContainerListOptions) []types.Container {
 	containers, err := d.cli.ContainerList(d.ctx, options)
 	if err != nil {
-		log.Error().Str("module", "docker").Err(err).Msg("error listing containers")
+		dockerLog(nil).Error(err, "error listing containers")
 	}
 	return containers
 }
@@ -176,82 +88,100 @@ func (d *Docker) ListContainers(options types.ContainerListOptions) []types.Cont
 func (d *Docker) ListNetworks() []types.NetworkResource {
 	networks, err := d.cli.NetworkList(d.ctx, types.NetworkListOptions{})
 	if err != nil {
-		log.Error().Str("module", "docker").Err(err).Msg("error listing networks")
+		dockerLog(nil).Error(err, "error listing networks")
 	}
 	return networks
 }
 
-func (d *Docker) Stop(containerID string) error {
-	timeout := time.Until(time.Now().Add(30 * time.Second))
-	if err := d.cli.ContainerStop(d.ctx, containerID, &timeout); err != nil {
-		log.Error().Str("module", "docker").Str("containerID", containerID).Err(err).Msg("error stopping container")
+// ModuleNetwork returns the docker network whose name ends with MODULE_NETWORK_NAME
+func (d *Docker) ModuleNetwork(context.Context) (string, error) {
+	return utils.AddModuleNetwork(d.ListNetworks())
+}
+
+// ResolveImage pulls the image and returns the digest it resolved to
+func (d *Docker) ResolveImage(ctx context.Context, imageRef string) (string, error) {
+	_, digest, err := d.PullImage(ctx, imageRef)
+	return digest, err
+}
+
+func (d *Docker) Stop(ctx context.Context, containerID string) error {
+	timeout := 30 * time.Second
+	if err := d.cli.ContainerStop(ctx, containerID, &timeout); err != nil {
+		dockerLog(applog.Fields{"containerID": containerID}).Error(err, "error stopping container")
 		return err
 	}
 	return nil
 }
 
-func (d *Docker) Start(containerID string) error {
-	if err := d.cli.ContainerStart(d.ctx, containerID, types.ContainerStartOptions{}); err != nil {
-		log.Error().Str("module", "docker").Str("containerID", containerID).Err(err).Msg("error starting container")
+func (d *Docker) Start(ctx context.Context, containerID string) error {
+	if err := d.cli.ContainerStart(ctx, containerID, types.ContainerStartOptions{}); err != nil {
+		dockerLog(applog.Fields{"containerID": containerID}).Error(err, "error starting container")
 		return err
 	}
 	return nil
 }
 
-func (d *Docker) Restart(containerID string) error {
-	if err := d.cli.ContainerRestart(d.ctx, containerID, nil); err != nil {
-		log.Error().Str("module", "docker").Str("containerID", containerID).Err(err).Msg("error restarting container")
+func (d *Docker) Restart(ctx context.Context, containerID string) error {
+	if err := d.cli.ContainerRestart(ctx, containerID, nil); err != nil {
+		dockerLog(applog.Fields{"containerID": containerID}).Error(err, "error restarting container")
 		return err
 	}
 	return nil
 }
 
-func (d *Docker) Remove(containerID string) error {
-	if err := d.Stop(containerID); err != nil {
-		log.Error().Str("module", "docker").Str("containerID", containerID).Err(err).Msg("error stopping container")
+func (d *Docker) Remove(ctx context.Context, containerID string) error {
+	if err := d.Stop(ctx, containerID); err != nil {
+		dockerLog(applog.Fields{"containerID": containerID}).Error(err, "error stopping container")
 		return err
 	}
-	if err := d.cli.ContainerRemove(d.ctx, containerID, types.ContainerRemoveOptions{}); err != nil {
-		log.Error().Str("module", "docker").Str("containerID", containerID).Err(err).Msg("error removing container")
+	if err := d.cli.ContainerRemove(ctx, containerID, types.ContainerRemoveOptions{}); err != nil {
+		dockerLog(applog.Fields{"containerID": containerID}).Error(err, "error removing container")
 		return err
 	}
 	return nil
 }
 
-func (d *Docker) Create(imageRef, containerName, containerNetwork string, volumes []string, envVars []string) error {
-	out, err := d.cli.ImagePull(d.ctx, imageRef, types.ImagePullOptions{RegistryAuth: d.authString})
+// Create pulls the image and creates and starts a module container from it, limited by the workload's runtime profile
+func (d *Docker) Create(ctx context.Context, workload structs3.Workload) error {
+	out, err := d.cli.ImagePull(ctx, workload.Image, types.ImagePullOptions{RegistryAuth: d.authString})
 	if err != nil {
 		return err
 	}
 
 	defer out.Close()
-	io.Copy(os.Stdout, out)
+	// The pull progress is read rather than printed, only an error from the pull is kept
+	if err := readPull(out); err != nil {
+		return err
+	}
 
 	networkConfig := &network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{}}
 
 	gatewayConfig := &network.EndpointSettings{
-		NetworkID: containerNetwork,
-		Aliases:   []string{containerName},
+		NetworkID: workload.Network,
+		Aliases:   []string{workload.Name},
 	}
 
-	networkConfig.EndpointsConfig[containerNetwork] = gatewayConfig
+	networkConfig.EndpointsConfig[workload.Network] = gatewayConfig
 
-	resp, err := d.cli.ContainerCreate(d.ctx, &container.Config{
-		Image: imageRef,
-		Tty:   false,
-		Env:   envVars,
-	}, &container.HostConfig{
-		Binds: volumes,
+	hostConfig := &container.HostConfig{
+		Binds: workload.Volumes,
 		RestartPolicy: container.RestartPolicy{
 			Name: "always",
 		},
-	}, networkConfig, containerName)
+	}
+	applyRuntimeProfile(hostConfig, workload.Runtime)
+
+	resp, err := d.cli.ContainerCreate(ctx, &container.Config{
+		Image: workload.Image,
+		Tty:   false,
+		Env:   workload.Env,
+	}, hostConfig, networkConfig, workload.Name)
 
 	if err != nil {
 		return err
 	}
 
-	if err := d.cli.ContainerStart(d.ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+	if err := d.cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
 		return err
 	}
 
@@ -275,7 +205,9 @@ func (d *Docker) PullAndStart(imageRef, createdContainerName string) error {
 	}
 
 	defer out.Close()
-	io.Copy(os.Stdout, out)
+	if err := readPull(out); err != nil {
+		return err
+	}
 
 	resp, err := d.cli.ContainerCreate(d.ctx, &container.Config{
 		Image: imageRef,
@@ -292,70 +224,7 @@ func (d *Docker) PullAndStart(imageRef, createdContainerName string) error {
 	return nil
 }
 
-func (d *Docker) PullAndRestart(imageRef, containerName string) error {
-	out, err := d.cli.ImagePull(d.ctx, imageRef, types.ImagePullOptions{RegistryAuth: d.authString})
-	if err != nil {
-		return err
-	}
-
-	defer out.Close()
-	io.Copy(os.Stdout, out)
-
-	timeout := time.Second * 30
-
-	if err := d.cli.ContainerRestart(d.ctx, containerName, &timeout); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (d *Docker) Inspect(containerID string) (*types.ContainerJSON, error) {
-	ctr, err := d.cli.ContainerInspect(d.ctx, containerID)
-	if err != nil {
-		log.Error().Str("module", "docker").Str("containerID", containerID).Err(err).Msg("error inspecting container")
-		return nil, err
-	}
-	return &ctr, nil
-}
-
-func (d *Docker) Logs(containerID string, tail string) ([]string, error) {
-	var (
-		bytes          = make([]byte, 3000) // Telegram message length limit
-		logs  []string = nil
-		err   error    = nil
-	)
-	if tail != "all" && !isNumber(tail) {
-		tail = "10"
-	}
-	logsReader, err := d.cli.ContainerLogs(d.ctx, containerID, types.ContainerLogsOptions{Tail: tail, ShowStderr: true, ShowStdout: true})
-	if err != nil {
-		log.Error().Str("module", "docker").Str("containerID", containerID).Err(err).Msg("error getting container logs")
-		return nil, err
-	}
-	defer func() {
-		err := logsReader.Close()
-		if err != nil {
-			log.Error().Str("module", "docker").Str("containerID", containerID).Err(err).Msg("error closing io.Reader")
-		}
-	}()
-
-	for {
-		numBytes, err := logsReader.Read(bytes)
-		logs = append(logs, string(bytes[:numBytes]))
-		if err == io.EOF {
-			break
-		}
-	}
-	return logs, nil
-}
-
 func (d *Docker) isValidID(containerID string) bool {
 	re := regexp.MustCompile(`(?m)^[A-Fa-f0-9]{10,12}$`)
 	return re.MatchString(containerID)
 }
-
-func isNumber(s string) bool {
-	_, err := strconv.Atoi(s)
-	return err == nil
-}
@@ -1,151 +1,526 @@
 package docker
 
 import (
-	"container/list"
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"fp-dynamic-elements-manager-controller/internal/db/persistence"
 	"fp-dynamic-elements-manager-controller/internal/docker/structs"
 	"fp-dynamic-elements-manager-controller/internal/docker/utils"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
+	"fp-dynamic-elements-manager-controller/internal/metrics"
 	"fp-dynamic-elements-manager-controller/internal/notification"
+	"fp-dynamic-elements-manager-controller/internal/orchestrator"
+	orchestratorstructs "fp-dynamic-elements-manager-controller/internal/orchestrator/structs"
+	"fp-dynamic-elements-manager-controller/internal/routing"
+	"fp-dynamic-elements-manager-controller/internal/secrets"
+	"fp-dynamic-elements-manager-controller/internal/tracing"
 	"github.com/docker/docker/api/types"
-	"github.com/rs/zerolog/log"
+	"github.com/lithammer/shortuuid"
+	"os"
 	"strings"
 	"sync"
+	"time"
 )
 
+var (
+	ErrJobNotFound  = errors.New("job not found")
+	ErrJobFinished  = errors.New("job has already finished")
+	ErrJobQueueFull = errors.New("too many jobs are queued")
+	// ErrDockerOnly is returned for the commands which need the docker orchestrator
+	ErrDockerOnly = errors.New("the command is only supported by the docker orchestrator")
+)
+
+const (
+	// jobQueueSize is the number of jobs which can wait to be run
+	jobQueueSize = 64
+	// startDelay holds back the notification that a module was started or created, modules are slow to start up
+	startDelay = time.Second
+)
+
+// jobRun is a job which is queued or running
+type jobRun struct {
+	job        structs.Job
+	containers []structs.ContainerDetails
+	ctx        context.Context
+	cancel     context.CancelFunc
+	done       chan struct{}
+}
+
+// CommandHandler runs docker commands as jobs. Each submission is stored as a job with a step per command, jobs are
+// run one at a time in the order they were submitted and the progress of each is sent as notifications
 type CommandHandler struct {
-	mu           *sync.Mutex
-	docker       Dockers
-	commandQueue *list.List
-	errCh        chan error
-	doneCh       chan struct{}
-	evtCh        chan notification.Event
-	repo         persistence.ModuleRepo
+	orch orchestrator.Orchestrator
+	// docker is set when the orchestrator is docker, it runs the commands which only docker supports
+	docker   Dockers
+	repo     persistence.ModuleRepo
+	routes   routing.RouteTable
+	jobs     persistence.JobRepo
+	ns       notification.Service
+	upgrader *Upgrader
+	verifier *ImageVerifier
+	profile  structs.RuntimeProfile
+	// secrets resolves the secrets referenced by env vars when a container is created
+	secrets    *secrets.Service
+	now        func() time.Time
+	startDelay time.Duration
+
+	mu        sync.Mutex
+	active    map[string]*jobRun
+	queue     chan *jobRun
+	startOnce sync.Once
 }
 
-func NewCommandHandler(d Dockers, mRepo persistence.ModuleRepo) *CommandHandler {
+func NewCommandHandler(orch orchestrator.Orchestrator,
+	mRepo persistence.ModuleRepo,
+	routes routing.RouteTable,
+	jobs persistence.JobRepo,
+	ns notification.Service,
+	upgrader *Upgrader,
+	verifier *ImageVerifier,
+	profile structs.RuntimeProfile,
+	secrets *secrets.Service) *CommandHandler {
+	docker, _ := orch.(Dockers)
 	return &CommandHandler{
-		docker:       d,
-		commandQueue: list.New(),
-		mu:           &sync.Mutex{},
-		errCh:        make(chan error),
-		doneCh:       make(chan struct{}),
-		evtCh:        make(chan notification.Event),
-		repo:         mRepo,
+		orch:       orch,
+		docker:     docker,
+		repo:       mRepo,
+		routes:     routes,
+		jobs:       jobs,
+		ns:         ns,
+		upgrader:   upgrader,
+		verifier:   verifier,
+		profile:    profile,
+		secrets:    secrets,
+		now:        time.Now,
+		startDelay: startDelay,
+		active:     make(map[string]*jobRun),
+		queue:      make(chan *jobRun, jobQueueSize),
 	}
 }
 
-func (c *CommandHandler) RunCommands(details structs.ContainerDetailsWrapper) (<-chan struct{}, <-chan notification.Event, <-chan error) {
-	// Add commands to the queue
-	for _, container := range details.Containers {
+// Submit stores a job for the commands and queues it to be run, the returned job holds the ID used to follow it.
+// Commands which cannot be run, e.g. a create from an image outside the module registry, are skipped
+func (c *CommandHandler) Submit(ctx context.Context, details structs.ContainerDetailsWrapper) (structs.Job, error) {
+	job := structs.Job{
+		ID:        shortuuid.New(),
+		CreatedAt: c.now(),
+		Status:    structs.JobQueued,
+		RequestID: applog.RequestID(ctx),
+		Steps:     []structs.JobStep{},
+	}
 
-		if container.Command == structs.Create {
-			// Make sure the image that is trying to be used is coming from our own registry
-			if !utils.ValidImageRef(container.ImageRef) {
-				continue
-			}
+	containers := make([]structs.ContainerDetails, len(details.Containers))
+	for i, container := range details.Containers {
+		step := structs.JobStep{
+			JobID:     job.ID,
+			Position:  i,
+			Command:   container.Command,
+			Container: container.ID,
+			ImageRef:  container.ImageRef,
+			Status:    structs.JobQueued,
 		}
 
-		if err := enrichContainerStruct(&container, c.docker); err != nil {
-			log.Error().Err(err)
-			continue
+		// Make sure the image that is trying to be used is coming from our own registry
+		if container.Command == structs.Create && !utils.ValidImageRef(container.ImageRef) {
+			step.Status, step.Error = structs.JobSkipped, "image is not from the module registry"
+		} else if err := c.resolveRuntime(&container); err != nil {
+			step.Status, step.Error = structs.JobSkipped, err.Error()
+		} else if err := enrichContainerStruct(ctx, &container, c.orch); err != nil {
+			dockerLog(applog.Fields{"container": container.Name}).Error(err, "error reading container details, skipping command")
+			step.Status, step.Error = structs.JobSkipped, err.Error()
 		}
 
-		c.commandQueue.PushBack(container)
+		containers[i] = container
+		job.Steps = append(job.Steps, step)
+	}
+
+	if err := c.jobs.InsertJob(job); err != nil {
+		return job, err
 	}
 
-	// Start processing the commands
-	go c.run()
+	runCtx, cancel := context.WithCancel(tracing.Detach(ctx))
+	run := &jobRun{job: job, containers: containers, ctx: runCtx, cancel: cancel, done: make(chan struct{})}
 
-	return c.doneCh, c.evtCh, c.errCh
+	c.mu.Lock()
+	c.active[job.ID] = run
+	c.mu.Unlock()
+	c.startOnce.Do(func() { go c.worker() })
+
+	select {
+	case c.queue <- run:
+	default:
+		c.finish(run, structs.JobFailed, ErrJobQueueFull.Error())
+		return c.snapshot(run), ErrJobQueueFull
+	}
+
+	return job, nil
 }
 
-func (c *CommandHandler) List() []types.Container {
-	return c.docker.ListContainers(types.ContainerListOptions{})
+// Job returns a job and the status of its steps
+func (c *CommandHandler) Job(id string) (structs.Job, error) {
+	c.mu.Lock()
+	run, ok := c.active[id]
+	c.mu.Unlock()
+	if ok {
+		return c.snapshot(run), nil
+	}
+	return c.storedJob(id)
 }
 
-func (c *CommandHandler) MapContainerNames() structs.ContainerNames {
-	containers := c.List()
+// Wait returns the job once it has finished, or an error if ctx is done first
+func (c *CommandHandler) Wait(ctx context.Context, id string) (structs.Job, error) {
+	c.mu.Lock()
+	run, ok := c.active[id]
+	c.mu.Unlock()
+	if !ok {
+		return c.storedJob(id)
+	}
 
-	var names []string
-	for _, con := range containers {
-		names = append(names, con.Names[0])
+	select {
+	case <-run.done:
+		return c.snapshot(run), nil
+	case <-ctx.Done():
+		return c.snapshot(run), ctx.Err()
 	}
+}
 
-	return structs.ContainerNames{Containers: names}
+// Cancel stops a job, a queued job never runs and a running job stops once its current step has finished
+func (c *CommandHandler) Cancel(id string) (structs.Job, error) {
+	c.mu.Lock()
+	run, ok := c.active[id]
+	queued := ok && run.job.Status == structs.JobQueued
+	if queued {
+		// Claim the job so the worker skips it
+		run.job.Status = structs.JobCancelled
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		job, err := c.storedJob(id)
+		if err != nil {
+			return job, err
+		}
+		return job, ErrJobFinished
+	}
+
+	run.cancel()
+	if queued {
+		c.finish(run, structs.JobCancelled, "")
+	}
+	return c.snapshot(run), nil
 }
 
-func (c *CommandHandler) run() {
-	if c.commandQueue.Len() < 1 {
-		c.doneCh <- struct{}{}
-		return
+// FailInterruptedJobs fails the jobs which were queued or running when the controller last stopped
+func (c *CommandHandler) FailInterruptedJobs() {
+	if err := c.jobs.FailUnfinishedJobs("the controller restarted before the job finished", c.now()); err != nil {
+		dockerLog(nil).Error(err, "error failing interrupted docker jobs")
 	}
+}
 
-	// Create a lock here to avoid weird race conditions if new commands are pushed before old ones are done
+func (c *CommandHandler) storedJob(id string) (structs.Job, error) {
+	job, err := c.jobs.GetJob(id)
+	if err == sql.ErrNoRows {
+		return job, ErrJobNotFound
+	}
+	return job, err
+}
+
+// snapshot returns a copy of the job which is safe to read while the job runs
+func (c *CommandHandler) snapshot(run *jobRun) structs.Job {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	job := run.job
+	job.Steps = append([]structs.JobStep{}, run.job.Steps...)
+	return job
+}
+
+func (c *CommandHandler) worker() {
+	for run := range c.queue {
+		c.run(run)
+	}
+}
 
-	// Pull from the queue and process the command
-	for c.commandQueue.Len() > 0 {
-		element := c.commandQueue.Front()
-		casted := element.Value.(structs.ContainerDetails)
-		err := c.processCommand(casted)
-		c.commandQueue.Remove(element)
+func (c *CommandHandler) run(run *jobRun) {
+	logger := dockerLog(applog.Fields{"job": run.job.ID}).WithContext(run.ctx)
 
-		if err == nil {
-			c.evtCh <- notification.Event{
-				EventType: notification.Success,
-				Value:     fmt.Sprintf("%s module %s", strings.Title(string(casted.Command.CommandToState())), casted.Name),
-				Context: notification.EventContext{
-					Type:       notification.Module,
-					Identifier: casted.ID,
-					State:      casted.Command.CommandToState(),
-				},
+	c.mu.Lock()
+	if run.job.Status.Finished() {
+		// The job was cancelled while it was queued
+		c.mu.Unlock()
+		return
+	}
+	now := c.now()
+	run.job.Status, run.job.StartedAt = structs.JobRunning, &now
+	job := run.job
+	c.mu.Unlock()
+	c.jobs.UpdateJob(job)
+
+	var failure string
+	for i := range run.job.Steps {
+		c.mu.Lock()
+		step := &run.job.Steps[i]
+		switch {
+		case step.Status == structs.JobSkipped:
+			c.mu.Unlock()
+			c.sendEvent(run, notification.Event{
+				EventType: notification.Warning,
+				Value:     fmt.Sprintf("Skipped %s of %s: %s", step.Command, step.Container, step.Error),
+			})
+			continue
+		case run.ctx.Err() != nil || failure != "":
+			// Steps after a failure are skipped and steps after a cancel are cancelled
+			now := c.now()
+			step.Status, step.FinishedAt = structs.JobSkipped, &now
+			if run.ctx.Err() != nil {
+				step.Status = structs.JobCancelled
+			}
+			update := *step
+			c.mu.Unlock()
+			c.jobs.UpdateJobStep(update)
+			continue
+		}
+		now := c.now()
+		step.Status, step.StartedAt = structs.JobRunning, &now
+		update := *step
+		c.mu.Unlock()
+		c.jobs.UpdateJobStep(update)
+
+		container := run.containers[i]
+		digest, err := c.processCommand(run.ctx, container)
+
+		c.mu.Lock()
+		now = c.now()
+		step.Status, step.FinishedAt, step.Digest = structs.JobSucceeded, &now, digest
+		if err != nil {
+			step.Status, step.Error = structs.JobFailed, err.Error()
+			failure = fmt.Sprintf("%s of %s failed: %v", step.Command, step.Container, err)
+		}
+		update = *step
+		c.mu.Unlock()
+		c.jobs.UpdateJobStep(update)
+
+		if err != nil {
+			logger.Error(err, "error running docker command")
+			event := notification.Event{EventType: notification.Error, Value: err.Error()}
+			var refused *ImageRefusedError
+			if errors.As(err, &refused) {
+				event.Context = notification.EventContext{Type: notification.Module, Identifier: container.ID, State: notification.ImageRefused}
 			}
+			c.sendEvent(run, event)
+			continue
+		}
+
+		state := container.Command.CommandToState()
+		// Hold back the notification for modules which are slow to start up
+		if state == notification.Started || state == notification.Created {
+			time.Sleep(c.startDelay)
 		}
+		c.sendEvent(run, notification.Event{
+			EventType: notification.Success,
+			Value:     fmt.Sprintf("%s module %s", strings.Title(string(state)), container.Name),
+			Context: notification.EventContext{
+				Type:       notification.Module,
+				Identifier: container.ID,
+				State:      state,
+			},
+		})
 	}
 
-	c.doneCh <- struct{}{}
+	switch {
+	case failure != "":
+		c.finish(run, structs.JobFailed, failure)
+	case run.ctx.Err() != nil:
+		c.finish(run, structs.JobCancelled, "")
+	default:
+		c.finish(run, structs.JobSucceeded, "")
+	}
+	logger.Info(fmt.Sprintf("docker job %s", c.snapshot(run).Status))
+}
+
+// finish records the final status of a job, steps which have not run are cancelled, and notifies the client
+func (c *CommandHandler) finish(run *jobRun, status structs.JobStatus, msg string) {
+	c.mu.Lock()
+	now := c.now()
+	run.job.Status, run.job.Error, run.job.FinishedAt = status, msg, &now
+	var steps []structs.JobStep
+	for i := range run.job.Steps {
+		if step := &run.job.Steps[i]; !step.Status.Finished() {
+			step.Status, step.FinishedAt = structs.JobCancelled, &now
+			steps = append(steps, *step)
+		}
+	}
+	job := run.job
+	delete(c.active, job.ID)
+	c.mu.Unlock()
+
+	for _, step := range steps {
+		c.jobs.UpdateJobStep(step)
+	}
+	c.jobs.UpdateJob(job)
+	run.cancel()
+	close(run.done)
+
+	eventType := notification.Success
+	switch status {
+	case structs.JobFailed:
+		eventType = notification.Error
+	case structs.JobCancelled:
+		eventType = notification.Warning
+	}
+	value := fmt.Sprintf("Docker job %s", status)
+	if msg != "" {
+		value = fmt.Sprintf("%s: %s", value, msg)
+	}
+	c.sendEvent(run, notification.Event{
+		EventType: eventType,
+		Value:     value,
+		Context: notification.EventContext{
+			Type:       notification.Job,
+			Identifier: job.ID,
+			State:      notification.State(status),
+		},
+	})
 }
 
-func (c *CommandHandler) processCommand(container structs.ContainerDetails) error {
+func (c *CommandHandler) sendEvent(run *jobRun, event notification.Event) {
+	event = event.WithContext(run.ctx)
+	event.JobID = run.job.ID
+	c.ns.Send(event)
+}
+
+// List returns the docker containers, there are none with other orchestrators
+func (c *CommandHandler) List() []types.Container {
+	if c.docker == nil {
+		return []types.Container{}
+	}
+	return c.docker.ListContainers(types.ContainerListOptions{})
+}
+
+// Logs streams the container's log lines with the value of every secret redacted
+func (c *CommandHandler) Logs(ctx context.Context, containerID string, opts structs.LogOptions, out func(structs.LogLine) error) error {
+	return c.orch.Logs(ctx, containerID, opts, func(line structs.LogLine) error {
+		line.Line = c.secrets.Redact(line.Line)
+		return out(line)
+	})
+}
+
+// Inspect returns the container's details, env vars holding a secret resolved when the container was created are redacted
+func (c *CommandHandler) Inspect(ctx context.Context, containerID string) (structs.ContainerInspect, error) {
+	inspect, err := c.orch.Inspect(ctx, containerID)
+	if err != nil {
+		return inspect, err
+	}
+	inspect.Env = c.secrets.RedactEnv(inspect.Env)
+	return inspect, nil
+}
+
+func (c *CommandHandler) MapContainerNames() structs.ContainerNames {
+	containers := c.List()
+
+	var names []string
+	for _, con := range containers {
+		names = append(names, con.Names[0])
+	}
+
+	return structs.ContainerNames{Containers: names}
+}
+
+// processCommand runs a single docker command, for commands which pull an image the digest it was pinned to is returned
+func (c *CommandHandler) processCommand(ctx context.Context, container structs.ContainerDetails) (string, error) {
+	var digest string
 	var err error
 	switch container.Command {
 	case structs.PullAndStart:
-		err = c.docker.PullAndStart(container.ImageRef, container.ID)
+		if c.docker == nil {
+			err = ErrDockerOnly
+		} else if digest, err = c.pin(ctx, container.ImageRef); err == nil {
+			err = c.docker.PullAndStart(digest, container.ID)
+		}
 	case structs.PullAndRestart:
-		err = c.docker.PullAndRestart(container.ImageRef, container.ID)
+		var upgrade structs.Upgrade
+		upgrade, err = c.upgrader.Upgrade(ctx, container.ID, container.ImageRef)
+		digest = upgrade.ToDigest
 	case structs.Create:
-		err = c.docker.Create(container.ImageRef, container.ID, container.Network, container.Volumes, container.EnvVars)
+		// Secrets are only resolved here, the env vars held by the job keep their references
+		var env []string
+		if env, err = c.secrets.ResolveEnv(container.EnvVars); err != nil {
+			break
+		}
+		if digest, err = c.pin(ctx, container.ImageRef); err == nil {
+			err = c.orch.Create(ctx, orchestratorstructs.Workload{
+				Name:    container.ID,
+				Image:   digest,
+				Network: container.Network,
+				Volumes: container.Volumes,
+				Env:     env,
+				Runtime: *container.Runtime,
+			})
+		}
 	case structs.Stop:
-		err = c.docker.Stop(container.ID)
+		err = c.orch.Stop(ctx, container.ID)
 	case structs.Start:
-		err = c.docker.Start(container.ID)
+		err = c.orch.Start(ctx, container.ID)
 	case structs.Restart:
-		err = c.docker.Restart(container.ID)
+		err = c.orch.Restart(ctx, container.ID)
 	case structs.Remove:
-		err = c.docker.Remove(container.ID)
+		err = c.orch.Remove(ctx, container.ID)
 		if err == nil {
 			err = c.deleteFromControllerDB(container.ID)
 		}
+	default:
+		return "", nil
 	}
 
+	metrics.DockerCommands.WithLabelValues(string(container.Command), metrics.Result(err)).Inc()
+
+	return digest, err
+}
+
+// pin resolves the image and returns the digest its tag resolved to, once the digest's signature has been verified
+func (c *CommandHandler) pin(ctx context.Context, imageRef string) (string, error) {
+	digest, err := c.orch.ResolveImage(ctx, imageRef)
 	if err != nil {
-		c.errCh <- err
+		return "", err
 	}
-
-	return err
+	return c.verifier.Pin(ctx, imageRef, digest)
 }
 
 func (c *CommandHandler) deleteFromControllerDB(svcName string) error {
-	return c.repo.DeleteByServiceName(svcName)
+	if err := c.repo.DeleteByServiceName(svcName); err != nil {
+		return err
+	}
+	// Drop the proxy routes for the module so nothing is left pointing at the removed container
+	c.routes.Remove(svcName)
+	return nil
+}
+
+// resolveRuntime sets the runtime profile a container is created with, the module's overrides on top of the default
+// profile, and checks it and the container's volumes are allowed
+func (c *CommandHandler) resolveRuntime(container *structs.ContainerDetails) error {
+	if container.Command != structs.Create {
+		return nil
+	}
+	profile := c.profile.Merge(container.Runtime)
+	if err := ValidateRuntimeProfile(profile); err != nil {
+		return err
+	}
+	if err := ValidateVolumes(container.ID, container.Volumes); err != nil {
+		return err
+	}
+	container.Runtime = &profile
+	return nil
 }
 
-func enrichContainerStruct(container *structs.ContainerDetails, docker Dockers) error {
+func enrichContainerStruct(ctx context.Context, container *structs.ContainerDetails, orch orchestrator.Orchestrator) error {
 	utils.BuildModuleEnvVars(&container.EnvVars)
+	// the module verifies the identity headers on requests from the controller with a key only it and the controller hold
+	container.EnvVars = append(container.EnvVars, fmt.Sprintf("%s=%s", routing.ModuleSigningKeyEnv,
+		routing.ModuleSigningKey(os.Getenv("PROXY_SIGNING_KEY"), container.ID)))
 	utils.AddModuleBindPaths(&container.Volumes, container.ID)
 
-	network, err := utils.AddModuleNetwork(docker.ListNetworks())
+	network, err := orch.ModuleNetwork(ctx)
 
 	if err != nil {
 		return err
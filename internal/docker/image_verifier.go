@@ -0,0 +1,232 @@
+package docker
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type VerifyConfig struct {
+	// TrustedKeysDir holds the PEM encoded public keys which sign module images, signatures are only verified when
+	// there is at least one key
+	TrustedKeysDir string
+	// SignatureURL is where the detached signature of an image is fetched from, {repository} and {digest} are
+	// replaced with the image's repository and digest, e.g. https://signatures.example.com/{repository}/{digest}.sig
+	SignatureURL string
+	// Timeout is the time allowed to fetch a signature
+	Timeout time.Duration
+}
+
+// VerifyConfigFromEnv builds the image verification config from the environment
+func VerifyConfigFromEnv() VerifyConfig {
+	cfg := VerifyConfig{
+		TrustedKeysDir: os.Getenv("IMAGE_TRUSTED_KEYS_DIR"),
+		SignatureURL:   os.Getenv("IMAGE_SIGNATURE_URL"),
+		Timeout:        10 * time.Second,
+	}
+
+	if d, err := time.ParseDuration(os.Getenv("IMAGE_SIGNATURE_TIMEOUT")); err == nil && d > 0 {
+		cfg.Timeout = d
+	}
+
+	return cfg
+}
+
+// ImageRefusedError is returned when an image cannot be used because it is unsigned or its signature does not match
+type ImageRefusedError struct {
+	ImageRef string
+	Reason   string
+}
+
+func (e *ImageRefusedError) Error() string {
+	return fmt.Sprintf("refused image %s: %s", e.ImageRef, e.Reason)
+}
+
+// ImageVerifier pins module images to the digest their tag resolved to and, when trusted keys are configured, checks
+// that the digest has a detached signature from one of the keys. A nil verifier pins without checking signatures
+type ImageVerifier struct {
+	cfg    VerifyConfig
+	keys   []crypto.PublicKey
+	client *http.Client
+}
+
+// NewImageVerifier loads the trusted keys, it fails if a key cannot be read or there are keys but no signature URL
+func NewImageVerifier(cfg VerifyConfig) (*ImageVerifier, error) {
+	v := &ImageVerifier{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}
+	if cfg.TrustedKeysDir == "" {
+		return v, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(cfg.TrustedKeysDir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := parsePublicKeys(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		v.keys = append(v.keys, keys...)
+	}
+
+	if len(v.keys) > 0 && cfg.SignatureURL == "" {
+		return nil, errors.New("trusted image keys are configured without IMAGE_SIGNATURE_URL")
+	}
+	return v, nil
+}
+
+// Enabled is true when image signatures are verified
+func (v *ImageVerifier) Enabled() bool {
+	return v != nil && len(v.keys) > 0
+}
+
+// Pin returns the image reference to use for imageRef, which is the digest it resolved to once the digest's signature
+// is verified. An image without a digest, e.g. one built locally, can only be used unpinned when signatures are not
+// verified
+func (v *ImageVerifier) Pin(ctx context.Context, imageRef, digest string) (string, error) {
+	if digest == "" {
+		if v.Enabled() {
+			return "", &ImageRefusedError{ImageRef: imageRef, Reason: "the image has no digest so its signature cannot be checked"}
+		}
+		return imageRef, nil
+	}
+	if !v.Enabled() {
+		return digest, nil
+	}
+
+	payload := signedName(digest)
+	signatures, err := v.fetchSignatures(ctx, payload)
+	if err != nil {
+		return "", &ImageRefusedError{ImageRef: imageRef, Reason: err.Error()}
+	}
+	for _, sig := range signatures {
+		for _, key := range v.keys {
+			if verifySignature(key, []byte(payload), sig) {
+				return digest, nil
+			}
+		}
+	}
+	return "", &ImageRefusedError{ImageRef: imageRef, Reason: fmt.Sprintf("the signature of %s does not match a trusted key", payload)}
+}
+
+// fetchSignatures reads the base64 encoded signatures of the image, one per line
+func (v *ImageVerifier) fetchSignatures(ctx context.Context, name string) ([][]byte, error) {
+	i := strings.Index(name, "@")
+	if i < 0 {
+		return nil, fmt.Errorf("%s is not a digest reference", name)
+	}
+	url := strings.NewReplacer("{repository}", name[:i], "{digest}", name[i+1:]).Replace(v.cfg.SignatureURL)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("the signature could not be fetched: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errors.New("the image is not signed")
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("the signature could not be fetched, the server returned %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	var signatures [][]byte
+	for _, line := range strings.Fields(string(body)) {
+		if sig, err := base64.StdEncoding.DecodeString(line); err == nil {
+			signatures = append(signatures, sig)
+		}
+	}
+	if len(signatures) == 0 {
+		return nil, errors.New("the image is not signed")
+	}
+	return signatures, nil
+}
+
+// signedName is the name of the image which is signed, the digest reference without the registry host so the same
+// signature holds for any mirror of the registry, e.g. fp-dim/fp-ngfw@sha256:...
+func signedName(digest string) string {
+	if i := strings.Index(digest, "/"); i > 0 {
+		host := digest[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			return digest[i+1:]
+		}
+	}
+	return digest
+}
+
+func parsePublicKeys(data []byte) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch key.(type) {
+		case ed25519.PublicKey, *ecdsa.PublicKey, *rsa.PublicKey:
+			keys = append(keys, key)
+		default:
+			return nil, fmt.Errorf("unsupported public key type %T", key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no public keys found")
+	}
+	return keys, nil
+}
+
+// verifySignature checks an Ed25519 signature of the payload, or an ECDSA or RSA PKCS #1 v1.5 signature of its SHA-256
+func verifySignature(key crypto.PublicKey, payload, sig []byte) bool {
+	hash := sha256.Sum256(payload)
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, payload, sig)
+	case *ecdsa.PublicKey:
+		var esig struct {
+			R, S *big.Int
+		}
+		if rest, err := asn1.Unmarshal(sig, &esig); err != nil || len(rest) != 0 {
+			return false
+		}
+		return ecdsa.Verify(k, hash[:], esig.R, esig.S)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, hash[:], sig) == nil
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+package docker
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fp-dynamic-elements-manager-controller/internal/docker/mocks"
+	"fp-dynamic-elements-manager-controller/internal/docker/structs"
+	"fp-dynamic-elements-manager-controller/internal/notification"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type ImageVerifierTestSuite struct {
+	suite.Suite
+	keysDir    string
+	edKey      ed25519.PrivateKey
+	ecKey      *ecdsa.PrivateKey
+	signatures map[string]string
+	server     *httptest.Server
+	cfg        VerifyConfig
+}
+
+func TestImageVerifier(t *testing.T) {
+	suite.Run(t, new(ImageVerifierTestSuite))
+}
+
+func (v *ImageVerifierTestSuite) SetupTest() {
+	var err error
+	v.keysDir, err = ioutil.TempDir("", "trusted-keys")
+	v.Require().Nil(err)
+
+	var edPublic ed25519.PublicKey
+	edPublic, v.edKey, err = ed25519.GenerateKey(rand.Reader)
+	v.Require().Nil(err)
+	v.ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	v.Require().Nil(err)
+	v.writeKey("ed25519.pem", edPublic)
+	v.writeKey("ecdsa.pem", &v.ecKey.PublicKey)
+
+	// Signatures are served by repository and digest, like the signature store of the registry
+	v.signatures = map[string]string{}
+	v.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sig, ok := v.signatures[strings.TrimPrefix(r.URL.Path, "/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(sig))
+	}))
+
+	v.cfg = VerifyConfig{
+		TrustedKeysDir: v.keysDir,
+		SignatureURL:   v.server.URL + "/{repository}/{digest}.sig",
+		Timeout:        5 * time.Second,
+	}
+}
+
+func (v *ImageVerifierTestSuite) TearDownTest() {
+	v.server.Close()
+	os.RemoveAll(v.keysDir)
+}
+
+func (v *ImageVerifierTestSuite) writeKey(name string, key interface{}) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	v.Require().Nil(err)
+	data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	v.Require().Nil(ioutil.WriteFile(filepath.Join(v.keysDir, name), data, 0600))
+}
+
+// sign stores signatures of the digest, served from the path the verifier will ask for
+func (v *ImageVerifierTestSuite) sign(digest string, signatures ...[]byte) {
+	name := signedName(digest)
+	var lines []string
+	for _, sig := range signatures {
+		lines = append(lines, base64.StdEncoding.EncodeToString(sig))
+	}
+	v.signatures[strings.Replace(name, "@", "/", 1)+".sig"] = strings.Join(lines, "\n")
+}
+
+func (v *ImageVerifierTestSuite) TestPin() {
+	verifier, err := NewImageVerifier(v.cfg)
+	v.Require().Nil(err)
+	v.True(verifier.Enabled())
+
+	ctx := context.Background()
+	imageRef := "docker.frcpnt.com/fp-dim/fp-ngfw:latest"
+
+	v.T().Run("Test an image signed with a trusted ed25519 key is pinned to its digest", func(t *testing.T) {
+		digest := "docker.frcpnt.com/fp-dim/fp-ngfw@sha256:ed"
+		v.sign(digest, ed25519.Sign(v.edKey, []byte("fp-dim/fp-ngfw@sha256:ed")))
+
+		pinned, err := verifier.Pin(ctx, imageRef, digest)
+		assert.Nil(t, err)
+		assert.Equal(t, digest, pinned)
+	})
+
+	v.T().Run("Test any of several signatures can match a trusted ECDSA key", func(t *testing.T) {
+		digest := "docker.frcpnt.com/fp-dim/fp-ngfw@sha256:ec"
+		hash := sha256.Sum256([]byte("fp-dim/fp-ngfw@sha256:ec"))
+		r, s, err := ecdsa.Sign(rand.Reader, v.ecKey, hash[:])
+		assert.Nil(t, err)
+		sig, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+		assert.Nil(t, err)
+		v.sign(digest, []byte("not a signature"), sig)
+
+		pinned, err := verifier.Pin(ctx, imageRef, digest)
+		assert.Nil(t, err)
+		assert.Equal(t, digest, pinned)
+	})
+
+	v.T().Run("Test images which are unsigned or signed by another key are refused", func(t *testing.T) {
+		_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+		signed := "docker.frcpnt.com/fp-dim/fp-ngfw@sha256:other"
+		v.sign(signed, ed25519.Sign(otherKey, []byte("fp-dim/fp-ngfw@sha256:other")))
+
+		for _, digest := range []string{signed, "docker.frcpnt.com/fp-dim/fp-ngfw@sha256:unsigned", ""} {
+			_, err := verifier.Pin(ctx, imageRef, digest)
+			var refused *ImageRefusedError
+			assert.True(t, errors.As(err, &refused), digest)
+			assert.Equal(t, imageRef, refused.ImageRef)
+		}
+	})
+}
+
+func (v *ImageVerifierTestSuite) TestPin_NotEnabled() {
+	verifier, err := NewImageVerifier(VerifyConfig{})
+	v.Nil(err)
+	v.False(verifier.Enabled())
+	v.False((*ImageVerifier)(nil).Enabled())
+
+	pinned, err := verifier.Pin(context.Background(), "fp-ngfw:latest", "fp-ngfw@sha256:abc")
+	v.Nil(err)
+	v.Equal("fp-ngfw@sha256:abc", pinned)
+
+	pinned, err = (*ImageVerifier)(nil).Pin(context.Background(), "fp-ngfw:latest", "")
+	v.Nil(err)
+	v.Equal("fp-ngfw:latest", pinned)
+}
+
+func (v *ImageVerifierTestSuite) TestNewImageVerifier_Invalid() {
+	v.cfg.SignatureURL = ""
+	_, err := NewImageVerifier(v.cfg)
+	v.NotNil(err)
+
+	v.Require().Nil(ioutil.WriteFile(filepath.Join(v.keysDir, "broken.pem"), []byte("not a key"), 0600))
+	v.cfg.SignatureURL = v.server.URL
+	_, err = NewImageVerifier(v.cfg)
+	v.NotNil(err)
+}
+
+func (v *ImageVerifierTestSuite) TestSignedName() {
+	v.Equal("fp-dim/fp-ngfw@sha256:abc", signedName("docker.frcpnt.com/fp-dim/fp-ngfw@sha256:abc"))
+	v.Equal("fp-dim/fp-ngfw@sha256:abc", signedName("localhost:5000/fp-dim/fp-ngfw@sha256:abc"))
+	v.Equal("fp-dim/fp-ngfw@sha256:abc", signedName("fp-dim/fp-ngfw@sha256:abc"))
+}
+
+func (v *ImageVerifierTestSuite) TestCommandHandler_ImageRefused() {
+	verifier, err := NewImageVerifier(v.cfg)
+	v.Require().Nil(err)
+
+	docker := new(mocks.TestDocker)
+	docker.On("ModuleNetwork", mock.Anything).Return("module_net", nil)
+	docker.On("ResolveImage", mock.Anything, testContainer.ImageRef).Return(testDigest, nil)
+
+	handler, _, ns := newTestHandler(docker, new(mocks.MockModuleMetadataRepo), new(mocks.MockRouteTable))
+	handler.verifier = verifier
+
+	create := testContainer
+	create.Command = structs.Create
+	job, err := handler.Submit(context.Background(), structs.ContainerDetailsWrapper{Containers: []structs.ContainerDetails{create}})
+	v.Require().Nil(err)
+	job, err = handler.Wait(context.Background(), job.ID)
+	v.Nil(err)
+
+	v.Equal(structs.JobFailed, job.Status)
+	v.Contains(job.Steps[0].Error, "the image is not signed")
+	docker.AssertNotCalled(v.T(), "Create", mock.Anything, mock.Anything)
+	ns.AssertCalled(v.T(), "Send", mock.MatchedBy(func(e notification.Event) bool {
+		return e.EventType == notification.Error && e.Context.State == notification.ImageRefused && e.Context.Identifier == create.ID
+	}))
+}
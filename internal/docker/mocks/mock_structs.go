@@ -1,8 +1,15 @@
 package mocks
 
 import (
+	"context"
+	dockerstructs "fp-dynamic-elements-manager-controller/internal/docker/structs"
+	"fp-dynamic-elements-manager-controller/internal/modules/structs"
+	"fp-dynamic-elements-manager-controller/internal/notification"
+	orchestratorstructs "fp-dynamic-elements-manager-controller/internal/orchestrator/structs"
 	"github.com/docker/docker/api/types"
 	"github.com/stretchr/testify/mock"
+	"io"
+	"time"
 )
 
 type MockModuleMetadataRepo struct {
@@ -14,6 +21,107 @@ func (r *MockModuleMetadataRepo) DeleteByServiceName(svcName string) error {
 	return args.Error(0)
 }
 
+type MockModuleListRepo struct {
+	mock.Mock
+}
+
+func (r *MockModuleListRepo) GetAll() ([]structs.ModuleMetadata, error) {
+	args := r.Called()
+	return args.Get(0).([]structs.ModuleMetadata), args.Error(1)
+}
+
+type MockJobRepo struct {
+	mock.Mock
+}
+
+func (r *MockJobRepo) InsertJob(job dockerstructs.Job) error {
+	args := r.Called(job)
+	return args.Error(0)
+}
+
+func (r *MockJobRepo) UpdateJob(job dockerstructs.Job) error {
+	args := r.Called(job)
+	return args.Error(0)
+}
+
+func (r *MockJobRepo) UpdateJobStep(step dockerstructs.JobStep) error {
+	args := r.Called(step)
+	return args.Error(0)
+}
+
+func (r *MockJobRepo) GetJob(id string) (dockerstructs.Job, error) {
+	args := r.Called(id)
+	return args.Get(0).(dockerstructs.Job), args.Error(1)
+}
+
+func (r *MockJobRepo) FailUnfinishedJobs(reason string, at time.Time) error {
+	args := r.Called(reason, at)
+	return args.Error(0)
+}
+
+type MockModuleLookupRepo struct {
+	mock.Mock
+}
+
+func (r *MockModuleLookupRepo) GetByServiceName(svcName string) (structs.ModuleMetadata, error) {
+	args := r.Called(svcName)
+	return args.Get(0).(structs.ModuleMetadata), args.Error(1)
+}
+
+func (r *MockModuleLookupRepo) GetByInboundRoute(route string) (structs.ModuleMetadata, error) {
+	args := r.Called(route)
+	return args.Get(0).(structs.ModuleMetadata), args.Error(1)
+}
+
+type MockUpgradeRepo struct {
+	mock.Mock
+}
+
+func (r *MockUpgradeRepo) InsertUpgrade(upgrade dockerstructs.Upgrade) (int64, error) {
+	args := r.Called(upgrade)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (r *MockUpgradeRepo) UpdateUpgrade(upgrade dockerstructs.Upgrade) error {
+	args := r.Called(upgrade)
+	return args.Error(0)
+}
+
+func (r *MockUpgradeRepo) FailUnfinishedUpgrades(reason string, at time.Time) error {
+	args := r.Called(reason, at)
+	return args.Error(0)
+}
+
+type NSMock struct {
+	mock.Mock
+}
+
+func (n *NSMock) Receive() {
+	n.Called()
+}
+
+func (n *NSMock) Send(event notification.Event) {
+	n.Called(event)
+}
+
+func (n *NSMock) Hub() *notification.Hub {
+	n.Called()
+	return nil
+}
+
+type MockRouteTable struct {
+	mock.Mock
+}
+
+func (r *MockRouteTable) Upsert(module structs.ModuleMetadata) {
+	r.Called(module)
+}
+
+func (r *MockRouteTable) Remove(svcName string) bool {
+	args := r.Called(svcName)
+	return args.Bool(0)
+}
+
 type TestDocker struct {
 	mock.Mock
 }
@@ -23,28 +131,43 @@ func (t *TestDocker) PullAndStart(ref, id string) error {
 	return args.Error(0)
 }
 
-func (t *TestDocker) Create(imageRef, containerName, containerNetwork string, volumes []string, envVars []string) error {
-	args := t.Called(imageRef, containerName, containerNetwork, volumes, envVars)
+func (t *TestDocker) ModuleNetwork(ctx context.Context) (string, error) {
+	args := t.Called(ctx)
+	return args.String(0), args.Error(1)
+}
+
+func (t *TestDocker) ResolveImage(ctx context.Context, ref string) (string, error) {
+	args := t.Called(ctx, ref)
+	return args.String(0), args.Error(1)
+}
+
+func (t *TestDocker) Create(ctx context.Context, workload orchestratorstructs.Workload) error {
+	args := t.Called(ctx, workload)
 	return args.Error(0)
 }
 
-func (t *TestDocker) Start(id string) error {
-	args := t.Called(id)
+func (t *TestDocker) Start(ctx context.Context, id string) error {
+	args := t.Called(ctx, id)
 	return args.Error(0)
 }
 
-func (t *TestDocker) Stop(id string) error {
-	args := t.Called(id)
+func (t *TestDocker) Stop(ctx context.Context, id string) error {
+	args := t.Called(ctx, id)
 	return args.Error(0)
 }
 
-func (t *TestDocker) Restart(id string) error {
-	args := t.Called(id)
+func (t *TestDocker) Restart(ctx context.Context, id string) error {
+	args := t.Called(ctx, id)
 	return args.Error(0)
 }
 
-func (t *TestDocker) Remove(id string) error {
-	args := t.Called(id)
+func (t *TestDocker) Remove(ctx context.Context, id string) error {
+	args := t.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (t *TestDocker) Exec(ctx context.Context, service string, cmd []string, stdin io.Reader, stdout io.Writer) error {
+	args := t.Called(ctx, service, cmd)
 	return args.Error(0)
 }
 
@@ -58,11 +181,32 @@ func (t *TestDocker) ListContainers(opts types.ContainerListOptions) []types.Con
 	return args.Get(0).([]types.Container)
 }
 
-func (t *TestDocker) RunDatabaseDump() error {
-	args := t.Called()
+func (t *TestDocker) Logs(ctx context.Context, id string, opts dockerstructs.LogOptions, out func(dockerstructs.LogLine) error) error {
+	args := t.Called(ctx, id, opts)
 	return args.Error(0)
 }
 
-func (t *TestDocker) RunDatabaseRestore() {
-	t.Called()
+func (t *TestDocker) Inspect(ctx context.Context, id string) (dockerstructs.ContainerInspect, error) {
+	args := t.Called(ctx, id)
+	return args.Get(0).(dockerstructs.ContainerInspect), args.Error(1)
+}
+
+func (t *TestDocker) Stats(ctx context.Context, id string) (dockerstructs.ContainerSample, error) {
+	args := t.Called(ctx, id)
+	return args.Get(0).(dockerstructs.ContainerSample), args.Error(1)
+}
+
+func (t *TestDocker) Snapshot(ctx context.Context, id string) (dockerstructs.ContainerSnapshot, error) {
+	args := t.Called(ctx, id)
+	return args.Get(0).(dockerstructs.ContainerSnapshot), args.Error(1)
+}
+
+func (t *TestDocker) PullImage(ctx context.Context, ref string) (string, string, error) {
+	args := t.Called(ctx, ref)
+	return args.String(0), args.String(1), args.Error(2)
+}
+
+func (t *TestDocker) Recreate(ctx context.Context, snapshot dockerstructs.ContainerSnapshot, image string) error {
+	args := t.Called(ctx, snapshot, image)
+	return args.Error(0)
 }
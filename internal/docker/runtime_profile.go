@@ -0,0 +1,242 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	structs2 "fp-dynamic-elements-manager-controller/internal/docker/structs"
+	"github.com/docker/docker/api/types/container"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// minMemoryMB is the smallest memory limit docker accepts, rounded up
+const minMemoryMB = 6
+
+var (
+	// AllowedCapabilities are the kernel capabilities a module can be given, anything which would let a module take
+	// over the host, e.g. SYS_ADMIN, is left out
+	AllowedCapabilities = []string{"CHOWN", "DAC_OVERRIDE", "FOWNER", "FSETID", "KILL", "NET_BIND_SERVICE", "NET_RAW", "SETGID", "SETUID"}
+	logSize             = regexp.MustCompile(`^[1-9][0-9]*[kmg]?$`)
+	// bindModes are the options a module volume can be mounted with, propagation options are left out so a module
+	// cannot see mounts made on the host
+	bindModes = map[string]bool{"ro": true, "rw": true, "z": true, "Z": true}
+	// tmpfsOptions are the options a tmpfs can be mounted with besides size and mode, exec, suid and dev are left
+	// out so docker keeps mounting it noexec, nosuid and nodev
+	tmpfsOptions = map[string]bool{"ro": true, "rw": true, "noexec": true, "nosuid": true, "nodev": true}
+	tmpfsSize    = regexp.MustCompile(`^size=[1-9][0-9]*[kmg%]?$`)
+	tmpfsMode    = regexp.MustCompile(`^mode=[0-7]{3,4}$`)
+)
+
+// DefaultRuntimeProfile limits module containers to what a typical module needs, every capability is dropped but
+// those needed to run as a non-root user or bind a low port
+func DefaultRuntimeProfile() structs2.RuntimeProfile {
+	readOnly, noNewPrivileges := false, true
+	return structs2.RuntimeProfile{
+		MemoryMB:        512,
+		CPUs:            1,
+		PidsLimit:       256,
+		ReadOnlyRootFS:  &readOnly,
+		NoNewPrivileges: &noNewPrivileges,
+		CapDrop:         []string{"ALL"},
+		CapAdd:          []string{"CHOWN", "DAC_OVERRIDE", "FOWNER", "NET_BIND_SERVICE", "SETGID", "SETUID"},
+		Tmpfs:           []string{},
+		LogMaxSize:      "10m",
+		LogMaxFiles:     3,
+	}
+}
+
+// RuntimeProfileFromEnv reads the default runtime profile of module containers from the MODULE_* settings
+func RuntimeProfileFromEnv() structs2.RuntimeProfile {
+	profile := DefaultRuntimeProfile()
+
+	if n, err := strconv.ParseInt(os.Getenv("MODULE_MEMORY_MB"), 10, 64); err == nil && n > 0 {
+		profile.MemoryMB = n
+	}
+
+	if f, err := strconv.ParseFloat(os.Getenv("MODULE_CPUS"), 64); err == nil && f > 0 {
+		profile.CPUs = f
+	}
+
+	if n, err := strconv.ParseInt(os.Getenv("MODULE_PIDS_LIMIT"), 10, 64); err == nil && n > 0 {
+		profile.PidsLimit = n
+	}
+
+	if b, err := strconv.ParseBool(os.Getenv("MODULE_READ_ONLY_ROOTFS")); err == nil {
+		profile.ReadOnlyRootFS = &b
+	}
+
+	if b, err := strconv.ParseBool(os.Getenv("MODULE_NO_NEW_PRIVILEGES")); err == nil {
+		profile.NoNewPrivileges = &b
+	}
+
+	if v, ok := os.LookupEnv("MODULE_CAP_DROP"); ok {
+		profile.CapDrop = splitList(v)
+	}
+
+	if v, ok := os.LookupEnv("MODULE_CAP_ADD"); ok {
+		profile.CapAdd = splitList(v)
+	}
+
+	if v, ok := os.LookupEnv("MODULE_TMPFS"); ok {
+		profile.Tmpfs = splitList(v)
+	}
+
+	if v := os.Getenv("MODULE_LOG_MAX_SIZE"); v != "" {
+		profile.LogMaxSize = v
+	}
+
+	if n, err := strconv.Atoi(os.Getenv("MODULE_LOG_MAX_FILES")); err == nil && n > 0 {
+		profile.LogMaxFiles = n
+	}
+
+	return profile
+}
+
+func splitList(v string) []string {
+	list := []string{}
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// ValidateRuntimeProfile checks a module's runtime profile, privileged mode and capabilities outside
+// AllowedCapabilities are refused. Every capability must be dropped so only those in CapAdd are left
+func ValidateRuntimeProfile(profile structs2.RuntimeProfile) error {
+	if profile.Privileged {
+		return errors.New("modules cannot run in privileged mode")
+	}
+	if profile.MemoryMB < 0 || profile.CPUs < 0 || profile.PidsLimit < 0 || profile.LogMaxFiles < 0 {
+		return errors.New("runtime limits cannot be negative")
+	}
+	if profile.MemoryMB > 0 && profile.MemoryMB < minMemoryMB {
+		return fmt.Errorf("the memory limit must be at least %dMB", minMemoryMB)
+	}
+	if !dropsAll(profile.CapDrop) {
+		return errors.New("modules must drop ALL capabilities, the ones needed are given with cap_add")
+	}
+	for _, capability := range profile.CapAdd {
+		if !allowedCapability(capability) {
+			return fmt.Errorf("modules cannot be given the %s capability", capability)
+		}
+	}
+	for _, mount := range profile.Tmpfs {
+		parts := strings.SplitN(mount, ":", 2)
+		path := parts[0]
+		if !filepath.IsAbs(path) || filepath.Clean(path) != path || path == "/" {
+			return fmt.Errorf("tmpfs mount %s must be an absolute path below /", path)
+		}
+		if len(parts) == 2 {
+			for _, option := range strings.Split(parts[1], ",") {
+				if !tmpfsOptions[option] && !tmpfsSize.MatchString(option) && !tmpfsMode.MatchString(option) {
+					return fmt.Errorf("tmpfs mount %s cannot be mounted with %s", path, option)
+				}
+			}
+		}
+	}
+	if profile.LogMaxSize != "" && !logSize.MatchString(profile.LogMaxSize) {
+		return fmt.Errorf("log max size %s must be a size such as 10m", profile.LogMaxSize)
+	}
+	return nil
+}
+
+func dropsAll(capabilities []string) bool {
+	for _, capability := range capabilities {
+		if strings.EqualFold(capability, "ALL") {
+			return true
+		}
+	}
+	return false
+}
+
+func allowedCapability(capability string) bool {
+	capability = strings.TrimPrefix(strings.ToUpper(capability), "CAP_")
+	for _, allowed := range AllowedCapabilities {
+		if capability == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateVolumes checks the volumes of a module can be mounted. The source of each volume is a path within the
+// module's directory under PROJECT_ROOT, it is refused if it would resolve outside of PROJECT_ROOT. Without a
+// PROJECT_ROOT the source must stay within the module's directory
+func ValidateVolumes(moduleID string, volumes []string) error {
+	if moduleID == "" || strings.ContainsAny(moduleID, `/\`) || moduleID == "." || moduleID == ".." {
+		return fmt.Errorf("%s is not a valid module ID for mounting volumes", moduleID)
+	}
+	root := filepath.Join("/", os.Getenv("PROJECT_ROOT"))
+	if root == "/" {
+		root = filepath.Join(root, moduleID)
+	}
+
+	for _, volume := range volumes {
+		parts := strings.Split(volume, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+			return fmt.Errorf("volume %s must be in the form source:target[:mode]", volume)
+		}
+		// This is the path the volume is mounted from once the module's directory is added, see AddModuleBindPaths
+		source := filepath.Join("/", os.Getenv("PROJECT_ROOT"), moduleID, parts[0])
+		if rel, err := filepath.Rel(root, source); err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			return fmt.Errorf("volume %s is outside of the project root", volume)
+		}
+		if !filepath.IsAbs(parts[1]) {
+			return fmt.Errorf("volume %s must be mounted at an absolute path", volume)
+		}
+		if len(parts) == 3 {
+			for _, mode := range strings.Split(parts[2], ",") {
+				if !bindModes[mode] {
+					return fmt.Errorf("volume %s cannot be mounted with %s", volume, mode)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// applyRuntimeProfile sets the limits and security options of the profile on the container's host config
+func applyRuntimeProfile(hostConfig *container.HostConfig, profile structs2.RuntimeProfile) {
+	if profile.MemoryMB > 0 {
+		hostConfig.Memory = profile.MemoryMB * 1024 * 1024
+		hostConfig.MemorySwap = hostConfig.Memory
+	}
+	if profile.CPUs > 0 {
+		hostConfig.NanoCPUs = int64(profile.CPUs * 1e9)
+	}
+	hostConfig.PidsLimit = profile.PidsLimit
+	hostConfig.ReadonlyRootfs = profile.ReadOnlyRootFS != nil && *profile.ReadOnlyRootFS
+	if profile.NoNewPrivileges != nil && *profile.NoNewPrivileges {
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "no-new-privileges")
+	}
+	hostConfig.CapDrop = profile.CapDrop
+	hostConfig.CapAdd = profile.CapAdd
+	hostConfig.Privileged = false
+
+	if len(profile.Tmpfs) > 0 {
+		hostConfig.Tmpfs = make(map[string]string, len(profile.Tmpfs))
+		for _, mount := range profile.Tmpfs {
+			parts := strings.SplitN(mount, ":", 2)
+			options := "rw,noexec,nosuid"
+			if len(parts) == 2 {
+				options = parts[1]
+			}
+			hostConfig.Tmpfs[parts[0]] = options
+		}
+	}
+
+	if profile.LogMaxSize != "" || profile.LogMaxFiles > 0 {
+		hostConfig.LogConfig = container.LogConfig{Type: "json-file", Config: map[string]string{}}
+		if profile.LogMaxSize != "" {
+			hostConfig.LogConfig.Config["max-size"] = profile.LogMaxSize
+		}
+		if profile.LogMaxFiles > 0 {
+			hostConfig.LogConfig.Config["max-file"] = strconv.Itoa(profile.LogMaxFiles)
+		}
+	}
+}
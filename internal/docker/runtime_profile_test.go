@@ -0,0 +1,140 @@
+package docker
+
+import (
+	"context"
+	"fp-dynamic-elements-manager-controller/internal/docker/mocks"
+	"fp-dynamic-elements-manager-controller/internal/docker/structs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/strslice"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"testing"
+)
+
+type RuntimeProfileTestSuite struct {
+	suite.Suite
+}
+
+func TestRuntimeProfile(t *testing.T) {
+	suite.Run(t, new(RuntimeProfileTestSuite))
+}
+
+func (r *RuntimeProfileTestSuite) TestMerge() {
+	readOnly := true
+	profile := DefaultRuntimeProfile().Merge(&structs.RuntimeProfile{
+		MemoryMB:       1024,
+		ReadOnlyRootFS: &readOnly,
+		Tmpfs:          []string{"/tmp"},
+	})
+
+	r.Equal(int64(1024), profile.MemoryMB)
+	r.Equal(float64(1), profile.CPUs)
+	r.True(*profile.ReadOnlyRootFS)
+	r.True(*profile.NoNewPrivileges)
+	r.Equal([]string{"/tmp"}, profile.Tmpfs)
+	r.Equal([]string{"ALL"}, profile.CapDrop)
+	r.Equal(DefaultRuntimeProfile(), DefaultRuntimeProfile().Merge(nil))
+}
+
+func (r *RuntimeProfileTestSuite) TestValidateRuntimeProfile() {
+	r.Nil(ValidateRuntimeProfile(DefaultRuntimeProfile()))
+
+	invalid := map[string]structs.RuntimeProfile{
+		"privileged":          {Privileged: true},
+		"negative limit":      {CPUs: -1},
+		"tiny memory limit":   {MemoryMB: 1},
+		"host capability":     {CapAdd: []string{"SYS_ADMIN"}},
+		"capabilities kept":   {CapDrop: []string{}},
+		"relative tmpfs":      {Tmpfs: []string{"tmp"}},
+		"tmpfs over the root": {Tmpfs: []string{"/"}},
+		"executable tmpfs":    {Tmpfs: []string{"/tmp:exec,suid"}},
+		"tmpfs device files":  {Tmpfs: []string{"/tmp:rw,dev"}},
+		"unknown log size":    {LogMaxSize: "10 megabytes"},
+	}
+	for name, profile := range invalid {
+		profile = DefaultRuntimeProfile().Merge(&profile)
+		r.NotNil(ValidateRuntimeProfile(profile), name)
+	}
+
+	r.Nil(ValidateRuntimeProfile(structs.RuntimeProfile{
+		CapDrop: []string{"all"},
+		CapAdd:  []string{"cap_net_raw"},
+		Tmpfs:   []string{"/run:rw,size=16m", "/tmp:noexec,nosuid,mode=1777"},
+	}))
+}
+
+func (r *RuntimeProfileTestSuite) TestValidateVolumes() {
+	os.Setenv("PROJECT_ROOT", "/opt/fp-dem")
+	defer os.Unsetenv("PROJECT_ROOT")
+
+	r.Nil(ValidateVolumes("fp-ngfw", []string{"/config:/config", "data:/data:ro", "../shared:/shared:rw,z"}))
+
+	r.T().Run("Test volumes outside the project root are refused", func(t *testing.T) {
+		assert.NotNil(t, ValidateVolumes("fp-ngfw", []string{"../../../etc:/host-etc"}))
+		assert.NotNil(t, ValidateVolumes("fp-ngfw", []string{"/../../var/run/docker.sock:/var/run/docker.sock"}))
+		assert.NotNil(t, ValidateVolumes("../..", []string{"etc:/etc"}))
+	})
+
+	r.T().Run("Test malformed volumes are refused", func(t *testing.T) {
+		assert.NotNil(t, ValidateVolumes("fp-ngfw", []string{"/config"}))
+		assert.NotNil(t, ValidateVolumes("fp-ngfw", []string{"/config:config"}))
+		assert.NotNil(t, ValidateVolumes("fp-ngfw", []string{"/config:/config:rshared"}))
+	})
+}
+
+func (r *RuntimeProfileTestSuite) TestApplyRuntimeProfile() {
+	profile := DefaultRuntimeProfile()
+	readOnly := true
+	profile.ReadOnlyRootFS = &readOnly
+	profile.CPUs = 0.5
+	profile.Tmpfs = []string{"/tmp", "/run:rw,size=16m"}
+
+	hostConfig := &container.HostConfig{Binds: []string{"/opt/fp-dem/fp-ngfw/config:/config"}}
+	applyRuntimeProfile(hostConfig, profile)
+
+	r.Equal(int64(512*1024*1024), hostConfig.Memory)
+	r.Equal(hostConfig.Memory, hostConfig.MemorySwap)
+	r.Equal(int64(500000000), hostConfig.NanoCPUs)
+	r.Equal(int64(256), hostConfig.PidsLimit)
+	r.True(hostConfig.ReadonlyRootfs)
+	r.False(hostConfig.Privileged)
+	r.Equal([]string{"no-new-privileges"}, hostConfig.SecurityOpt)
+	r.Equal(strslice.StrSlice{"ALL"}, hostConfig.CapDrop)
+	r.Equal(map[string]string{"/tmp": "rw,noexec,nosuid", "/run": "rw,size=16m"}, hostConfig.Tmpfs)
+	r.Equal(container.LogConfig{Type: "json-file", Config: map[string]string{"max-size": "10m", "max-file": "3"}}, hostConfig.LogConfig)
+	r.Equal([]string{"/opt/fp-dem/fp-ngfw/config:/config"}, hostConfig.Binds)
+}
+
+func (r *RuntimeProfileTestSuite) TestCommandHandler_RefusedProfile() {
+	docker := new(mocks.TestDocker)
+	docker.On("ModuleNetwork", mock.Anything).Return("module_net", nil)
+
+	privileged, escaping, overridden := testContainer, testContainer, testContainer
+	privileged.Command, escaping.Command, overridden.Command = structs.Create, structs.Create, structs.Create
+	privileged.Runtime = &structs.RuntimeProfile{Privileged: true}
+	escaping.Volumes = []string{"../../:/host"}
+	overridden.Volumes = []string{"/config:/config"}
+	overridden.Runtime = &structs.RuntimeProfile{MemoryMB: 2048}
+
+	expected := DefaultRuntimeProfile()
+	expected.MemoryMB = 2048
+	docker.On("ResolveImage", mock.Anything, testContainer.ImageRef).Return(testDigest, nil)
+	docker.On("Create", mock.Anything, testWorkload(expected)).Return(nil)
+
+	handler, _, _ := newTestHandler(docker, new(mocks.MockModuleMetadataRepo), new(mocks.MockRouteTable))
+	job, err := handler.Submit(context.Background(), structs.ContainerDetailsWrapper{
+		Containers: []structs.ContainerDetails{privileged, escaping, overridden},
+	})
+	r.Require().Nil(err)
+	job, err = handler.Wait(context.Background(), job.ID)
+	r.Nil(err)
+
+	r.Equal(structs.JobSkipped, job.Steps[0].Status)
+	r.Contains(job.Steps[0].Error, "privileged")
+	r.Equal(structs.JobSkipped, job.Steps[1].Status)
+	r.Contains(job.Steps[1].Error, "outside of the project root")
+	r.Equal(structs.JobSucceeded, job.Steps[2].Status, job.Steps[2].Error)
+	docker.AssertNumberOfCalls(r.T(), "Create", 1)
+}
@@ -0,0 +1,307 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"fp-dynamic-elements-manager-controller/internal/db/persistence"
+	"fp-dynamic-elements-manager-controller/internal/docker/structs"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
+	structs2 "fp-dynamic-elements-manager-controller/internal/modules/structs"
+	"fp-dynamic-elements-manager-controller/internal/notification"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type StatsConfig struct {
+	// Interval is the time between samples of each module container
+	Interval time.Duration
+	// Retention is how long samples are kept, samples are only held in memory
+	Retention time.Duration
+	// A container which restarts CrashLoopRestarts times within CrashLoopWindow is crash looping
+	CrashLoopWindow   time.Duration
+	CrashLoopRestarts int
+}
+
+func DefaultStatsConfig() StatsConfig {
+	return StatsConfig{
+		Interval:          15 * time.Second,
+		Retention:         time.Hour,
+		CrashLoopWindow:   10 * time.Minute,
+		CrashLoopRestarts: 3,
+	}
+}
+
+// StatsConfigFromEnv reads STATS_SAMPLE_INTERVAL, STATS_RETENTION and the CRASH_LOOP_* thresholds
+func StatsConfigFromEnv() StatsConfig {
+	cfg := DefaultStatsConfig()
+
+	if d, err := time.ParseDuration(os.Getenv("STATS_SAMPLE_INTERVAL")); err == nil && d > 0 {
+		cfg.Interval = d
+	}
+
+	if d, err := time.ParseDuration(os.Getenv("STATS_RETENTION")); err == nil && d > 0 {
+		cfg.Retention = d
+	}
+
+	if d, err := time.ParseDuration(os.Getenv("CRASH_LOOP_WINDOW")); err == nil && d > 0 {
+		cfg.CrashLoopWindow = d
+	}
+
+	if n, err := strconv.Atoi(os.Getenv("CRASH_LOOP_RESTARTS")); err == nil && n > 0 {
+		cfg.CrashLoopRestarts = n
+	}
+
+	return cfg
+}
+
+type containerSeries struct {
+	samples []structs.ContainerSample
+	// restarts holds the times a restart was seen within the crash loop window
+	restarts     []time.Time
+	oomKilled    bool
+	crashLooping bool
+}
+
+// StatsSampler samples the resource usage of every module container in the background and keeps the recent samples
+// of each. Clients are notified when a container is killed for running out of memory or starts crash looping
+type StatsSampler struct {
+	cfg           StatsConfig
+	docker        Dockers
+	modules       persistence.ModuleListRepo
+	notifications notification.Service
+	now           func() time.Time
+
+	mu       sync.RWMutex
+	series   map[string]*containerSeries
+	doneChan chan struct{}
+}
+
+func NewStatsSampler(cfg StatsConfig, docker Dockers, modules persistence.ModuleListRepo, notifications notification.Service) *StatsSampler {
+	return &StatsSampler{
+		cfg:           cfg,
+		docker:        docker,
+		modules:       modules,
+		notifications: notifications,
+		now:           time.Now,
+		series:        make(map[string]*containerSeries),
+		doneChan:      make(chan struct{}),
+	}
+}
+
+// Start samples every module container each interval until Stop is called
+func (s *StatsSampler) Start() {
+	go func() {
+		for {
+			s.SampleAll()
+			select {
+			case <-time.After(s.cfg.Interval):
+			case <-s.doneChan:
+				return
+			}
+		}
+	}()
+}
+
+func (s *StatsSampler) Stop() {
+	close(s.doneChan)
+}
+
+// Stats returns the recent samples of a module container, the container is named after the module service
+func (s *StatsSampler) Stats(serviceName string) structs.ContainerStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := structs.ContainerStats{Samples: []structs.ContainerSample{}}
+	series, ok := s.series[serviceName]
+	if !ok || len(series.samples) == 0 {
+		return stats
+	}
+
+	stats.Samples = append(stats.Samples, series.samples...)
+	latest := stats.Samples[len(stats.Samples)-1]
+	stats.Latest = &latest
+	stats.Restarts = len(series.restarts)
+	stats.CrashLooping = series.crashLooping
+	return stats
+}
+
+// SampleAll samples every registered module container once, containers are sampled concurrently
+func (s *StatsSampler) SampleAll() {
+	if s.docker == nil {
+		// Container stats are only sampled with the docker orchestrator
+		return
+	}
+	modules, err := s.modules.GetAll()
+	if err != nil {
+		applog.System().Error(err, "error retrieving modules for container stats")
+		return
+	}
+
+	s.prune(modules)
+
+	wg := sync.WaitGroup{}
+	wg.Add(len(modules))
+	for _, module := range modules {
+		go func(module structs2.ModuleMetadata) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Interval)
+			defer cancel()
+
+			sample, err := s.docker.Stats(ctx, module.ModuleServiceName)
+			if err != nil {
+				// Modules which run outside of docker, or whose container has been removed, have no stats
+				if !client.IsErrContainerNotFound(err) {
+					dockerLog(applog.Fields{"container": module.ModuleServiceName}).Error(err, "error sampling container stats")
+				}
+				return
+			}
+			s.record(module, sample)
+		}(module)
+	}
+	wg.Wait()
+}
+
+// prune drops the samples of modules which are no longer registered
+func (s *StatsSampler) prune(modules []structs2.ModuleMetadata) {
+	registered := make(map[string]struct{}, len(modules))
+	for _, module := range modules {
+		registered[module.ModuleServiceName] = struct{}{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name := range s.series {
+		if _, ok := registered[name]; !ok {
+			delete(s.series, name)
+		}
+	}
+}
+
+func (s *StatsSampler) record(module structs2.ModuleMetadata, sample structs.ContainerSample) {
+	now := s.now()
+	sample.Time = now
+
+	s.mu.Lock()
+	series, ok := s.series[module.ModuleServiceName]
+	if !ok {
+		series = &containerSeries{}
+		s.series[module.ModuleServiceName] = series
+	}
+
+	// Restarts before the first sample are not counted, they may have happened long ago
+	if n := len(series.samples); n > 0 {
+		for i := series.samples[n-1].RestartCount; i < sample.RestartCount; i++ {
+			series.restarts = append(series.restarts, now)
+		}
+	}
+	series.restarts = trimTimes(series.restarts, now.Add(-s.cfg.CrashLoopWindow))
+
+	cutoff := now.Add(-s.cfg.Retention)
+	i := 0
+	for i < len(series.samples) && series.samples[i].Time.Before(cutoff) {
+		i++
+	}
+	series.samples = append(series.samples[i:], sample)
+
+	oomKilled := sample.OOMKilled && !series.oomKilled
+	series.oomKilled = sample.OOMKilled
+
+	looping := len(series.restarts) >= s.cfg.CrashLoopRestarts
+	crashLooping := looping && !series.crashLooping
+	series.crashLooping = looping
+	restarts := len(series.restarts)
+	s.mu.Unlock()
+
+	if oomKilled {
+		s.notify(module, notification.Error, notification.OOMKilled, fmt.Sprintf("Module %s was killed for running out of memory", module.ModuleDisplayName))
+	}
+	if crashLooping {
+		s.notify(module, notification.Warning, notification.CrashLooping, fmt.Sprintf("Module %s is crash looping, it restarted %d times in %s", module.ModuleDisplayName, restarts, s.cfg.CrashLoopWindow))
+	}
+}
+
+func (s *StatsSampler) notify(module structs2.ModuleMetadata, eventType notification.EventType, state notification.State, msg string) {
+	dockerLog(applog.Fields{"container": module.ModuleServiceName}).Warn(msg)
+	s.notifications.Send(notification.Event{
+		EventType: eventType,
+		Value:     msg,
+		Context: notification.EventContext{
+			Type:       notification.Module,
+			Identifier: module.ModuleServiceName,
+			State:      state,
+		},
+	})
+}
+
+// trimTimes drops the times before cutoff, times are in order
+func trimTimes(times []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(times) && times[i].Before(cutoff) {
+		i++
+	}
+	return times[i:]
+}
+
+// Stats samples the resource usage and state of a container
+func (d *Docker) Stats(ctx context.Context, containerID string) (structs.ContainerSample, error) {
+	ctr, err := d.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return structs.ContainerSample{}, err
+	}
+
+	sample := structs.ContainerSample{Time: time.Now(), RestartCount: ctr.RestartCount}
+	if ctr.State != nil {
+		sample.Status = ctr.State.Status
+		sample.OOMKilled = ctr.State.OOMKilled
+		if !ctr.State.Running {
+			return sample, nil
+		}
+	}
+
+	resp, err := d.cli.ContainerStats(ctx, containerID, false)
+	if err != nil {
+		return sample, err
+	}
+	defer resp.Body.Close()
+
+	stats := types.StatsJSON{}
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return sample, err
+	}
+	applyStats(&sample, stats)
+	return sample, nil
+}
+
+// applyStats calculates usage the same way as docker stats, the CPU is the share of the host used since the
+// previous reading and the page cache is not counted as used memory
+func applyStats(sample *structs.ContainerSample, stats types.StatsJSON) {
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	if cpuDelta > 0 && systemDelta > 0 {
+		cpus := float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+		if cpus == 0 {
+			cpus = 1
+		}
+		sample.CPUPercent = cpuDelta / systemDelta * cpus * 100
+	}
+
+	sample.MemoryBytes = stats.MemoryStats.Usage
+	if cache, ok := stats.MemoryStats.Stats["cache"]; ok && cache < sample.MemoryBytes {
+		sample.MemoryBytes -= cache
+	}
+	sample.MemoryLimitBytes = stats.MemoryStats.Limit
+	if sample.MemoryLimitBytes > 0 {
+		sample.MemoryPercent = float64(sample.MemoryBytes) / float64(sample.MemoryLimitBytes) * 100
+	}
+
+	for _, n := range stats.Networks {
+		sample.NetworkRxBytes += n.RxBytes
+		sample.NetworkTxBytes += n.TxBytes
+	}
+}
@@ -0,0 +1,162 @@
+package docker
+
+import (
+	"errors"
+	"fp-dynamic-elements-manager-controller/internal/docker/mocks"
+	"fp-dynamic-elements-manager-controller/internal/docker/structs"
+	structs2 "fp-dynamic-elements-manager-controller/internal/modules/structs"
+	"fp-dynamic-elements-manager-controller/internal/notification"
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+var statsModule = structs2.ModuleMetadata{ModuleServiceName: "fp-ngfw", ModuleDisplayName: "Forcepoint NGFW"}
+
+type StatsSamplerTestSuite struct {
+	suite.Suite
+	docker  *mocks.TestDocker
+	modules *mocks.MockModuleListRepo
+	ns      *mocks.NSMock
+	now     time.Time
+	sampler *StatsSampler
+	events  []notification.Event
+}
+
+func TestStatsSampler(t *testing.T) {
+	suite.Run(t, new(StatsSamplerTestSuite))
+}
+
+func (s *StatsSamplerTestSuite) SetupTest() {
+	s.docker = new(mocks.TestDocker)
+	s.modules = new(mocks.MockModuleListRepo)
+	s.modules.On("GetAll").Return([]structs2.ModuleMetadata{statsModule}, nil)
+	s.ns = new(mocks.NSMock)
+	s.events = nil
+	s.ns.On("Send", mock.Anything).Run(func(args mock.Arguments) {
+		s.events = append(s.events, args.Get(0).(notification.Event))
+	}).Return()
+
+	cfg := DefaultStatsConfig()
+	cfg.Retention = 3 * time.Minute
+	cfg.CrashLoopWindow = 5 * time.Minute
+	cfg.CrashLoopRestarts = 3
+
+	s.now = time.Date(2020, 6, 6, 12, 0, 0, 0, time.UTC)
+	s.sampler = NewStatsSampler(cfg, s.docker, s.modules, s.ns)
+	s.sampler.now = func() time.Time { return s.now }
+}
+
+// sample runs a round of sampling a minute after the last one with the container returning the given sample
+func (s *StatsSamplerTestSuite) sample(sample structs.ContainerSample) structs.ContainerStats {
+	s.now = s.now.Add(time.Minute)
+	s.docker.ExpectedCalls = nil
+	s.docker.On("Stats", mock.Anything, "fp-ngfw").Return(sample, nil)
+	s.sampler.SampleAll()
+	return s.sampler.Stats("fp-ngfw")
+}
+
+func (s *StatsSamplerTestSuite) TestSamplesAreKeptForRetention() {
+	for i := 1; i <= 5; i++ {
+		s.sample(structs.ContainerSample{Status: "running", CPUPercent: float64(i)})
+	}
+
+	stats := s.sampler.Stats("fp-ngfw")
+	s.Len(stats.Samples, 4)
+	s.Equal(float64(2), stats.Samples[0].CPUPercent)
+	s.Equal(float64(5), stats.Latest.CPUPercent)
+	s.Equal(s.now, stats.Latest.Time)
+	s.Empty(s.events)
+
+	s.T().Run("Test a module without samples has empty stats", func(t *testing.T) {
+		stats := s.sampler.Stats("fp-dep")
+		assert.Nil(t, stats.Latest)
+		assert.Empty(t, stats.Samples)
+	})
+
+	s.T().Run("Test samples are dropped once the module is removed", func(t *testing.T) {
+		s.modules.ExpectedCalls = nil
+		s.modules.On("GetAll").Return([]structs2.ModuleMetadata{}, nil)
+		s.sampler.SampleAll()
+		assert.Nil(t, s.sampler.Stats("fp-ngfw").Latest)
+	})
+}
+
+func (s *StatsSamplerTestSuite) TestOOMKilled() {
+	s.sample(structs.ContainerSample{Status: "running"})
+	s.sample(structs.ContainerSample{Status: "exited", OOMKilled: true})
+	s.sample(structs.ContainerSample{Status: "exited", OOMKilled: true})
+
+	s.Len(s.events, 1)
+	s.Equal(notification.Error, s.events[0].EventType)
+	s.Equal(notification.OOMKilled, s.events[0].Context.State)
+	s.Equal("fp-ngfw", s.events[0].Context.Identifier)
+
+	s.T().Run("Test a second OOM kill is notified after the container recovers", func(t *testing.T) {
+		s.sample(structs.ContainerSample{Status: "running", RestartCount: 1})
+		s.sample(structs.ContainerSample{Status: "exited", RestartCount: 1, OOMKilled: true})
+		assert.Len(t, s.events, 2)
+	})
+}
+
+func (s *StatsSamplerTestSuite) TestCrashLooping() {
+	// Restarts before the first sample are not counted
+	stats := s.sample(structs.ContainerSample{Status: "running", RestartCount: 10})
+	s.Equal(0, stats.Restarts)
+
+	s.sample(structs.ContainerSample{Status: "restarting", RestartCount: 11})
+	stats = s.sample(structs.ContainerSample{Status: "restarting", RestartCount: 12})
+	s.False(stats.CrashLooping)
+	s.Empty(s.events)
+
+	stats = s.sample(structs.ContainerSample{Status: "restarting", RestartCount: 13})
+	s.True(stats.CrashLooping)
+	s.Equal(3, stats.Restarts)
+	s.Len(s.events, 1)
+	s.Equal(notification.Warning, s.events[0].EventType)
+	s.Equal(notification.CrashLooping, s.events[0].Context.State)
+
+	s.T().Run("Test crash looping ends once restarts leave the window", func(t *testing.T) {
+		s.sample(structs.ContainerSample{Status: "restarting", RestartCount: 14})
+		assert.Len(t, s.events, 1)
+
+		for i := 0; i < 6; i++ {
+			stats = s.sample(structs.ContainerSample{Status: "running", RestartCount: 14})
+		}
+		assert.False(t, stats.CrashLooping)
+		assert.Equal(t, 0, stats.Restarts)
+	})
+}
+
+func (s *StatsSamplerTestSuite) TestSampleErrors() {
+	s.docker.On("Stats", mock.Anything, "fp-ngfw").Return(structs.ContainerSample{}, errors.New("daemon unavailable"))
+	s.sampler.SampleAll()
+	s.Nil(s.sampler.Stats("fp-ngfw").Latest)
+}
+
+func (s *StatsSamplerTestSuite) TestApplyStats() {
+	stats := types.StatsJSON{}
+	stats.CPUStats.CPUUsage.TotalUsage = 400
+	stats.CPUStats.CPUUsage.PercpuUsage = []uint64{200, 200}
+	stats.CPUStats.SystemUsage = 2000
+	stats.PreCPUStats.CPUUsage.TotalUsage = 300
+	stats.PreCPUStats.SystemUsage = 1000
+	stats.MemoryStats.Usage = 300
+	stats.MemoryStats.Stats = map[string]uint64{"cache": 100}
+	stats.MemoryStats.Limit = 1000
+	stats.Networks = map[string]types.NetworkStats{
+		"eth0": {RxBytes: 10, TxBytes: 20},
+		"eth1": {RxBytes: 1, TxBytes: 2},
+	}
+
+	sample := structs.ContainerSample{}
+	applyStats(&sample, stats)
+	s.Equal(float64(20), sample.CPUPercent)
+	s.Equal(uint64(200), sample.MemoryBytes)
+	s.Equal(float64(20), sample.MemoryPercent)
+	s.Equal(uint64(11), sample.NetworkRxBytes)
+	s.Equal(uint64(22), sample.NetworkTxBytes)
+}
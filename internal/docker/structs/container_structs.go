@@ -1,6 +1,9 @@
 package structs
 
-import "fp-dynamic-elements-manager-controller/internal/notification"
+import (
+	"fp-dynamic-elements-manager-controller/internal/notification"
+	"time"
+)
 
 type ModuleType string
 type ElementType string
@@ -40,6 +43,8 @@ type ContainerDetails struct {
 	IconURL           string        `json:"icon_url"`
 	Command           Command       `json:"command"`
 	RegistrationToken string        `json:"registration_token"`
+	// Runtime overrides the default runtime profile of the container, it is only used when the container is created
+	Runtime *RuntimeProfile `json:"runtime,omitempty"`
 }
 
 type ContainerDetailsWrapper struct {
@@ -61,10 +66,87 @@ func (c Command) CommandToState() notification.State {
 		return notification.Created
 	case Stop:
 		return notification.Stopped
-	case Start, Restart:
+	case Start, Restart, PullAndRestart:
 		return notification.Started
 	case Remove:
 		return notification.Deleted
 	}
 	return notification.None
 }
+
+const (
+	Stdout = "stdout"
+	Stderr = "stderr"
+)
+
+// LogOptions selects the container log lines to read, Since and Until are zero when not set
+type LogOptions struct {
+	Follow bool
+	Since  time.Time
+	Until  time.Time
+	// Tail is the number of lines to read from the end of the logs, or "all"
+	Tail string
+}
+
+// LogLine is a single line written by a container to stdout or stderr
+type LogLine struct {
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+	Line   string    `json:"line"`
+}
+
+// ContainerInspect is the detail of a container shown in the UI, secrets in the environment are redacted
+type ContainerInspect struct {
+	ID           string           `json:"id"`
+	Name         string           `json:"name"`
+	Image        string           `json:"image"`
+	ImageID      string           `json:"image_id"`
+	ImageDigests []string         `json:"image_digests"`
+	Created      string           `json:"created"`
+	State        ContainerState   `json:"state"`
+	RestartCount int              `json:"restart_count"`
+	Mounts       []ContainerMount `json:"mounts"`
+	Env          []string         `json:"env"`
+}
+
+type ContainerState struct {
+	Status     string `json:"status"`
+	Running    bool   `json:"running"`
+	Restarting bool   `json:"restarting"`
+	OOMKilled  bool   `json:"oom_killed"`
+	ExitCode   int    `json:"exit_code"`
+	Error      string `json:"error,omitempty"`
+	Health     string `json:"health,omitempty"`
+	StartedAt  string `json:"started_at"`
+	FinishedAt string `json:"finished_at"`
+}
+
+type ContainerMount struct {
+	Type        string `json:"type"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	ReadWrite   bool   `json:"read_write"`
+}
+
+// ContainerSample is the resource usage of a container at a point in time
+type ContainerSample struct {
+	Time             time.Time `json:"time"`
+	Status           string    `json:"status"`
+	CPUPercent       float64   `json:"cpu_percent"`
+	MemoryBytes      uint64    `json:"memory_bytes"`
+	MemoryLimitBytes uint64    `json:"memory_limit_bytes"`
+	MemoryPercent    float64   `json:"memory_percent"`
+	NetworkRxBytes   uint64    `json:"network_rx_bytes"`
+	NetworkTxBytes   uint64    `json:"network_tx_bytes"`
+	RestartCount     int       `json:"restart_count"`
+	OOMKilled        bool      `json:"oom_killed"`
+}
+
+// ContainerStats holds the recent samples of a module container, oldest first
+type ContainerStats struct {
+	Latest *ContainerSample `json:"latest,omitempty"`
+	// Restarts is the number of times the container restarted within the crash loop window
+	Restarts     int               `json:"restarts"`
+	CrashLooping bool              `json:"crash_looping"`
+	Samples      []ContainerSample `json:"samples"`
+}
@@ -0,0 +1,51 @@
+package structs
+
+import "time"
+
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+	JobSkipped   JobStatus = "skipped"
+)
+
+// Finished is true once a job or step can no longer change
+func (s JobStatus) Finished() bool {
+	switch s {
+	case JobSucceeded, JobFailed, JobCancelled, JobSkipped:
+		return true
+	}
+	return false
+}
+
+// Job is a submission of docker commands, the steps are run in order and the job stops at the first failed step
+type Job struct {
+	ID         string     `json:"id" db:"id"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	StartedAt  *time.Time `json:"started_at" db:"started_at"`
+	FinishedAt *time.Time `json:"finished_at" db:"finished_at"`
+	Status     JobStatus  `json:"status" db:"status"`
+	Error      string     `json:"error,omitempty" db:"error"`
+	RequestID  string     `json:"request_id,omitempty" db:"request_id"`
+	Steps      []JobStep  `json:"steps" db:"-"`
+}
+
+// JobStep is a single docker command of a job
+type JobStep struct {
+	ID        int64   `json:"-" db:"id"`
+	JobID     string  `json:"-" db:"job_id"`
+	Position  int     `json:"position" db:"position"`
+	Command   Command `json:"command" db:"command"`
+	Container string  `json:"container" db:"container"`
+	ImageRef  string  `json:"image_ref,omitempty" db:"image_ref"`
+	// Digest is the image digest the image ref was pinned to, for commands which pull an image
+	Digest     string     `json:"digest,omitempty" db:"digest"`
+	Status     JobStatus  `json:"status" db:"status"`
+	Error      string     `json:"error,omitempty" db:"error"`
+	StartedAt  *time.Time `json:"started_at" db:"started_at"`
+	FinishedAt *time.Time `json:"finished_at" db:"finished_at"`
+}
@@ -0,0 +1,64 @@
+package structs
+
+// RuntimeProfile holds the resource limits and security options a module container is created with. The controller
+// has a default profile, any field set in a module's profile overrides it
+type RuntimeProfile struct {
+	// MemoryMB is the memory limit of the container, swap is limited to the same amount so the container cannot swap
+	MemoryMB int64 `json:"memory_mb,omitempty"`
+	// CPUs is the number of CPUs the container can use, e.g. 0.5
+	CPUs float64 `json:"cpus,omitempty"`
+	// PidsLimit is the number of processes and threads the container can run
+	PidsLimit       int64 `json:"pids_limit,omitempty"`
+	ReadOnlyRootFS  *bool `json:"read_only_root_fs,omitempty"`
+	NoNewPrivileges *bool `json:"no_new_privileges,omitempty"`
+	// CapDrop is the kernel capabilities removed from the container, ALL removes every capability
+	CapDrop []string `json:"cap_drop,omitempty"`
+	// CapAdd is the capabilities given back to the container after CapDrop
+	CapAdd []string `json:"cap_add,omitempty"`
+	// Tmpfs is the paths which are mounted as writable in-memory filesystems, e.g. /tmp when the root is read-only
+	Tmpfs []string `json:"tmpfs,omitempty"`
+	// LogMaxSize is the size a container log reaches before it is rotated, e.g. 10m
+	LogMaxSize  string `json:"log_max_size,omitempty"`
+	LogMaxFiles int    `json:"log_max_files,omitempty"`
+	// Privileged is never allowed, it is read so that asking for it is refused rather than ignored
+	Privileged bool `json:"privileged,omitempty"`
+}
+
+// Merge returns the profile with the fields set in override replacing its own
+func (p RuntimeProfile) Merge(override *RuntimeProfile) RuntimeProfile {
+	if override == nil {
+		return p
+	}
+	if override.MemoryMB != 0 {
+		p.MemoryMB = override.MemoryMB
+	}
+	if override.CPUs != 0 {
+		p.CPUs = override.CPUs
+	}
+	if override.PidsLimit != 0 {
+		p.PidsLimit = override.PidsLimit
+	}
+	if override.ReadOnlyRootFS != nil {
+		p.ReadOnlyRootFS = override.ReadOnlyRootFS
+	}
+	if override.NoNewPrivileges != nil {
+		p.NoNewPrivileges = override.NoNewPrivileges
+	}
+	if override.CapDrop != nil {
+		p.CapDrop = override.CapDrop
+	}
+	if override.CapAdd != nil {
+		p.CapAdd = override.CapAdd
+	}
+	if override.Tmpfs != nil {
+		p.Tmpfs = override.Tmpfs
+	}
+	if override.LogMaxSize != "" {
+		p.LogMaxSize = override.LogMaxSize
+	}
+	if override.LogMaxFiles != 0 {
+		p.LogMaxFiles = override.LogMaxFiles
+	}
+	p.Privileged = p.Privileged || override.Privileged
+	return p
+}
@@ -0,0 +1,53 @@
+package structs
+
+import (
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"time"
+)
+
+type UpgradeStatus string
+
+const (
+	UpgradeRunning    UpgradeStatus = "running"
+	UpgradeSucceeded  UpgradeStatus = "succeeded"
+	UpgradeUnchanged  UpgradeStatus = "unchanged"
+	UpgradeRolledBack UpgradeStatus = "rolled_back"
+	UpgradeFailed     UpgradeStatus = "failed"
+)
+
+// Upgrade is an upgrade of a module container from one image to another
+type Upgrade struct {
+	ID                int64         `json:"id" db:"id"`
+	ModuleServiceName string        `json:"module_service_name" db:"module_service_name"`
+	FromImage         string        `json:"from_image" db:"from_image"`
+	FromDigest        string        `json:"from_digest" db:"from_digest"`
+	ToImage           string        `json:"to_image" db:"to_image"`
+	ToDigest          string        `json:"to_digest" db:"to_digest"`
+	Status            UpgradeStatus `json:"status" db:"status"`
+	Error             string        `json:"error,omitempty" db:"error"`
+	RequestID         string        `json:"request_id,omitempty" db:"request_id"`
+	StartedAt         time.Time     `json:"started_at" db:"started_at"`
+	FinishedAt        *time.Time    `json:"finished_at" db:"finished_at"`
+}
+
+// ContainerSnapshot is the config of a container, it is taken before an upgrade so the container can be recreated
+// from another image with the same env, binds and networks
+type ContainerSnapshot struct {
+	Name    string
+	Image   string
+	ImageID string
+	// Digest is the repo digest of the image, e.g. repo@sha256:..., it is empty for images not pulled from a registry
+	Digest     string
+	Config     *container.Config
+	HostConfig *container.HostConfig
+	Networks   map[string]*network.EndpointSettings
+}
+
+// RollbackImage is the image to recreate the container from to roll back to the snapshot
+func (s ContainerSnapshot) RollbackImage() string {
+	if s.Digest != "" {
+		return s.Digest
+	}
+	return s.ImageID
+}
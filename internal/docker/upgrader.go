@@ -0,0 +1,206 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"fp-dynamic-elements-manager-controller/internal/db/persistence"
+	"fp-dynamic-elements-manager-controller/internal/docker/structs"
+	"fp-dynamic-elements-manager-controller/internal/health"
+	healthstructs "fp-dynamic-elements-manager-controller/internal/health/structs"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
+	"fp-dynamic-elements-manager-controller/internal/tracing"
+	"os"
+	"sync"
+	"time"
+)
+
+type UpgradeConfig struct {
+	// HealthTimeout is the time an upgraded module has to re-register and pass its health check before it is
+	// rolled back to its previous image
+	HealthTimeout time.Duration
+	// PollInterval is the time between health checks of the upgraded module
+	PollInterval time.Duration
+}
+
+func DefaultUpgradeConfig() UpgradeConfig {
+	return UpgradeConfig{
+		HealthTimeout: 2 * time.Minute,
+		PollInterval:  2 * time.Second,
+	}
+}
+
+// UpgradeConfigFromEnv reads UPGRADE_HEALTH_TIMEOUT and UPGRADE_POLL_INTERVAL
+func UpgradeConfigFromEnv() UpgradeConfig {
+	cfg := DefaultUpgradeConfig()
+
+	if d, err := time.ParseDuration(os.Getenv("UPGRADE_HEALTH_TIMEOUT")); err == nil && d > 0 {
+		cfg.HealthTimeout = d
+	}
+
+	if d, err := time.ParseDuration(os.Getenv("UPGRADE_POLL_INTERVAL")); err == nil && d > 0 {
+		cfg.PollInterval = d
+	}
+
+	return cfg
+}
+
+// Upgrader upgrades module containers to a new image. The container is recreated from the new image with the same
+// config and the module must re-register and pass its health check, otherwise it is rolled back to the previous image.
+// Every upgrade is recorded in the module's upgrade history. Upgrades need the docker orchestrator
+type Upgrader struct {
+	cfg      UpgradeConfig
+	docker   Dockers
+	modules  persistence.ModuleLookupRepo
+	history  persistence.UpgradeRepo
+	probe    health.Prober
+	verifier *ImageVerifier
+	now      func() time.Time
+
+	mu         sync.Mutex
+	registered map[string]time.Time
+}
+
+func NewUpgrader(cfg UpgradeConfig,
+	docker Dockers,
+	modules persistence.ModuleLookupRepo,
+	history persistence.UpgradeRepo,
+	probe health.Prober,
+	verifier *ImageVerifier) *Upgrader {
+	if probe == nil {
+		probe = health.NewHTTPProber(health.DefaultMonitorConfig().Timeout)
+	}
+	return &Upgrader{
+		cfg:        cfg,
+		docker:     docker,
+		modules:    modules,
+		history:    history,
+		probe:      probe,
+		verifier:   verifier,
+		now:        time.Now,
+		registered: make(map[string]time.Time),
+	}
+}
+
+// Registered records that a module has just registered, an upgraded module must register again before it is healthy
+func (u *Upgrader) Registered(serviceName string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.registered[serviceName] = u.now()
+}
+
+// FailInterruptedUpgrades fails the upgrades which were running when the controller last stopped
+func (u *Upgrader) FailInterruptedUpgrades() {
+	if err := u.history.FailUnfinishedUpgrades("the controller restarted before the upgrade finished", u.now()); err != nil {
+		dockerLog(nil).Error(err, "error failing interrupted module upgrades")
+	}
+}
+
+// Upgrade pulls the image and recreates the module's container from it. An error is returned if the module was not
+// upgraded, the returned upgrade says whether it was rolled back
+func (u *Upgrader) Upgrade(ctx context.Context, serviceName, imageRef string) (structs.Upgrade, error) {
+	if u.docker == nil {
+		return structs.Upgrade{}, ErrDockerOnly
+	}
+	upgrade := structs.Upgrade{
+		ModuleServiceName: serviceName,
+		ToImage:           imageRef,
+		Status:            structs.UpgradeRunning,
+		RequestID:         applog.RequestID(ctx),
+		StartedAt:         u.now(),
+	}
+	id, err := u.history.InsertUpgrade(upgrade)
+	if err != nil {
+		return upgrade, err
+	}
+	upgrade.ID = id
+
+	snapshot, err := u.docker.Snapshot(ctx, serviceName)
+	if err != nil {
+		return u.finish(ctx, upgrade, structs.UpgradeFailed, err)
+	}
+	upgrade.FromImage, upgrade.FromDigest = snapshot.Image, snapshot.Digest
+
+	imageID, digest, err := u.docker.PullImage(ctx, imageRef)
+	if err != nil {
+		return u.finish(ctx, upgrade, structs.UpgradeFailed, err)
+	}
+	upgrade.ToDigest = digest
+	if imageID == snapshot.ImageID {
+		return u.finish(ctx, upgrade, structs.UpgradeUnchanged, nil)
+	}
+
+	// The container is recreated from the digest so it runs the image which was verified
+	pinned, err := u.verifier.Pin(ctx, imageRef, digest)
+	if err != nil {
+		return u.finish(ctx, upgrade, structs.UpgradeFailed, err)
+	}
+
+	since := u.now()
+	if err := u.docker.Recreate(ctx, snapshot, pinned); err != nil {
+		return u.rollback(ctx, upgrade, snapshot, err)
+	}
+	if err := u.waitHealthy(ctx, serviceName, since); err != nil {
+		return u.rollback(ctx, upgrade, snapshot, err)
+	}
+	return u.finish(ctx, upgrade, structs.UpgradeSucceeded, nil)
+}
+
+// rollback recreates the container from the image it ran before the upgrade, this carries on if ctx is cancelled
+func (u *Upgrader) rollback(ctx context.Context, upgrade structs.Upgrade, snapshot structs.ContainerSnapshot, cause error) (structs.Upgrade, error) {
+	dockerLog(applog.Fields{"containerID": upgrade.ModuleServiceName}).WithContext(ctx).Error(cause, "module upgrade failed, rolling back")
+
+	if err := u.docker.Recreate(tracing.Detach(ctx), snapshot, snapshot.RollbackImage()); err != nil {
+		return u.finish(ctx, upgrade, structs.UpgradeFailed, fmt.Errorf("%v, rollback failed: %v", cause, err))
+	}
+	return u.finish(ctx, upgrade, structs.UpgradeRolledBack, cause)
+}
+
+func (u *Upgrader) finish(ctx context.Context, upgrade structs.Upgrade, status structs.UpgradeStatus, err error) (structs.Upgrade, error) {
+	now := u.now()
+	upgrade.Status, upgrade.FinishedAt = status, &now
+	if err != nil {
+		upgrade.Error = err.Error()
+		outcome := "failed"
+		if status == structs.UpgradeRolledBack {
+			outcome = "was rolled back"
+		}
+		err = fmt.Errorf("upgrade of %s %s: %w", upgrade.ModuleServiceName, outcome, err)
+	}
+	u.history.UpdateUpgrade(upgrade)
+
+	dockerLog(applog.Fields{"containerID": upgrade.ModuleServiceName, "image": upgrade.ToImage}).WithContext(ctx).
+		Info(fmt.Sprintf("module upgrade %s", status))
+	return upgrade, err
+}
+
+// waitHealthy waits for the module to register after since and pass its health check
+func (u *Upgrader) waitHealthy(ctx context.Context, serviceName string, since time.Time) error {
+	waitCtx, cancel := context.WithTimeout(ctx, u.cfg.HealthTimeout)
+	defer cancel()
+
+	for {
+		if u.registeredSince(serviceName, since) {
+			module, err := u.modules.GetByServiceName(serviceName)
+			if err == nil && u.probe(module).Status == healthstructs.Healthy {
+				return nil
+			}
+		}
+
+		select {
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				return errors.New("the upgrade was cancelled")
+			}
+			return fmt.Errorf("the module did not re-register and pass its health check within %s", u.cfg.HealthTimeout)
+		case <-time.After(u.cfg.PollInterval):
+		}
+	}
+}
+
+func (u *Upgrader) registeredSince(serviceName string, since time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	at, ok := u.registered[serviceName]
+	return ok && !at.Before(since)
+}
@@ -0,0 +1,128 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fp-dynamic-elements-manager-controller/internal/docker/mocks"
+	"fp-dynamic-elements-manager-controller/internal/docker/structs"
+	healthstructs "fp-dynamic-elements-manager-controller/internal/health/structs"
+	modulestructs "fp-dynamic-elements-manager-controller/internal/modules/structs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type UpgraderTestSuite struct {
+	suite.Suite
+	docker   *mocks.TestDocker
+	modules  *mocks.MockModuleLookupRepo
+	history  *mocks.MockUpgradeRepo
+	snapshot structs.ContainerSnapshot
+	healthy  bool
+}
+
+func TestUpgrader(t *testing.T) {
+	suite.Run(t, new(UpgraderTestSuite))
+}
+
+func (u *UpgraderTestSuite) SetupTest() {
+	u.docker = new(mocks.TestDocker)
+	u.modules = new(mocks.MockModuleLookupRepo)
+	u.history = new(mocks.MockUpgradeRepo)
+	u.healthy = true
+	u.snapshot = structs.ContainerSnapshot{
+		Name:    "fp-ngfw",
+		Image:   "docker.frcpnt.com/fp-dem/fp-ngfw:latest",
+		ImageID: "sha256:old",
+		Digest:  "docker.frcpnt.com/fp-dem/fp-ngfw@sha256:old",
+	}
+
+	u.history.On("InsertUpgrade", mock.Anything).Return(int64(7), nil)
+	u.history.On("UpdateUpgrade", mock.Anything).Return(nil)
+	u.modules.On("GetByServiceName", "fp-ngfw").Return(modulestructs.ModuleMetadata{ModuleServiceName: "fp-ngfw"}, nil)
+	u.docker.On("Snapshot", mock.Anything, "fp-ngfw").Return(u.snapshot, nil)
+}
+
+func (u *UpgraderTestSuite) newUpgrader() *Upgrader {
+	probe := func(module modulestructs.ModuleMetadata) healthstructs.HealthCheck {
+		if u.healthy {
+			return healthstructs.HealthCheck{Status: healthstructs.Healthy}
+		}
+		return healthstructs.HealthCheck{Status: healthstructs.Down}
+	}
+	return NewUpgrader(UpgradeConfig{HealthTimeout: 50 * time.Millisecond, PollInterval: time.Millisecond}, u.docker, u.modules, u.history, probe, nil)
+}
+
+func (u *UpgraderTestSuite) TestUpgrade_Succeeded() {
+	upgrader := u.newUpgrader()
+	u.docker.On("PullImage", mock.Anything, u.snapshot.Image).Return("sha256:new", "docker.frcpnt.com/fp-dem/fp-ngfw@sha256:new", nil)
+	u.docker.On("Recreate", mock.Anything, u.snapshot, "docker.frcpnt.com/fp-dem/fp-ngfw@sha256:new").Run(func(mock.Arguments) {
+		// The new container registers as it starts
+		upgrader.Registered("fp-ngfw")
+	}).Return(nil)
+
+	upgrade, err := upgrader.Upgrade(context.Background(), "fp-ngfw", u.snapshot.Image)
+	u.Nil(err)
+	u.Equal(int64(7), upgrade.ID)
+	u.Equal(structs.UpgradeSucceeded, upgrade.Status)
+	u.Equal(u.snapshot.Digest, upgrade.FromDigest)
+	u.Equal("docker.frcpnt.com/fp-dem/fp-ngfw@sha256:new", upgrade.ToDigest)
+	u.NotNil(upgrade.FinishedAt)
+	u.history.AssertCalled(u.T(), "UpdateUpgrade", upgrade)
+	u.docker.AssertNumberOfCalls(u.T(), "Recreate", 1)
+}
+
+func (u *UpgraderTestSuite) TestUpgrade_Unchanged() {
+	u.docker.On("PullImage", mock.Anything, u.snapshot.Image).Return("sha256:old", u.snapshot.Digest, nil)
+
+	upgrade, err := u.newUpgrader().Upgrade(context.Background(), "fp-ngfw", u.snapshot.Image)
+	u.Nil(err)
+	u.Equal(structs.UpgradeUnchanged, upgrade.Status)
+	u.docker.AssertNotCalled(u.T(), "Recreate", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (u *UpgraderTestSuite) TestUpgrade_RolledBack() {
+	u.docker.On("PullImage", mock.Anything, u.snapshot.Image).Return("sha256:new", "", nil)
+	u.docker.On("Recreate", mock.Anything, u.snapshot, mock.Anything).Return(nil)
+
+	u.T().Run("Test a module which never re-registers is rolled back", func(t *testing.T) {
+		upgrade, err := u.newUpgrader().Upgrade(context.Background(), "fp-ngfw", u.snapshot.Image)
+		assert.NotNil(t, err)
+		assert.Equal(t, structs.UpgradeRolledBack, upgrade.Status)
+		assert.Contains(t, upgrade.Error, "did not re-register")
+		u.docker.AssertCalled(t, "Recreate", mock.Anything, u.snapshot, u.snapshot.Digest)
+	})
+
+	u.T().Run("Test an unhealthy module is rolled back", func(t *testing.T) {
+		u.healthy = false
+		upgrader := u.newUpgrader()
+		upgrader.Registered("fp-ngfw")
+		upgrader.now = func() time.Time { return time.Now().Add(-time.Minute) }
+
+		upgrade, err := upgrader.Upgrade(context.Background(), "fp-ngfw", u.snapshot.Image)
+		assert.NotNil(t, err)
+		assert.Equal(t, structs.UpgradeRolledBack, upgrade.Status)
+	})
+}
+
+func (u *UpgraderTestSuite) TestUpgrade_RollbackFailed() {
+	u.docker.On("PullImage", mock.Anything, u.snapshot.Image).Return("sha256:new", "", nil)
+	u.docker.On("Recreate", mock.Anything, u.snapshot, u.snapshot.Image).Return(errors.New("port is already allocated"))
+	u.docker.On("Recreate", mock.Anything, u.snapshot, u.snapshot.Digest).Return(errors.New("no such image"))
+
+	upgrade, err := u.newUpgrader().Upgrade(context.Background(), "fp-ngfw", u.snapshot.Image)
+	u.NotNil(err)
+	u.Equal(structs.UpgradeFailed, upgrade.Status)
+	u.Equal("port is already allocated, rollback failed: no such image", upgrade.Error)
+}
+
+func (u *UpgraderTestSuite) TestDigestFor() {
+	digests := []string{"mirror.io/fp-ngfw@sha256:aaa", "docker.frcpnt.com:5000/fp-dem/fp-ngfw@sha256:bbb"}
+
+	u.Equal("docker.frcpnt.com:5000/fp-dem/fp-ngfw@sha256:bbb", digestFor("docker.frcpnt.com:5000/fp-dem/fp-ngfw:1.2", digests))
+	u.Equal("docker.frcpnt.com:5000/fp-dem/fp-ngfw@sha256:bbb", digestFor("docker.frcpnt.com:5000/fp-dem/fp-ngfw", digests))
+	u.Equal("mirror.io/fp-ngfw@sha256:aaa", digestFor("other/fp-ngfw:latest", digests))
+	u.Equal("", digestFor("fp-ngfw:latest", nil))
+}
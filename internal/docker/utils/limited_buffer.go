@@ -0,0 +1,25 @@
+package utils
+
+import "bytes"
+
+// LimitedBuffer keeps the first Max bytes written to it and drops the rest, it is used to capture the stderr of
+// commands for their error message
+type LimitedBuffer struct {
+	Max int
+	buf bytes.Buffer
+}
+
+func (l *LimitedBuffer) Write(p []byte) (int, error) {
+	if room := l.Max - l.buf.Len(); room > 0 {
+		if len(p) > room {
+			l.buf.Write(p[:room])
+		} else {
+			l.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func (l *LimitedBuffer) String() string {
+	return l.buf.String()
+}
@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const Redacted = "********"
+
+// secretName matches the names of environment variables which usually hold secrets
+var secretName = regexp.MustCompile(`(?i)(PASS|SECRET|TOKEN|KEY|CREDENTIAL|AUTH|PRIVATE|CERT)`)
+
+// RedactEnv returns a copy of the NAME=value environment with the value of every secret replaced, along with any
+// password held in a URL, e.g. a DB connection string
+func RedactEnv(env []string) []string {
+	redacted := make([]string, len(env))
+	for i, v := range env {
+		kv := strings.SplitN(v, "=", 2)
+		switch {
+		case len(kv) != 2:
+			redacted[i] = v
+		case secretName.MatchString(kv[0]):
+			redacted[i] = kv[0] + "=" + Redacted
+		default:
+			redacted[i] = kv[0] + "=" + redactURL(kv[1])
+		}
+	}
+	return redacted
+}
+
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return value
+	}
+	if _, ok := u.User.Password(); !ok {
+		return value
+	}
+	u.User = url.UserPassword(u.User.Username(), Redacted)
+	return strings.Replace(u.String(), url.QueryEscape(Redacted), Redacted, 1)
+}
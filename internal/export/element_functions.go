@@ -2,8 +2,8 @@ package export
 
 import (
 	"fp-dynamic-elements-manager-controller/internal/db/persistence"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
 	"fp-dynamic-elements-manager-controller/internal/queue/structs"
-	"github.com/rs/zerolog/log"
 	"math"
 )
 
@@ -19,28 +19,28 @@ func BuildPagedResults(page, pageSize int, searchTerm string, safeList bool, dao
 		paginatedResults.Elements, err = dao.ListElementRepo.GetAllPaginated(offset, pageSize, safeList)
 
 		if err != nil {
-			log.Error().Err(err).Msg("Error retrieving paged list elements")
+			applog.System().Error(err, "Error retrieving paged list elements")
 			return paginatedResults
 		}
 
 		totalCount, err = dao.ListElementRepo.GetTotalCount(safeList)
 
 		if err != nil {
-			log.Error().Err(err).Msg("Error retrieving total count list elements")
+			applog.System().Error(err, "Error retrieving total count list elements")
 			return paginatedResults
 		}
 	} else {
 		paginatedResults.Elements, err = dao.ListElementRepo.GetAllLike(offset, pageSize, searchTerm, safeList)
 
 		if err != nil {
-			log.Error().Err(err).Msg("Error retrieving paged list elements with search term")
+			applog.System().Error(err, "Error retrieving paged list elements with search term")
 			return paginatedResults
 		}
 
 		totalCount, err = dao.ListElementRepo.GetTotalCountWhereLike(safeList, searchTerm)
 
 		if err != nil {
-			log.Error().Err(err).Msg("Error retrieving total count list elements with search term")
+			applog.System().Error(err, "Error retrieving total count list elements with search term")
 			return paginatedResults
 		}
 	}
@@ -5,12 +5,10 @@ import (
 	"fmt"
 	"fp-dynamic-elements-manager-controller/internal/db/persistence"
 	"fp-dynamic-elements-manager-controller/internal/health/structs"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
 	structs2 "fp-dynamic-elements-manager-controller/internal/modules/structs"
 	"fp-dynamic-elements-manager-controller/internal/util"
-	"github.com/rs/zerolog/log"
 	"net/http"
-	"sync"
-	"time"
 )
 
 func GetControllerHealth(dao *persistence.DataAccessObject) structs.Health {
@@ -36,43 +34,8 @@ func GetControllerHealth(dao *persistence.DataAccessObject) structs.Health {
 	}
 }
 
-func ModuleHealthCheck(modules []structs2.ModuleMetadata, dao *persistence.DataAccessObject) {
-	wg := sync.WaitGroup{}
-	wg.Add(len(modules))
-	for i := range modules {
-		go func(wait *sync.WaitGroup, module *structs2.ModuleMetadata) {
-			defer wg.Done()
-			getModuleHealth(module, dao)
-		}(&wg, &(modules)[i])
-	}
-	wg.Wait()
-}
-
-func getModuleHealth(module *structs2.ModuleMetadata, dao *persistence.DataAccessObject) {
-	resp, err := util.DimHTTPClient.Get(fmt.Sprintf("http://%s:%s/health", module.ModuleServiceName, module.InternalPort))
-
-	if err != nil {
-		log.Error().Err(err).Msg("Error retrieving module http health")
-		module.ModuleHealth.Status = structs.Down
-		module.ModuleHealth.StatusCode = 0
-		return
-	}
-
-	module.ModuleHealth.ModuleName = module.ModuleDisplayName
-	module.ModuleHealth.StatusCode = resp.StatusCode
-
-	switch resp.StatusCode {
-	case http.StatusOK, http.StatusTeapot:
-		module.ModuleHealth.Status = structs.Healthy
-		module.LastPing = time.Now()
-		module.ModuleEndpoints = nil
-		go dao.ModuleMetadataRepo.UpsertModuleMetadata(*module)
-	case http.StatusNotImplemented:
-		module.ModuleHealth.Status = structs.Unhealthy
-	default:
-		module.ModuleHealth.Status = structs.Down
-	}
-
+// SetLastUpdate sets the time of the last update sent to (egress) or received from (ingress) a module on its health
+func SetLastUpdate(module *structs2.ModuleMetadata, dao *persistence.DataAccessObject) {
 	switch module.ModuleType {
 	case structs2.INGRESS:
 		item, err := dao.ListElementRepo.GetLatestUpdate(module.ModuleServiceName)
@@ -80,7 +43,7 @@ func getModuleHealth(module *structs2.ModuleMetadata, dao *persistence.DataAcces
 			return
 		}
 		if err != nil {
-			log.Error().Err(err).Msg("Error retrieving latest update ingress module health")
+			applog.System().Error(err, "Error retrieving latest update ingress module health")
 			return
 		}
 		if !item.CreatedAt.IsZero() {
@@ -92,7 +55,7 @@ func getModuleHealth(module *structs2.ModuleMetadata, dao *persistence.DataAcces
 			return
 		}
 		if err != nil {
-			log.Error().Err(err).Msg("Error retrieving latest update egress module health")
+			applog.System().Error(err, "Error retrieving latest update egress module health")
 			return
 		}
 		if !updateStatus.CreatedAt.IsZero() {
@@ -104,7 +67,7 @@ func getModuleHealth(module *structs2.ModuleMetadata, dao *persistence.DataAcces
 func IsUp(svcName, port string) bool {
 	resp, err := util.DimHTTPClient.Get(fmt.Sprintf("http://%s:%s/health", svcName, port))
 	if err != nil {
-		log.Error().Err(err).Msg("Error retrieving module isUp")
+		applog.System().Error(err, "Error retrieving module isUp")
 		return false
 	}
 	if resp.StatusCode == http.StatusOK {
@@ -0,0 +1,363 @@
+package health
+
+import (
+	"fmt"
+	"fp-dynamic-elements-manager-controller/internal/db/persistence"
+	"fp-dynamic-elements-manager-controller/internal/health/structs"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
+	structs2 "fp-dynamic-elements-manager-controller/internal/modules/structs"
+	"fp-dynamic-elements-manager-controller/internal/notification"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type MonitorConfig struct {
+	// Interval is the time between health checks of the registered modules
+	Interval time.Duration
+	// Jitter is the maximum random delay before each module is checked so modules are not all probed at once
+	Jitter time.Duration
+	// Timeout is the time allowed for a module to answer its health check
+	Timeout time.Duration
+	// A module which changes status FlapThreshold times within FlapWindow is marked as flapping,
+	// it stays flapping until its status has not changed for a whole FlapWindow
+	FlapWindow    time.Duration
+	FlapThreshold int
+	// HistoryRetention is how long the result of each health check is kept for uptime reports
+	HistoryRetention time.Duration
+}
+
+func DefaultMonitorConfig() MonitorConfig {
+	return MonitorConfig{
+		Interval:         30 * time.Second,
+		Jitter:           5 * time.Second,
+		Timeout:          5 * time.Second,
+		FlapWindow:       10 * time.Minute,
+		FlapThreshold:    4,
+		HistoryRetention: historyWindow,
+	}
+}
+
+// MonitorConfigFromEnv reads the HEALTH_CHECK_*, HEALTH_FLAP_* and HEALTH_HISTORY_RETENTION settings
+func MonitorConfigFromEnv() MonitorConfig {
+	cfg := DefaultMonitorConfig()
+
+	if d, err := time.ParseDuration(os.Getenv("HEALTH_CHECK_INTERVAL")); err == nil && d > 0 {
+		cfg.Interval = d
+	}
+
+	if d, err := time.ParseDuration(os.Getenv("HEALTH_CHECK_JITTER")); err == nil {
+		cfg.Jitter = d
+	}
+
+	if d, err := time.ParseDuration(os.Getenv("HEALTH_CHECK_TIMEOUT")); err == nil && d > 0 {
+		cfg.Timeout = d
+	}
+
+	if d, err := time.ParseDuration(os.Getenv("HEALTH_FLAP_WINDOW")); err == nil {
+		cfg.FlapWindow = d
+	}
+
+	if n, err := strconv.Atoi(os.Getenv("HEALTH_FLAP_THRESHOLD")); err == nil {
+		cfg.FlapThreshold = n
+	}
+
+	if d, err := time.ParseDuration(os.Getenv("HEALTH_HISTORY_RETENTION")); err == nil && d > 0 {
+		cfg.HistoryRetention = d
+	}
+
+	return cfg
+}
+
+// Prober checks the health of a single module, the returned check holds the status, status code and latency
+type Prober func(module structs2.ModuleMetadata) structs.HealthCheck
+
+// NewHTTPProber returns a Prober which calls the /health endpoint of a module
+func NewHTTPProber(timeout time.Duration) Prober {
+	client := &http.Client{Timeout: timeout}
+
+	return func(module structs2.ModuleMetadata) structs.HealthCheck {
+		check := structs.HealthCheck{ModuleServiceName: module.ModuleServiceName, Status: structs.Down}
+		start := time.Now()
+		resp, err := client.Get(fmt.Sprintf("http://%s:%s/health", module.ModuleServiceName, module.InternalPort))
+		check.LatencyMs = time.Since(start).Milliseconds()
+		if err != nil {
+			return check
+		}
+		resp.Body.Close()
+		check.Status = statusFromCode(resp.StatusCode)
+		check.StatusCode = resp.StatusCode
+		return check
+	}
+}
+
+func statusFromCode(code int) structs.HealthStatus {
+	switch code {
+	case http.StatusOK, http.StatusTeapot:
+		return structs.Healthy
+	case http.StatusNotImplemented:
+		return structs.Unhealthy
+	}
+	return structs.Down
+}
+
+type moduleState struct {
+	health structs.ModuleHealth
+	// observed is the status returned by the last check, this can differ from health.Status while flapping
+	observed structs.HealthStatus
+	// changes holds the times the observed status changed within the flap window
+	changes []time.Time
+}
+
+// Monitor checks the health of every registered module in the background and caches the result so that reading
+// module health never waits on a module. Status changes are persisted and sent to clients as notifications
+type Monitor struct {
+	cfg           MonitorConfig
+	modules       persistence.MonitoredModuleRepo
+	history       persistence.HealthHistoryRepo
+	notifications notification.Service
+	probe         Prober
+	now           func() time.Time
+
+	mu        sync.RWMutex
+	states    map[string]*moduleState
+	lastPurge time.Time
+	trigger   chan struct{}
+	doneChan  chan struct{}
+}
+
+func NewMonitor(cfg MonitorConfig,
+	modules persistence.MonitoredModuleRepo,
+	history persistence.HealthHistoryRepo,
+	notifications notification.Service,
+	probe Prober) *Monitor {
+	if probe == nil {
+		probe = NewHTTPProber(cfg.Timeout)
+	}
+	return &Monitor{
+		cfg:           cfg,
+		modules:       modules,
+		history:       history,
+		notifications: notifications,
+		probe:         probe,
+		now:           time.Now,
+		states:        make(map[string]*moduleState),
+		trigger:       make(chan struct{}, 1),
+		doneChan:      make(chan struct{}),
+	}
+}
+
+// Start restores the last known status of each module then checks every module each interval until Stop is called
+func (m *Monitor) Start() {
+	m.restore()
+
+	go func() {
+		for {
+			m.CheckAll()
+			select {
+			case <-m.trigger:
+			case <-time.After(m.cfg.Interval):
+			case <-m.doneChan:
+				return
+			}
+		}
+	}()
+}
+
+func (m *Monitor) Stop() {
+	close(m.doneChan)
+}
+
+// Trigger starts the next round of checks straight away, e.g. when a module has just registered
+func (m *Monitor) Trigger() {
+	select {
+	case m.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Health returns the cached health of a module, modules which have not been checked yet are Unknown
+func (m *Monitor) Health(serviceName string) structs.ModuleHealth {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if state, ok := m.states[serviceName]; ok {
+		return state.health
+	}
+	return structs.ModuleHealth{Status: structs.Unknown}
+}
+
+// CheckAll checks every registered module once, modules are checked concurrently after a random delay of up to Jitter
+func (m *Monitor) CheckAll() {
+	modules, err := m.modules.GetAll()
+	if err != nil {
+		applog.System().Error(err, "error retrieving modules for health check")
+		return
+	}
+
+	m.prune(modules)
+	m.purgeHistory()
+
+	wg := sync.WaitGroup{}
+	wg.Add(len(modules))
+	for _, module := range modules {
+		go func(module structs2.ModuleMetadata) {
+			defer wg.Done()
+			if m.cfg.Jitter > 0 {
+				time.Sleep(time.Duration(rand.Int63n(int64(m.cfg.Jitter))))
+			}
+			m.record(module, m.probe(module))
+		}(module)
+	}
+	wg.Wait()
+}
+
+func (m *Monitor) restore() {
+	transitions, err := m.history.GetLatestTransitions()
+	if err != nil {
+		applog.System().Error(err, "error retrieving last known module health")
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range transitions {
+		since := t.CreatedAt
+		m.states[t.ModuleServiceName] = &moduleState{
+			health:   structs.ModuleHealth{Status: t.ToStatus, StatusCode: t.StatusCode, Since: &since},
+			observed: t.ToStatus,
+		}
+	}
+}
+
+// prune drops the state of modules which are no longer registered
+func (m *Monitor) prune(modules []structs2.ModuleMetadata) {
+	registered := make(map[string]struct{}, len(modules))
+	for _, module := range modules {
+		registered[module.ModuleServiceName] = struct{}{}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for name := range m.states {
+		if _, ok := registered[name]; !ok {
+			delete(m.states, name)
+		}
+	}
+}
+
+// purgeHistory deletes health checks which are older than the retention period, this is done at most once an hour
+func (m *Monitor) purgeHistory() {
+	now := m.now()
+	if now.Sub(m.lastPurge) < time.Hour {
+		return
+	}
+	m.lastPurge = now
+	m.history.DeleteChecksBefore(now.Add(-m.cfg.HistoryRetention))
+}
+
+func (m *Monitor) record(module structs2.ModuleMetadata, check structs.HealthCheck) {
+	now := m.now()
+	observed, code := check.Status, check.StatusCode
+
+	check.CreatedAt = now
+	check.ModuleServiceName = module.ModuleServiceName
+	m.history.InsertCheck(check)
+
+	if observed == structs.Healthy {
+		if err := m.modules.UpdateLastPing(module.ModuleServiceName, now); err != nil {
+			applog.System().WithFields(applog.Fields{"module": module.ModuleServiceName}).Error(err, "error updating module last ping")
+		}
+	}
+
+	m.mu.Lock()
+	state, ok := m.states[module.ModuleServiceName]
+	if !ok {
+		state = &moduleState{health: structs.ModuleHealth{Status: structs.Unknown}, observed: structs.Unknown}
+		m.states[module.ModuleServiceName] = state
+	}
+
+	from := state.health.Status
+	to := m.nextStatus(state, observed, now)
+
+	state.health.ModuleName = module.ModuleDisplayName
+	state.health.StatusCode = code
+	state.health.LastChecked = &now
+	if to != from {
+		state.health.Status = to
+		state.health.Since = &now
+	}
+	m.mu.Unlock()
+
+	if to != from {
+		m.transition(module, from, to, code, now)
+	}
+}
+
+// nextStatus is the state machine for a module, the status follows the observed status unless it has changed
+// FlapThreshold times within FlapWindow, the module is then flapping until the observed status settles
+func (m *Monitor) nextStatus(state *moduleState, observed structs.HealthStatus, now time.Time) structs.HealthStatus {
+	if state.observed != structs.Unknown && observed != state.observed {
+		state.changes = append(state.changes, now)
+	}
+	state.observed = observed
+
+	cutoff := now.Add(-m.cfg.FlapWindow)
+	i := 0
+	for i < len(state.changes) && state.changes[i].Before(cutoff) {
+		i++
+	}
+	state.changes = state.changes[i:]
+
+	if state.health.Status == structs.Flapping {
+		if len(state.changes) > 0 {
+			return structs.Flapping
+		}
+		return observed
+	}
+
+	if m.cfg.FlapThreshold > 0 && len(state.changes) >= m.cfg.FlapThreshold {
+		return structs.Flapping
+	}
+
+	return observed
+}
+
+func (m *Monitor) transition(module structs2.ModuleMetadata, from, to structs.HealthStatus, code int, at time.Time) {
+	// The repo logs its own errors, a failed insert only loses history so the notification is still sent
+	m.history.InsertTransition(structs.HealthTransition{
+		CreatedAt:         at,
+		ModuleServiceName: module.ModuleServiceName,
+		FromStatus:        from,
+		ToStatus:          to,
+		StatusCode:        code,
+	})
+
+	// There is nothing to tell anyone when a module is first seen and is healthy
+	if from == structs.Unknown && to == structs.Healthy {
+		return
+	}
+
+	eventType := notification.Warning
+	switch to {
+	case structs.Healthy:
+		eventType = notification.Success
+	case structs.Down:
+		eventType = notification.Error
+	}
+
+	m.notifications.Send(notification.Event{
+		EventType: eventType,
+		Value:     fmt.Sprintf("Module %s is %s", module.ModuleDisplayName, to),
+		Context: notification.EventContext{
+			Type:       notification.Module,
+			Identifier: module.ModuleServiceName,
+			State:      notification.State(to.String()),
+		},
+	})
+}
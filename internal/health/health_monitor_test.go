@@ -0,0 +1,148 @@
+package health
+
+import (
+	"fp-dynamic-elements-manager-controller/internal/health/mocks"
+	"fp-dynamic-elements-manager-controller/internal/health/structs"
+	structs2 "fp-dynamic-elements-manager-controller/internal/modules/structs"
+	"fp-dynamic-elements-manager-controller/internal/notification"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type HealthMonitorTestSuite struct {
+	suite.Suite
+	modules *mocks.MockModuleRepo
+	history *mocks.MockHistoryRepo
+	ns      *mocks.NSMock
+	status  structs.HealthStatus
+	now     time.Time
+	monitor *Monitor
+}
+
+func TestHealthMonitor(t *testing.T) {
+	suite.Run(t, new(HealthMonitorTestSuite))
+}
+
+var testModule = structs2.ModuleMetadata{ModuleServiceName: "fp-dep", ModuleDisplayName: "Forcepoint DEP", InternalPort: "8080"}
+
+func (h *HealthMonitorTestSuite) SetupTest() {
+	h.modules = new(mocks.MockModuleRepo)
+	h.modules.On("GetAll").Return([]structs2.ModuleMetadata{testModule}, nil)
+	h.modules.On("UpdateLastPing", "fp-dep", mock.Anything).Return(nil)
+	h.history = new(mocks.MockHistoryRepo)
+	h.history.On("InsertTransition", mock.Anything).Return(nil)
+	h.history.On("InsertCheck", mock.Anything).Return(nil)
+	h.history.On("DeleteChecksBefore", mock.Anything).Return(nil)
+	h.ns = new(mocks.NSMock)
+	h.ns.On("Send", mock.Anything).Return()
+
+	cfg := DefaultMonitorConfig()
+	cfg.Jitter = 0
+	cfg.FlapThreshold = 3
+
+	h.now = time.Now()
+	h.monitor = NewMonitor(cfg, h.modules, h.history, h.ns, func(structs2.ModuleMetadata) structs.HealthCheck {
+		if h.status == structs.Healthy {
+			return structs.HealthCheck{Status: h.status, StatusCode: 200, LatencyMs: 12}
+		}
+		return structs.HealthCheck{Status: h.status}
+	})
+	h.monitor.now = func() time.Time { return h.now }
+}
+
+// check runs a round of checks a minute after the last one with the module returning the given status
+func (h *HealthMonitorTestSuite) check(status structs.HealthStatus) structs.HealthStatus {
+	h.now = h.now.Add(time.Minute)
+	h.status = status
+	h.monitor.CheckAll()
+	return h.monitor.Health("fp-dep").Status
+}
+
+func (h *HealthMonitorTestSuite) sent(state notification.State) int {
+	n := 0
+	for _, call := range h.ns.Calls {
+		if call.Arguments.Get(0).(notification.Event).Context.State == state {
+			n++
+		}
+	}
+	return n
+}
+
+func (h *HealthMonitorTestSuite) TestMonitor_Transitions() {
+	h.T().Run("Test unchecked module is unknown", func(t *testing.T) {
+		assert.Equal(t, structs.Unknown, h.monitor.Health("fp-dep").Status)
+	})
+
+	h.T().Run("Test first healthy check is recorded quietly", func(t *testing.T) {
+		assert.Equal(t, structs.Healthy, h.check(structs.Healthy))
+		h.history.AssertCalled(t, "InsertTransition", structs.HealthTransition{
+			CreatedAt: h.now, ModuleServiceName: "fp-dep", FromStatus: structs.Unknown, ToStatus: structs.Healthy, StatusCode: 200,
+		})
+		h.modules.AssertCalled(t, "UpdateLastPing", "fp-dep", h.now)
+		h.history.AssertCalled(t, "InsertCheck", structs.HealthCheck{
+			CreatedAt: h.now, ModuleServiceName: "fp-dep", Status: structs.Healthy, StatusCode: 200, LatencyMs: 12,
+		})
+		h.ns.AssertNotCalled(t, "Send", mock.Anything)
+	})
+
+	h.T().Run("Test unchanged status is not recorded again", func(t *testing.T) {
+		h.check(structs.Healthy)
+		h.history.AssertNumberOfCalls(t, "InsertTransition", 1)
+		h.history.AssertNumberOfCalls(t, "InsertCheck", 2)
+		h.history.AssertNumberOfCalls(t, "DeleteChecksBefore", 1)
+	})
+
+	h.T().Run("Test module going down is notified", func(t *testing.T) {
+		assert.Equal(t, structs.Down, h.check(structs.Down))
+		assert.Equal(t, 1, h.sent(notification.Down))
+		health := h.monitor.Health("fp-dep")
+		assert.Equal(t, h.now, *health.Since)
+		assert.Equal(t, "Forcepoint DEP", health.ModuleName)
+	})
+}
+
+func (h *HealthMonitorTestSuite) TestMonitor_Flapping() {
+	h.check(structs.Healthy)
+	h.check(structs.Down)
+	h.check(structs.Healthy)
+
+	h.T().Run("Test repeated changes mark the module flapping", func(t *testing.T) {
+		assert.Equal(t, structs.Flapping, h.check(structs.Down))
+		assert.Equal(t, 1, h.sent(notification.Flapping))
+	})
+
+	h.T().Run("Test module stays flapping until it settles", func(t *testing.T) {
+		recovered := h.sent(notification.Healthy)
+		assert.Equal(t, structs.Flapping, h.check(structs.Healthy))
+		for i := 0; i < 10; i++ {
+			assert.Equal(t, structs.Flapping, h.check(structs.Healthy))
+		}
+		assert.Equal(t, structs.Healthy, h.check(structs.Healthy))
+		assert.Equal(t, recovered+1, h.sent(notification.Healthy))
+	})
+}
+
+func (h *HealthMonitorTestSuite) TestMonitor_RestoreAndPrune() {
+	since := h.now.Add(-time.Hour)
+	h.history.On("GetLatestTransitions").Return([]structs.HealthTransition{
+		{ModuleServiceName: "fp-dep", ToStatus: structs.Down, CreatedAt: since},
+		{ModuleServiceName: "removed", ToStatus: structs.Healthy, CreatedAt: since},
+	}, nil)
+
+	h.monitor.restore()
+
+	h.T().Run("Test last known status is restored", func(t *testing.T) {
+		assert.Equal(t, structs.Down, h.monitor.Health("fp-dep").Status)
+		assert.Equal(t, structs.Healthy, h.monitor.Health("removed").Status)
+	})
+
+	h.T().Run("Test unregistered modules are dropped", func(t *testing.T) {
+		h.check(structs.Down)
+		assert.Equal(t, structs.Unknown, h.monitor.Health("removed").Status)
+		assert.Equal(t, since, *h.monitor.Health("fp-dep").Since)
+		h.history.AssertNotCalled(t, "InsertTransition", mock.Anything)
+	})
+}
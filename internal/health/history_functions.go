@@ -0,0 +1,119 @@
+package health
+
+import (
+	"fp-dynamic-elements-manager-controller/internal/db/persistence"
+	"fp-dynamic-elements-manager-controller/internal/health/structs"
+	"math"
+	"time"
+)
+
+// historyWindow is the longest period an uptime report covers, it is also the default retention of health checks
+const historyWindow = 30 * 24 * time.Hour
+
+// GetHealthHistory builds the uptime report of a module from its health checks over the last 30 days
+func GetHealthHistory(serviceName string, repo persistence.HealthHistoryRepo, now time.Time) (structs.HealthHistory, error) {
+	checks, err := repo.GetChecksSince(serviceName, now.Add(-historyWindow))
+
+	if err != nil {
+		return structs.HealthHistory{}, err
+	}
+
+	return structs.HealthHistory{
+		ModuleServiceName: serviceName,
+		Uptime24h:         uptime(checks, now.Add(-24*time.Hour), now),
+		Uptime7d:          uptime(checks, now.Add(-7*24*time.Hour), now),
+		Uptime30d:         uptime(checks, now.Add(-historyWindow), now),
+		AverageLatencyMs:  averageLatency(checks, now.Add(-24*time.Hour)),
+		Outages:           outages(checks, now),
+	}, nil
+}
+
+// uptime returns the percentage of time between from and now that the module was healthy. The result of each check
+// is taken to hold until the next check, time before the first check is not counted
+func uptime(checks []structs.HealthCheck, from, now time.Time) *float64 {
+	var up, total time.Duration
+	var last *structs.HealthCheck
+
+	for i := range checks {
+		start, end := checks[i].CreatedAt, now
+		if i+1 < len(checks) {
+			end = checks[i+1].CreatedAt
+		}
+		if !end.After(from) {
+			continue
+		}
+		if start.Before(from) {
+			start = from
+		}
+		last = &checks[i]
+
+		total += end.Sub(start)
+		if checks[i].Status == structs.Healthy {
+			up += end.Sub(start)
+		}
+	}
+
+	if last == nil {
+		return nil
+	}
+
+	pct := 0.0
+	if total > 0 {
+		pct = math.Round(float64(up)/float64(total)*10000) / 100
+	} else if last.Status == structs.Healthy {
+		pct = 100
+	}
+	return &pct
+}
+
+// averageLatency returns the mean latency of the checks since the given time which got a response from the module
+func averageLatency(checks []structs.HealthCheck, from time.Time) *int64 {
+	var sum, n int64
+	for _, c := range checks {
+		if c.CreatedAt.Before(from) || c.StatusCode == 0 {
+			continue
+		}
+		sum += c.LatencyMs
+		n++
+	}
+
+	if n == 0 {
+		return nil
+	}
+
+	avg := sum / n
+	return &avg
+}
+
+// outages groups consecutive unhealthy checks into outages, oldest first. An outage ends at the next healthy check
+// and its status is the worst status seen during it
+func outages(checks []structs.HealthCheck, now time.Time) []structs.Outage {
+	out := []structs.Outage{}
+	var current *structs.Outage
+
+	for _, c := range checks {
+		if c.Status != structs.Healthy {
+			if current == nil {
+				current = &structs.Outage{Start: c.CreatedAt, Status: c.Status}
+			} else if c.Status < current.Status {
+				current.Status = c.Status
+			}
+			continue
+		}
+
+		if current != nil {
+			end := c.CreatedAt
+			current.End = &end
+			current.Seconds = int64(end.Sub(current.Start).Seconds())
+			out = append(out, *current)
+			current = nil
+		}
+	}
+
+	if current != nil {
+		current.Seconds = int64(now.Sub(current.Start).Seconds())
+		out = append(out, *current)
+	}
+
+	return out
+}
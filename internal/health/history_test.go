@@ -0,0 +1,80 @@
+package health
+
+import (
+	"fp-dynamic-elements-manager-controller/internal/health/mocks"
+	"fp-dynamic-elements-manager-controller/internal/health/structs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type HealthHistoryTestSuite struct {
+	suite.Suite
+}
+
+func TestHealthHistory(t *testing.T) {
+	suite.Run(t, new(HealthHistoryTestSuite))
+}
+
+func check(at time.Time, status structs.HealthStatus, latency int64) structs.HealthCheck {
+	code := 0
+	switch status {
+	case structs.Healthy:
+		code = 200
+	case structs.Unhealthy:
+		code = 501
+	}
+	return structs.HealthCheck{CreatedAt: at, ModuleServiceName: "fp-ngfw", Status: status, StatusCode: code, LatencyMs: latency}
+}
+
+func (h *HealthHistoryTestSuite) TestGetHealthHistory() {
+	now := time.Date(2020, 10, 19, 12, 0, 0, 0, time.UTC)
+	checks := []structs.HealthCheck{
+		check(now.Add(-10*24*time.Hour), structs.Healthy, 10),
+		// Down for a day, 9 days ago
+		check(now.Add(-9*24*time.Hour), structs.Down, 0),
+		check(now.Add(-8*24*time.Hour), structs.Healthy, 10),
+		// Unhealthy then down for 6 hours, ongoing
+		check(now.Add(-6*time.Hour), structs.Unhealthy, 30),
+		check(now.Add(-3*time.Hour), structs.Down, 0),
+	}
+
+	repo := new(mocks.MockHistoryRepo)
+	repo.On("GetChecksSince", "fp-ngfw", now.Add(-historyWindow)).Return(checks, nil)
+
+	history, err := GetHealthHistory("fp-ngfw", repo, now)
+	assert.Nil(h.T(), err)
+
+	h.T().Run("Test uptime over each window", func(t *testing.T) {
+		assert.Equal(t, 75.0, *history.Uptime24h)
+		assert.Equal(t, 96.43, *history.Uptime7d)
+		assert.Equal(t, 87.5, *history.Uptime30d)
+	})
+
+	h.T().Run("Test latency ignores checks without a response", func(t *testing.T) {
+		assert.Equal(t, int64(30), *history.AverageLatencyMs)
+	})
+
+	h.T().Run("Test outages are grouped", func(t *testing.T) {
+		assert.Len(t, history.Outages, 2)
+		assert.Equal(t, int64(24*60*60), history.Outages[0].Seconds)
+		assert.Equal(t, structs.Down, history.Outages[0].Status)
+		assert.Nil(t, history.Outages[1].End)
+		assert.Equal(t, int64(6*60*60), history.Outages[1].Seconds)
+		assert.Equal(t, structs.Down, history.Outages[1].Status)
+	})
+}
+
+func (h *HealthHistoryTestSuite) TestGetHealthHistory_NoChecks() {
+	now := time.Now()
+	repo := new(mocks.MockHistoryRepo)
+	repo.On("GetChecksSince", "fp-ngfw", now.Add(-historyWindow)).Return([]structs.HealthCheck{}, nil)
+
+	history, err := GetHealthHistory("fp-ngfw", repo, now)
+	assert.Nil(h.T(), err)
+	assert.Nil(h.T(), history.Uptime24h)
+	assert.Nil(h.T(), history.Uptime30d)
+	assert.Nil(h.T(), history.AverageLatencyMs)
+	assert.Empty(h.T(), history.Outages)
+}
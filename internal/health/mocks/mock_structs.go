@@ -0,0 +1,69 @@
+package mocks
+
+import (
+	"fp-dynamic-elements-manager-controller/internal/health/structs"
+	structs2 "fp-dynamic-elements-manager-controller/internal/modules/structs"
+	"fp-dynamic-elements-manager-controller/internal/notification"
+	"github.com/stretchr/testify/mock"
+	"time"
+)
+
+type MockModuleRepo struct {
+	mock.Mock
+}
+
+func (r *MockModuleRepo) GetAll() ([]structs2.ModuleMetadata, error) {
+	args := r.Called()
+	return args.Get(0).([]structs2.ModuleMetadata), args.Error(1)
+}
+
+func (r *MockModuleRepo) UpdateLastPing(svcName string, t time.Time) error {
+	args := r.Called(svcName, t)
+	return args.Error(0)
+}
+
+type MockHistoryRepo struct {
+	mock.Mock
+}
+
+func (r *MockHistoryRepo) InsertTransition(t structs.HealthTransition) error {
+	args := r.Called(t)
+	return args.Error(0)
+}
+
+func (r *MockHistoryRepo) GetLatestTransitions() ([]structs.HealthTransition, error) {
+	args := r.Called()
+	return args.Get(0).([]structs.HealthTransition), args.Error(1)
+}
+
+func (r *MockHistoryRepo) InsertCheck(c structs.HealthCheck) error {
+	args := r.Called(c)
+	return args.Error(0)
+}
+
+func (r *MockHistoryRepo) GetChecksSince(svcName string, since time.Time) ([]structs.HealthCheck, error) {
+	args := r.Called(svcName, since)
+	return args.Get(0).([]structs.HealthCheck), args.Error(1)
+}
+
+func (r *MockHistoryRepo) DeleteChecksBefore(before time.Time) error {
+	args := r.Called(before)
+	return args.Error(0)
+}
+
+type NSMock struct {
+	mock.Mock
+}
+
+func (n *NSMock) Receive() {
+	n.Called()
+}
+
+func (n *NSMock) Send(event notification.Event) {
+	n.Called(event)
+}
+
+func (n *NSMock) Hub() *notification.Hub {
+	n.Called()
+	return nil
+}
@@ -1,19 +1,79 @@
 package structs
 
+import "time"
+
 type HealthStatus int
 
 const (
 	Down HealthStatus = iota - 1
 	Unhealthy
 	Healthy
+	// Flapping is set by the health monitor when a module changes state too often to be trusted
+	Flapping
+	// Unknown is used for modules which the health monitor has not checked yet
+	Unknown
 )
 
+func (h HealthStatus) String() string {
+	switch h {
+	case Down:
+		return "down"
+	case Unhealthy:
+		return "unhealthy"
+	case Healthy:
+		return "healthy"
+	case Flapping:
+		return "flapping"
+	}
+	return "unknown"
+}
+
 type Health struct {
 	Modules []ModuleHealth `json:"modules"`
 }
 type ModuleHealth struct {
-	ModuleName string       `json:"module_name"`
-	Status     HealthStatus `json:"status"`
-	StatusCode int          `json:"status_code"`
-	LastUpdate string       `json:"last_update"`
+	ModuleName  string       `json:"module_name"`
+	Status      HealthStatus `json:"status"`
+	StatusCode  int          `json:"status_code"`
+	LastUpdate  string       `json:"last_update"`
+	LastChecked *time.Time   `json:"last_checked,omitempty"`
+	Since       *time.Time   `json:"since,omitempty"`
+}
+
+// HealthTransition is recorded every time the health monitor changes the status of a module
+type HealthTransition struct {
+	ID                int64        `json:"id" db:"id"`
+	CreatedAt         time.Time    `json:"created_at" db:"created_at"`
+	ModuleServiceName string       `json:"module_service_name" db:"module_service_name"`
+	FromStatus        HealthStatus `json:"from_status" db:"from_status"`
+	ToStatus          HealthStatus `json:"to_status" db:"to_status"`
+	StatusCode        int          `json:"status_code" db:"status_code"`
+}
+
+// HealthCheck is the result of a single health check of a module made by the health monitor
+type HealthCheck struct {
+	ID                int64        `json:"id" db:"id"`
+	CreatedAt         time.Time    `json:"created_at" db:"created_at"`
+	ModuleServiceName string       `json:"module_service_name" db:"module_service_name"`
+	Status            HealthStatus `json:"status" db:"status"`
+	StatusCode        int          `json:"status_code" db:"status_code"`
+	LatencyMs         int64        `json:"latency_ms" db:"latency_ms"`
+}
+
+// HealthHistory is the uptime report of a module, uptimes are percentages and are nil when there were no checks
+type HealthHistory struct {
+	ModuleServiceName string   `json:"module_service_name"`
+	Uptime24h         *float64 `json:"uptime_24h"`
+	Uptime7d          *float64 `json:"uptime_7d"`
+	Uptime30d         *float64 `json:"uptime_30d"`
+	AverageLatencyMs  *int64   `json:"average_latency_ms"`
+	Outages           []Outage `json:"outages"`
+}
+
+// Outage is a period where a module was not healthy, End is nil while the outage is ongoing
+type Outage struct {
+	Start   time.Time    `json:"start"`
+	End     *time.Time   `json:"end"`
+	Seconds int64        `json:"seconds"`
+	Status  HealthStatus `json:"status"`
 }
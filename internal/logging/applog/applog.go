@@ -0,0 +1,209 @@
+package applog
+
+import (
+	"context"
+	"errors"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/api/trace"
+	"sync/atomic"
+)
+
+const (
+	RequestIDHeader = "X-Request-Id"
+	RequestIDField  = "request_id"
+	TraceIDField    = "trace_id"
+)
+
+type requestIDKey struct{}
+
+// Fields are structured key/value pairs added to a log entry
+type Fields map[string]interface{}
+
+// Logger is the logging facade used throughout the controller, every logger writes structured entries and can carry
+// fields, e.g. the module or container an entry relates to and the ID of the request which caused it
+type Logger interface {
+	Panic(string)
+	Fatal(error, string)
+	Error(error, string)
+	Warn(string)
+	Info(string)
+	Debug(string)
+	Trace(string)
+	// WithFields returns a logger which adds the fields to every entry
+	WithFields(Fields) Logger
+	// WithContext returns a logger which adds the request and trace IDs held in ctx to every entry
+	WithContext(context.Context) Logger
+}
+
+// Redactor removes secrets from text, every message, error and string field is passed through it before it is logged
+type Redactor interface {
+	Redact(string) string
+}
+
+type redactorHolder struct {
+	Redactor
+}
+
+var redactor atomic.Value
+
+// SetRedactor sets the redactor applied to every entry written from then on
+func SetRedactor(r Redactor) {
+	redactor.Store(redactorHolder{r})
+}
+
+// Redact applies the redactor to text, e.g. an entry written to the log table without going through a logger
+func Redact(text string) string {
+	if holder, ok := redactor.Load().(redactorHolder); ok && holder.Redactor != nil {
+		return holder.Redact(text)
+	}
+	return text
+}
+
+func redactErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if text := Redact(err.Error()); text != err.Error() {
+		return errors.New(text)
+	}
+	return err
+}
+
+func redactFields(fields Fields) Fields {
+	redacted := make(Fields, len(fields))
+	for k, v := range fields {
+		switch value := v.(type) {
+		case string:
+			redacted[k] = Redact(value)
+		case error:
+			redacted[k] = redactErr(value)
+		default:
+			redacted[k] = v
+		}
+	}
+	return redacted
+}
+
+// ContextWithRequestID returns a context holding the ID of the request being served
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID of the request held in ctx, or an empty string if there is none
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func contextFields(ctx context.Context) Fields {
+	fields := Fields{}
+	if id := RequestID(ctx); id != "" {
+		fields[RequestIDField] = id
+	}
+	if sc := trace.SpanFromContext(ctx).SpanContext(); sc.IsValid() {
+		fields[TraceIDField] = sc.TraceID.String()
+	}
+	return fields
+}
+
+// System returns the logger for developer facing messages, these are written to the console only
+func System() Logger {
+	return &SystemLogger{log: log.Logger}
+}
+
+// User returns the logger for end user facing messages, entries at info level and above are also written to the
+// log table and so can be read back on /api/logs
+func User() Logger {
+	return &UserLogger{entry: logrus.NewEntry(logrus.StandardLogger())}
+}
+
+type SystemLogger struct {
+	log zerolog.Logger
+}
+
+func (s *SystemLogger) Panic(msg string) {
+	s.log.Panic().Msg(Redact(msg))
+}
+
+func (s *SystemLogger) Fatal(err error, msg string) {
+	s.log.Fatal().Err(redactErr(err)).Msg(Redact(msg))
+}
+
+func (s *SystemLogger) Error(err error, msg string) {
+	s.log.Error().Err(redactErr(err)).Msg(Redact(msg))
+}
+
+func (s *SystemLogger) Warn(msg string) {
+	s.log.Warn().Msg(Redact(msg))
+}
+
+func (s *SystemLogger) Info(msg string) {
+	s.log.Info().Msg(Redact(msg))
+}
+
+func (s *SystemLogger) Debug(msg string) {
+	s.log.Debug().Msg(Redact(msg))
+}
+
+func (s *SystemLogger) Trace(msg string) {
+	s.log.Trace().Msg(Redact(msg))
+}
+
+func (s *SystemLogger) WithFields(fields Fields) Logger {
+	if len(fields) == 0 {
+		return s
+	}
+	return &SystemLogger{log: s.log.With().Fields(map[string]interface{}(redactFields(fields))).Logger()}
+}
+
+func (s *SystemLogger) WithContext(ctx context.Context) Logger {
+	return s.WithFields(contextFields(ctx))
+}
+
+type UserLogger struct {
+	entry *logrus.Entry
+}
+
+func (u *UserLogger) Panic(msg string) {
+	u.entry.Panic(Redact(msg))
+}
+
+func (u *UserLogger) Fatal(err error, msg string) {
+	u.entry.WithError(redactErr(err)).Fatal(Redact(msg))
+}
+
+func (u *UserLogger) Error(err error, msg string) {
+	u.entry.WithError(redactErr(err)).Error(Redact(msg))
+}
+
+func (u *UserLogger) Warn(msg string) {
+	u.entry.Warn(Redact(msg))
+}
+
+func (u *UserLogger) Info(msg string) {
+	u.entry.Info(Redact(msg))
+}
+
+func (u *UserLogger) Debug(msg string) {
+	u.entry.Debug(Redact(msg))
+}
+
+func (u *UserLogger) Trace(msg string) {
+	u.entry.Trace(Redact(msg))
+}
+
+func (u *UserLogger) WithFields(fields Fields) Logger {
+	if len(fields) == 0 {
+		return u
+	}
+	return &UserLogger{entry: u.entry.WithFields(logrus.Fields(redactFields(fields)))}
+}
+
+func (u *UserLogger) WithContext(ctx context.Context) Logger {
+	return u.WithFields(contextFields(ctx))
+}
@@ -0,0 +1,100 @@
+package applog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/rs/zerolog"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"strings"
+	"testing"
+)
+
+type AppLogTestSuite struct {
+	suite.Suite
+}
+
+func TestAppLog(t *testing.T) {
+	suite.Run(t, new(AppLogTestSuite))
+}
+
+func decode(t *testing.T, buf *bytes.Buffer) map[string]interface{} {
+	entry := map[string]interface{}{}
+	assert.Nil(t, json.NewDecoder(buf).Decode(&entry))
+	return entry
+}
+
+func requestContext() context.Context {
+	ctx := ContextWithRequestID(context.Background(), "req-1")
+	ctx, _ = sdktrace.NewTracerProvider().Tracer("test").Start(ctx, "request")
+	return ctx
+}
+
+func (m *AppLogTestSuite) TestRequestID() {
+	m.Equal("req-1", RequestID(requestContext()))
+	m.Equal("", RequestID(context.Background()))
+}
+
+func (m *AppLogTestSuite) TestSystemLogger() {
+	buf := &bytes.Buffer{}
+	var logger Logger = &SystemLogger{log: zerolog.New(buf)}
+
+	logger.WithContext(requestContext()).WithFields(Fields{"module": "docker"}).Error(errors.New("boom"), "error starting container")
+
+	entry := decode(m.T(), buf)
+	m.Equal("error", entry["level"])
+	m.Equal("error starting container", entry["message"])
+	m.Equal("boom", entry["error"])
+	m.Equal("docker", entry["module"])
+	m.Equal("req-1", entry[RequestIDField])
+	m.Len(entry[TraceIDField], 32)
+
+	m.T().Run("Test fields are not shared with the parent logger", func(t *testing.T) {
+		logger.Info("no fields")
+		entry := decode(t, buf)
+		assert.NotContains(t, entry, "module")
+		assert.NotContains(t, entry, RequestIDField)
+	})
+}
+
+func (m *AppLogTestSuite) TestUserLogger() {
+	buf := &bytes.Buffer{}
+	base := logrus.New()
+	base.SetOutput(buf)
+	base.SetFormatter(&logrus.JSONFormatter{})
+	var logger Logger = &UserLogger{entry: logrus.NewEntry(base)}
+
+	logger.WithContext(ContextWithRequestID(context.Background(), "req-2")).Error(errors.New("boom"), "Pushing failed")
+
+	entry := decode(m.T(), buf)
+	m.Equal("error", entry["level"])
+	m.Equal("Pushing failed", entry["msg"])
+	m.Equal("boom", entry[logrus.ErrorKey])
+	m.Equal("req-2", entry[RequestIDField])
+	m.NotContains(entry, TraceIDField)
+}
+
+type replacer struct{}
+
+func (replacer) Redact(text string) string {
+	return strings.Replace(text, "hunter2", "********", -1)
+}
+
+func (m *AppLogTestSuite) TestRedactor() {
+	SetRedactor(replacer{})
+	defer SetRedactor(nil)
+
+	buf := &bytes.Buffer{}
+	var logger Logger = &SystemLogger{log: zerolog.New(buf)}
+	logger.WithFields(Fields{"dsn": "root:hunter2@db", "port": 3306}).Error(errors.New("password hunter2 refused"), "login hunter2")
+
+	entry := decode(m.T(), buf)
+	m.Equal("login ********", entry["message"])
+	m.Equal("password ******** refused", entry["error"])
+	m.Equal("root:********@db", entry["dsn"])
+	m.Equal(float64(3306), entry["port"])
+}
@@ -1,29 +1,41 @@
 package logging
 
 import (
-	"fp-dynamic-elements-manager-controller/internal/db/persistence"
+	"fmt"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
 	"fp-dynamic-elements-manager-controller/internal/logging/structs"
 	"github.com/sirupsen/logrus"
 )
 
-// hook to buffer logs and only send at right severity.
+// hook to send logs of the right severity to the log table.
 type DatabaseHook struct {
-	repo *persistence.LogEntryRepo
+	writer *LogWriter
 }
 
-func NewDatabaseHook(repo *persistence.LogEntryRepo) *DatabaseHook {
-	return &DatabaseHook{repo: repo}
+func NewDatabaseHook(writer *LogWriter) *DatabaseHook {
+	return &DatabaseHook{writer: writer}
 }
 
-// Fire will append all logs to a circular buffer and only 'flush'
-// them when a log of sufficient severity(ERROR) is emitted.
+// Fire queues the entry to be written to the DB by the LogWriter, it never waits on the DB
 func (h *DatabaseHook) Fire(entry *logrus.Entry) error {
-	h.repo.InsertLogEntry(&structs.LogEntry{
+	message := entry.Message
+	if err, ok := entry.Data[logrus.ErrorKey].(error); ok {
+		message = fmt.Sprintf("%s: %v", message, err)
+	}
+	requestID, _ := entry.Data[applog.RequestIDField].(string)
+
+	var caller string
+	if entry.Caller != nil {
+		caller = entry.Caller.Function
+	}
+
+	h.writer.Write(structs.LogEntry{
 		ModuleName: "Controller",
 		Level:      entry.Level.String(),
-		Message:    entry.Message,
-		Caller:     entry.Caller.Func.Name(),
+		Message:    message,
+		Caller:     caller,
 		Time:       entry.Time,
+		RequestID:  requestID,
 	})
 	return nil
 }
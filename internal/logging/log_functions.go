@@ -2,14 +2,12 @@ package logging
 
 import (
 	"fp-dynamic-elements-manager-controller/internal/db/persistence"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
 	structs2 "fp-dynamic-elements-manager-controller/internal/logging/structs"
-	"github.com/rs/zerolog/log"
-	"github.com/sirupsen/logrus"
 	"math"
-	"strings"
 )
 
-func BuildLogResults(page, pageSize int, moduleName, level string, repo *persistence.LogEntryRepo) structs2.LogEvents {
+func BuildLogResults(page, pageSize int, moduleName, requestID, level string, repo *persistence.LogEntryRepo) structs2.LogEvents {
 	logs := structs2.LogEvents{
 		Events:         []structs2.LogEntry{},
 		TotalPageCount: 0,
@@ -21,10 +19,10 @@ func BuildLogResults(page, pageSize int, moduleName, level string, repo *persist
 
 	// Query the table using the given parameters in the where clause
 	var err error
-	logs.Events, err = repo.GetAllForLevels(moduleName, pageSize, offset, buildSearchLevels(strings.ToLower(level)))
+	logs.Events, err = repo.GetAllForLevels(moduleName, requestID, pageSize, offset, LevelsAtOrAbove(level))
 
 	if err != nil {
-		log.Error().Err(err).Msg("Error retrieving paginated log entries")
+		applog.System().Error(err, "Error retrieving paginated log entries")
 		return logs
 	}
 
@@ -32,7 +30,7 @@ func BuildLogResults(page, pageSize int, moduleName, level string, repo *persist
 	totalRows, err = repo.GetTotalCount()
 
 	if err != nil {
-		log.Error().Err(err).Msg("Error retrieving total count log entries")
+		applog.System().Error(err, "Error retrieving total count log entries")
 		return logs
 	}
 
@@ -42,18 +40,3 @@ func BuildLogResults(page, pageSize int, moduleName, level string, repo *persist
 
 	return logs
 }
-
-func buildSearchLevels(levelParam string) []string {
-	var levelsToSearch []string
-	var addLevel = true
-	for _, level := range logrus.AllLevels {
-		if addLevel {
-			levelsToSearch = append(levelsToSearch, level.String())
-		}
-		if levelParam == level.String() {
-			addLevel = false
-		}
-	}
-
-	return levelsToSearch
-}
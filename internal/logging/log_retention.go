@@ -0,0 +1,319 @@
+package logging
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"fp-dynamic-elements-manager-controller/internal/db/persistence"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
+	"fp-dynamic-elements-manager-controller/internal/logging/structs"
+	"fp-dynamic-elements-manager-controller/internal/metrics"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// anyValue matches every module or level in a retention rule
+	anyValue = "*"
+	// purgeChunkSize is the number of entries archived and deleted at a time
+	purgeChunkSize = 1000
+)
+
+// RetentionRule limits the log entries kept for a module at a level, a zero MaxAge or MaxRows means no limit
+type RetentionRule struct {
+	ModuleName string
+	Level      string
+	MaxAge     time.Duration
+	MaxRows    int64
+}
+
+type RetentionConfig struct {
+	// Interval is the time between purges
+	Interval time.Duration
+	// MaxAge and MaxRows are the limits for each module and level which no rule matches
+	MaxAge  time.Duration
+	MaxRows int64
+	// Rules override the default limits, the most specific rule which matches a module and level is used
+	Rules []RetentionRule
+	// ArchiveDir is where purged entries are written as gzipped JSON lines, purged entries are not kept if it is empty
+	ArchiveDir string
+	// BufferSize is the number of entries which can wait to be written to the DB before new entries are dropped
+	BufferSize int
+}
+
+func DefaultRetentionConfig() RetentionConfig {
+	return RetentionConfig{
+		Interval:   time.Hour,
+		MaxAge:     30 * 24 * time.Hour,
+		MaxRows:    100000,
+		ArchiveDir: "./logs/archive",
+		BufferSize: defaultBufferSize,
+	}
+}
+
+// RetentionConfigFromEnv reads LOG_RETENTION_*, LOG_PURGE_INTERVAL, LOG_BUFFER_SIZE and LOG_ARCHIVE_DIR.
+// LOG_RETENTION_RULES takes a comma separated list of module/level=maxAge:maxRows rules where * matches any module
+// or level and an empty limit keeps the default, e.g. fp-ngfw/debug=24h:1000,*/error=2160h:
+func RetentionConfigFromEnv() RetentionConfig {
+	cfg := DefaultRetentionConfig()
+
+	if d, err := time.ParseDuration(os.Getenv("LOG_PURGE_INTERVAL")); err == nil && d > 0 {
+		cfg.Interval = d
+	}
+
+	if d, err := time.ParseDuration(os.Getenv("LOG_RETENTION_MAX_AGE")); err == nil && d >= 0 {
+		cfg.MaxAge = d
+	}
+
+	if n, err := strconv.ParseInt(os.Getenv("LOG_RETENTION_MAX_ROWS"), 10, 64); err == nil && n >= 0 {
+		cfg.MaxRows = n
+	}
+
+	for _, rule := range strings.Split(os.Getenv("LOG_RETENTION_RULES"), ",") {
+		if r, ok := parseRetentionRule(strings.TrimSpace(rule), cfg); ok {
+			cfg.Rules = append(cfg.Rules, r)
+		}
+	}
+
+	if dir, ok := os.LookupEnv("LOG_ARCHIVE_DIR"); ok {
+		cfg.ArchiveDir = dir
+	}
+
+	if n, err := strconv.Atoi(os.Getenv("LOG_BUFFER_SIZE")); err == nil && n > 0 {
+		cfg.BufferSize = n
+	}
+
+	return cfg
+}
+
+func parseRetentionRule(rule string, cfg RetentionConfig) (RetentionRule, bool) {
+	kv := strings.SplitN(rule, "=", 2)
+	if len(kv) != 2 {
+		return RetentionRule{}, false
+	}
+	target := strings.SplitN(kv[0], "/", 2)
+	limits := strings.SplitN(kv[1], ":", 2)
+	if len(target) != 2 || len(limits) != 2 {
+		return RetentionRule{}, false
+	}
+
+	r := RetentionRule{ModuleName: target[0], Level: strings.ToLower(target[1]), MaxAge: cfg.MaxAge, MaxRows: cfg.MaxRows}
+	if limits[0] != "" {
+		d, err := time.ParseDuration(limits[0])
+		if err != nil || d < 0 {
+			return RetentionRule{}, false
+		}
+		r.MaxAge = d
+	}
+	if limits[1] != "" {
+		n, err := strconv.ParseInt(limits[1], 10, 64)
+		if err != nil || n < 0 {
+			return RetentionRule{}, false
+		}
+		r.MaxRows = n
+	}
+	return r, true
+}
+
+// RuleFor returns the limits for a module and level. A rule for the module and level is preferred to a rule for the
+// module at any level, which is preferred to a rule for the level in any module
+func (c RetentionConfig) RuleFor(moduleName, level string) RetentionRule {
+	best, bestScore := RetentionRule{ModuleName: moduleName, Level: level, MaxAge: c.MaxAge, MaxRows: c.MaxRows}, -1
+	for _, r := range c.Rules {
+		score := 0
+		switch r.ModuleName {
+		case moduleName:
+			score += 2
+		case anyValue:
+		default:
+			continue
+		}
+		switch r.Level {
+		case strings.ToLower(level):
+			score++
+		case anyValue:
+		default:
+			continue
+		}
+		if score > bestScore {
+			best, bestScore = r, score
+		}
+	}
+	return best
+}
+
+// Purger deletes the log entries which are past their retention limits, archiving them first
+type Purger struct {
+	cfg      RetentionConfig
+	repo     persistence.LogRetentionRepo
+	now      func() time.Time
+	doneChan chan struct{}
+}
+
+func NewPurger(cfg RetentionConfig, repo persistence.LogRetentionRepo) *Purger {
+	return &Purger{
+		cfg:      cfg,
+		repo:     repo,
+		now:      time.Now,
+		doneChan: make(chan struct{}),
+	}
+}
+
+// Start purges the log entries straight away then every interval until Stop is called
+func (p *Purger) Start() {
+	go func() {
+		for {
+			if _, err := p.Purge(); err != nil {
+				applog.System().Error(err, "error purging log entries")
+			}
+			select {
+			case <-time.After(p.cfg.Interval):
+			case <-p.doneChan:
+				return
+			}
+		}
+	}()
+}
+
+func (p *Purger) Stop() {
+	close(p.doneChan)
+}
+
+// Purge archives and deletes every entry which is older than its MaxAge or beyond the newest MaxRows entries of
+// its module and level, it returns the number of entries deleted. Entries are only deleted once they are archived
+func (p *Purger) Purge() (int64, error) {
+	groups, err := p.repo.GetLogGroups()
+	if err != nil {
+		return 0, err
+	}
+
+	now := p.now()
+	arc := &archive{dir: p.cfg.ArchiveDir, created: now}
+	defer arc.Close()
+
+	var purged int64
+	for _, group := range groups {
+		n, err := p.purgeGroup(group, now, arc)
+		purged += n
+		if err != nil {
+			return purged, err
+		}
+	}
+
+	if err := arc.Close(); err != nil {
+		return purged, err
+	}
+
+	if purged > 0 {
+		applog.System().WithFields(applog.Fields{"archive": arc.path}).Info(fmt.Sprintf("purged %d log entries", purged))
+	}
+	return purged, nil
+}
+
+func (p *Purger) purgeGroup(group structs.LogGroup, now time.Time, arc *archive) (int64, error) {
+	rule := p.cfg.RuleFor(group.ModuleName, group.Level)
+
+	// Entries created before the zero time do not exist, so a zero MaxAge never matches an entry
+	var before time.Time
+	if rule.MaxAge > 0 {
+		before = now.Add(-rule.MaxAge)
+	}
+
+	var maxID int64
+	if rule.MaxRows > 0 && group.Count > rule.MaxRows {
+		id, err := p.repo.GetRowLimitID(group.ModuleName, group.Level, rule.MaxRows)
+		if err != nil {
+			return 0, err
+		}
+		maxID = id
+	}
+
+	if before.IsZero() && maxID == 0 {
+		return 0, nil
+	}
+
+	var purged int64
+	for {
+		entries, err := p.repo.GetForPurge(group.ModuleName, group.Level, before, maxID, purgeChunkSize)
+		if err != nil || len(entries) == 0 {
+			return purged, err
+		}
+
+		if err := arc.Write(entries); err != nil {
+			return purged, err
+		}
+
+		ids := make([]uint, len(entries))
+		for i, entry := range entries {
+			ids[i] = entry.ID
+		}
+		if err := p.repo.DeleteByIDs(ids); err != nil {
+			return purged, err
+		}
+
+		purged += int64(len(entries))
+		metrics.LogEntriesPurged.Add(float64(len(entries)))
+
+		if len(entries) < purgeChunkSize {
+			return purged, nil
+		}
+	}
+}
+
+// archive writes log entries as gzipped JSON lines, the file is only created once the first entry is written
+type archive struct {
+	dir     string
+	created time.Time
+	path    string
+	file    *os.File
+	gz      *gzip.Writer
+	enc     *json.Encoder
+}
+
+func (a *archive) Write(entries []structs.LogEntry) error {
+	if a.dir == "" {
+		return nil
+	}
+
+	if a.file == nil {
+		if err := os.MkdirAll(a.dir, 0755); err != nil {
+			return err
+		}
+		path := filepath.Join(a.dir, fmt.Sprintf("log_entries-%s.jsonl.gz", a.created.UTC().Format("20060102T150405Z")))
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		a.path, a.file = path, file
+		a.gz = gzip.NewWriter(file)
+		a.enc = json.NewEncoder(a.gz)
+	}
+
+	for _, entry := range entries {
+		if err := a.enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	// Flush so the entries are on disk before they are deleted from the DB
+	if err := a.gz.Flush(); err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+// Close finishes the archive, it is safe to call more than once
+func (a *archive) Close() error {
+	if a.file == nil {
+		return nil
+	}
+	err := a.gz.Close()
+	if cerr := a.file.Close(); err == nil {
+		err = cerr
+	}
+	a.file = nil
+	return err
+}
@@ -0,0 +1,182 @@
+package logging
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fp-dynamic-elements-manager-controller/internal/logging/mocks"
+	"fp-dynamic-elements-manager-controller/internal/logging/structs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type LogRetentionTestSuite struct {
+	suite.Suite
+	dir string
+	now time.Time
+}
+
+func TestLogRetention(t *testing.T) {
+	suite.Run(t, new(LogRetentionTestSuite))
+}
+
+func (m *LogRetentionTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "log-archive")
+	m.Nil(err)
+	m.dir = dir
+	m.now = time.Date(2020, 6, 6, 12, 0, 0, 0, time.UTC)
+}
+
+func (m *LogRetentionTestSuite) TearDownTest() {
+	os.RemoveAll(m.dir)
+}
+
+func (m *LogRetentionTestSuite) newPurger(cfg RetentionConfig, repo *mocks.MockRetentionRepo) *Purger {
+	cfg.ArchiveDir = m.dir
+	p := NewPurger(cfg, repo)
+	p.now = func() time.Time { return m.now }
+	return p
+}
+
+func readArchive(t *testing.T, path string) []structs.LogEntry {
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	assert.Nil(t, err)
+
+	var entries []structs.LogEntry
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		entry := structs.LogEntry{}
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func (m *LogRetentionTestSuite) TestRuleFor() {
+	cfg := DefaultRetentionConfig()
+	cfg.Rules = []RetentionRule{
+		{ModuleName: "*", Level: "debug", MaxAge: time.Hour},
+		{ModuleName: "fp-ngfw", Level: "*", MaxAge: 2 * time.Hour},
+		{ModuleName: "fp-ngfw", Level: "error", MaxAge: 3 * time.Hour},
+	}
+
+	m.Equal(3*time.Hour, cfg.RuleFor("fp-ngfw", "error").MaxAge)
+	m.Equal(2*time.Hour, cfg.RuleFor("fp-ngfw", "debug").MaxAge)
+	m.Equal(time.Hour, cfg.RuleFor("Controller", "DEBUG").MaxAge)
+	m.Equal(cfg.MaxAge, cfg.RuleFor("Controller", "info").MaxAge)
+	m.Equal(cfg.MaxRows, cfg.RuleFor("Controller", "info").MaxRows)
+}
+
+func (m *LogRetentionTestSuite) TestRetentionConfigFromEnv() {
+	os.Setenv("LOG_RETENTION_MAX_ROWS", "500")
+	os.Setenv("LOG_RETENTION_RULES", "fp-ngfw/debug=24h:1000, */error=:0, invalid, x/y=bad:1")
+	os.Setenv("LOG_ARCHIVE_DIR", "")
+	defer os.Unsetenv("LOG_RETENTION_MAX_ROWS")
+	defer os.Unsetenv("LOG_RETENTION_RULES")
+	defer os.Unsetenv("LOG_ARCHIVE_DIR")
+
+	cfg := RetentionConfigFromEnv()
+	m.Equal(int64(500), cfg.MaxRows)
+	m.Equal("", cfg.ArchiveDir)
+	m.Equal([]RetentionRule{
+		{ModuleName: "fp-ngfw", Level: "debug", MaxAge: 24 * time.Hour, MaxRows: 1000},
+		{ModuleName: "*", Level: "error", MaxAge: DefaultRetentionConfig().MaxAge, MaxRows: 0},
+	}, cfg.Rules)
+}
+
+func (m *LogRetentionTestSuite) TestPurge_ArchivesThenDeletes() {
+	repo := new(mocks.MockRetentionRepo)
+	cfg := DefaultRetentionConfig()
+	cfg.MaxAge = 24 * time.Hour
+	cfg.MaxRows = 2
+
+	old := []structs.LogEntry{
+		{ID: 1, ModuleName: "fp-ngfw", Level: "info", Message: "first", RequestID: "req-1"},
+		{ID: 2, ModuleName: "fp-ngfw", Level: "info", Message: "second"},
+	}
+	repo.On("GetLogGroups").Return([]structs.LogGroup{
+		{ModuleName: "fp-ngfw", Level: "info", Count: 4},
+		{ModuleName: "Controller", Level: "error", Count: 1},
+	}, nil)
+	repo.On("GetRowLimitID", "fp-ngfw", "info", int64(2)).Return(int64(2), nil)
+	repo.On("GetForPurge", "fp-ngfw", "info", m.now.Add(-24*time.Hour), int64(2), purgeChunkSize).Return(old, nil)
+	repo.On("GetForPurge", "Controller", "error", m.now.Add(-24*time.Hour), int64(0), purgeChunkSize).Return([]structs.LogEntry{}, nil)
+	repo.On("DeleteByIDs", []uint{1, 2}).Return(nil)
+
+	purged, err := m.newPurger(cfg, repo).Purge()
+	m.Nil(err)
+	m.Equal(int64(2), purged)
+	repo.AssertExpectations(m.T())
+
+	m.T().Run("Test purged entries are archived", func(t *testing.T) {
+		files, _ := filepath.Glob(filepath.Join(m.dir, "*.jsonl.gz"))
+		assert.Equal(t, []string{filepath.Join(m.dir, "log_entries-20200606T120000Z.jsonl.gz")}, files)
+		assert.Equal(t, old, readArchive(t, files[0]))
+	})
+}
+
+func (m *LogRetentionTestSuite) TestPurge_NoLimits() {
+	repo := new(mocks.MockRetentionRepo)
+	cfg := DefaultRetentionConfig()
+	cfg.Rules = []RetentionRule{{ModuleName: "Controller", Level: "*"}}
+
+	repo.On("GetLogGroups").Return([]structs.LogGroup{{ModuleName: "Controller", Level: "info", Count: 10000000}}, nil)
+
+	purged, err := m.newPurger(cfg, repo).Purge()
+	m.Nil(err)
+	m.Equal(int64(0), purged)
+	repo.AssertNotCalled(m.T(), "GetForPurge", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	files, _ := filepath.Glob(filepath.Join(m.dir, "*"))
+	m.Empty(files)
+}
+
+func (m *LogRetentionTestSuite) TestLogWriter() {
+	m.T().Run("Test entries are written in batches", func(t *testing.T) {
+		inserter := &mocks.MockInserter{}
+		writer := NewLogWriter(inserter, 500, nil)
+		writer.Start()
+		for i := 0; i < 250; i++ {
+			assert.True(t, writer.Write(structs.LogEntry{Message: "entry"}))
+		}
+		writer.Stop()
+
+		assert.Equal(t, 250, inserter.Count())
+		for _, batch := range inserter.Batches[:2] {
+			assert.Len(t, batch, writeBatchSize)
+		}
+	})
+
+	m.T().Run("Test entries are dropped when the buffer is full", func(t *testing.T) {
+		inserter := &mocks.MockInserter{Block: make(chan struct{})}
+		writer := NewLogWriter(inserter, 1, nil)
+		assert.True(t, writer.Write(structs.LogEntry{Message: "kept"}))
+		assert.False(t, writer.Write(structs.LogEntry{Message: "dropped"}))
+
+		close(inserter.Block)
+		writer.Start()
+		writer.Stop()
+		assert.Equal(t, 1, inserter.Count())
+	})
+
+	m.T().Run("Test buffered entries are written on stop", func(t *testing.T) {
+		inserter := &mocks.MockInserter{}
+		writer := NewLogWriter(inserter, 500, nil)
+		for i := 0; i < 150; i++ {
+			assert.True(t, writer.Write(structs.LogEntry{Message: "buffered"}))
+		}
+		writer.Start()
+		writer.Stop()
+		assert.Equal(t, 150, inserter.Count())
+		writer.Stop()
+	})
+}
@@ -0,0 +1,191 @@
+package logging
+
+import (
+	"fp-dynamic-elements-manager-controller/internal/logging/structs"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultTailHistory = 1000
+	// subscriberBuffer is the number of entries which can wait to be sent to a subscriber before entries are dropped
+	subscriberBuffer = 256
+)
+
+// TailHistoryFromEnv returns the number of recent entries kept for backfill, set with LOG_TAIL_HISTORY
+func TailHistoryFromEnv() int {
+	if n, err := strconv.Atoi(os.Getenv("LOG_TAIL_HISTORY")); err == nil && n >= 0 {
+		return n
+	}
+	return defaultTailHistory
+}
+
+// TailFilter selects the log entries sent to a subscriber, an empty field matches every entry
+type TailFilter struct {
+	ModuleName string `json:"modulename"`
+	// Level is the minimum level, e.g. warning matches warning, error, fatal and panic entries
+	Level string `json:"level"`
+	// Text is matched against the message, ignoring case
+	Text string `json:"text"`
+}
+
+func (f TailFilter) Matches(entry structs.LogEntry) bool {
+	if f.ModuleName != "" && f.ModuleName != entry.ModuleName {
+		return false
+	}
+	if min, err := logrus.ParseLevel(f.Level); f.Level != "" && err == nil {
+		level, err := logrus.ParseLevel(entry.Level)
+		if err != nil || level > min {
+			return false
+		}
+	}
+	if f.Text != "" && !strings.Contains(strings.ToLower(entry.Message), strings.ToLower(f.Text)) {
+		return false
+	}
+	return true
+}
+
+// Tail fans out log entries to live subscribers as they are logged and keeps the most recent entries so a new
+// subscriber can be sent what happened just before it connected
+type Tail struct {
+	mu          sync.Mutex
+	history     []structs.LogEntry
+	next        int
+	full        bool
+	subscribers map[*Subscription]struct{}
+}
+
+// NewTail returns a tail which keeps the last historySize entries
+func NewTail(historySize int) *Tail {
+	return &Tail{
+		history:     make([]structs.LogEntry, historySize),
+		subscribers: map[*Subscription]struct{}{},
+	}
+}
+
+// Publish records the entry and sends it to every subscriber whose filter it matches. A subscriber which is not
+// keeping up misses the entry rather than holding up the code which logged
+func (t *Tail) Publish(entry structs.LogEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.history) > 0 {
+		t.history[t.next] = entry
+		t.next = (t.next + 1) % len(t.history)
+		t.full = t.full || t.next == 0
+	}
+
+	for s := range t.subscribers {
+		if !s.Filter().Matches(entry) {
+			continue
+		}
+		select {
+		case s.entries <- entry:
+		default:
+			s.mu.Lock()
+			s.dropped++
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Subscribe returns a subscription to the entries matching filter along with up to backfill of the most recent
+// matching entries, oldest first. Unsubscribe must be called once the subscriber is done
+func (t *Tail) Subscribe(filter TailFilter, backfill int) (*Subscription, []structs.LogEntry) {
+	s := &Subscription{tail: t, filter: filter, entries: make(chan structs.LogEntry, subscriberBuffer)}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.subscribers[s] = struct{}{}
+	return s, t.recent(filter, backfill)
+}
+
+func (t *Tail) recent(filter TailFilter, n int) []structs.LogEntry {
+	entries := []structs.LogEntry{}
+	if n <= 0 {
+		return entries
+	}
+
+	size := t.next
+	if t.full {
+		size = len(t.history)
+	}
+	// Walk back from the newest entry so only the last n matches are collected
+	for i := 1; i <= size && len(entries) < n; i++ {
+		entry := t.history[(t.next-i+len(t.history))%len(t.history)]
+		if filter.Matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries
+}
+
+// Subscription receives the entries published to a Tail which match its filter
+type Subscription struct {
+	tail    *Tail
+	entries chan structs.LogEntry
+
+	mu      sync.Mutex
+	filter  TailFilter
+	dropped int
+}
+
+// Entries returns the channel the matching entries are sent on, it is never closed
+func (s *Subscription) Entries() <-chan structs.LogEntry {
+	return s.entries
+}
+
+func (s *Subscription) Filter() TailFilter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.filter
+}
+
+// SetFilter replaces the filter, entries already waiting to be received are not filtered again
+func (s *Subscription) SetFilter(filter TailFilter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filter = filter
+}
+
+// Dropped returns the number of matching entries missed because the subscriber was not keeping up and resets it
+func (s *Subscription) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.dropped
+	s.dropped = 0
+	return n
+}
+
+func (s *Subscription) Unsubscribe() {
+	s.tail.mu.Lock()
+	defer s.tail.mu.Unlock()
+	delete(s.tail.subscribers, s)
+}
+
+// LevelsAtOrAbove returns the names of level and every more severe level, as stored in the log table. Both spellings
+// of warning are included as modules may log either. Nil is returned if level is empty or not a known level
+func LevelsAtOrAbove(level string) []string {
+	min, err := logrus.ParseLevel(level)
+	if level == "" || err != nil {
+		return nil
+	}
+
+	var levels []string
+	for _, l := range logrus.AllLevels {
+		if l > min {
+			break
+		}
+		levels = append(levels, l.String())
+		if l == logrus.WarnLevel {
+			levels = append(levels, "warn")
+		}
+	}
+	return levels
+}
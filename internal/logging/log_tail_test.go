@@ -0,0 +1,109 @@
+package logging
+
+import (
+	"fp-dynamic-elements-manager-controller/internal/logging/mocks"
+	"fp-dynamic-elements-manager-controller/internal/logging/structs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type LogTailTestSuite struct {
+	suite.Suite
+}
+
+func TestLogTail(t *testing.T) {
+	suite.Run(t, new(LogTailTestSuite))
+}
+
+func messages(entries []structs.LogEntry) []string {
+	var msgs []string
+	for _, e := range entries {
+		msgs = append(msgs, e.Message)
+	}
+	return msgs
+}
+
+func (m *LogTailTestSuite) TestFilterMatches() {
+	entry := structs.LogEntry{ModuleName: "fp-ngfw", Level: "warning", Message: "Push to NGFW failed"}
+
+	m.True(TailFilter{}.Matches(entry))
+	m.True(TailFilter{ModuleName: "fp-ngfw", Level: "warn", Text: "ngfw FAILED"}.Matches(entry))
+	m.True(TailFilter{Level: "info"}.Matches(entry))
+	m.False(TailFilter{Level: "error"}.Matches(entry))
+	m.False(TailFilter{ModuleName: "Controller"}.Matches(entry))
+	m.False(TailFilter{Text: "succeeded"}.Matches(entry))
+	m.False(TailFilter{Level: "info"}.Matches(structs.LogEntry{Level: "verbose"}))
+}
+
+func (m *LogTailTestSuite) TestBackfill() {
+	tail := NewTail(3)
+	for _, msg := range []string{"one", "two", "three", "four"} {
+		tail.Publish(structs.LogEntry{Level: "info", Message: msg})
+	}
+	tail.Publish(structs.LogEntry{Level: "error", Message: "five"})
+
+	m.T().Run("Test only the history size is kept, oldest first", func(t *testing.T) {
+		sub, recent := tail.Subscribe(TailFilter{}, 10)
+		defer sub.Unsubscribe()
+		assert.Equal(t, []string{"three", "four", "five"}, messages(recent))
+	})
+
+	m.T().Run("Test the last matching entries are returned", func(t *testing.T) {
+		sub, recent := tail.Subscribe(TailFilter{Level: "info"}, 2)
+		defer sub.Unsubscribe()
+		assert.Equal(t, []string{"four", "five"}, messages(recent))
+
+		sub, recent = tail.Subscribe(TailFilter{Level: "error"}, 0)
+		defer sub.Unsubscribe()
+		assert.Empty(t, recent)
+	})
+}
+
+func (m *LogTailTestSuite) TestSubscription() {
+	tail := NewTail(0)
+	sub, recent := tail.Subscribe(TailFilter{ModuleName: "fp-ngfw"}, 10)
+	m.Empty(recent)
+
+	tail.Publish(structs.LogEntry{ModuleName: "Controller", Message: "skipped"})
+	tail.Publish(structs.LogEntry{ModuleName: "fp-ngfw", Message: "sent"})
+	m.Equal("sent", (<-sub.Entries()).Message)
+
+	sub.SetFilter(TailFilter{ModuleName: "Controller"})
+	tail.Publish(structs.LogEntry{ModuleName: "Controller", Message: "now sent"})
+	m.Equal("now sent", (<-sub.Entries()).Message)
+
+	m.T().Run("Test entries are dropped for a slow subscriber", func(t *testing.T) {
+		for i := 0; i < subscriberBuffer+5; i++ {
+			tail.Publish(structs.LogEntry{ModuleName: "Controller"})
+		}
+		assert.Len(t, sub.Entries(), subscriberBuffer)
+		assert.Equal(t, 5, sub.Dropped())
+		assert.Equal(t, 0, sub.Dropped())
+	})
+
+	m.T().Run("Test nothing is sent after unsubscribing", func(t *testing.T) {
+		sub.Unsubscribe()
+		tail.Publish(structs.LogEntry{ModuleName: "Controller"})
+		assert.Equal(t, 0, sub.Dropped())
+	})
+}
+
+func (m *LogTailTestSuite) TestWriterPublishes() {
+	tail := NewTail(10)
+	writer := NewLogWriter(&mocks.MockInserter{}, 1, tail)
+
+	m.True(writer.Write(structs.LogEntry{Message: "kept"}))
+	m.False(writer.Write(structs.LogEntry{Message: "dropped"}))
+
+	sub, recent := tail.Subscribe(TailFilter{}, 10)
+	defer sub.Unsubscribe()
+	m.Equal([]string{"kept"}, messages(recent))
+}
+
+func (m *LogTailTestSuite) TestLevelsAtOrAbove() {
+	m.Equal([]string{"panic", "fatal", "error", "warning", "warn"}, LevelsAtOrAbove("WARN"))
+	m.Equal([]string{"panic", "fatal", "error"}, LevelsAtOrAbove("error"))
+	m.Nil(LevelsAtOrAbove(""))
+	m.Nil(LevelsAtOrAbove("verbose"))
+}
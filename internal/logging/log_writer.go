@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
+	"fp-dynamic-elements-manager-controller/internal/logging/structs"
+	"fp-dynamic-elements-manager-controller/internal/metrics"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBufferSize = 1000
+	writeBatchSize    = 100
+	flushInterval     = time.Second
+)
+
+// LogEntryInserter writes a batch of log entries to the DB
+type LogEntryInserter interface {
+	InsertLogEntries([]structs.LogEntry) error
+}
+
+// LogWriter buffers log entries and writes them to the DB in batches in the background, so a slow DB never blocks
+// the code which logged. Entries are dropped, and counted in the metrics, if the buffer is full
+type LogWriter struct {
+	repo    LogEntryInserter
+	tail    *Tail
+	entries chan structs.LogEntry
+
+	stopOnce sync.Once
+	doneChan chan struct{}
+	stopped  chan struct{}
+}
+
+// NewLogWriter returns a writer which buffers up to bufferSize entries, Start must be called before entries are written.
+// Every entry accepted is also published to tail, if it is not nil
+func NewLogWriter(repo LogEntryInserter, bufferSize int, tail *Tail) *LogWriter {
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	return &LogWriter{
+		repo:     repo,
+		tail:     tail,
+		entries:  make(chan structs.LogEntry, bufferSize),
+		doneChan: make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// Write queues the entry to be written, it returns false if the buffer is full and the entry was dropped
+func (w *LogWriter) Write(entry structs.LogEntry) bool {
+	// Entries sent by modules are not written through a logger, so the values of secrets are redacted here
+	entry.Message = applog.Redact(entry.Message)
+	select {
+	case w.entries <- entry:
+		if w.tail != nil {
+			w.tail.Publish(entry)
+		}
+		return true
+	default:
+		metrics.LogEntriesDropped.Inc()
+		return false
+	}
+}
+
+// Start writes queued entries until Stop is called, a batch is written when it is full or every flush interval
+func (w *LogWriter) Start() {
+	go func() {
+		defer close(w.stopped)
+
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+
+		batch := make([]structs.LogEntry, 0, writeBatchSize)
+		flush := func() {
+			if len(batch) == 0 {
+				return
+			}
+			if err := w.repo.InsertLogEntries(batch); err != nil {
+				metrics.LogEntriesDropped.Add(float64(len(batch)))
+				applog.System().Error(err, "error writing log entries, entries dropped")
+			}
+			batch = make([]structs.LogEntry, 0, writeBatchSize)
+		}
+
+		add := func(entry structs.LogEntry) {
+			batch = append(batch, entry)
+			if len(batch) >= writeBatchSize {
+				flush()
+			}
+		}
+
+		for {
+			select {
+			case entry := <-w.entries:
+				add(entry)
+			case <-ticker.C:
+				flush()
+			case <-w.doneChan:
+				for {
+					select {
+					case entry := <-w.entries:
+						add(entry)
+					default:
+						flush()
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// Stop writes any queued entries then stops the writer
+func (w *LogWriter) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.doneChan)
+	})
+	<-w.stopped
+}
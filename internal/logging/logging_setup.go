@@ -36,10 +36,11 @@ func InitUserLogger(logLevel, logfile string) {
 func InitInternalLogger() {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	// Entries are written through the applog facade, skip its frame so the caller is the code which logged
 	log.Logger = log.
 		Output(zerolog.ConsoleWriter{Out: os.Stdout}).
 		With().
-		Caller().
+		CallerWithSkipFrameCount(zerolog.CallerSkipFrameCount + 1).
 		Logger()
 }
 
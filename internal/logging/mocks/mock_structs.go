@@ -0,0 +1,59 @@
+package mocks
+
+import (
+	"fp-dynamic-elements-manager-controller/internal/logging/structs"
+	"github.com/stretchr/testify/mock"
+	"sync"
+	"time"
+)
+
+type MockRetentionRepo struct {
+	mock.Mock
+}
+
+func (r *MockRetentionRepo) GetLogGroups() ([]structs.LogGroup, error) {
+	args := r.Called()
+	return args.Get(0).([]structs.LogGroup), args.Error(1)
+}
+
+func (r *MockRetentionRepo) GetRowLimitID(moduleName, level string, maxRows int64) (int64, error) {
+	args := r.Called(moduleName, level, maxRows)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (r *MockRetentionRepo) GetForPurge(moduleName, level string, before time.Time, maxID int64, limit int) ([]structs.LogEntry, error) {
+	args := r.Called(moduleName, level, before, maxID, limit)
+	return args.Get(0).([]structs.LogEntry), args.Error(1)
+}
+
+func (r *MockRetentionRepo) DeleteByIDs(ids []uint) error {
+	args := r.Called(ids)
+	return args.Error(0)
+}
+
+// MockInserter records every batch of log entries written, Block can be set to hold up writes
+type MockInserter struct {
+	mu      sync.Mutex
+	Batches [][]structs.LogEntry
+	Block   chan struct{}
+}
+
+func (i *MockInserter) InsertLogEntries(entries []structs.LogEntry) error {
+	if i.Block != nil {
+		<-i.Block
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.Batches = append(i.Batches, entries)
+	return nil
+}
+
+func (i *MockInserter) Count() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	n := 0
+	for _, b := range i.Batches {
+		n += len(b)
+	}
+	return n
+}
@@ -14,6 +14,7 @@ type LogEntry struct {
 	Message    string     `json:"message"`
 	Caller     string     `json:"caller"`
 	Time       time.Time  `json:"time"`
+	RequestID  string     `json:"request_id,omitempty" db:"request_id"`
 }
 
 type LogEvents struct {
@@ -21,3 +22,23 @@ type LogEvents struct {
 	TotalPageCount int        `json:"total_page_count"`
 	PageNumber     int        `json:"page_number"`
 }
+
+// LogGroup is the number of log entries stored for a module at a level, retention limits are applied per group
+type LogGroup struct {
+	ModuleName string `json:"module_name" db:"module_name"`
+	Level      string `json:"level"`
+	Count      int64  `json:"count"`
+}
+
+const (
+	TailEntry   = "entry"
+	TailDropped = "dropped"
+)
+
+// TailEvent is sent on the live log tail, either an entry or the number of entries missed because the client was not
+// keeping up
+type TailEvent struct {
+	Type    string    `json:"type"`
+	Entry   *LogEntry `json:"entry,omitempty"`
+	Dropped int       `json:"dropped,omitempty"`
+}
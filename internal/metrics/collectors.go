@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"database/sql"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ElementCounter counts the list elements of each type, it is queried on every scrape
+type ElementCounter interface {
+	CountByType() (map[string]int64, error)
+}
+
+// ClientCounter returns the number of connected websocket clients
+type ClientCounter interface {
+	ClientCount() int
+}
+
+// RegisterCollectors adds the metrics which are read from other components when /metrics is scraped
+func RegisterCollectors(db *sql.DB, elements ElementCounter, clients ClientCounter) {
+	Registry.MustRegister(
+		NewDBStatsCollector(db),
+		NewElementCollector(elements),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "websocket",
+			Name:      "clients",
+			Help:      "Number of connected websocket clients.",
+		}, func() float64 {
+			return float64(clients.ClientCount())
+		}),
+	)
+}
+
+type dbStatsCollector struct {
+	db                *sql.DB
+	maxOpen           *prometheus.Desc
+	open              *prometheus.Desc
+	inUse             *prometheus.Desc
+	idle              *prometheus.Desc
+	waitCount         *prometheus.Desc
+	waitDuration      *prometheus.Desc
+	maxIdleClosed     *prometheus.Desc
+	maxLifetimeClosed *prometheus.Desc
+}
+
+// NewDBStatsCollector exposes the connection pool stats of the database
+func NewDBStatsCollector(db *sql.DB) prometheus.Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db", name), help, nil, nil)
+	}
+	return &dbStatsCollector{
+		db:                db,
+		maxOpen:           desc("max_open_connections", "Maximum number of open connections to the database."),
+		open:              desc("open_connections", "Number of established connections, in use and idle."),
+		inUse:             desc("in_use_connections", "Number of connections currently in use."),
+		idle:              desc("idle_connections", "Number of idle connections."),
+		waitCount:         desc("wait_count_total", "Number of connections waited for."),
+		waitDuration:      desc("wait_duration_seconds_total", "Total time blocked waiting for a new connection."),
+		maxIdleClosed:     desc("max_idle_closed_total", "Number of connections closed due to SetMaxIdleConns."),
+		maxLifetimeClosed: desc("max_lifetime_closed_total", "Number of connections closed due to SetConnMaxLifetime."),
+	}
+}
+
+func (c *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.maxOpen
+	ch <- c.open
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.waitCount
+	ch <- c.waitDuration
+	ch <- c.maxIdleClosed
+	ch <- c.maxLifetimeClosed
+}
+
+func (c *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.db.Stats()
+	ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(stats.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, float64(stats.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.inUse, prometheus.GaugeValue, float64(stats.InUse))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stats.Idle))
+	ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.maxIdleClosed, prometheus.CounterValue, float64(stats.MaxIdleClosed))
+	ch <- prometheus.MustNewConstMetric(c.maxLifetimeClosed, prometheus.CounterValue, float64(stats.MaxLifetimeClosed))
+}
+
+type elementCollector struct {
+	elements ElementCounter
+	count    *prometheus.Desc
+}
+
+// NewElementCollector exposes the number of list elements of each type
+func NewElementCollector(elements ElementCounter) prometheus.Collector {
+	return &elementCollector{
+		elements: elements,
+		count: prometheus.NewDesc(prometheus.BuildFQName(namespace, "elements", "total"),
+			"Number of list elements stored by type.", []string{"type"}, nil),
+	}
+}
+
+func (c *elementCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.count
+}
+
+func (c *elementCollector) Collect(ch chan<- prometheus.Metric) {
+	counts, err := c.elements.CountByType()
+	if err != nil {
+		applog.System().Error(err, "error counting list elements for metrics")
+		return
+	}
+	for elementType, n := range counts {
+		ch <- prometheus.MustNewConstMetric(c.count, prometheus.GaugeValue, float64(n), elementType)
+	}
+}
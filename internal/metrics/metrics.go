@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	namespace = "dem"
+
+	Success = "success"
+	Failure = "failure"
+)
+
+// Registry holds every metric exposed on /metrics, a dedicated registry is used so that only the metrics
+// defined here (and the Go runtime/process metrics) are exposed
+var Registry = prometheus.NewRegistry()
+
+var (
+	PushAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "push",
+		Name:      "attempts_total",
+		Help:      "Number of pushes of list elements to egress modules.",
+	}, []string{"module"})
+
+	PushResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "push",
+		Name:      "results_total",
+		Help:      "Number of pushes to egress modules by result, success or failure.",
+	}, []string{"module", "result"})
+
+	PushDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "push",
+		Name:      "duration_seconds",
+		Help:      "Time taken for an egress module to accept a push.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"module"})
+
+	BatchBacklog = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "push",
+		Name:      "batch_backlog",
+		Help:      "Number of batches waiting to be pushed to an egress module, as of the last push run.",
+	}, []string{"module", "status"})
+
+	ElementsIngested = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "queue",
+		Name:      "elements_ingested_total",
+		Help:      "Number of list elements received on the queue by source.",
+	}, []string{"source"})
+
+	ProxyRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "requests_total",
+		Help:      "Number of requests proxied to module routes.",
+	}, []string{"module", "route", "method", "code"})
+
+	ProxyDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to proxy a request to a module route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"module", "route", "method"})
+
+	DockerCommands = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "docker",
+		Name:      "commands_total",
+		Help:      "Number of docker commands run by command and result, success or failure.",
+	}, []string{"command", "result"})
+
+	LogEntriesDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "logs",
+		Name:      "entries_dropped_total",
+		Help:      "Number of log entries which were not written to the DB because the buffer was full or the write failed.",
+	})
+
+	LogEntriesPurged = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "logs",
+		Name:      "entries_purged_total",
+		Help:      "Number of log entries deleted by the retention policy.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		PushAttempts,
+		PushResults,
+		PushDuration,
+		BatchBacklog,
+		ElementsIngested,
+		ProxyRequests,
+		ProxyDuration,
+		DockerCommands,
+		LogEntriesDropped,
+		LogEntriesPurged,
+	)
+}
+
+// Result returns the result label for an operation which returned the given error
+func Result(err error) string {
+	if err != nil {
+		return Failure
+	}
+	return Success
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"strings"
+	"testing"
+)
+
+type MetricsTestSuite struct {
+	suite.Suite
+}
+
+func TestMetrics(t *testing.T) {
+	suite.Run(t, new(MetricsTestSuite))
+}
+
+type fakeCounter struct {
+	counts map[string]int64
+	err    error
+}
+
+func (f fakeCounter) CountByType() (map[string]int64, error) {
+	return f.counts, f.err
+}
+
+func (m *MetricsTestSuite) TestElementCollector() {
+	m.T().Run("Test counts are exposed by type", func(t *testing.T) {
+		collector := NewElementCollector(fakeCounter{counts: map[string]int64{"IP": 12, "DOMAIN": 3}})
+		expected := `
+# HELP dem_elements_total Number of list elements stored by type.
+# TYPE dem_elements_total gauge
+dem_elements_total{type="DOMAIN"} 3
+dem_elements_total{type="IP"} 12
+`
+		assert.Nil(t, testutil.CollectAndCompare(collector, strings.NewReader(expected)))
+	})
+
+	m.T().Run("Test nothing is exposed when counting fails", func(t *testing.T) {
+		collector := NewElementCollector(fakeCounter{err: errors.New("db down")})
+		assert.Equal(t, 0, testutil.CollectAndCount(collector))
+	})
+}
+
+func (m *MetricsTestSuite) TestResult() {
+	DockerCommands.Reset()
+	DockerCommands.WithLabelValues("restart", Result(nil)).Inc()
+	DockerCommands.WithLabelValues("restart", Result(errors.New("no such container"))).Inc()
+	DockerCommands.WithLabelValues("restart", Result(nil)).Inc()
+
+	assert.Equal(m.T(), 2.0, testutil.ToFloat64(DockerCommands.WithLabelValues("restart", Success)))
+	assert.Equal(m.T(), 1.0, testutil.ToFloat64(DockerCommands.WithLabelValues("restart", Failure)))
+}
@@ -0,0 +1,505 @@
+package moduleconfig
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"fp-dynamic-elements-manager-controller/api/util"
+	"fp-dynamic-elements-manager-controller/internal/auth"
+	authstructs "fp-dynamic-elements-manager-controller/internal/auth/structs"
+	"fp-dynamic-elements-manager-controller/internal/db/persistence"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
+	"fp-dynamic-elements-manager-controller/internal/moduleconfig/structs"
+	modulestructs "fp-dynamic-elements-manager-controller/internal/modules/structs"
+	"fp-dynamic-elements-manager-controller/internal/notification"
+	"fp-dynamic-elements-manager-controller/internal/routing"
+	"fp-dynamic-elements-manager-controller/internal/secrets"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ConfigEndpoint is the module endpoint which serves the module's config template on GET and takes its config on POST
+const ConfigEndpoint = "/config"
+
+var (
+	ErrVersionNotFound = errors.New("config version not found")
+	// ErrNotConfigurable is returned for modules which did not register a config endpoint
+	ErrNotConfigurable = errors.New("the module has no config endpoint")
+)
+
+type Config struct {
+	// Timeout is the time allowed for a module to answer a config request made by the controller
+	Timeout time.Duration
+	// MaxBytes is the size of the largest config which can be posted to a module
+	MaxBytes int64
+	// ReplayAttempts is the number of times the config is sent to a module which registered, ReplayBackoff is the
+	// time between attempts
+	ReplayAttempts int
+	ReplayBackoff  time.Duration
+	// SigningKey signs the identity headers of config requests, see routing.ProxyConfig. It is required
+	SigningKey string
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Timeout:        10 * time.Second,
+		MaxBytes:       1 << 20,
+		ReplayAttempts: 5,
+		ReplayBackoff:  10 * time.Second,
+	}
+}
+
+// ConfigFromEnv reads the MODULE_CONFIG_* settings and PROXY_SIGNING_KEY
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+
+	if d, err := time.ParseDuration(os.Getenv("MODULE_CONFIG_TIMEOUT")); err == nil && d > 0 {
+		cfg.Timeout = d
+	}
+
+	if n, err := strconv.ParseInt(os.Getenv("MODULE_CONFIG_MAX_BYTES"), 10, 64); err == nil && n > 0 {
+		cfg.MaxBytes = n
+	}
+
+	if n, err := strconv.Atoi(os.Getenv("MODULE_CONFIG_REPLAY_ATTEMPTS")); err == nil && n > 0 {
+		cfg.ReplayAttempts = n
+	}
+
+	if d, err := time.ParseDuration(os.Getenv("MODULE_CONFIG_REPLAY_BACKOFF")); err == nil {
+		cfg.ReplayBackoff = d
+	}
+
+	cfg.SigningKey = os.Getenv("PROXY_SIGNING_KEY")
+
+	return cfg
+}
+
+// Validate checks the store can sign the identity headers of the config requests it makes
+func (c Config) Validate() error {
+	return routing.ValidateSigningKey(c.SigningKey)
+}
+
+func configLog(fields applog.Fields) applog.Logger {
+	return applog.System().WithFields(applog.Fields{"module": "config"}).WithFields(fields)
+}
+
+// Store keeps every version of each module's config. Configs posted to a module through the proxy are checked against
+// the module's template and recorded once the module accepts them, the latest version is sent to the module again
+// whenever it registers so that a recreated container gets its config back
+type Store struct {
+	cfg    Config
+	repo   persistence.ConfigRepo
+	ns     notification.Service
+	client *http.Client
+	// secrets resolves the secrets a config references when it is sent to a module, and conceals the values of secrets
+	// read from a module behind their references
+	secrets *secrets.Service
+
+	mu sync.Mutex
+	// locks serialise the config changes of each module so versions are recorded in the order they were applied
+	locks map[string]*sync.Mutex
+}
+
+func NewStore(cfg Config, repo persistence.ConfigRepo, ns notification.Service, secrets *secrets.Service) *Store {
+	return &Store{
+		cfg:     cfg,
+		repo:    repo,
+		ns:      ns,
+		client:  &http.Client{Timeout: cfg.Timeout},
+		secrets: secrets,
+		locks:   make(map[string]*sync.Mutex),
+	}
+}
+
+func (s *Store) lock(serviceName string) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lock, ok := s.locks[serviceName]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.locks[serviceName] = lock
+	}
+	return lock
+}
+
+// Intercept wraps a ProxyFactory so that configs posted to a module's config endpoint are validated and recorded
+func (s *Store) Intercept(next routing.ProxyFactory) routing.ProxyFactory {
+	return func(target *url.URL, module modulestructs.ModuleMetadata, endpoint modulestructs.ModuleEndpoint) http.Handler {
+		proxy := next(target, module, endpoint)
+		if endpoint.Endpoint != ConfigEndpoint {
+			return proxy
+		}
+		return s.configHandler(target, module, proxy)
+	}
+}
+
+// statusRecorder keeps the status of the module's response so the config is only recorded if the module accepted it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// bufferedResponse holds the module's response so the values of secrets can be concealed before it is written
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+	return b.body.Write(data)
+}
+
+// concealed serves the module's config with the value of every secret replaced by its reference
+func (s *Store) concealed(w http.ResponseWriter, r *http.Request, proxy http.Handler) {
+	buffered := &bufferedResponse{header: http.Header{}, status: http.StatusOK}
+	proxy.ServeHTTP(buffered, r)
+
+	body := buffered.body.Bytes()
+	if buffered.status == http.StatusOK {
+		if config, err := decodeObject(body); err == nil {
+			if data, err := json.Marshal(s.secrets.ConcealJSON(config)); err == nil {
+				body = data
+			}
+		}
+	}
+	for name, values := range buffered.header {
+		w.Header()[name] = values
+	}
+	w.Header().Del("Content-Length")
+	w.WriteHeader(buffered.status)
+	w.Write(body)
+}
+
+// resolve resolves the secrets referenced by the config, a config which references a missing secret is invalid
+func (s *Store) resolve(config []byte) ([]byte, error) {
+	resolved, err := s.secrets.ResolveJSON(config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	return resolved, nil
+}
+
+func (s *Store) configHandler(target *url.URL, module modulestructs.ModuleMetadata, proxy http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			s.concealed(w, r, proxy)
+			return
+		}
+		if r.Method != http.MethodPost {
+			proxy.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, s.cfg.MaxBytes))
+		if err != nil {
+			util.ReturnHTTPStatus(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("the config must be at most %d bytes", s.cfg.MaxBytes))
+			return
+		}
+
+		lock := s.lock(module.ModuleServiceName)
+		lock.Lock()
+		defer lock.Unlock()
+
+		user, _ := auth.UserFromContext(r.Context())
+		template, err := s.fetch(r.Context(), target, user)
+		if err != nil {
+			configLog(applog.Fields{"service": module.ModuleServiceName}).WithContext(r.Context()).Error(err, "error reading module config template")
+			util.ReturnHTTPStatus(w, http.StatusBadGateway, fmt.Sprintf("the config template of module %s could not be read", module.ModuleDisplayName))
+			return
+		}
+		config, err := Apply(template, body)
+		if err != nil {
+			util.ReturnHTTPStatus(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		// The module is sent the values of the secrets while the recorded config keeps their references
+		resolved, err := s.resolve(body)
+		if err != nil {
+			util.ReturnHTTPStatus(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		r.Body = ioutil.NopCloser(bytes.NewReader(resolved))
+		r.ContentLength = int64(len(resolved))
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		proxy.ServeHTTP(recorder, r)
+		if recorder.status < 200 || recorder.status > 299 {
+			return
+		}
+
+		// The module has its new config whether or not it is recorded, so a failure here is only logged
+		s.record(r.Context(), module.ModuleServiceName, config, user, structs.ConfigUpdated, 0)
+	})
+}
+
+func (s *Store) record(ctx context.Context, serviceName string, config map[string]interface{}, user *authstructs.Token, action structs.ConfigAction, rollbackOf int) (structs.ConfigVersion, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return structs.ConfigVersion{}, err
+	}
+	version := structs.ConfigVersion{
+		ModuleServiceName: serviceName,
+		Config:            data,
+		Action:            action,
+		RollbackOf:        rollbackOf,
+		RequestID:         applog.RequestID(ctx),
+		CreatedAt:         time.Now().UTC(),
+	}
+	if user != nil {
+		version.AuthorID, version.AuthorEmail, version.AuthorName = user.UserID, user.Email, user.Name
+	}
+	version, err = s.repo.InsertConfig(version)
+	if err != nil {
+		configLog(applog.Fields{"service": serviceName}).WithContext(ctx).Error(err, "error recording module config")
+		return version, err
+	}
+	configLog(applog.Fields{"service": serviceName, "version": version.Version, "action": action}).WithContext(ctx).Info("module config recorded")
+	return version, nil
+}
+
+// fetch reads the module's current config, which is also its template. The values of secrets are concealed so the
+// config can be compared with, and recorded alongside, configs holding references
+func (s *Store) fetch(ctx context.Context, target *url.URL, user *authstructs.Token) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.sign(req, target, user); err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the module answered GET %s with %d", ConfigEndpoint, resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, s.cfg.MaxBytes))
+	if err != nil {
+		return nil, err
+	}
+	config, err := decodeObject(data)
+	if err != nil {
+		return nil, err
+	}
+	return s.secrets.ConcealJSON(config).(map[string]interface{}), nil
+}
+
+// post sends a whole config to the module, with the secrets it references resolved
+func (s *Store) post(ctx context.Context, target *url.URL, config []byte, user *authstructs.Token) error {
+	resolved, err := s.resolve(config)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), bytes.NewReader(resolved))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err = s.sign(req, target, user); err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the module answered POST %s with %d", ConfigEndpoint, resp.StatusCode)
+	}
+	return nil
+}
+
+// sign sets the identity of the user on a config request, with the key of the module the target is, see
+// routing.SignRequest
+func (s *Store) sign(req *http.Request, target *url.URL, user *authstructs.Token) error {
+	if user == nil {
+		return nil
+	}
+	return routing.SignRequest(req, target.Hostname(), user.UserID, user.Email, user.Name, s.cfg.SigningKey)
+}
+
+// configURL returns the URL of the module's config endpoint, the same target the proxy forwards to
+func configURL(module modulestructs.ModuleMetadata) (*url.URL, error) {
+	for _, ep := range module.ModuleEndpoints {
+		if ep.Endpoint == ConfigEndpoint {
+			return url.Parse(fmt.Sprintf("http://%s:%s%s", module.ModuleServiceName, module.InternalPort, ConfigEndpoint))
+		}
+	}
+	return nil, ErrNotConfigurable
+}
+
+// author returns the identity the config requests for a version are made with
+func author(version structs.ConfigVersion) *authstructs.Token {
+	if version.AuthorID == 0 && version.AuthorEmail == "" {
+		return nil
+	}
+	return &authstructs.Token{UserID: version.AuthorID, Email: version.AuthorEmail, Name: version.AuthorName}
+}
+
+// Registered replays the latest config to a module in the background, it is called whenever a module registers
+func (s *Store) Registered(module modulestructs.ModuleMetadata) {
+	if _, err := configURL(module); err != nil {
+		return
+	}
+	go s.Replay(context.Background(), module)
+}
+
+// Replay sends the latest version of the config to the module unless the module already has it, it is retried
+// ReplayAttempts times as a module can register before it is ready to take its config
+func (s *Store) Replay(ctx context.Context, module modulestructs.ModuleMetadata) error {
+	target, err := configURL(module)
+	if err != nil {
+		return err
+	}
+	lock := s.lock(module.ModuleServiceName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	latest, err := s.repo.GetLatestConfig(module.ModuleServiceName)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		configLog(applog.Fields{"service": module.ModuleServiceName}).Error(err, "error reading the latest module config")
+		return err
+	}
+	logger := configLog(applog.Fields{"service": module.ModuleServiceName, "version": latest.Version})
+
+	for attempt := 1; ; attempt++ {
+		var replayed bool
+		replayed, err = s.replay(ctx, target, latest)
+		if err == nil {
+			if replayed {
+				logger.Info("module config replayed")
+				s.ns.Send(notification.Event{
+					EventType: notification.Success,
+					Value:     fmt.Sprintf("Config version %d was restored to module %s", latest.Version, module.ModuleDisplayName),
+					Context:   notification.EventContext{Type: notification.Module, Identifier: module.ModuleServiceName, State: notification.ConfigReplayed},
+				})
+			}
+			return nil
+		}
+		// A config which no longer matches the module's template will not match it on the next attempt either
+		if attempt >= s.cfg.ReplayAttempts || errors.Is(err, ErrInvalidConfig) {
+			break
+		}
+		select {
+		case <-time.After(s.cfg.ReplayBackoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	logger.Error(err, "error replaying module config")
+	s.ns.Send(notification.Event{
+		EventType: notification.Error,
+		Value:     fmt.Sprintf("Config version %d could not be restored to module %s: %s", latest.Version, module.ModuleDisplayName, err),
+		Context:   notification.EventContext{Type: notification.Module, Identifier: module.ModuleServiceName, State: notification.ConfigReplayed},
+	})
+	return err
+}
+
+// replay posts the config to the module if its current config differs, it reports whether the config was posted
+func (s *Store) replay(ctx context.Context, target *url.URL, version structs.ConfigVersion) (bool, error) {
+	current, err := s.fetch(ctx, target, author(version))
+	if err != nil {
+		return false, err
+	}
+	config, err := Apply(current, version.Config)
+	if err != nil {
+		return false, err
+	}
+	if reflect.DeepEqual(current, config) {
+		return false, nil
+	}
+	return true, s.post(ctx, target, version.Config, author(version))
+}
+
+// Rollback applies an earlier version of the config to the module and records it as the newest version. The version
+// must still match the module's template, e.g. it is refused if the module has since dropped one of its fields
+func (s *Store) Rollback(ctx context.Context, module modulestructs.ModuleMetadata, version int, user *authstructs.Token) (structs.ConfigVersion, error) {
+	target, err := configURL(module)
+	if err != nil {
+		return structs.ConfigVersion{}, err
+	}
+	lock := s.lock(module.ModuleServiceName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	previous, err := s.repo.GetConfig(module.ModuleServiceName, version)
+	if err == sql.ErrNoRows {
+		return structs.ConfigVersion{}, ErrVersionNotFound
+	}
+	if err != nil {
+		return structs.ConfigVersion{}, err
+	}
+
+	template, err := s.fetch(ctx, target, user)
+	if err != nil {
+		return structs.ConfigVersion{}, err
+	}
+	config, err := Apply(template, previous.Config)
+	if err != nil {
+		return structs.ConfigVersion{}, err
+	}
+	if err := s.post(ctx, target, previous.Config, user); err != nil {
+		return structs.ConfigVersion{}, err
+	}
+	return s.record(ctx, module.ModuleServiceName, config, user, structs.ConfigRolledBack, version)
+}
+
+// Diff returns the changes from one version of a module's config to another, the latest version is used when to is 0
+func (s *Store) Diff(serviceName string, from, to int) (structs.ConfigDiff, error) {
+	a, err := s.repo.GetConfig(serviceName, from)
+	if err != nil {
+		return structs.ConfigDiff{}, notFound(err)
+	}
+	var b structs.ConfigVersion
+	if to == 0 {
+		b, err = s.repo.GetLatestConfig(serviceName)
+	} else {
+		b, err = s.repo.GetConfig(serviceName, to)
+	}
+	if err != nil {
+		return structs.ConfigDiff{}, notFound(err)
+	}
+
+	changes, err := Diff(a.Config, b.Config)
+	if err != nil {
+		return structs.ConfigDiff{}, err
+	}
+	return structs.ConfigDiff{ModuleServiceName: serviceName, From: a.Version, To: b.Version, Changes: changes}, nil
+}
+
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrVersionNotFound
+	}
+	return err
+}
@@ -0,0 +1,256 @@
+package moduleconfig
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	authstructs "fp-dynamic-elements-manager-controller/internal/auth/structs"
+	"fp-dynamic-elements-manager-controller/internal/moduleconfig/mocks"
+	"fp-dynamic-elements-manager-controller/internal/moduleconfig/structs"
+	modulestructs "fp-dynamic-elements-manager-controller/internal/modules/structs"
+	"fp-dynamic-elements-manager-controller/internal/notification"
+	"fp-dynamic-elements-manager-controller/internal/routing"
+	"fp-dynamic-elements-manager-controller/internal/secrets"
+	secretmocks "fp-dynamic-elements-manager-controller/internal/secrets/mocks"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeModule serves a config endpoint, POST merges the posted fields into its config. key is the module's identity
+// signing key
+type fakeModule struct {
+	mu     sync.Mutex
+	key    string
+	config map[string]interface{}
+	posts  []string
+	users  []string
+	status int
+}
+
+func (f *fakeModule) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Method == http.MethodGet {
+		json.NewEncoder(w).Encode(f.config)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	f.posts = append(f.posts, string(body))
+	f.users = append(f.users, f.user(r))
+	if f.status != http.StatusOK {
+		w.WriteHeader(f.status)
+		return
+	}
+	var posted map[string]interface{}
+	json.Unmarshal(body, &posted)
+	for k, v := range posted {
+		f.config[k] = v
+	}
+}
+
+// signingKey is the proxy signing key, the fake module holds the key derived from it for its service name
+const signingKey = "0123456789abcdef0123456789abcdef"
+
+// user returns the user ID of the request, which is empty unless the identity is signed with the module's key
+func (f *fakeModule) user(r *http.Request) string {
+	h := r.Header
+	if routing.SignIdentity(f.key, h.Get(routing.UserIDHeader), h.Get(routing.UserEmailHeader), h.Get(routing.UserNameHeader), h.Get(routing.TimestampHeader)) != h.Get(routing.SignatureHeader) {
+		return ""
+	}
+	return h.Get(routing.UserIDHeader)
+}
+
+type ConfigStoreTestSuite struct {
+	suite.Suite
+	fake   *fakeModule
+	server *httptest.Server
+	module modulestructs.ModuleMetadata
+	repo   *mocks.MockConfigRepo
+	ns     *mocks.NSMock
+	store  *Store
+	admin  *authstructs.Token
+}
+
+func TestConfigStore(t *testing.T) {
+	suite.Run(t, new(ConfigStoreTestSuite))
+}
+
+func (c *ConfigStoreTestSuite) SetupTest() {
+	c.fake = &fakeModule{config: map[string]interface{}{"host": "", "port": 443.0, "api_key": "s3cr3t-api-key"}, status: http.StatusOK}
+	c.server = httptest.NewServer(c.fake)
+	target, _ := url.Parse(c.server.URL)
+	c.fake.key = routing.ModuleSigningKey(signingKey, target.Hostname())
+	c.module = modulestructs.ModuleMetadata{
+		ModuleServiceName: target.Hostname(),
+		ModuleDisplayName: "Fake Module",
+		InternalPort:      target.Port(),
+		InboundRoute:      "/fake",
+		ModuleEndpoints:   []modulestructs.ModuleEndpoint{{Endpoint: ConfigEndpoint, Secure: true}},
+	}
+
+	c.repo = new(mocks.MockConfigRepo)
+	c.repo.On("InsertConfig", mock.Anything).Return(structs.ConfigVersion{Version: 2}, nil)
+	c.ns = new(mocks.NSMock)
+	c.ns.On("Send", mock.Anything).Return()
+
+	cfg := DefaultConfig()
+	cfg.ReplayAttempts = 2
+	cfg.ReplayBackoff = 0
+	cfg.SigningKey = signingKey
+	keyring, _ := secrets.NewKeyring([]byte(strings.Repeat("k", 32)))
+	secretRepo := new(secretmocks.MockSecretStore)
+	secretRepo.On("UpsertSecret", mock.Anything).Return(nil)
+	secretService := secrets.NewService(keyring, secretRepo)
+	secretService.Put("fw-api-key", "s3cr3t-api-key", "admin@example.com")
+	c.store = NewStore(cfg, c.repo, c.ns, secretService)
+	c.admin = &authstructs.Token{UserID: 1, Email: "admin@example.com", Name: "Admin", Admin: true}
+}
+
+func (c *ConfigStoreTestSuite) TearDownTest() {
+	c.server.Close()
+}
+
+func (c *ConfigStoreTestSuite) post(body string) *httptest.ResponseRecorder {
+	return c.serve(httptest.NewRequest(http.MethodPost, "/api/fake/config", strings.NewReader(body)))
+}
+
+func (c *ConfigStoreTestSuite) serve(req *http.Request) *httptest.ResponseRecorder {
+	proxyConfig := routing.DefaultProxyConfig()
+	proxyConfig.SigningKey = signingKey
+	router := routing.NewModuleRouter("/api", "/ingress", c.store.Intercept(routing.NewProxyFactory(proxyConfig)))
+	router.Upsert(c.module)
+
+	req = req.WithContext(context.WithValue(req.Context(), "user", c.admin))
+	rec := httptest.NewRecorder()
+	router.SecureHandler().ServeHTTP(rec, req)
+	return rec
+}
+
+func (c *ConfigStoreTestSuite) version(version int, config string) structs.ConfigVersion {
+	return structs.ConfigVersion{
+		ModuleServiceName: c.module.ModuleServiceName,
+		Version:           version,
+		Config:            json.RawMessage(config),
+		AuthorID:          1,
+		AuthorEmail:       "admin@example.com",
+	}
+}
+
+func (c *ConfigStoreTestSuite) TestIntercept() {
+	rec := c.post(`{"host": "ngfw.example.com"}`)
+	c.Equal(http.StatusOK, rec.Code)
+	c.Equal([]string{`{"host": "ngfw.example.com"}`}, c.fake.posts, "the posted config is forwarded as it is")
+	c.Equal([]string{"1"}, c.fake.users)
+
+	// The value of the secret read from the module is recorded as its reference
+	c.repo.AssertCalled(c.T(), "InsertConfig", mock.MatchedBy(func(v structs.ConfigVersion) bool {
+		return v.Action == structs.ConfigUpdated && v.AuthorID == 1 && v.AuthorEmail == "admin@example.com" &&
+			string(v.Config) == `{"api_key":"${secret:fw-api-key}","host":"ngfw.example.com","port":443}`
+	}))
+}
+
+func (c *ConfigStoreTestSuite) TestIntercept_Secrets() {
+	rec := c.post(`{"api_key": "${secret:fw-api-key}"}`)
+	c.Equal(http.StatusOK, rec.Code)
+	c.Equal([]string{`{"api_key":"s3cr3t-api-key"}`}, c.fake.posts, "the module is sent the value of the secret")
+
+	rec = c.serve(httptest.NewRequest(http.MethodGet, "/api/fake/config", nil))
+	c.Equal(http.StatusOK, rec.Code)
+	c.JSONEq(`{"api_key":"${secret:fw-api-key}","host":"","port":443}`, rec.Body.String())
+
+	rec = c.post(`{"api_key": "${secret:missing}"}`)
+	c.Equal(http.StatusBadRequest, rec.Code)
+	c.Contains(rec.Body.String(), "secret not found: missing")
+	c.Len(c.fake.posts, 1)
+}
+
+func (c *ConfigStoreTestSuite) TestIntercept_Refused() {
+	rec := c.post(`{"host": 1}`)
+	c.Equal(http.StatusBadRequest, rec.Code)
+	c.Contains(rec.Body.String(), "host must be a string")
+
+	c.fake.status = http.StatusUnprocessableEntity
+	rec = c.post(`{"host": "ngfw.example.com"}`)
+	c.Equal(http.StatusUnprocessableEntity, rec.Code)
+
+	c.Len(c.fake.posts, 1, "only the valid config reached the module")
+	c.repo.AssertNotCalled(c.T(), "InsertConfig", mock.Anything)
+}
+
+func (c *ConfigStoreTestSuite) TestReplay() {
+	c.repo.On("GetLatestConfig", c.module.ModuleServiceName).Return(c.version(3, `{"api_key":"${secret:fw-api-key}","host":"ngfw.example.com","port":8443}`), nil)
+
+	c.Nil(c.store.Replay(context.Background(), c.module))
+	c.Equal([]string{`{"api_key":"s3cr3t-api-key","host":"ngfw.example.com","port":8443}`}, c.fake.posts)
+	c.Equal([]string{"1"}, c.fake.users, "the config is replayed as its author")
+	c.ns.AssertCalled(c.T(), "Send", mock.MatchedBy(func(e notification.Event) bool {
+		return e.EventType == notification.Success && e.Context.State == notification.ConfigReplayed
+	}))
+
+	c.T().Run("Test a module which has the config is left alone", func(t *testing.T) {
+		c.Nil(c.store.Replay(context.Background(), c.module))
+		c.Len(c.fake.posts, 1)
+	})
+}
+
+func (c *ConfigStoreTestSuite) TestReplay_Failed() {
+	c.repo.On("GetLatestConfig", c.module.ModuleServiceName).Return(c.version(3, `{"host":"ngfw.example.com"}`), nil)
+	c.fake.status = http.StatusServiceUnavailable
+
+	c.NotNil(c.store.Replay(context.Background(), c.module))
+	c.Len(c.fake.posts, 2, "the replay is retried")
+	c.ns.AssertCalled(c.T(), "Send", mock.MatchedBy(func(e notification.Event) bool {
+		return e.EventType == notification.Error && e.Context.State == notification.ConfigReplayed
+	}))
+}
+
+func (c *ConfigStoreTestSuite) TestReplay_NoConfig() {
+	c.repo.On("GetLatestConfig", c.module.ModuleServiceName).Return(structs.ConfigVersion{}, sql.ErrNoRows)
+
+	c.Nil(c.store.Replay(context.Background(), c.module))
+	c.Empty(c.fake.posts)
+	c.Equal(ErrNotConfigurable, c.store.Replay(context.Background(), modulestructs.ModuleMetadata{ModuleServiceName: "fp-dep"}))
+}
+
+func (c *ConfigStoreTestSuite) TestRollback() {
+	c.repo.On("GetConfig", c.module.ModuleServiceName, 1).Return(c.version(1, `{"host":"old.example.com","port":443}`), nil)
+	c.repo.On("GetConfig", c.module.ModuleServiceName, 2).Return(c.version(2, `{"host":"old.example.com","removed":true}`), nil)
+	c.repo.On("GetConfig", c.module.ModuleServiceName, 9).Return(structs.ConfigVersion{}, sql.ErrNoRows)
+
+	version, err := c.store.Rollback(context.Background(), c.module, 1, c.admin)
+	c.Require().Nil(err)
+	c.Equal(2, version.Version)
+	c.repo.AssertCalled(c.T(), "InsertConfig", mock.MatchedBy(func(v structs.ConfigVersion) bool {
+		return v.Action == structs.ConfigRolledBack && v.RollbackOf == 1 && v.AuthorID == 1
+	}))
+	c.Equal([]string{`{"host":"old.example.com","port":443}`}, c.fake.posts)
+
+	_, err = c.store.Rollback(context.Background(), c.module, 2, c.admin)
+	c.True(errors.Is(err, ErrInvalidConfig), "the module no longer has the removed field")
+	_, err = c.store.Rollback(context.Background(), c.module, 9, c.admin)
+	c.Equal(ErrVersionNotFound, err)
+	c.Len(c.fake.posts, 1)
+}
+
+func (c *ConfigStoreTestSuite) TestDiff() {
+	c.repo.On("GetConfig", "fp-dep", 1).Return(c.version(1, `{"host":"a","port":443}`), nil)
+	c.repo.On("GetConfig", "fp-dep", 5).Return(structs.ConfigVersion{}, sql.ErrNoRows)
+	c.repo.On("GetLatestConfig", "fp-dep").Return(c.version(4, `{"host":"b","port":443}`), nil)
+
+	diff, err := c.store.Diff("fp-dep", 1, 0)
+	c.Require().Nil(err)
+	c.Equal(1, diff.From)
+	c.Equal(4, diff.To)
+	c.Equal([]structs.ConfigChange{{Field: "host", Change: structs.FieldChanged, From: "a", To: "b"}}, diff.Changes)
+
+	_, err = c.store.Diff("fp-dep", 1, 5)
+	c.Equal(ErrVersionNotFound, err)
+}
@@ -0,0 +1,146 @@
+package moduleconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"fp-dynamic-elements-manager-controller/internal/moduleconfig/structs"
+	"reflect"
+	"sort"
+)
+
+// ErrInvalidConfig is wrapped by every error caused by a config which does not match the module's template
+var ErrInvalidConfig = errors.New("invalid config")
+
+// decodeObject decodes a JSON object, numbers are kept as json.Number so they are stored exactly as they were sent
+func decodeObject(data []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil || object == nil {
+		return nil, fmt.Errorf("%w: the config must be a JSON object", ErrInvalidConfig)
+	}
+	return object, nil
+}
+
+// Apply checks the posted config against the template and returns the template with the posted fields merged in.
+// The template is the config the module returns from GET /config: a posted field must be in the template and have
+// the same JSON type as the template's value, unless that value is null. Nested objects are checked and merged field
+// by field, any other value replaces the template's value
+func Apply(template map[string]interface{}, posted []byte) (map[string]interface{}, error) {
+	update, err := decodeObject(posted)
+	if err != nil {
+		return nil, err
+	}
+	if err := validate(template, update, ""); err != nil {
+		return nil, err
+	}
+	return merge(template, update), nil
+}
+
+func validate(template, update map[string]interface{}, prefix string) error {
+	for name, value := range update {
+		field := prefix + name
+		expected, ok := template[name]
+		if !ok {
+			return fmt.Errorf("%w: %s is not a field of the module's config", ErrInvalidConfig, field)
+		}
+		if expected == nil || value == nil {
+			continue
+		}
+		if kindOf(expected) != kindOf(value) {
+			return fmt.Errorf("%w: %s must be %s", ErrInvalidConfig, field, kindOf(expected))
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if err := validate(expected.(map[string]interface{}), v, field+"."); err != nil {
+				return err
+			}
+		case []interface{}:
+			// The items of a list must all be of the type of the template's first item
+			if items := expected.([]interface{}); len(items) > 0 && items[0] != nil {
+				for i, item := range v {
+					if item != nil && kindOf(item) != kindOf(items[0]) {
+						return fmt.Errorf("%w: %s[%d] must be %s", ErrInvalidConfig, field, i, kindOf(items[0]))
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func kindOf(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "a list"
+	case string:
+		return "a string"
+	case json.Number, float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	}
+	return "null"
+}
+
+func merge(template, update map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(template))
+	for name, value := range template {
+		merged[name] = value
+	}
+	for name, value := range update {
+		current, currentOK := merged[name].(map[string]interface{})
+		posted, postedOK := value.(map[string]interface{})
+		if currentOK && postedOK {
+			merged[name] = merge(current, posted)
+			continue
+		}
+		merged[name] = value
+	}
+	return merged
+}
+
+// Diff returns the fields which were added, removed or changed from one config to the other, sorted by field
+func Diff(from, to []byte) ([]structs.ConfigChange, error) {
+	a, err := decodeObject(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := decodeObject(to)
+	if err != nil {
+		return nil, err
+	}
+	changes := diffObjects(a, b, "", []structs.ConfigChange{})
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes, nil
+}
+
+func diffObjects(from, to map[string]interface{}, prefix string, changes []structs.ConfigChange) []structs.ConfigChange {
+	for name, old := range from {
+		field := prefix + name
+		value, ok := to[name]
+		if !ok {
+			changes = append(changes, structs.ConfigChange{Field: field, Change: structs.FieldRemoved, From: old})
+			continue
+		}
+		oldObject, oldOK := old.(map[string]interface{})
+		newObject, newOK := value.(map[string]interface{})
+		if oldOK && newOK {
+			changes = diffObjects(oldObject, newObject, field+".", changes)
+			continue
+		}
+		if !reflect.DeepEqual(old, value) {
+			changes = append(changes, structs.ConfigChange{Field: field, Change: structs.FieldChanged, From: old, To: value})
+		}
+	}
+	for name, value := range to {
+		if _, ok := from[name]; !ok {
+			changes = append(changes, structs.ConfigChange{Field: prefix + name, Change: structs.FieldAdded, To: value})
+		}
+	}
+	return changes
+}
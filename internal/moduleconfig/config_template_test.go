@@ -0,0 +1,84 @@
+package moduleconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"fp-dynamic-elements-manager-controller/internal/moduleconfig/structs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type ConfigTemplateTestSuite struct {
+	suite.Suite
+	template map[string]interface{}
+}
+
+func TestConfigTemplate(t *testing.T) {
+	suite.Run(t, new(ConfigTemplateTestSuite))
+}
+
+func (c *ConfigTemplateTestSuite) SetupTest() {
+	var err error
+	c.template, err = decodeObject([]byte(`{
+		"host": "", "port": 443, "enabled": false, "token": null,
+		"proxy": {"host": "", "port": 0},
+		"tags": ["default"]
+	}`))
+	c.Require().Nil(err)
+}
+
+func (c *ConfigTemplateTestSuite) TestApply() {
+	config, err := Apply(c.template, []byte(`{"host": "ngfw.example.com", "token": 12, "proxy": {"port": 8080}, "tags": ["a", "b"]}`))
+	c.Require().Nil(err)
+
+	data, err := json.Marshal(config)
+	c.Nil(err)
+	c.JSONEq(`{
+		"host": "ngfw.example.com", "port": 443, "enabled": false, "token": 12,
+		"proxy": {"host": "", "port": 8080},
+		"tags": ["a", "b"]
+	}`, string(data))
+}
+
+func (c *ConfigTemplateTestSuite) TestApply_Invalid() {
+	invalid := map[string]string{
+		"not an object":       `["host"]`,
+		"unknown field":       `{"hostname": "ngfw"}`,
+		"wrong type":          `{"port": "443"}`,
+		"wrong nested type":   `{"proxy": {"port": true}}`,
+		"unknown nested":      `{"proxy": {"user": "admin"}}`,
+		"object for a value":  `{"host": {"name": "ngfw"}}`,
+		"wrong list item":     `{"tags": ["a", 1]}`,
+		"malformed":           `{"host": `,
+		"null for the config": `null`,
+	}
+	for name, posted := range invalid {
+		_, err := Apply(c.template, []byte(posted))
+		c.True(errors.Is(err, ErrInvalidConfig), name)
+	}
+
+	_, err := Apply(c.template, []byte(`{"proxy": {"port": "8080"}}`))
+	c.EqualError(err, "invalid config: proxy.port must be a number")
+}
+
+func (c *ConfigTemplateTestSuite) TestDiff() {
+	changes, err := Diff(
+		[]byte(`{"host": "a", "port": 443, "proxy": {"host": "p", "port": 0}, "tags": ["a"], "old": true}`),
+		[]byte(`{"host": "b", "port": 443, "proxy": {"host": "p", "port": 8080}, "tags": ["a", "b"], "new": 1}`),
+	)
+	c.Require().Nil(err)
+	c.Equal([]structs.ConfigChange{
+		{Field: "host", Change: structs.FieldChanged, From: "a", To: "b"},
+		{Field: "new", Change: structs.FieldAdded, To: json.Number("1")},
+		{Field: "old", Change: structs.FieldRemoved, From: true},
+		{Field: "proxy.port", Change: structs.FieldChanged, From: json.Number("0"), To: json.Number("8080")},
+		{Field: "tags", Change: structs.FieldChanged, From: []interface{}{"a"}, To: []interface{}{"a", "b"}},
+	}, changes)
+
+	c.T().Run("Test identical configs have no changes", func(t *testing.T) {
+		changes, err := Diff([]byte(`{"a": {"b": 1}}`), []byte(`{"a": {"b": 1}}`))
+		assert.Nil(t, err)
+		assert.Empty(t, changes)
+	})
+}
@@ -0,0 +1,48 @@
+package mocks
+
+import (
+	"fp-dynamic-elements-manager-controller/internal/moduleconfig/structs"
+	"fp-dynamic-elements-manager-controller/internal/notification"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockConfigRepo struct {
+	mock.Mock
+}
+
+func (m *MockConfigRepo) InsertConfig(config structs.ConfigVersion) (structs.ConfigVersion, error) {
+	args := m.Called(config)
+	return args.Get(0).(structs.ConfigVersion), args.Error(1)
+}
+
+func (m *MockConfigRepo) GetLatestConfig(serviceName string) (structs.ConfigVersion, error) {
+	args := m.Called(serviceName)
+	return args.Get(0).(structs.ConfigVersion), args.Error(1)
+}
+
+func (m *MockConfigRepo) GetConfig(serviceName string, version int) (structs.ConfigVersion, error) {
+	args := m.Called(serviceName, version)
+	return args.Get(0).(structs.ConfigVersion), args.Error(1)
+}
+
+func (m *MockConfigRepo) GetConfigs(serviceName string, limit int) ([]structs.ConfigVersion, error) {
+	args := m.Called(serviceName, limit)
+	return args.Get(0).([]structs.ConfigVersion), args.Error(1)
+}
+
+type NSMock struct {
+	mock.Mock
+}
+
+func (n *NSMock) Receive() {
+	n.Called()
+}
+
+func (n *NSMock) Send(event notification.Event) {
+	n.Called(event)
+}
+
+func (n *NSMock) Hub() *notification.Hub {
+	n.Called()
+	return nil
+}
@@ -0,0 +1,56 @@
+package structs
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type ConfigAction string
+
+const (
+	// ConfigUpdated is a config posted to the module through its proxied /config endpoint
+	ConfigUpdated ConfigAction = "update"
+	// ConfigRolledBack is an earlier version of the config applied to the module again
+	ConfigRolledBack ConfigAction = "rollback"
+)
+
+// ConfigVersion is a version of a module's config. Config holds the whole config the module had once the change was
+// applied, not only the fields which were posted, so any version can be applied to the module on its own
+type ConfigVersion struct {
+	ID                int64           `json:"id" db:"id"`
+	ModuleServiceName string          `json:"module_service_name" db:"module_service_name"`
+	Version           int             `json:"version" db:"version"`
+	Config            json.RawMessage `json:"config" db:"config"`
+	Action            ConfigAction    `json:"action" db:"action"`
+	// RollbackOf is the version a rollback applied again
+	RollbackOf  int       `json:"rollback_of,omitempty" db:"rollback_of"`
+	AuthorID    uint      `json:"author_id" db:"author_id"`
+	AuthorEmail string    `json:"author_email" db:"author_email"`
+	AuthorName  string    `json:"author_name" db:"author_name"`
+	RequestID   string    `json:"request_id,omitempty" db:"request_id"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+type ChangeType string
+
+const (
+	FieldAdded   ChangeType = "added"
+	FieldRemoved ChangeType = "removed"
+	FieldChanged ChangeType = "changed"
+)
+
+// ConfigChange is a field which differs between two versions, nested fields are named by their path, e.g. proxy.port
+type ConfigChange struct {
+	Field  string      `json:"field"`
+	Change ChangeType  `json:"change"`
+	From   interface{} `json:"from,omitempty"`
+	To     interface{} `json:"to,omitempty"`
+}
+
+// ConfigDiff is the changes made to a module's config from one version to another
+type ConfigDiff struct {
+	ModuleServiceName string         `json:"module_service_name"`
+	From              int            `json:"from"`
+	To                int            `json:"to"`
+	Changes           []ConfigChange `json:"changes"`
+}
@@ -0,0 +1,20 @@
+package mocks
+
+import (
+	"fp-dynamic-elements-manager-controller/internal/modules/structs"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockModuleLookupRepo struct {
+	mock.Mock
+}
+
+func (r *MockModuleLookupRepo) GetByServiceName(svcName string) (structs.ModuleMetadata, error) {
+	args := r.Called(svcName)
+	return args.Get(0).(structs.ModuleMetadata), args.Error(1)
+}
+
+func (r *MockModuleLookupRepo) GetByInboundRoute(route string) (structs.ModuleMetadata, error) {
+	args := r.Called(route)
+	return args.Get(0).(structs.ModuleMetadata), args.Error(1)
+}
@@ -2,12 +2,15 @@ package modules
 
 import (
 	"fp-dynamic-elements-manager-controller/internal/db/persistence"
+	"fp-dynamic-elements-manager-controller/internal/docker"
 	"fp-dynamic-elements-manager-controller/internal/health"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
 	"fp-dynamic-elements-manager-controller/internal/modules/structs"
-	"github.com/rs/zerolog/log"
 )
 
-func GetModuleData(moduleType structs.ModuleType, dao *persistence.DataAccessObject) (receiver []structs.ModuleMetadata, err error) {
+// GetModuleData returns the registered modules with the health cached by the monitor and the recent container stats
+// kept by the sampler, modules are never probed here
+func GetModuleData(moduleType structs.ModuleType, dao *persistence.DataAccessObject, monitor *health.Monitor, sampler *docker.StatsSampler) (receiver []structs.ModuleMetadata, err error) {
 	if moduleType == "" {
 		receiver, err = dao.ModuleMetadataRepo.GetAllModuleMetadata()
 	} else {
@@ -15,11 +18,17 @@ func GetModuleData(moduleType structs.ModuleType, dao *persistence.DataAccessObj
 	}
 
 	if err != nil {
-		log.Error().Err(err)
+		applog.System().Error(err, "error retrieving module metadata")
 		return nil, err
 	}
 
-	health.ModuleHealthCheck(receiver, dao)
+	for i := range receiver {
+		receiver[i].ModuleHealth = monitor.Health(receiver[i].ModuleServiceName)
+		receiver[i].ModuleHealth.ModuleName = receiver[i].ModuleDisplayName
+		health.SetLastUpdate(&receiver[i], dao)
+		stats := sampler.Stats(receiver[i].ModuleServiceName)
+		receiver[i].ContainerStats = &stats
+	}
 
 	return receiver, nil
 }
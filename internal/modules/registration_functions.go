@@ -0,0 +1,213 @@
+package modules
+
+import (
+	"database/sql"
+	"fmt"
+	authstructs "fp-dynamic-elements-manager-controller/internal/auth/structs"
+	"fp-dynamic-elements-manager-controller/internal/db/persistence"
+	healthstructs "fp-dynamic-elements-manager-controller/internal/health/structs"
+	"fp-dynamic-elements-manager-controller/internal/modules/structs"
+	structs2 "fp-dynamic-elements-manager-controller/internal/queue/structs"
+	"github.com/pkg/errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxEndpoints      = 32
+	maxEndpointLength = 128
+)
+
+var ErrInvalidRegistration = errors.New("invalid registration")
+var ErrRouteCollision = errors.New("inbound route already registered to another module")
+var ErrServiceCollision = errors.New("service name already registered with another inbound route")
+
+// reservedRoutes are the first path segments used by the controller itself, modules cannot claim any of these
+// as their inbound route as it would shadow (or be shadowed by) the controller API
+var reservedRoutes = map[string]struct{}{
+	"api": {}, "internal": {}, "ingress": {}, "login": {}, "ws": {}, "export": {}, "backup": {}, "keys": {},
+	"health": {}, "stats": {}, "logs": {}, "modules": {}, "docker": {}, "batch": {}, "user": {}, "elements": {},
+	"register": {}, "queue": {}, "update": {}, "logevent": {}, "lookup": {}, "metrics": {},
+}
+
+var serviceNameRegex = regexp.MustCompile("^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,127}$")
+var inboundRouteRegex = regexp.MustCompile("^/[a-z0-9][a-z0-9_-]{0,62}$")
+var endpointRegex = regexp.MustCompile("^(/[a-zA-Z0-9_-][a-zA-Z0-9._-]*)+$")
+
+var validMethods = map[string]struct{}{
+	http.MethodGet: {}, http.MethodPost: {}, http.MethodPut: {}, http.MethodPatch: {},
+	http.MethodDelete: {}, http.MethodHead: {}, http.MethodOptions: {},
+}
+
+var validRoles = map[authstructs.Role]struct{}{
+	authstructs.AnyRole: {},
+	authstructs.Viewer:  {},
+	authstructs.Admin:   {},
+}
+
+var validModuleTypes = map[structs.ModuleType]struct{}{
+	structs.EGRESS:     {},
+	structs.INGRESS:    {},
+	structs.FUNCTIONAL: {},
+}
+
+var validElementTypes = map[structs2.ElementType]struct{}{
+	structs2.IP:     {},
+	structs2.DOMAIN: {},
+	structs2.URL:    {},
+	structs2.RANGE:  {},
+	structs2.SNORT:  {},
+}
+
+// ValidateRegistration sanitises the ModuleMetadata posted by a module and checks it against the registration schema,
+// then checks that the inbound route and service name do not collide with an already registered module.
+// Errors returned wrap one of ErrInvalidRegistration, ErrRouteCollision or ErrServiceCollision
+func ValidateRegistration(metadata *structs.ModuleMetadata, repo persistence.ModuleLookupRepo) error {
+	sanitiseRegistration(metadata)
+
+	if err := validateSchema(*metadata); err != nil {
+		return err
+	}
+
+	return checkCollisions(*metadata, repo)
+}
+
+// sanitiseRegistration clears any fields which are owned by the controller so a module cannot overwrite another
+// module's row or forge its own health/audit data
+func sanitiseRegistration(metadata *structs.ModuleMetadata) {
+	metadata.ID = 0
+	metadata.CreatedAt = time.Time{}
+	metadata.UpdatedAt = time.Time{}
+	metadata.DeletedAt = nil
+	metadata.LastPing = time.Time{}
+	metadata.ModuleHealth = healthstructs.ModuleHealth{}
+	metadata.ModuleServiceName = strings.TrimSpace(metadata.ModuleServiceName)
+	metadata.InboundRoute = strings.TrimSpace(metadata.InboundRoute)
+	metadata.InternalPort = strings.TrimSpace(metadata.InternalPort)
+
+	for i := range metadata.ModuleEndpoints {
+		metadata.ModuleEndpoints[i].ID = 0
+		metadata.ModuleEndpoints[i].CreatedAt = time.Time{}
+		metadata.ModuleEndpoints[i].UpdatedAt = time.Time{}
+		metadata.ModuleEndpoints[i].DeletedAt = nil
+		metadata.ModuleEndpoints[i].ModuleMetadataId = 0
+		for j := range metadata.ModuleEndpoints[i].HttpMethods {
+			m := &metadata.ModuleEndpoints[i].HttpMethods[j]
+			m.Method = strings.ToUpper(strings.TrimSpace(m.Method))
+		}
+	}
+}
+
+func validateSchema(metadata structs.ModuleMetadata) error {
+	if !serviceNameRegex.MatchString(metadata.ModuleServiceName) {
+		return invalid("module_service_name must be a valid container name")
+	}
+
+	if strings.TrimSpace(metadata.ModuleDisplayName) == "" {
+		return invalid("module_display_name is required")
+	}
+
+	if _, ok := validModuleTypes[metadata.ModuleType]; !ok {
+		return invalid(fmt.Sprintf("module_type '%s' is not recognised", metadata.ModuleType))
+	}
+
+	if !inboundRouteRegex.MatchString(metadata.InboundRoute) {
+		return invalid("inbound_route must be a single lowercase path segment, e.g. /mymodule")
+	}
+
+	if _, ok := reservedRoutes[strings.TrimPrefix(metadata.InboundRoute, "/")]; ok {
+		return invalid(fmt.Sprintf("inbound_route '%s' is reserved by the controller", metadata.InboundRoute))
+	}
+
+	if port, err := strconv.Atoi(metadata.InternalPort); err != nil || port < 1 || port > 65535 {
+		return invalid("internal_port must be a number between 1 and 65535")
+	}
+
+	if len(metadata.ModuleEndpoints) > maxEndpoints {
+		return invalid(fmt.Sprintf("a module cannot register more than %d endpoints", maxEndpoints))
+	}
+
+	seen := make(map[string]struct{})
+	for _, ep := range metadata.ModuleEndpoints {
+		if len(ep.Endpoint) > maxEndpointLength || !endpointRegex.MatchString(ep.Endpoint) {
+			return invalid(fmt.Sprintf("endpoint '%s' is not a valid path", ep.Endpoint))
+		}
+		if _, ok := seen[ep.Endpoint]; ok {
+			return invalid(fmt.Sprintf("endpoint '%s' is registered more than once", ep.Endpoint))
+		}
+		seen[ep.Endpoint] = struct{}{}
+
+		if err := validateAccess(ep); err != nil {
+			return err
+		}
+	}
+
+	for _, elementType := range metadata.AcceptedElementTypes.ElementTypes {
+		if _, ok := validElementTypes[elementType]; !ok {
+			return invalid(fmt.Sprintf("element type '%s' is not recognised", elementType))
+		}
+	}
+
+	return nil
+}
+
+// validateAccess checks the methods and roles of an endpoint, roles can only be enforced on secure endpoints
+// as ingress requests do not carry a user
+func validateAccess(ep structs.ModuleEndpoint) error {
+	if _, ok := validRoles[ep.RequiredRole]; !ok {
+		return invalid(fmt.Sprintf("required_role '%s' of endpoint '%s' is not recognised", ep.RequiredRole, ep.Endpoint))
+	}
+
+	hasRole := ep.RequiredRole != authstructs.AnyRole
+	methods := make(map[string]struct{})
+	for _, m := range ep.HttpMethods {
+		if _, ok := validMethods[m.Method]; !ok {
+			return invalid(fmt.Sprintf("method '%s' of endpoint '%s' is not supported", m.Method, ep.Endpoint))
+		}
+		if _, ok := methods[m.Method]; ok {
+			return invalid(fmt.Sprintf("method '%s' of endpoint '%s' is registered more than once", m.Method, ep.Endpoint))
+		}
+		methods[m.Method] = struct{}{}
+		if _, ok := validRoles[m.Role]; !ok {
+			return invalid(fmt.Sprintf("role '%s' of %s '%s' is not recognised", m.Role, m.Method, ep.Endpoint))
+		}
+		hasRole = hasRole || m.Role != authstructs.AnyRole
+	}
+
+	if hasRole && !ep.Secure {
+		return invalid(fmt.Sprintf("endpoint '%s' must be secure to require a role", ep.Endpoint))
+	}
+
+	return nil
+}
+
+func checkCollisions(metadata structs.ModuleMetadata, repo persistence.ModuleLookupRepo) error {
+	byRoute, err := repo.GetByInboundRoute(metadata.InboundRoute)
+
+	if err != nil && err != sql.ErrNoRows {
+		return errors.Wrap(err, "error looking up module by inbound route")
+	}
+
+	if err == nil && byRoute.ModuleServiceName != metadata.ModuleServiceName {
+		return errors.Wrap(ErrRouteCollision, fmt.Sprintf("'%s' is owned by %s", metadata.InboundRoute, byRoute.ModuleServiceName))
+	}
+
+	byName, err := repo.GetByServiceName(metadata.ModuleServiceName)
+
+	if err != nil && err != sql.ErrNoRows {
+		return errors.Wrap(err, "error looking up module by service name")
+	}
+
+	if err == nil && byName.InboundRoute != metadata.InboundRoute {
+		return errors.Wrap(ErrServiceCollision, fmt.Sprintf("%s is registered on '%s'", metadata.ModuleServiceName, byName.InboundRoute))
+	}
+
+	return nil
+}
+
+func invalid(msg string) error {
+	return errors.Wrap(ErrInvalidRegistration, msg)
+}
@@ -0,0 +1,136 @@
+package modules
+
+import (
+	"database/sql"
+	authstructs "fp-dynamic-elements-manager-controller/internal/auth/structs"
+	"fp-dynamic-elements-manager-controller/internal/modules/mocks"
+	"fp-dynamic-elements-manager-controller/internal/modules/structs"
+	structs2 "fp-dynamic-elements-manager-controller/internal/queue/structs"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+func testMetadata() structs.ModuleMetadata {
+	return structs.ModuleMetadata{
+		ID:                5,
+		ModuleServiceName: "fp-dep",
+		ModuleDisplayName: "Forcepoint DEP",
+		ModuleType:        structs.EGRESS,
+		InboundRoute:      "/fpdep",
+		InternalPort:      "8080",
+		AcceptedElementTypes: structs.ElementTypesWrapper{
+			ElementTypes: []structs2.ElementType{structs2.IP, structs2.RANGE},
+		},
+		ModuleEndpoints: []structs.ModuleEndpoint{
+			{Endpoint: "/run"},
+			{Endpoint: "/health"},
+			{Endpoint: "/config", Secure: true, HttpMethods: structs.HttpMethods{
+				{Method: "GET"},
+				{Method: "POST", Role: authstructs.Admin},
+			}},
+		},
+	}
+}
+
+type RegistrationTestSuite struct {
+	suite.Suite
+}
+
+func TestRegistrationValidation(t *testing.T) {
+	suite.Run(t, new(RegistrationTestSuite))
+}
+
+func (r *RegistrationTestSuite) newRepo(byRoute, byName structs.ModuleMetadata, routeErr, nameErr error) *mocks.MockModuleLookupRepo {
+	repo := new(mocks.MockModuleLookupRepo)
+	repo.On("GetByInboundRoute", "/fpdep").Return(byRoute, routeErr)
+	repo.On("GetByServiceName", "fp-dep").Return(byName, nameErr)
+	return repo
+}
+
+func (r *RegistrationTestSuite) TestValidRegistration() {
+	r.T().Run("Test new module is accepted and sanitised", func(t *testing.T) {
+		repo := r.newRepo(structs.ModuleMetadata{}, structs.ModuleMetadata{}, sql.ErrNoRows, sql.ErrNoRows)
+		metadata := testMetadata()
+		assert.Nil(t, ValidateRegistration(&metadata, repo))
+		assert.Equal(t, int64(0), metadata.ID)
+		repo.AssertExpectations(t)
+	})
+
+	r.T().Run("Test methods are normalised", func(t *testing.T) {
+		repo := r.newRepo(structs.ModuleMetadata{}, structs.ModuleMetadata{}, sql.ErrNoRows, sql.ErrNoRows)
+		metadata := testMetadata()
+		metadata.ModuleEndpoints[2].HttpMethods[0].Method = " get"
+		assert.Nil(t, ValidateRegistration(&metadata, repo))
+		assert.Equal(t, "GET", metadata.ModuleEndpoints[2].HttpMethods[0].Method)
+		repo.AssertExpectations(t)
+	})
+
+	r.T().Run("Test re-registration of the same module is accepted", func(t *testing.T) {
+		existing := testMetadata()
+		repo := r.newRepo(existing, existing, nil, nil)
+		metadata := testMetadata()
+		assert.Nil(t, ValidateRegistration(&metadata, repo))
+	})
+}
+
+func (r *RegistrationTestSuite) TestInvalidRegistration() {
+	cases := map[string]func(*structs.ModuleMetadata){
+		"Test missing service name":      func(m *structs.ModuleMetadata) { m.ModuleServiceName = "" },
+		"Test unknown module type":       func(m *structs.ModuleMetadata) { m.ModuleType = "superuser" },
+		"Test reserved inbound route":    func(m *structs.ModuleMetadata) { m.InboundRoute = "/api" },
+		"Test nested inbound route":      func(m *structs.ModuleMetadata) { m.InboundRoute = "/fpdep/../docker" },
+		"Test invalid port":              func(m *structs.ModuleMetadata) { m.InternalPort = "99999" },
+		"Test endpoint path traversal":   func(m *structs.ModuleMetadata) { m.ModuleEndpoints[0].Endpoint = "/../../api/user" },
+		"Test endpoint without slash":    func(m *structs.ModuleMetadata) { m.ModuleEndpoints[0].Endpoint = "run" },
+		"Test endpoint encoded chars":    func(m *structs.ModuleMetadata) { m.ModuleEndpoints[0].Endpoint = "/%2e%2e/run" },
+		"Test duplicate endpoint":        func(m *structs.ModuleMetadata) { m.ModuleEndpoints[1].Endpoint = "/run" },
+		"Test unknown element type":      func(m *structs.ModuleMetadata) { m.AcceptedElementTypes.ElementTypes[0] = "HASH" },
+		"Test missing module name":       func(m *structs.ModuleMetadata) { m.ModuleDisplayName = " " },
+		"Test uppercase inbound route":   func(m *structs.ModuleMetadata) { m.InboundRoute = "/FPDEP" },
+		"Test empty inbound route":       func(m *structs.ModuleMetadata) { m.InboundRoute = "" },
+		"Test endpoint with dot segment": func(m *structs.ModuleMetadata) { m.ModuleEndpoints[0].Endpoint = "/run/./x" },
+		"Test unknown method":            func(m *structs.ModuleMetadata) { m.ModuleEndpoints[2].HttpMethods[0].Method = "TRACE" },
+		"Test duplicate method":          func(m *structs.ModuleMetadata) { m.ModuleEndpoints[2].HttpMethods[1].Method = "get" },
+		"Test unknown method role":       func(m *structs.ModuleMetadata) { m.ModuleEndpoints[2].HttpMethods[1].Role = "root" },
+		"Test unknown required role":     func(m *structs.ModuleMetadata) { m.ModuleEndpoints[2].RequiredRole = "root" },
+		"Test role on insecure endpoint": func(m *structs.ModuleMetadata) { m.ModuleEndpoints[0].RequiredRole = authstructs.Admin },
+	}
+
+	for name, mutate := range cases {
+		r.T().Run(name, func(t *testing.T) {
+			repo := new(mocks.MockModuleLookupRepo)
+			metadata := testMetadata()
+			mutate(&metadata)
+			err := ValidateRegistration(&metadata, repo)
+			assert.Equal(t, ErrInvalidRegistration, errors.Cause(err))
+			repo.AssertNotCalled(t, "GetByInboundRoute", metadata.InboundRoute)
+		})
+	}
+}
+
+func (r *RegistrationTestSuite) TestRegistrationCollisions() {
+	r.T().Run("Test inbound route owned by another module", func(t *testing.T) {
+		other := testMetadata()
+		other.ModuleServiceName = "fp-ngfw"
+		repo := r.newRepo(other, structs.ModuleMetadata{}, nil, sql.ErrNoRows)
+		metadata := testMetadata()
+		assert.Equal(t, ErrRouteCollision, errors.Cause(ValidateRegistration(&metadata, repo)))
+	})
+
+	r.T().Run("Test service name registered on another route", func(t *testing.T) {
+		other := testMetadata()
+		other.InboundRoute = "/other"
+		repo := r.newRepo(structs.ModuleMetadata{}, other, sql.ErrNoRows, nil)
+		metadata := testMetadata()
+		assert.Equal(t, ErrServiceCollision, errors.Cause(ValidateRegistration(&metadata, repo)))
+	})
+
+	r.T().Run("Test repo error is returned", func(t *testing.T) {
+		repoErr := errors.New("db down")
+		repo := r.newRepo(structs.ModuleMetadata{}, structs.ModuleMetadata{}, repoErr, nil)
+		metadata := testMetadata()
+		assert.Equal(t, repoErr, errors.Cause(ValidateRegistration(&metadata, repo)))
+	})
+}
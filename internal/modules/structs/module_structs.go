@@ -1,8 +1,13 @@
 package structs
 
 import (
+	"database/sql/driver"
+	"fmt"
+	authstructs "fp-dynamic-elements-manager-controller/internal/auth/structs"
+	dockerstructs "fp-dynamic-elements-manager-controller/internal/docker/structs"
 	"fp-dynamic-elements-manager-controller/internal/health/structs"
 	structs2 "fp-dynamic-elements-manager-controller/internal/queue/structs"
+	"strings"
 	"time"
 )
 
@@ -15,34 +20,107 @@ const (
 )
 
 type ModuleMetadata struct {
-	ID                   int64                `json:"id"`
-	CreatedAt            time.Time            `json:"created_at" db:"created_at"`
-	UpdatedAt            time.Time            `json:"updated_at" db:"updated_at"`
-	DeletedAt            *time.Time           `json:"deleted_at" db:"deleted_at"`
-	ModuleServiceName    string               `json:"module_service_name" db:"module_service_name"`
-	ModuleDisplayName    string               `json:"module_display_name" db:"module_display_name"`
-	ModuleType           ModuleType           `json:"module_type" db:"module_type"`
-	ModuleDescription    string               `json:"module_description" db:"module_description"`
-	InboundRoute         string               `json:"inbound_route" db:"inbound_route"`
-	InternalIP           string               `json:"internal_ip" db:"internal_ip"`
-	InternalPort         string               `json:"internal_port" db:"internal_port"`
-	IconURL              string               `json:"icon_url" db:"icon_url"`
-	Configured           bool                 `json:"configured"`
-	Configurable         bool                 `json:"configurable"`
-	LastPing             time.Time            `json:"last_ping" db:"last_ping"`
-	AcceptedElementTypes ElementTypesWrapper  `json:"accepted_element_types" db:"-"`
-	ModuleEndpoints      []ModuleEndpoint     `json:"internal_endpoints" db:"-"`
-	ModuleHealth         structs.ModuleHealth `json:"module_health" db:"-"`
+	ID                   int64                         `json:"id"`
+	CreatedAt            time.Time                     `json:"created_at" db:"created_at"`
+	UpdatedAt            time.Time                     `json:"updated_at" db:"updated_at"`
+	DeletedAt            *time.Time                    `json:"deleted_at" db:"deleted_at"`
+	ModuleServiceName    string                        `json:"module_service_name" db:"module_service_name"`
+	ModuleDisplayName    string                        `json:"module_display_name" db:"module_display_name"`
+	ModuleType           ModuleType                    `json:"module_type" db:"module_type"`
+	ModuleDescription    string                        `json:"module_description" db:"module_description"`
+	InboundRoute         string                        `json:"inbound_route" db:"inbound_route"`
+	InternalIP           string                        `json:"internal_ip" db:"internal_ip"`
+	InternalPort         string                        `json:"internal_port" db:"internal_port"`
+	IconURL              string                        `json:"icon_url" db:"icon_url"`
+	Configured           bool                          `json:"configured"`
+	Configurable         bool                          `json:"configurable"`
+	LastPing             time.Time                     `json:"last_ping" db:"last_ping"`
+	AcceptedElementTypes ElementTypesWrapper           `json:"accepted_element_types" db:"-"`
+	ModuleEndpoints      []ModuleEndpoint              `json:"internal_endpoints" db:"-"`
+	ModuleHealth         structs.ModuleHealth          `json:"module_health" db:"-"`
+	ContainerStats       *dockerstructs.ContainerStats `json:"container_stats,omitempty" db:"-"`
 }
 
 type ModuleEndpoint struct {
-	ID               int64      `json:"id" db:"id"`
-	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
-	UpdatedAt        time.Time  `json:"updated_at" db:"updated_at"`
-	DeletedAt        *time.Time `json:"deleted_at" db:"deleted_at"`
-	Secure           bool       `json:"secure"`
-	Endpoint         string     `json:"endpoint"`
-	ModuleMetadataId uint       `json:"module_metadata_id" db:"module_metadata_id"`
+	ID               int64            `json:"id" db:"id"`
+	CreatedAt        time.Time        `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time        `json:"updated_at" db:"updated_at"`
+	DeletedAt        *time.Time       `json:"deleted_at" db:"deleted_at"`
+	Secure           bool             `json:"secure"`
+	Endpoint         string           `json:"endpoint"`
+	HttpMethods      HttpMethods      `json:"http_methods" db:"http_methods"`
+	RequiredRole     authstructs.Role `json:"required_role" db:"required_role"`
+	ModuleMetadataId uint             `json:"module_metadata_id" db:"module_metadata_id"`
+}
+
+// HttpMethod is a method allowed on a module endpoint, Role optionally overrides the RequiredRole of the endpoint
+// for this method only, e.g. GET /config for viewers and POST /config for admins
+type HttpMethod struct {
+	Method string           `json:"method"`
+	Role   authstructs.Role `json:"role,omitempty"`
+}
+
+// HttpMethods is stored as a comma separated list of METHOD or METHOD:role, e.g. "GET,POST:admin"
+type HttpMethods []HttpMethod
+
+// RoleFor returns the role required to call the endpoint with the given method
+func (e ModuleEndpoint) RoleFor(method string) authstructs.Role {
+	for _, m := range e.HttpMethods {
+		if strings.EqualFold(m.Method, method) && m.Role != authstructs.AnyRole {
+			return m.Role
+		}
+	}
+	return e.RequiredRole
+}
+
+// Names returns the upper case method names, an empty result means every method is allowed
+func (h HttpMethods) Names() []string {
+	names := make([]string, 0, len(h))
+	for _, m := range h {
+		names = append(names, strings.ToUpper(m.Method))
+	}
+	return names
+}
+
+func (h HttpMethods) Value() (driver.Value, error) {
+	parts := make([]string, 0, len(h))
+	for _, m := range h {
+		part := strings.ToUpper(m.Method)
+		if m.Role != authstructs.AnyRole {
+			part += ":" + string(m.Role)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ","), nil
+}
+
+func (h *HttpMethods) Scan(src interface{}) error {
+	var value string
+	switch v := src.(type) {
+	case nil:
+		*h = nil
+		return nil
+	case []byte:
+		value = string(v)
+	case string:
+		value = v
+	default:
+		return fmt.Errorf("cannot scan %T into HttpMethods", src)
+	}
+
+	*h = nil
+	for _, part := range strings.Split(value, ",") {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, ":", 2)
+		method := HttpMethod{Method: kv[0]}
+		if len(kv) == 2 {
+			method.Role = authstructs.Role(kv[1])
+		}
+		*h = append(*h, method)
+	}
+	return nil
 }
 
 type ElementTypesWrapper struct {
@@ -1,8 +1,8 @@
 package notification
 
 import (
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
 	"github.com/gorilla/websocket"
-	"log"
 	"time"
 )
 
@@ -54,7 +54,7 @@ func (c *Client) readPump() {
 		err := c.conn.ReadJSON(&evt)
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("error: %v", err)
+				applog.System().Error(err, "websocket client closed unexpectedly")
 			}
 			return
 		}
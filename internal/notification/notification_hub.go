@@ -1,5 +1,7 @@
 package notification
 
+import "sync/atomic"
+
 type Hub struct {
 	// Registered clients.
 	clients map[*Client]bool
@@ -12,6 +14,9 @@ type Hub struct {
 
 	// Unregister requests from clients.
 	unregister chan *Client
+
+	// Number of registered clients, this is read outside of the run loop so is accessed atomically
+	clientCount int64
 }
 
 func NewHub() *Hub {
@@ -23,15 +28,22 @@ func NewHub() *Hub {
 	}
 }
 
+// ClientCount returns the number of connected clients
+func (h *Hub) ClientCount() int {
+	return int(atomic.LoadInt64(&h.clientCount))
+}
+
 func (h *Hub) run() {
 	for {
 		select {
 		case client := <-h.register:
 			h.clients[client] = true
+			atomic.StoreInt64(&h.clientCount, int64(len(h.clients)))
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
 				close(client.send)
+				atomic.StoreInt64(&h.clientCount, int64(len(h.clients)))
 			}
 		case message := <-h.broadcast:
 			for client := range h.clients {
@@ -42,6 +54,7 @@ func (h *Hub) run() {
 					delete(h.clients, client)
 				}
 			}
+			atomic.StoreInt64(&h.clientCount, int64(len(h.clients)))
 		}
 	}
 }
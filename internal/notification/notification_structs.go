@@ -1,5 +1,10 @@
 package notification
 
+import (
+	"context"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
+)
+
 type EventType string
 type EntityType string
 type State string
@@ -13,18 +18,46 @@ const (
 
 	Module      EntityType = "module"
 	ListElement EntityType = "listElement"
+	Job         EntityType = "job"
+	Backup      EntityType = "backup"
 
 	None    State = "none"
 	Deleted State = "deleted"
 	Created State = "created"
 	Started State = "started"
 	Stopped State = "stopped"
+
+	Healthy   State = "healthy"
+	Unhealthy State = "unhealthy"
+	Down      State = "down"
+	Flapping  State = "flapping"
+
+	OOMKilled    State = "oomKilled"
+	CrashLooping State = "crashLooping"
+	ImageRefused State = "imageRefused"
+
+	ConfigReplayed State = "configReplayed"
+
+	BackupProgress  State = "backupProgress"
+	RestoreProgress State = "restoreProgress"
+	TargetSynced    State = "targetSynced"
+	TargetFailed    State = "targetFailed"
 )
 
 type Event struct {
 	EventType EventType    `json:"event_type"`
 	Value     string       `json:"value"`
 	Context   EventContext `json:"context"`
+	RequestID string       `json:"request_id,omitempty"`
+	// JobID is set on the progress of a docker job so the client can follow the job it submitted
+	JobID string `json:"job_id,omitempty"`
+}
+
+// WithContext returns the event tagged with the ID of the request held in ctx, so that the client can match the
+// notification to the action which caused it
+func (e Event) WithContext(ctx context.Context) Event {
+	e.RequestID = applog.RequestID(ctx)
+	return e
 }
 
 type EventContext struct {
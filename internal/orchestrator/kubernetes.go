@@ -0,0 +1,533 @@
+package orchestrator
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"fp-dynamic-elements-manager-controller/internal/catalog"
+	dockerstructs "fp-dynamic-elements-manager-controller/internal/docker/structs"
+	"fp-dynamic-elements-manager-controller/internal/docker/utils"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
+	"fp-dynamic-elements-manager-controller/internal/orchestrator/structs"
+	"io"
+	"io/ioutil"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/remotecommand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// managedByLabel marks the Deployments and Services the controller created for modules
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedBy      = "fp-dem-controller"
+	// restartedAtAnnotation is changed on the pod template to restart a Deployment, the same way kubectl does
+	restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+	// registryTimeout is the time allowed for each request to the module registry
+	registryTimeout = 10 * time.Second
+)
+
+var (
+	// ErrNoPod is returned when a module has no pod to read logs from or run commands in, e.g. when it is stopped
+	ErrNoPod = errors.New("no running pod")
+	// errExecUnavailable is returned by Exec when there is no connection to run commands in pods over
+	errExecUnavailable = errors.New("exec is not available without a connection to the cluster")
+)
+
+// KubernetesOrchestrator runs each module as a Deployment of one replica with a headless Service of the same name,
+// so the module is reached by its name as it is on the docker network. Stopping a module scales it to zero
+type KubernetesOrchestrator struct {
+	client kubernetes.Interface
+	// config is used to open exec streams to pods, it is nil when the client is not connected to a cluster
+	config *rest.Config
+	cfg    Config
+	// registry reads the digests of module images, it is nil when no registry is configured
+	registry *catalog.RegistryClient
+}
+
+// NewKubernetes connects to the cluster through the kubeconfig file, or the in-cluster config when it is not set
+func NewKubernetes(cfg Config) (*KubernetesOrchestrator, error) {
+	var config *rest.Config
+	var err error
+	if cfg.Kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", cfg.Kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	kubeLog(nil).Info("connected to the kubernetes cluster")
+
+	return NewKubernetesWithClient(cfg, client, config), nil
+}
+
+// NewKubernetesWithClient uses an existing client, commands cannot be run in pods unless config is set
+func NewKubernetesWithClient(cfg Config, client kubernetes.Interface, config *rest.Config) *KubernetesOrchestrator {
+	k := &KubernetesOrchestrator{client: client, config: config, cfg: cfg}
+	if cfg.RegistryURL != "" {
+		k.registry = catalog.NewRegistryClient(cfg.RegistryURL, cfg.RegistryUsername, cfg.RegistryPassword, &http.Client{Timeout: registryTimeout})
+	}
+	return k
+}
+
+func kubeLog(fields applog.Fields) applog.Logger {
+	return applog.System().WithFields(applog.Fields{"module": "kubernetes"}).WithFields(fields)
+}
+
+// ModuleNetwork returns the namespace, modules in the namespace reach each other through their Services
+func (k *KubernetesOrchestrator) ModuleNetwork(context.Context) (string, error) {
+	return k.cfg.Namespace, nil
+}
+
+// ResolveImage reads the digest the image's tag points to from the module registry, the image itself is pulled by the
+// nodes. The digest is empty when there is no registry to read it from
+func (k *KubernetesOrchestrator) ResolveImage(ctx context.Context, imageRef string) (string, error) {
+	if strings.Contains(imageRef, "@") {
+		return imageRef, nil
+	}
+	if k.registry == nil {
+		return "", nil
+	}
+
+	name, tag := imageRef, "latest"
+	if i := strings.LastIndex(imageRef, ":"); i > strings.LastIndex(imageRef, "/") {
+		name, tag = imageRef[:i], imageRef[i+1:]
+	}
+	parts := strings.SplitN(name, "/", 2)
+	if u, err := url.Parse(k.cfg.RegistryURL); err != nil || len(parts) != 2 || parts[0] != u.Host {
+		return "", fmt.Errorf("%s is not an image of the module registry", imageRef)
+	}
+
+	digest, err := k.registry.Digest(ctx, parts[1], tag)
+	if err != nil {
+		kubeLog(applog.Fields{"image": imageRef}).Error(err, "error reading the image digest from the registry")
+		return "", err
+	}
+	return name + "@" + digest, nil
+}
+
+// Create creates the module's Deployment and Service
+func (k *KubernetesOrchestrator) Create(ctx context.Context, workload structs.Workload) error {
+	deployment, err := k.deployment(workload)
+	if err != nil {
+		return err
+	}
+	if _, err := k.client.AppsV1().Deployments(k.cfg.Namespace).Create(ctx, deployment, metav1.CreateOptions{}); err != nil {
+		kubeLog(applog.Fields{"deployment": workload.Name}).Error(err, "error creating deployment")
+		return err
+	}
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: workload.Name, Labels: k.labels(workload.Name)},
+		Spec: corev1.ServiceSpec{
+			// A headless Service resolves to the pod itself, so the module is reached on whichever port it listens on
+			ClusterIP: corev1.ClusterIPNone,
+			Selector:  k.selector(workload.Name),
+		},
+	}
+	if _, err := k.client.CoreV1().Services(k.cfg.Namespace).Create(ctx, service, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		kubeLog(applog.Fields{"service": workload.Name}).Error(err, "error creating service")
+		return err
+	}
+	return nil
+}
+
+func (k *KubernetesOrchestrator) deployment(workload structs.Workload) (*appsv1.Deployment, error) {
+	replicas := int32(1)
+	container := corev1.Container{
+		Name:  workload.Name,
+		Image: workload.Image,
+	}
+	for _, env := range workload.Env {
+		parts := strings.SplitN(env, "=", 2)
+		v := corev1.EnvVar{Name: parts[0]}
+		if len(parts) == 2 {
+			v.Value = parts[1]
+		}
+		container.Env = append(container.Env, v)
+	}
+
+	pod := corev1.PodSpec{Containers: []corev1.Container{}}
+	if k.cfg.ImagePullSecret != "" {
+		pod.ImagePullSecrets = []corev1.LocalObjectReference{{Name: k.cfg.ImagePullSecret}}
+	}
+
+	hostPath := corev1.HostPathDirectoryOrCreate
+	for i, volume := range workload.Volumes {
+		parts := strings.Split(volume, ":")
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("volume %s must be in the form source:target[:mode]", volume)
+		}
+		name := fmt.Sprintf("volume-%d", i)
+		pod.Volumes = append(pod.Volumes, corev1.Volume{
+			Name:         name,
+			VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: parts[0], Type: &hostPath}},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      name,
+			MountPath: parts[1],
+			ReadOnly:  len(parts) == 3 && strings.Contains(","+parts[2]+",", ",ro,"),
+		})
+	}
+
+	applyRuntimeProfile(&pod, &container, workload.Runtime)
+	pod.Containers = append(pod.Containers, container)
+
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: workload.Name, Labels: k.labels(workload.Name)},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: k.selector(workload.Name)},
+			// A module holds its state on host paths, so the old pod is stopped before the new one starts
+			Strategy: appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: k.labels(workload.Name)},
+				Spec:       pod,
+			},
+		},
+	}, nil
+}
+
+// applyRuntimeProfile sets the limits and security options of the profile on the pod. Kubernetes has no per pod
+// process limit or log rotation options, these are left to the kubelet's config
+func applyRuntimeProfile(pod *corev1.PodSpec, container *corev1.Container, profile dockerstructs.RuntimeProfile) {
+	limits := corev1.ResourceList{}
+	if profile.MemoryMB > 0 {
+		limits[corev1.ResourceMemory] = *resource.NewQuantity(profile.MemoryMB*1024*1024, resource.BinarySI)
+	}
+	if profile.CPUs > 0 {
+		limits[corev1.ResourceCPU] = *resource.NewMilliQuantity(int64(profile.CPUs*1000), resource.DecimalSI)
+	}
+	if len(limits) > 0 {
+		container.Resources.Limits = limits
+	}
+
+	privileged, readOnly, escalation := false, profile.ReadOnlyRootFS != nil && *profile.ReadOnlyRootFS, true
+	if profile.NoNewPrivileges != nil && *profile.NoNewPrivileges {
+		escalation = false
+	}
+	security := &corev1.SecurityContext{
+		Privileged:               &privileged,
+		ReadOnlyRootFilesystem:   &readOnly,
+		AllowPrivilegeEscalation: &escalation,
+	}
+	if len(profile.CapDrop) > 0 || len(profile.CapAdd) > 0 {
+		security.Capabilities = &corev1.Capabilities{}
+		for _, c := range profile.CapDrop {
+			security.Capabilities.Drop = append(security.Capabilities.Drop, corev1.Capability(c))
+		}
+		for _, c := range profile.CapAdd {
+			security.Capabilities.Add = append(security.Capabilities.Add, corev1.Capability(c))
+		}
+	}
+	container.SecurityContext = security
+
+	for i, mount := range profile.Tmpfs {
+		name := fmt.Sprintf("tmpfs-%d", i)
+		pod.Volumes = append(pod.Volumes, corev1.Volume{
+			Name:         name,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumMemory}},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: name, MountPath: strings.SplitN(mount, ":", 2)[0]})
+	}
+}
+
+func (k *KubernetesOrchestrator) labels(name string) map[string]string {
+	return map[string]string{k.cfg.ServiceLabel: name, managedByLabel: managedBy}
+}
+
+func (k *KubernetesOrchestrator) selector(name string) map[string]string {
+	return map[string]string{k.cfg.ServiceLabel: name}
+}
+
+// Start scales the module's Deployment up to one replica
+func (k *KubernetesOrchestrator) Start(ctx context.Context, name string) error {
+	return k.scale(ctx, name, 1)
+}
+
+// Stop scales the module's Deployment down to zero replicas
+func (k *KubernetesOrchestrator) Stop(ctx context.Context, name string) error {
+	return k.scale(ctx, name, 0)
+}
+
+func (k *KubernetesOrchestrator) scale(ctx context.Context, name string, replicas int32) error {
+	return k.updateDeployment(ctx, name, func(deployment *appsv1.Deployment) {
+		deployment.Spec.Replicas = &replicas
+	})
+}
+
+// Restart replaces the module's pod by changing its template, the same way kubectl rollout restart does
+func (k *KubernetesOrchestrator) Restart(ctx context.Context, name string) error {
+	return k.updateDeployment(ctx, name, func(deployment *appsv1.Deployment) {
+		if deployment.Spec.Template.Annotations == nil {
+			deployment.Spec.Template.Annotations = map[string]string{}
+		}
+		deployment.Spec.Template.Annotations[restartedAtAnnotation] = time.Now().Format(time.RFC3339)
+	})
+}
+
+func (k *KubernetesOrchestrator) updateDeployment(ctx context.Context, name string, update func(*appsv1.Deployment)) error {
+	deployments := k.client.AppsV1().Deployments(k.cfg.Namespace)
+	deployment, err := deployments.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		kubeLog(applog.Fields{"deployment": name}).Error(err, "error getting deployment")
+		return err
+	}
+	update(deployment)
+	if _, err := deployments.Update(ctx, deployment, metav1.UpdateOptions{}); err != nil {
+		kubeLog(applog.Fields{"deployment": name}).Error(err, "error updating deployment")
+		return err
+	}
+	return nil
+}
+
+// Remove deletes the module's Deployment, its pods and its Service
+func (k *KubernetesOrchestrator) Remove(ctx context.Context, name string) error {
+	propagation := metav1.DeletePropagationForeground
+	err := k.client.AppsV1().Deployments(k.cfg.Namespace).Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil {
+		kubeLog(applog.Fields{"deployment": name}).Error(err, "error deleting deployment")
+		return err
+	}
+	err = k.client.CoreV1().Services(k.cfg.Namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		kubeLog(applog.Fields{"service": name}).Error(err, "error deleting service")
+		return err
+	}
+	return nil
+}
+
+// pod returns the newest pod of the service, preferring one which is running
+func (k *KubernetesOrchestrator) pod(ctx context.Context, service string) (corev1.Pod, error) {
+	pods, err := k.client.CoreV1().Pods(k.cfg.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(k.selector(service)).String(),
+	})
+	if err != nil {
+		return corev1.Pod{}, err
+	}
+	if len(pods.Items) == 0 {
+		return corev1.Pod{}, fmt.Errorf("%w for %s", ErrNoPod, service)
+	}
+	sort.SliceStable(pods.Items, func(i, j int) bool {
+		a, b := pods.Items[i], pods.Items[j]
+		if (a.Status.Phase == corev1.PodRunning) != (b.Status.Phase == corev1.PodRunning) {
+			return a.Status.Phase == corev1.PodRunning
+		}
+		return b.CreationTimestamp.Before(&a.CreationTimestamp)
+	})
+	return pods.Items[0], nil
+}
+
+// Logs reads the logs of the module's pod. Kubernetes does not keep stdout and stderr apart, so every line is read
+// as stdout
+func (k *KubernetesOrchestrator) Logs(ctx context.Context, name string, opts dockerstructs.LogOptions, out func(dockerstructs.LogLine) error) error {
+	pod, err := k.pod(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	follow := opts.Follow
+	if follow && !opts.Until.IsZero() {
+		if !opts.Until.After(time.Now()) {
+			follow = false
+		} else {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, opts.Until)
+			defer cancel()
+		}
+	}
+
+	logOpts := &corev1.PodLogOptions{Container: pod.Spec.Containers[0].Name, Follow: follow, Timestamps: true}
+	if !opts.Since.IsZero() {
+		since := metav1.NewTime(opts.Since)
+		logOpts.SinceTime = &since
+	}
+	if tail, err := strconv.ParseInt(opts.Tail, 10, 64); err == nil {
+		logOpts.TailLines = &tail
+	}
+
+	stream, err := k.client.CoreV1().Pods(k.cfg.Namespace).GetLogs(pod.Name, logOpts).Stream(ctx)
+	if err != nil {
+		kubeLog(applog.Fields{"pod": pod.Name}).Error(err, "error getting pod logs")
+		return err
+	}
+	defer stream.Close()
+
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := dockerstructs.LogLine{Stream: dockerstructs.Stdout, Line: scanner.Text()}
+		if parts := strings.SplitN(line.Line, " ", 2); len(parts) == 2 {
+			if t, err := time.Parse(time.RFC3339Nano, parts[0]); err == nil {
+				line.Time, line.Line = t, parts[1]
+			}
+		}
+		if !opts.Until.IsZero() && line.Time.After(opts.Until) {
+			return nil
+		}
+		if err := out(line); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		// The client went away or the until time passed while following
+		return nil
+	}
+	return scanner.Err()
+}
+
+// Inspect returns the detail of the module's Deployment and its pod, with the secrets in its environment redacted
+func (k *KubernetesOrchestrator) Inspect(ctx context.Context, name string) (dockerstructs.ContainerInspect, error) {
+	deployment, err := k.client.AppsV1().Deployments(k.cfg.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		kubeLog(applog.Fields{"deployment": name}).Error(err, "error getting deployment")
+		return dockerstructs.ContainerInspect{}, err
+	}
+
+	inspect := dockerstructs.ContainerInspect{
+		ID:           string(deployment.UID),
+		Name:         deployment.Name,
+		ImageDigests: []string{},
+		Created:      deployment.CreationTimestamp.UTC().Format(time.RFC3339Nano),
+		Mounts:       []dockerstructs.ContainerMount{},
+		Env:          []string{},
+		State:        dockerstructs.ContainerState{Status: "stopped"},
+	}
+
+	spec := deployment.Spec.Template.Spec
+	if len(spec.Containers) > 0 {
+		container := spec.Containers[0]
+		inspect.Image = container.Image
+		var env []string
+		for _, v := range container.Env {
+			env = append(env, fmt.Sprintf("%s=%s", v.Name, v.Value))
+		}
+		inspect.Env = utils.RedactEnv(env)
+
+		paths := map[string]string{}
+		for _, v := range spec.Volumes {
+			if v.HostPath != nil {
+				paths[v.Name] = v.HostPath.Path
+			}
+		}
+		for _, m := range container.VolumeMounts {
+			mount := dockerstructs.ContainerMount{Type: "tmpfs", Destination: m.MountPath, ReadWrite: !m.ReadOnly}
+			if path, ok := paths[m.Name]; ok {
+				mount.Type, mount.Source = "bind", path
+			}
+			inspect.Mounts = append(inspect.Mounts, mount)
+		}
+	}
+
+	pod, err := k.pod(ctx, name)
+	if errors.Is(err, ErrNoPod) {
+		return inspect, nil
+	} else if err != nil {
+		kubeLog(applog.Fields{"deployment": name}).Error(err, "error listing pods")
+		return inspect, err
+	}
+	inspect.State.Status = strings.ToLower(string(pod.Status.Phase))
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name != pod.Spec.Containers[0].Name {
+			continue
+		}
+		inspect.ImageID = status.ImageID
+		if strings.Contains(status.ImageID, "@") {
+			inspect.ImageDigests = append(inspect.ImageDigests, strings.TrimPrefix(status.ImageID, "docker-pullable://"))
+		}
+		inspect.RestartCount = int(status.RestartCount)
+		applyContainerState(&inspect.State, status)
+	}
+	return inspect, nil
+}
+
+func applyContainerState(state *dockerstructs.ContainerState, status corev1.ContainerStatus) {
+	if last := status.LastTerminationState.Terminated; last != nil {
+		state.OOMKilled = last.Reason == "OOMKilled"
+		state.ExitCode = int(last.ExitCode)
+	}
+	switch {
+	case status.State.Running != nil:
+		state.Status, state.Running = "running", true
+		state.StartedAt = status.State.Running.StartedAt.UTC().Format(time.RFC3339Nano)
+	case status.State.Waiting != nil:
+		state.Status, state.Error = "waiting", status.State.Waiting.Message
+		// A container waiting to be started again after crashing is restarting
+		state.Restarting = status.State.Waiting.Reason == "CrashLoopBackOff"
+	case status.State.Terminated != nil:
+		terminated := status.State.Terminated
+		state.Status, state.ExitCode, state.Error = "exited", int(terminated.ExitCode), terminated.Message
+		state.OOMKilled = terminated.Reason == "OOMKilled"
+		state.StartedAt = terminated.StartedAt.UTC().Format(time.RFC3339Nano)
+		state.FinishedAt = terminated.FinishedAt.UTC().Format(time.RFC3339Nano)
+	}
+	if status.Ready {
+		state.Health = "healthy"
+	} else if state.Running {
+		state.Health = "unhealthy"
+	}
+}
+
+// Exec runs the command in the first container of the service's pod
+func (k *KubernetesOrchestrator) Exec(ctx context.Context, service string, cmd []string, stdin io.Reader, stdout io.Writer) error {
+	if k.config == nil {
+		return errExecUnavailable
+	}
+	pod, err := k.pod(ctx, service)
+	if err != nil {
+		return err
+	}
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+
+	req := k.client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(k.cfg.Namespace).
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: pod.Spec.Containers[0].Name,
+			Command:   cmd,
+			Stdin:     stdin != nil,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(k.config, "POST", req.URL())
+	if err != nil {
+		return err
+	}
+
+	// The stream is not cancelled with ctx in this version of client-go, closing stdin ends commands reading it
+	stderr := &utils.LimitedBuffer{Max: 4096}
+	err = executor.Stream(remotecommand.StreamOptions{Stdin: stdin, Stdout: stdout, Stderr: stderr})
+	if err != nil {
+		kubeLog(applog.Fields{"pod": pod.Name}).Error(err, "error running command in pod")
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%v: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
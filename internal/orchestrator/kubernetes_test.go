@@ -0,0 +1,258 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	dockerstructs "fp-dynamic-elements-manager-controller/internal/docker/structs"
+	"fp-dynamic-elements-manager-controller/internal/docker/utils"
+	"fp-dynamic-elements-manager-controller/internal/orchestrator/structs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type KubernetesTestSuite struct {
+	suite.Suite
+	client *fake.Clientset
+	orch   *KubernetesOrchestrator
+	ctx    context.Context
+}
+
+func TestKubernetes(t *testing.T) {
+	suite.Run(t, new(KubernetesTestSuite))
+}
+
+func (k *KubernetesTestSuite) SetupTest() {
+	cfg := DefaultConfig()
+	cfg.Namespace = "fp-dem"
+	cfg.ImagePullSecret = "registry"
+	k.client = fake.NewSimpleClientset()
+	k.orch = NewKubernetesWithClient(cfg, k.client, nil)
+	k.ctx = context.Background()
+}
+
+func (k *KubernetesTestSuite) create() {
+	readOnly, noNewPrivileges := true, true
+	k.Require().Nil(k.orch.Create(k.ctx, structs.Workload{
+		Name:    "fp-ngfw",
+		Image:   "docker.frcpnt.com/fp-dem/fp-ngfw@sha256:abc",
+		Network: "fp-dem",
+		Volumes: []string{"/opt/fp-dem/fp-ngfw/config:/config", "/opt/fp-dem/fp-ngfw/certs:/certs:ro"},
+		Env:     []string{"LOCAL_PORT=8080", "INTERNAL_TOKEN=secret"},
+		Runtime: dockerstructs.RuntimeProfile{
+			MemoryMB:        256,
+			CPUs:            0.5,
+			ReadOnlyRootFS:  &readOnly,
+			NoNewPrivileges: &noNewPrivileges,
+			CapDrop:         []string{"ALL"},
+			CapAdd:          []string{"NET_BIND_SERVICE"},
+			Tmpfs:           []string{"/tmp"},
+		},
+	}))
+}
+
+func (k *KubernetesTestSuite) deployment() *appsv1.Deployment {
+	deployment, err := k.client.AppsV1().Deployments("fp-dem").Get(k.ctx, "fp-ngfw", metav1.GetOptions{})
+	k.Require().Nil(err)
+	return deployment
+}
+
+func (k *KubernetesTestSuite) TestCreate() {
+	k.create()
+
+	deployment := k.deployment()
+	k.Equal(int32(1), *deployment.Spec.Replicas)
+	k.Equal(appsv1.RecreateDeploymentStrategyType, deployment.Spec.Strategy.Type)
+	k.Equal(map[string]string{"app.kubernetes.io/name": "fp-ngfw"}, deployment.Spec.Selector.MatchLabels)
+	k.Equal(managedBy, deployment.Labels[managedByLabel])
+
+	pod := deployment.Spec.Template.Spec
+	k.Equal([]corev1.LocalObjectReference{{Name: "registry"}}, pod.ImagePullSecrets)
+	k.Require().Len(pod.Containers, 1)
+	container := pod.Containers[0]
+	k.Equal("docker.frcpnt.com/fp-dem/fp-ngfw@sha256:abc", container.Image)
+	k.Equal([]corev1.EnvVar{{Name: "LOCAL_PORT", Value: "8080"}, {Name: "INTERNAL_TOKEN", Value: "secret"}}, container.Env)
+
+	k.Equal("256Mi", container.Resources.Limits.Memory().String())
+	k.Equal("500m", container.Resources.Limits.Cpu().String())
+	k.False(*container.SecurityContext.Privileged)
+	k.True(*container.SecurityContext.ReadOnlyRootFilesystem)
+	k.False(*container.SecurityContext.AllowPrivilegeEscalation)
+	k.Equal([]corev1.Capability{"ALL"}, container.SecurityContext.Capabilities.Drop)
+	k.Equal([]corev1.Capability{"NET_BIND_SERVICE"}, container.SecurityContext.Capabilities.Add)
+
+	k.Require().Len(pod.Volumes, 3)
+	k.Equal("/opt/fp-dem/fp-ngfw/config", pod.Volumes[0].HostPath.Path)
+	k.Equal(corev1.StorageMediumMemory, pod.Volumes[2].EmptyDir.Medium)
+	k.Equal([]corev1.VolumeMount{
+		{Name: "volume-0", MountPath: "/config"},
+		{Name: "volume-1", MountPath: "/certs", ReadOnly: true},
+		{Name: "tmpfs-0", MountPath: "/tmp"},
+	}, container.VolumeMounts)
+
+	service, err := k.client.CoreV1().Services("fp-dem").Get(k.ctx, "fp-ngfw", metav1.GetOptions{})
+	k.Require().Nil(err)
+	k.Equal(corev1.ClusterIPNone, service.Spec.ClusterIP)
+	k.Equal(deployment.Spec.Selector.MatchLabels, service.Spec.Selector)
+
+	k.NotNil(k.orch.Create(k.ctx, structs.Workload{Name: "fp-ngfw"}), "the deployment already exists")
+	k.NotNil(k.orch.Create(k.ctx, structs.Workload{Name: "fp-bad", Volumes: []string{"/config"}}))
+}
+
+func (k *KubernetesTestSuite) TestLifecycle() {
+	k.create()
+
+	k.Nil(k.orch.Stop(k.ctx, "fp-ngfw"))
+	k.Equal(int32(0), *k.deployment().Spec.Replicas)
+
+	k.Nil(k.orch.Start(k.ctx, "fp-ngfw"))
+	k.Equal(int32(1), *k.deployment().Spec.Replicas)
+
+	k.Nil(k.orch.Restart(k.ctx, "fp-ngfw"))
+	restartedAt, err := time.Parse(time.RFC3339, k.deployment().Spec.Template.Annotations[restartedAtAnnotation])
+	k.Nil(err)
+	k.WithinDuration(time.Now(), restartedAt, time.Minute)
+
+	k.Nil(k.orch.Remove(k.ctx, "fp-ngfw"))
+	_, err = k.client.AppsV1().Deployments("fp-dem").Get(k.ctx, "fp-ngfw", metav1.GetOptions{})
+	k.NotNil(err)
+	_, err = k.client.CoreV1().Services("fp-dem").Get(k.ctx, "fp-ngfw", metav1.GetOptions{})
+	k.NotNil(err)
+
+	k.NotNil(k.orch.Start(k.ctx, "fp-ngfw"))
+	k.NotNil(k.orch.Remove(k.ctx, "fp-ngfw"))
+}
+
+func (k *KubernetesTestSuite) addPod(name string, phase corev1.PodPhase, created time.Time, status corev1.ContainerStatus) {
+	_, err := k.client.CoreV1().Pods("fp-dem").Create(k.ctx, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Labels:            map[string]string{"app.kubernetes.io/name": "fp-ngfw"},
+			CreationTimestamp: metav1.NewTime(created),
+		},
+		Spec:   corev1.PodSpec{Containers: []corev1.Container{{Name: "fp-ngfw"}}},
+		Status: corev1.PodStatus{Phase: phase, ContainerStatuses: []corev1.ContainerStatus{status}},
+	}, metav1.CreateOptions{})
+	k.Require().Nil(err)
+}
+
+func (k *KubernetesTestSuite) TestInspect() {
+	k.create()
+
+	inspect, err := k.orch.Inspect(k.ctx, "fp-ngfw")
+	k.Require().Nil(err)
+	k.Equal("stopped", inspect.State.Status)
+	k.Equal([]string{"LOCAL_PORT=8080", "INTERNAL_TOKEN=" + utils.Redacted}, inspect.Env)
+	k.Equal([]dockerstructs.ContainerMount{
+		{Type: "bind", Source: "/opt/fp-dem/fp-ngfw/config", Destination: "/config", ReadWrite: true},
+		{Type: "bind", Source: "/opt/fp-dem/fp-ngfw/certs", Destination: "/certs"},
+		{Type: "tmpfs", Destination: "/tmp", ReadWrite: true},
+	}, inspect.Mounts)
+
+	started := metav1.NewTime(time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC))
+	k.addPod("fp-ngfw-old", corev1.PodFailed, started.Time, corev1.ContainerStatus{
+		Name:  "fp-ngfw",
+		State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 137, Reason: "OOMKilled"}},
+	})
+	k.addPod("fp-ngfw-new", corev1.PodRunning, started.Time.Add(-time.Hour), corev1.ContainerStatus{
+		Name:                 "fp-ngfw",
+		Ready:                true,
+		RestartCount:         2,
+		ImageID:              "docker-pullable://docker.frcpnt.com/fp-dem/fp-ngfw@sha256:abc",
+		State:                corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: started}},
+		LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1}},
+	})
+
+	// The running pod is inspected even though it is older
+	inspect, err = k.orch.Inspect(k.ctx, "fp-ngfw")
+	k.Require().Nil(err)
+	k.Equal(dockerstructs.ContainerState{
+		Status:    "running",
+		Running:   true,
+		ExitCode:  1,
+		StartedAt: "2020-10-01T12:00:00Z",
+		Health:    "healthy",
+	}, inspect.State)
+	k.Equal(2, inspect.RestartCount)
+	k.Equal([]string{"docker.frcpnt.com/fp-dem/fp-ngfw@sha256:abc"}, inspect.ImageDigests)
+
+	_, err = k.orch.Inspect(k.ctx, "fp-missing")
+	k.NotNil(err)
+}
+
+func (k *KubernetesTestSuite) TestLogs() {
+	err := k.orch.Logs(k.ctx, "fp-ngfw", dockerstructs.LogOptions{}, func(dockerstructs.LogLine) error { return nil })
+	k.True(errors.Is(err, ErrNoPod))
+
+	k.addPod("fp-ngfw-1", corev1.PodRunning, time.Now(), corev1.ContainerStatus{Name: "fp-ngfw"})
+	var lines []dockerstructs.LogLine
+	err = k.orch.Logs(k.ctx, "fp-ngfw", dockerstructs.LogOptions{Tail: "10"}, func(line dockerstructs.LogLine) error {
+		lines = append(lines, line)
+		return nil
+	})
+	k.Nil(err)
+	// The fake client always returns the same log body
+	k.Equal([]dockerstructs.LogLine{{Stream: dockerstructs.Stdout, Line: "fake logs"}}, lines)
+}
+
+func (k *KubernetesTestSuite) TestResolveImage() {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead || r.URL.Path != "/v2/fp-dem/fp-ngfw/manifests/1.2.0" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", "sha256:abc")
+	}))
+	defer registry.Close()
+
+	cfg := DefaultConfig()
+	cfg.RegistryURL = registry.URL
+	orch := NewKubernetesWithClient(cfg, k.client, nil)
+	host := strings.TrimPrefix(registry.URL, "http://")
+
+	digest, err := orch.ResolveImage(k.ctx, host+"/fp-dem/fp-ngfw:1.2.0")
+	k.Nil(err)
+	k.Equal(host+"/fp-dem/fp-ngfw@sha256:abc", digest)
+
+	k.T().Run("Test an unknown tag is not resolved", func(t *testing.T) {
+		_, err := orch.ResolveImage(k.ctx, host+"/fp-dem/fp-ngfw:9.9.9")
+		assert.NotNil(t, err)
+	})
+
+	k.T().Run("Test images outside the module registry are not resolved", func(t *testing.T) {
+		_, err := orch.ResolveImage(k.ctx, "docker.io/library/nginx:latest")
+		assert.NotNil(t, err)
+	})
+
+	k.T().Run("Test without a registry the digest is unknown", func(t *testing.T) {
+		digest, err := k.orch.ResolveImage(k.ctx, host+"/fp-dem/fp-ngfw:1.2.0")
+		assert.Nil(t, err)
+		assert.Empty(t, digest)
+	})
+}
+
+func (k *KubernetesTestSuite) TestExec_Unavailable() {
+	k.Equal(errExecUnavailable, k.orch.Exec(k.ctx, "mariadb", []string{"true"}, nil, nil))
+}
+
+func (k *KubernetesTestSuite) TestConfigFromEnv() {
+	os.Setenv("ORCHESTRATOR", "kubernetes")
+	os.Setenv("KUBERNETES_NAMESPACE", "fp-dem")
+	defer os.Unsetenv("ORCHESTRATOR")
+	defer os.Unsetenv("KUBERNETES_NAMESPACE")
+
+	cfg := ConfigFromEnv()
+	k.Equal(Kubernetes, cfg.Backend)
+	k.Equal("fp-dem", cfg.Namespace)
+	k.Equal(DefaultConfig().ServiceLabel, cfg.ServiceLabel)
+	k.Equal(DefaultConfig().DatabaseService, cfg.DatabaseService)
+}
@@ -0,0 +1,97 @@
+package orchestrator
+
+import (
+	"context"
+	dockerstructs "fp-dynamic-elements-manager-controller/internal/docker/structs"
+	"fp-dynamic-elements-manager-controller/internal/orchestrator/structs"
+	"io"
+	"os"
+	"strings"
+)
+
+type Backend string
+
+const (
+	Docker     Backend = "docker"
+	Kubernetes Backend = "kubernetes"
+)
+
+// Orchestrator runs the module workloads and the services the controller depends on, such as the database. Workloads
+// and services are addressed by name, on docker this is the container name and on Kubernetes the Deployment name
+type Orchestrator interface {
+	// ModuleNetwork returns the network modules are attached to
+	ModuleNetwork(ctx context.Context) (string, error)
+	// ResolveImage returns the digest reference an image ref resolves to, or an empty string if it is not known
+	ResolveImage(ctx context.Context, imageRef string) (string, error)
+	Create(ctx context.Context, workload structs.Workload) error
+	Start(ctx context.Context, name string) error
+	Stop(ctx context.Context, name string) error
+	Restart(ctx context.Context, name string) error
+	Remove(ctx context.Context, name string) error
+	Logs(ctx context.Context, name string, opts dockerstructs.LogOptions, out func(dockerstructs.LogLine) error) error
+	Inspect(ctx context.Context, name string) (dockerstructs.ContainerInspect, error)
+	// Exec runs the command in the service and waits for it to finish, stdin is optional. An error is returned if
+	// the command exits with a non-zero status
+	Exec(ctx context.Context, service string, cmd []string, stdin io.Reader, stdout io.Writer) error
+}
+
+type Config struct {
+	Backend Backend
+	// Namespace is the Kubernetes namespace the modules are run in
+	Namespace string
+	// Kubeconfig is the path of the kubeconfig file, the in-cluster config is used when it is not set
+	Kubeconfig string
+	// ServiceLabel is the pod label which holds the name of the service a pod belongs to
+	ServiceLabel string
+	// ImagePullSecret is the secret Kubernetes pulls module images with
+	ImagePullSecret string
+	// DatabaseService is the name of the service the database runs in
+	DatabaseService string
+	// RegistryURL is the module registry, e.g. https://docker.frcpnt.com. On Kubernetes the digest of a module image
+	// is read from it, as the nodes rather than the controller pull the images
+	RegistryURL      string
+	RegistryUsername string
+	RegistryPassword string
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Backend:         Docker,
+		Namespace:       "default",
+		ServiceLabel:    "app.kubernetes.io/name",
+		DatabaseService: "mariadb",
+	}
+}
+
+// ConfigFromEnv reads ORCHESTRATOR, KUBECONFIG, the KUBERNETES_* settings, DB_SERVICE and the DOCKER_* registry login
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+
+	if v := strings.ToLower(os.Getenv("ORCHESTRATOR")); v != "" {
+		cfg.Backend = Backend(v)
+	}
+
+	if v := os.Getenv("KUBERNETES_NAMESPACE"); v != "" {
+		cfg.Namespace = v
+	}
+
+	cfg.Kubeconfig = os.Getenv("KUBECONFIG")
+
+	if v := os.Getenv("KUBERNETES_SERVICE_LABEL"); v != "" {
+		cfg.ServiceLabel = v
+	}
+
+	cfg.ImagePullSecret = os.Getenv("KUBERNETES_IMAGE_PULL_SECRET")
+
+	if v := os.Getenv("DB_SERVICE"); v != "" {
+		cfg.DatabaseService = v
+	}
+
+	if v := os.Getenv("DOCKER_REGISTRY"); v != "" {
+		cfg.RegistryURL = "https://" + v
+	}
+	cfg.RegistryUsername = os.Getenv("DOCKER_USER")
+	cfg.RegistryPassword = os.Getenv("DOCKER_PASSWORD")
+
+	return cfg
+}
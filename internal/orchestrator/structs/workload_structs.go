@@ -0,0 +1,16 @@
+package structs
+
+import dockerstructs "fp-dynamic-elements-manager-controller/internal/docker/structs"
+
+// Workload is a module to be run by the orchestrator, a container on docker or a Deployment on Kubernetes
+type Workload struct {
+	// Name is the name of the module's container or Deployment, other modules and the controller reach it by this name
+	Name  string
+	Image string
+	// Network is the docker network the module is attached to, it is not used on Kubernetes
+	Network string
+	// Volumes are mounted from the host as source:target[:mode]
+	Volumes []string
+	Env     []string
+	Runtime dockerstructs.RuntimeProfile
+}
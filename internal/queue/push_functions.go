@@ -2,21 +2,29 @@ package queue
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"fp-dynamic-elements-manager-controller/internal/db/persistence"
 	"fp-dynamic-elements-manager-controller/internal/health"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
 	structs3 "fp-dynamic-elements-manager-controller/internal/logging/structs"
+	"fp-dynamic-elements-manager-controller/internal/metrics"
 	structs2 "fp-dynamic-elements-manager-controller/internal/modules/structs"
 	"fp-dynamic-elements-manager-controller/internal/queue/structs"
+	"fp-dynamic-elements-manager-controller/internal/tracing"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/semconv"
 	"net/http"
 	"time"
 )
 
 type Pusher interface {
-	PushUpdates()
-	PushDeletes(item structs.ListElement)
+	PushUpdates(ctx context.Context)
+	PushDeletes(ctx context.Context, item structs.ListElement)
 }
 
 type DataPusher struct {
@@ -31,28 +39,33 @@ func NewDataPusher(dao *persistence.DataAccessObject, logger *structs3.AppLogger
 	}
 }
 
-func (t *DataPusher) PushDeletes(item structs.ListElement) {
+func (t *DataPusher) PushDeletes(ctx context.Context, item structs.ListElement) {
+	ctx, span := tracing.Start(ctx, "push.deletes", trace.WithAttributes(label.Int64("dem.batch_id", item.UpdateBatchId)))
+	defer span.End()
+
 	acceptedTypesMap := make(map[structs.ElementType]struct{})
 	// Enter function, get a list of all modules capable of consuming intelligence
 	modules, err := egressModules(t.dao.ModuleMetadataRepo)
 	if err != nil {
-		t.logger.SystemLogger.Error(err, "error getting egress modules")
+		t.logger.SystemLogger.WithContext(ctx).Error(err, "error getting egress modules")
 		return
 	}
 	// Iterate over the modules and check if they are up and configured, if not skip them to avoid congesting the network unnecessarily
 	for _, module := range modules {
 		if !module.Configured {
+			span.AddEvent(ctx, "module not configured", tracing.Module(module.ModuleServiceName))
 			continue
 		}
 		// If the module is not up and healthy, skip it and move on to the next one in the list
 		if !health.IsUp(module.ModuleServiceName, module.InternalPort) {
-			t.logger.UserLogger.Debug(fmt.Sprintf("%s is not up, cannot push", module.ModuleServiceName))
+			span.AddEvent(ctx, "module not up", tracing.Module(module.ModuleServiceName))
+			t.logger.UserLogger.WithContext(ctx).Debug(fmt.Sprintf("%s is not up, cannot push", module.ModuleServiceName))
 			continue
 		}
 		// Get a list of the accepted element types for the specific module (IP, Domain, Range, etc.)
 		acceptedTypes, err := t.dao.ElementTypeRepo.GetAllForModule(module.ID)
 		if err != nil {
-			t.logger.SystemLogger.Error(err, "error retrieving types for module")
+			t.logger.SystemLogger.WithContext(ctx).Error(err, "error retrieving types for module")
 			return
 		}
 		// Add the accepted elements to a map for quick lookup when sending patches
@@ -65,7 +78,7 @@ func (t *DataPusher) PushDeletes(item structs.ListElement) {
 		}
 
 		wrappedBatch := structs.ProcessedItems{UpdateType: structs.DELETE, SafeList: item.Safe, Item: item, BatchId: item.UpdateBatchId}
-		resp, err := pushData(module.ModuleServiceName, module.InternalPort, wrappedBatch, t.logger)
+		resp, err := pushData(ctx, module.ModuleServiceName, module.InternalPort, wrappedBatch, t.logger)
 		if err != nil || resp.StatusCode != http.StatusOK {
 			t.dao.UpdateStatusRepo.InsertUpdateStatus(structs.UpdateStatus{
 				ServiceName:      module.ModuleServiceName,
@@ -87,24 +100,29 @@ func (t *DataPusher) PushDeletes(item structs.ListElement) {
 	}
 }
 
-func (t *DataPusher) PushUpdates() {
+func (t *DataPusher) PushUpdates(ctx context.Context) {
+	ctx, span := tracing.Start(ctx, "push.updates")
+	defer span.End()
+
 	// Enter function, get a list of all modules capable of consuming intelligence
 	modules, err := egressModules(t.dao.ModuleMetadataRepo)
 
 	if err != nil {
-		t.logger.SystemLogger.Error(err, "error getting egress modules")
+		t.logger.SystemLogger.WithContext(ctx).Error(err, "error getting egress modules")
 		return
 	}
 	// Iterate over the modules and check if they are up and configured, if not skip them to avoid congesting the network unnecessarily
 	for _, module := range modules {
 
 		if !module.Configured {
+			span.AddEvent(ctx, "module not configured", tracing.Module(module.ModuleServiceName))
 			continue
 		}
 
 		// If the module is not up and healthy, skip it and move on to the next one in the list
 		if !health.IsUp(module.ModuleServiceName, module.InternalPort) {
-			t.logger.UserLogger.Debug(fmt.Sprintf("%s is not up, cannot push", module.ModuleServiceName))
+			span.AddEvent(ctx, "module not up", tracing.Module(module.ModuleServiceName))
+			t.logger.UserLogger.WithContext(ctx).Debug(fmt.Sprintf("%s is not up, cannot push", module.ModuleServiceName))
 			continue
 		}
 
@@ -112,7 +130,7 @@ func (t *DataPusher) PushUpdates() {
 		acceptedTypes, err := t.dao.ElementTypeRepo.GetAllForModule(module.ID)
 
 		if err != nil {
-			t.logger.SystemLogger.Error(err, "error retrieving types for module")
+			t.logger.SystemLogger.WithContext(ctx).Error(err, "error retrieving types for module")
 			return
 		}
 
@@ -120,13 +138,14 @@ func (t *DataPusher) PushUpdates() {
 		blocklistBatchIds, err := unpushedBatchIds(module.ID, false, acceptedTypes, t.dao.ListElementRepo)
 
 		if err != nil {
-			t.logger.SystemLogger.Error(err, "error retrieving batch ids for module")
+			t.logger.SystemLogger.WithContext(ctx).Error(err, "error retrieving batch ids for module")
 			return
 		}
 
 		// Iterate through the batch IDs and push them to the module
 		for _, val := range blocklistBatchIds {
 			err := queryBatchAndPush(
+				ctx,
 				val,
 				module,
 				acceptedTypes,
@@ -137,20 +156,23 @@ func (t *DataPusher) PushUpdates() {
 				false)
 
 			if err != nil {
-				t.logger.SystemLogger.Error(err, fmt.Sprintf("Safelist: %v pushing to module ID: %d", false, val))
+				t.logger.SystemLogger.WithContext(ctx).Error(err, fmt.Sprintf("Safelist: %v pushing to module ID: %d", false, val))
 			}
 		}
 
 		// Get batch IDs for the safelist items that have not been pushed to this module before
 		safelistBatchIds, err := unpushedBatchIds(module.ID, true, acceptedTypes, t.dao.ListElementRepo)
 		if err != nil {
-			t.logger.SystemLogger.Error(err, "error retrieving batch ids for module")
+			t.logger.SystemLogger.WithContext(ctx).Error(err, "error retrieving batch ids for module")
 			return
 		}
 
+		metrics.BatchBacklog.WithLabelValues(module.ModuleServiceName, "unpushed").Set(float64(len(blocklistBatchIds) + len(safelistBatchIds)))
+
 		// Iterate through the batch IDs and push them to the module
 		for _, val := range safelistBatchIds {
 			err := queryBatchAndPush(
+				ctx,
 				val,
 				module,
 				acceptedTypes,
@@ -161,23 +183,23 @@ func (t *DataPusher) PushUpdates() {
 				false)
 
 			if err != nil {
-				t.logger.SystemLogger.Error(err, fmt.Sprintf("Safelist: %v pushing to module ID: %d", true, val))
+				t.logger.SystemLogger.WithContext(ctx).Error(err, fmt.Sprintf("Safelist: %v pushing to module ID: %d", true, val))
 			}
 		}
 
 		go func(moduleData structs2.ModuleMetadata, types []structs.ElementType) {
 			time.Sleep(60 * time.Second)
 			// Check if there are any failed batches for the current module and if so, query them and push them
-			err = pushFailedBatches(t.dao, moduleData, types, t.logger)
+			err = pushFailedBatches(ctx, t.dao, moduleData, types, t.logger)
 			if err != nil {
-				t.logger.SystemLogger.Error(err, "error pushing failed batches")
+				t.logger.SystemLogger.WithContext(ctx).Error(err, "error pushing failed batches")
 			}
 			return
 		}(module, acceptedTypes)
 	}
 }
 
-func pushFailedBatches(dao *persistence.DataAccessObject, module structs2.ModuleMetadata, types []structs.ElementType, logger *structs3.AppLogger) error {
+func pushFailedBatches(ctx context.Context, dao *persistence.DataAccessObject, module structs2.ModuleMetadata, types []structs.ElementType, logger *structs3.AppLogger) error {
 	// Get batch IDs for the blocklist items that have failed for this module before (status in the table of FAILED)
 	blocklistFailedBatches, err := failedBatchIds(dao.UpdateStatusRepo, module.ID, false)
 
@@ -185,14 +207,23 @@ func pushFailedBatches(dao *persistence.DataAccessObject, module structs2.Module
 		return err
 	}
 
+	safelistFailedBatches, err := failedBatchIds(dao.UpdateStatusRepo, module.ID, true)
+
+	if err != nil {
+		return err
+	}
+
+	metrics.BatchBacklog.WithLabelValues(module.ModuleServiceName, "failed").Set(float64(len(blocklistFailedBatches) + len(safelistFailedBatches)))
+
 	if len(blocklistFailedBatches) == 0 {
 		return nil
 	}
 
-	logger.UserLogger.Info(fmt.Sprintf("Pushing failed batches for %s", module.ModuleServiceName))
+	logger.UserLogger.WithContext(ctx).Info(fmt.Sprintf("Pushing failed batches for %s", module.ModuleServiceName))
 
 	for _, val := range blocklistFailedBatches {
 		err := queryBatchAndPush(
+			ctx,
 			val,
 			module,
 			types,
@@ -203,25 +234,19 @@ func pushFailedBatches(dao *persistence.DataAccessObject, module structs2.Module
 			true)
 
 		if err != nil {
-			logger.SystemLogger.Error(err, fmt.Sprintf("Safelist: %v Failed: %v pushing to module ID: %d", false, true, val))
+			logger.SystemLogger.WithContext(ctx).Error(err, fmt.Sprintf("Safelist: %v Failed: %v pushing to module ID: %d", false, true, val))
 		}
 	}
 
-	// Get batch IDs for the safelist items that have failed for this module before (status in the table of FAILED)
-	safelistFailedBatches, err := failedBatchIds(dao.UpdateStatusRepo, module.ID, true)
-
-	if err != nil {
-		return err
-	}
-
 	if len(safelistFailedBatches) == 0 {
 		return nil
 	}
 
-	logger.UserLogger.Info(fmt.Sprintf("Pushing failed batches for %s", module.ModuleServiceName))
+	logger.UserLogger.WithContext(ctx).Info(fmt.Sprintf("Pushing failed batches for %s", module.ModuleServiceName))
 
 	for _, val := range safelistFailedBatches {
 		err := queryBatchAndPush(
+			ctx,
 			val,
 			module,
 			types,
@@ -232,7 +257,7 @@ func pushFailedBatches(dao *persistence.DataAccessObject, module structs2.Module
 			true)
 
 		if err != nil {
-			logger.SystemLogger.Error(err, fmt.Sprintf("Safelist: %v Failed: %v pushing to module ID: %d", true, true, val))
+			logger.SystemLogger.WithContext(ctx).Error(err, fmt.Sprintf("Safelist: %v Failed: %v pushing to module ID: %d", true, true, val))
 		}
 	}
 
@@ -258,9 +283,17 @@ func egressModules(repo *persistence.ModuleMetadataRepo) (receiver []structs2.Mo
 	return
 }
 
-func queryBatchAndPush(batchId int64, module structs2.ModuleMetadata,
+func queryBatchAndPush(ctx context.Context, batchId int64, module structs2.ModuleMetadata,
 	types []structs.ElementType, safe bool, listElementRepo *persistence.ListElementRepo,
-	updateStatusRepo *persistence.UpdateStatusRepo, logger *structs3.AppLogger, failed bool) error {
+	updateStatusRepo *persistence.UpdateStatusRepo, logger *structs3.AppLogger, failed bool) (err error) {
+
+	ctx, span := tracing.Start(ctx, "push.batch", trace.WithAttributes(
+		tracing.Module(module.ModuleServiceName),
+		label.Int64("dem.batch_id", batchId),
+		label.Bool("dem.safe", safe),
+		label.Bool("dem.retry", failed),
+	))
+	defer func() { tracing.End(ctx, span, err) }()
 
 	// Get the next batch for a module using a provided batch ID
 	updateBatch, err := nextBatch(batchId, types, listElementRepo)
@@ -269,15 +302,16 @@ func queryBatchAndPush(batchId int64, module structs2.ModuleMetadata,
 		return errors.Wrap(err, "Error retrieving next batch for pushing")
 	}
 
+	span.SetAttributes(label.Int("dem.elements", len(updateBatch)))
 	if len(updateBatch) == 0 {
 		return nil
 	}
 
-	logger.UserLogger.Info(fmt.Sprintf("Pushing to %s", module.ModuleServiceName))
+	logger.UserLogger.WithContext(ctx).Info(fmt.Sprintf("Pushing to %s", module.ModuleServiceName))
 
 	wrappedBatch := structs.ProcessedItems{UpdateType: structs.ADD, SafeList: safe, Items: updateBatch, BatchId: batchId}
 
-	resp, err := pushData(module.ModuleServiceName, module.InternalPort, wrappedBatch, logger)
+	resp, err := pushData(ctx, module.ModuleServiceName, module.InternalPort, wrappedBatch, logger)
 
 	if err != nil {
 		if !failed {
@@ -292,9 +326,10 @@ func queryBatchAndPush(batchId int64, module structs2.ModuleMetadata,
 		return errors.Wrap(err, "Http: Error pushing next batch")
 	}
 
-	logger.UserLogger.Info(fmt.Sprintf("Pushed batch %d to %s", batchId, module.ModuleServiceName))
+	logger.UserLogger.WithContext(ctx).Info(fmt.Sprintf("Pushed batch %d to %s", batchId, module.ModuleServiceName))
 	if resp.StatusCode != http.StatusAccepted {
-		logger.UserLogger.Info(fmt.Sprintf("Pushing failed to %s", module.ModuleServiceName))
+		logger.UserLogger.WithContext(ctx).Info(fmt.Sprintf("Pushing failed to %s", module.ModuleServiceName))
+		span.SetStatus(codes.Error, fmt.Sprintf("module responded with %d", resp.StatusCode))
 		if !failed {
 			updateStatusRepo.InsertUpdateStatus(structs.UpdateStatus{
 				ServiceName:      module.ModuleServiceName,
@@ -305,7 +340,7 @@ func queryBatchAndPush(batchId int64, module structs2.ModuleMetadata,
 			})
 		}
 	} else {
-		logger.UserLogger.Info(fmt.Sprintf("Pushing succeeded to %s", module.ModuleServiceName))
+		logger.UserLogger.WithContext(ctx).Info(fmt.Sprintf("Pushing succeeded to %s", module.ModuleServiceName))
 		if !failed {
 			updateStatusRepo.InsertUpdateStatus(structs.UpdateStatus{
 				ServiceName:      module.ModuleServiceName,
@@ -327,11 +362,13 @@ func queryBatchAndPush(batchId int64, module structs2.ModuleMetadata,
 	return nil
 }
 
-func pushData(moduleName, modulePort, data interface{}, logger *structs3.AppLogger) (*http.Response, error) {
+// pushData sends the data to the /run endpoint of a module, the trace context of ctx is sent with it so the module
+// can continue the trace and send it back with its acknowledgement on /internal/update
+func pushData(ctx context.Context, moduleName, modulePort string, data interface{}, logger *structs3.AppLogger) (*http.Response, error) {
 	jsonData, err := json.Marshal(data)
 
 	if err != nil {
-		logger.SystemLogger.Error(err, "Error marshalling batch into JSON")
+		logger.SystemLogger.WithContext(ctx).Error(err, "Error marshalling batch into JSON")
 		return nil, err
 	}
 
@@ -343,14 +380,37 @@ func pushData(moduleName, modulePort, data interface{}, logger *structs3.AppLogg
 		return nil, err
 	}
 
+	ctx, span := tracing.Start(ctx, "POST /run", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		append(semconv.HTTPClientAttributesFromHTTPRequest(req), tracing.Module(moduleName))...,
+	))
+	defer span.End()
+	req = req.WithContext(ctx)
+	tracing.Inject(ctx, req.Header)
+	if id := applog.RequestID(ctx); id != "" {
+		req.Header.Set(applog.RequestIDHeader, id)
+	}
+
 	client := &http.Client{
 		Timeout: 120 * time.Second,
 	}
+
+	metrics.PushAttempts.WithLabelValues(moduleName).Inc()
+	start := time.Now()
 	resp, err := client.Do(req)
+	metrics.PushDuration.WithLabelValues(moduleName).Observe(time.Since(start).Seconds())
 
 	if err != nil {
+		metrics.PushResults.WithLabelValues(moduleName, metrics.Failure).Inc()
+		tracing.Fail(ctx, span, err)
 		return nil, err
 	}
 
+	tracing.SetHTTPStatus(span, resp.StatusCode)
+	if resp.StatusCode >= http.StatusBadRequest {
+		metrics.PushResults.WithLabelValues(moduleName, metrics.Failure).Inc()
+	} else {
+		metrics.PushResults.WithLabelValues(moduleName, metrics.Success).Inc()
+	}
+
 	return resp, err
 }
@@ -1,13 +1,18 @@
 package queue
 
 import (
+	"context"
 	"errors"
 	"fp-dynamic-elements-manager-controller/internal/db/persistence"
 	structs2 "fp-dynamic-elements-manager-controller/internal/logging/structs"
+	"fp-dynamic-elements-manager-controller/internal/metrics"
 	notificationfuncs "fp-dynamic-elements-manager-controller/internal/notification"
 	"fp-dynamic-elements-manager-controller/internal/queue/structs"
+	"fp-dynamic-elements-manager-controller/internal/tracing"
 	validation "fp-dynamic-elements-manager-controller/internal/util"
 	"github.com/thoas/go-funk"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
 )
 
 const MaxBatchSize = 5000
@@ -15,79 +20,119 @@ const MaxBatchSize = 5000
 var ErrEmptySlice = errors.New("empty slice")
 var ErrInvalidFormat = errors.New("invalid format")
 
-func Delete(item structs.ListElement, pusher Pusher, dao *persistence.DataAccessObject) {
+func Delete(ctx context.Context, item structs.ListElement, pusher Pusher, dao *persistence.DataAccessObject) {
 	dao.ListElementRepo.DeleteByValue(item.Value)
+	ctx = tracing.Detach(ctx)
 	go func() {
-		pusher.PushDeletes(item)
+		pusher.PushDeletes(ctx, item)
 	}()
 	return
 }
 
-func AddOne(items []structs.ListElement, pusher Pusher, dao *persistence.DataAccessObject, logger *structs2.AppLogger) error {
+func AddOne(ctx context.Context, items []structs.ListElement, pusher Pusher, dao *persistence.DataAccessObject, logger *structs2.AppLogger) error {
 	if len(items) == 0 {
 		return ErrEmptySlice
 	}
 	element := items[0]
-	err := validateInput(element, logger)
+	err := validateInput(ctx, element, logger)
 	if err != nil {
 		return err
 	}
 	res, err := dao.ElementBatchRepo.InsertBatchElement()
 	if err != nil {
-		logger.SystemLogger.Error(err, "Error inserting batch element in queue")
+		logger.SystemLogger.WithContext(ctx).Error(err, "Error inserting batch element in queue")
 		return err
 	}
 	batchId, err := res.LastInsertId()
 	if err != nil {
-		logger.SystemLogger.Error(err, "Error retrieving last insert ID in queue")
+		logger.SystemLogger.WithContext(ctx).Error(err, "Error retrieving last insert ID in queue")
 		return err
 	}
 	for i := range items {
 		items[i].UpdateBatchId = batchId
 	}
+	countIngested(items[:1], dao.ModuleMetadataRepo)
 	err = dao.ListElementRepo.InsertListElement(items[0])
+	ctx = tracing.Detach(ctx)
 	go func() {
-		pusher.PushUpdates()
+		pusher.PushUpdates(ctx)
 	}()
 	return err
 }
 
-func AddToQueue(items []structs.ListElement, pusher Pusher, dao *persistence.DataAccessObject, logger *structs2.AppLogger) {
+// AddToQueue writes the elements to the DB in batches of at most MaxBatchSize then pushes them to the egress modules.
+// Each batch insert is traced as a child of the span in ctx, as is the push which follows
+func AddToQueue(ctx context.Context, items []structs.ListElement, pusher Pusher, dao *persistence.DataAccessObject, logger *structs2.AppLogger) {
 	if len(items) == 0 {
 		return
 	}
+	countIngested(items, dao.ModuleMetadataRepo)
 	chunkedItems := funk.Chunk(items, MaxBatchSize)
 	for _, chunk := range chunkedItems.([][]structs.ListElement) {
-		res, err := dao.ElementBatchRepo.InsertBatchElement()
-		if err != nil {
-			logger.SystemLogger.Error(err, "Error inserting batch element in queue")
+		if err := insertChunk(ctx, chunk, dao, logger); err != nil {
 			return
 		}
-		batchId, err := res.LastInsertId()
-		if err != nil {
-			logger.SystemLogger.Error(err, "Error retrieving last insert ID in queue")
-			return
-		}
-
-		for i := range chunk {
-			chunk[i].UpdateBatchId = batchId
-		}
-
-		dao.ListElementRepo.BatchInsertListElements(chunk)
 	}
 	go func() {
-		pusher.PushUpdates()
+		pusher.PushUpdates(ctx)
 	}()
 }
 
-func validateInput(element structs.ListElement, logger *structs2.AppLogger) error {
+func insertChunk(ctx context.Context, chunk []structs.ListElement, dao *persistence.DataAccessObject, logger *structs2.AppLogger) (err error) {
+	ctx, span := tracing.Start(ctx, "queue.insert_batch", trace.WithAttributes(label.Int("dem.elements", len(chunk))))
+	defer func() { tracing.End(ctx, span, err) }()
+
+	res, err := dao.ElementBatchRepo.InsertBatchElement()
+	if err != nil {
+		logger.SystemLogger.WithContext(ctx).Error(err, "Error inserting batch element in queue")
+		return err
+	}
+	batchId, err := res.LastInsertId()
+	if err != nil {
+		logger.SystemLogger.WithContext(ctx).Error(err, "Error retrieving last insert ID in queue")
+		return err
+	}
+	span.SetAttributes(label.Int64("dem.batch_id", batchId))
+
+	for i := range chunk {
+		chunk[i].UpdateBatchId = batchId
+	}
+
+	// The repo logs its own errors, the remaining chunks are still inserted as before
+	dao.ListElementRepo.BatchInsertListElements(chunk)
+	return nil
+}
+
+// countIngested adds the elements received to the ingestion metrics, the source is the registered module which sent
+// them. The service name comes from the client, so names which are not registered are counted as unknown
+func countIngested(items []structs.ListElement, modules persistence.ModuleLookupRepo) {
+	counts := make(map[string]int)
+	for _, item := range items {
+		counts[item.ServiceName]++
+	}
+	sources := make(map[string]int)
+	for serviceName, n := range counts {
+		source := "unknown"
+		if serviceName != "" {
+			if module, err := modules.GetByServiceName(serviceName); err == nil {
+				source = module.ModuleServiceName
+			}
+		}
+		sources[source] += n
+	}
+	for source, n := range sources {
+		metrics.ElementsIngested.WithLabelValues(source).Add(float64(n))
+	}
+}
+
+func validateInput(ctx context.Context, element structs.ListElement, logger *structs2.AppLogger) error {
 	switch element.Type {
 	case structs.IP:
 		if !validation.IsIpValid(element.Value) {
 			logger.NotificationService.Send(notificationfuncs.Event{
 				EventType: notificationfuncs.Error,
 				Value:     "Invalid IP Format",
-			})
+			}.WithContext(ctx))
 			return ErrInvalidFormat
 		}
 	case structs.URL:
@@ -95,7 +140,7 @@ func validateInput(element structs.ListElement, logger *structs2.AppLogger) erro
 			logger.NotificationService.Send(notificationfuncs.Event{
 				EventType: notificationfuncs.Error,
 				Value:     "Invalid URL Format",
-			})
+			}.WithContext(ctx))
 			return ErrInvalidFormat
 		}
 	case structs.DOMAIN:
@@ -103,7 +148,7 @@ func validateInput(element structs.ListElement, logger *structs2.AppLogger) erro
 			logger.NotificationService.Send(notificationfuncs.Event{
 				EventType: notificationfuncs.Error,
 				Value:     "Invalid Domain Format",
-			})
+			}.WithContext(ctx))
 			return ErrInvalidFormat
 		}
 	case structs.RANGE:
@@ -111,7 +156,7 @@ func validateInput(element structs.ListElement, logger *structs2.AppLogger) erro
 			logger.NotificationService.Send(notificationfuncs.Event{
 				EventType: notificationfuncs.Error,
 				Value:     "Invalid Range Format",
-			})
+			}.WithContext(ctx))
 			return ErrInvalidFormat
 		}
 	}
@@ -0,0 +1,100 @@
+package routing
+
+import (
+	"sync"
+	"time"
+)
+
+type BreakerState string
+
+const (
+	Closed   BreakerState = "closed"
+	Open     BreakerState = "open"
+	HalfOpen BreakerState = "half-open"
+)
+
+// CircuitBreaker stops requests being proxied to a module after a number of consecutive failures.
+// Once the cooldown has passed a single trial request is let through, if it succeeds the breaker closes again
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	threshold int
+	cooldown  time.Duration
+	openedAt  time.Time
+	trialSent bool
+	now       func() time.Time
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &CircuitBreaker{
+		state:     Closed,
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// Allow reports whether a request can be sent to the module
+func (c *CircuitBreaker) Allow() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.state {
+	case Open:
+		if c.now().Sub(c.openedAt) < c.cooldown {
+			return false
+		}
+		c.state = HalfOpen
+		c.trialSent = true
+		return true
+	case HalfOpen:
+		if c.trialSent {
+			return false
+		}
+		c.trialSent = true
+		return true
+	}
+	return true
+}
+
+func (c *CircuitBreaker) Success() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state = Closed
+	c.failures = 0
+	c.trialSent = false
+}
+
+func (c *CircuitBreaker) Failure() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures++
+	if c.state == HalfOpen || c.failures >= c.threshold {
+		c.state = Open
+		c.openedAt = c.now()
+		c.trialSent = false
+	}
+}
+
+// RetryAfter returns how long until the breaker lets a trial request through, zero if it is not open
+func (c *CircuitBreaker) RetryAfter() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state != Open {
+		return 0
+	}
+	if remaining := c.cooldown - c.now().Sub(c.openedAt); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+func (c *CircuitBreaker) State() BreakerState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
@@ -0,0 +1,382 @@
+package routing
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"fp-dynamic-elements-manager-controller/api/util"
+	"fp-dynamic-elements-manager-controller/internal/auth"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
+	"fp-dynamic-elements-manager-controller/internal/modules/structs"
+	"fp-dynamic-elements-manager-controller/internal/tracing"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	UserIDHeader    = "X-Dim-User-Id"
+	UserEmailHeader = "X-Dim-User-Email"
+	UserNameHeader  = "X-Dim-User-Name"
+	TimestampHeader = "X-Dim-Timestamp"
+	SignatureHeader = "X-Dim-Signature"
+)
+
+// credentialHeaders are never forwarded to modules, the identity headers are also removed so they cannot be spoofed
+var credentialHeaders = []string{
+	"x-access-token", "x-internal-token", "Cookie", "Authorization",
+	UserIDHeader, UserEmailHeader, UserNameHeader, TimestampHeader, SignatureHeader,
+}
+
+type ProxyConfig struct {
+	// DialTimeout is the time allowed to connect to a module
+	DialTimeout time.Duration
+	// Timeout is the time allowed for a module to start responding, ModuleTimeouts overrides it per service name
+	Timeout        time.Duration
+	ModuleTimeouts map[string]time.Duration
+	// FailureThreshold is the number of consecutive failures before a module's circuit breaker opens
+	FailureThreshold int
+	// Cooldown is how long a circuit breaker stays open before a trial request is let through
+	Cooldown time.Duration
+	// SigningKey is the key each module's identity signing key is derived from, see ModuleSigningKey. It is required,
+	// identity headers are never signed without it
+	SigningKey string
+	// TrustedProxies are the addresses of the proxies in front of the controller, the X-Forwarded-Proto and
+	// X-Forwarded-Host headers are only passed on to modules from these. Otherwise they are set from the request
+	TrustedProxies []*net.IPNet
+}
+
+// minSigningKeyLength is the length of the shortest signing key accepted
+const minSigningKeyLength = 32
+
+// ModuleSigningKeyEnv is the environment variable a module's identity signing key is passed to the module in
+const ModuleSigningKeyEnv = "IDENTITY_SIGNING_KEY"
+
+// ErrNoSigningKey is returned when identity headers are signed without a signing key
+var ErrNoSigningKey = errors.New("PROXY_SIGNING_KEY is not set, identity headers cannot be signed")
+
+// ValidateSigningKey checks the signing key identity headers are signed with is set and long enough
+func ValidateSigningKey(key string) error {
+	if key == "" {
+		return ErrNoSigningKey
+	}
+	if len(key) < minSigningKeyLength {
+		return fmt.Errorf("PROXY_SIGNING_KEY must be at least %d characters", minSigningKeyLength)
+	}
+	return nil
+}
+
+// Validate checks the proxy can sign the identity headers of the requests it forwards
+func (c ProxyConfig) Validate() error {
+	return ValidateSigningKey(c.SigningKey)
+}
+
+func DefaultProxyConfig() ProxyConfig {
+	return ProxyConfig{
+		DialTimeout:      5 * time.Second,
+		Timeout:          30 * time.Second,
+		ModuleTimeouts:   map[string]time.Duration{},
+		FailureThreshold: 5,
+		Cooldown:         30 * time.Second,
+	}
+}
+
+// ProxyConfigFromEnv reads the PROXY_* settings.
+// PROXY_MODULE_TIMEOUTS takes a comma separated list of service=duration pairs, e.g. fp-ngfw=60s,fp-dep=10s, and
+// PROXY_TRUSTED_PROXIES a comma separated list of addresses or CIDRs, e.g. 10.0.0.5,172.18.0.0/16
+func ProxyConfigFromEnv() ProxyConfig {
+	cfg := DefaultProxyConfig()
+
+	if d, err := time.ParseDuration(os.Getenv("PROXY_TIMEOUT")); err == nil {
+		cfg.Timeout = d
+	}
+
+	if d, err := time.ParseDuration(os.Getenv("PROXY_BREAKER_COOLDOWN")); err == nil {
+		cfg.Cooldown = d
+	}
+
+	if n, err := strconv.Atoi(os.Getenv("PROXY_BREAKER_THRESHOLD")); err == nil {
+		cfg.FailureThreshold = n
+	}
+
+	for _, pair := range strings.Split(os.Getenv("PROXY_MODULE_TIMEOUTS"), ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if d, err := time.ParseDuration(kv[1]); err == nil {
+			cfg.ModuleTimeouts[kv[0]] = d
+		}
+	}
+
+	cfg.SigningKey = os.Getenv("PROXY_SIGNING_KEY")
+
+	for _, proxy := range strings.Split(os.Getenv("PROXY_TRUSTED_PROXIES"), ",") {
+		if network := parseNetwork(strings.TrimSpace(proxy)); network != nil {
+			cfg.TrustedProxies = append(cfg.TrustedProxies, network)
+		}
+	}
+
+	return cfg
+}
+
+// retryAfter formats the wait in whole seconds rounded up, at least one second as a half-open breaker has a trial
+// request in flight
+func retryAfter(wait time.Duration) string {
+	seconds := int64(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.FormatInt(seconds, 10)
+}
+
+// parseNetwork parses an address or a CIDR, an address is a network of only itself. It returns nil if it is neither
+func parseNetwork(value string) *net.IPNet {
+	if _, network, err := net.ParseCIDR(value); err == nil {
+		return network
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil
+	}
+	bits := 128
+	if ip.To4() != nil {
+		ip, bits = ip.To4(), 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+}
+
+// trusted reports whether the request came straight from one of the trusted proxies
+func (c ProxyConfig) trusted(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range c.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewProxyFactory returns a ProxyFactory which builds hardened reverse proxies for module endpoints.
+// Transports and circuit breakers are shared by every endpoint of a module and survive route table rebuilds
+func NewProxyFactory(cfg ProxyConfig) ProxyFactory {
+	var mu sync.Mutex
+	transports := make(map[string]*http.Transport)
+	breakers := make(map[string]*CircuitBreaker)
+
+	return func(target *url.URL, module structs.ModuleMetadata, endpoint structs.ModuleEndpoint) http.Handler {
+		mu.Lock()
+		defer mu.Unlock()
+
+		name := module.ModuleServiceName
+		transport, ok := transports[name]
+		if !ok {
+			transport = newTransport(cfg, name)
+			transports[name] = transport
+		}
+		breaker, ok := breakers[name]
+		if !ok {
+			breaker = NewCircuitBreaker(cfg.FailureThreshold, cfg.Cooldown)
+			breakers[name] = breaker
+		}
+
+		return newModuleProxy(target, module, transport, breaker, cfg)
+	}
+}
+
+func newTransport(cfg ProxyConfig, serviceName string) *http.Transport {
+	timeout := cfg.Timeout
+	if t, ok := cfg.ModuleTimeouts[serviceName]; ok {
+		timeout = t
+	}
+	return &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout:   cfg.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		ResponseHeaderTimeout: timeout,
+	}
+}
+
+type moduleProxy struct {
+	module  structs.ModuleMetadata
+	proxy   *httputil.ReverseProxy
+	breaker *CircuitBreaker
+}
+
+func newModuleProxy(target *url.URL, module structs.ModuleMetadata, transport http.RoundTripper, breaker *CircuitBreaker, cfg ProxyConfig) *moduleProxy {
+	p := &moduleProxy{module: module, breaker: breaker}
+
+	p.proxy = &httputil.ReverseProxy{
+		Director:  director(target, module.ModuleServiceName, cfg),
+		Transport: transport,
+		// Flush straight away so streamed responses (e.g. server sent events) reach the client as they are written
+		FlushInterval: -1,
+		ModifyResponse: func(resp *http.Response) error {
+			tracing.SetHTTPStatus(trace.SpanFromContext(resp.Request.Context()), resp.StatusCode)
+			switch resp.StatusCode {
+			case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+				breaker.Failure()
+			default:
+				breaker.Success()
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			breaker.Failure()
+			tracing.Fail(r.Context(), trace.SpanFromContext(r.Context()), err)
+			applog.System().WithContext(r.Context()).WithFields(applog.Fields{"module": module.ModuleServiceName}).Error(err, "error proxying request to module")
+			if isTimeout(err) {
+				util.ReturnHTTPStatus(w, http.StatusGatewayTimeout, fmt.Sprintf("module %s timed out", module.ModuleDisplayName))
+				return
+			}
+			util.ReturnHTTPStatus(w, http.StatusBadGateway, fmt.Sprintf("module %s could not be reached", module.ModuleDisplayName))
+		},
+	}
+
+	return p
+}
+
+func (p *moduleProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Start(r.Context(), fmt.Sprintf("proxy %s", p.module.ModuleServiceName),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(tracing.Module(p.module.ModuleServiceName), label.String("http.route", r.URL.Path)),
+	)
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	if !p.breaker.Allow() {
+		span.SetStatus(codes.Error, "circuit breaker open")
+		w.Header().Set("Retry-After", retryAfter(p.breaker.RetryAfter()))
+		util.ReturnHTTPStatus(w, http.StatusServiceUnavailable, fmt.Sprintf("module %s is unavailable", p.module.ModuleDisplayName))
+		return
+	}
+	// The controller sets a JSON content type on every response, the module's own content type must be used instead
+	w.Header().Del("Content-Type")
+	p.proxy.ServeHTTP(w, r)
+}
+
+func director(target *url.URL, serviceName string, cfg ProxyConfig) func(*http.Request) {
+	targetQuery := target.RawQuery
+
+	return func(req *http.Request) {
+		prefix := strings.TrimSuffix(req.URL.Path, target.Path)
+
+		req.URL.Scheme = target.Scheme
+		req.URL.Host = target.Host
+		req.URL.Path = target.Path
+		req.URL.RawPath = ""
+
+		if targetQuery == "" || req.URL.RawQuery == "" {
+			req.URL.RawQuery = targetQuery + req.URL.RawQuery
+		} else {
+			req.URL.RawQuery = targetQuery + "&" + req.URL.RawQuery
+		}
+
+		if _, ok := req.Header["User-Agent"]; !ok {
+			// explicitly disable User-Agent so it's not set to default value
+			req.Header.Set("User-Agent", "")
+		}
+
+		for _, h := range credentialHeaders {
+			req.Header.Del(h)
+		}
+
+		// the forwarded headers are only taken from a trusted proxy, a client could set them to anything
+		proto, host := "http", req.Host
+		if req.TLS != nil {
+			proto = "https"
+		}
+		if cfg.trusted(req.RemoteAddr) {
+			if fwd := req.Header.Get("X-Forwarded-Proto"); fwd == "http" || fwd == "https" {
+				proto = fwd
+			}
+			if fwd := req.Header.Get("X-Forwarded-Host"); fwd != "" {
+				host = fwd
+			}
+		}
+		req.Header.Set("X-Forwarded-Proto", proto)
+		req.Header.Set("X-Forwarded-Host", host)
+		req.Header.Set("X-Forwarded-Prefix", prefix)
+
+		// Send the trace context of the proxy span and the request ID so the module can continue the trace
+		tracing.Inject(req.Context(), req.Header)
+		if id := applog.RequestID(req.Context()); id != "" {
+			req.Header.Set(applog.RequestIDHeader, id)
+		}
+
+		// without a signing key the request is forwarded without an identity, the identity headers were removed above
+		if user, ok := auth.UserFromContext(req.Context()); ok {
+			if err := SignRequest(req, serviceName, user.UserID, user.Email, user.Name, cfg.SigningKey); err != nil {
+				applog.System().WithContext(req.Context()).Error(err, "error signing the identity headers")
+			}
+		}
+	}
+}
+
+// SignRequest sets the identity headers of a user on a request to a module, the same way the proxy does. The headers
+// are signed with the module's key derived from signingKey, nothing is set if signingKey is empty
+func SignRequest(req *http.Request, serviceName string, userID uint, email, name, signingKey string) error {
+	if signingKey == "" {
+		return ErrNoSigningKey
+	}
+	setIdentityHeaders(req.Header, userID, email, name, ModuleSigningKey(signingKey, serviceName))
+	return nil
+}
+
+// ModuleSigningKey returns the key the identity headers sent to a module are signed with. Each module only holds its
+// own key, so a module cannot sign an identity on requests to another module
+func ModuleSigningKey(signingKey, serviceName string) string {
+	mac := hmac.New(sha256.New, []byte(signingKey))
+	mac.Write([]byte("module-identity\n" + serviceName))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func setIdentityHeaders(h http.Header, userID uint, email, name, key string) {
+	id := strconv.FormatUint(uint64(userID), 10)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	h.Set(UserIDHeader, id)
+	h.Set(UserEmailHeader, email)
+	h.Set(UserNameHeader, name)
+	h.Set(TimestampHeader, ts)
+	h.Set(SignatureHeader, SignIdentity(key, id, email, name, ts))
+}
+
+// SignIdentity returns the hex encoded HMAC-SHA256 of the identity headers, modules can recompute this with the key
+// in IDENTITY_SIGNING_KEY to verify that the identity was set by the controller
+func SignIdentity(key, userID, email, name, timestamp string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(strings.Join([]string{userID, email, name, timestamp}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func isTimeout(err error) bool {
+	if errors.Cause(err) == context.DeadlineExceeded {
+		return true
+	}
+	netErr, ok := errors.Cause(err).(net.Error)
+	return ok && netErr.Timeout()
+}
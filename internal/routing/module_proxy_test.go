@@ -0,0 +1,266 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"fp-dynamic-elements-manager-controller/api/util"
+	authstructs "fp-dynamic-elements-manager-controller/internal/auth/structs"
+	"fp-dynamic-elements-manager-controller/internal/modules/structs"
+	"fp-dynamic-elements-manager-controller/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/sdk/export/trace/tracetest"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type ModuleProxyTestSuite struct {
+	suite.Suite
+}
+
+func TestModuleProxy(t *testing.T) {
+	suite.Run(t, new(ModuleProxyTestSuite))
+}
+
+func newTestProxy(backend string, cfg ProxyConfig) http.Handler {
+	target, _ := url.Parse(backend + "/config")
+	module := structs.ModuleMetadata{ModuleServiceName: "fp-test", ModuleDisplayName: "Test Module"}
+	return NewProxyFactory(cfg)(target, module, structs.ModuleEndpoint{Endpoint: "/config", Secure: true})
+}
+
+func (m *ModuleProxyTestSuite) TestModuleProxy_Headers() {
+	var received http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	cfg := DefaultProxyConfig()
+	cfg.SigningKey = "test-key-0123456789abcdef0123456"
+	proxy := newTestProxy(backend.URL, cfg)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/fptest/config", nil)
+	req.Header.Set("x-access-token", "jwt")
+	req.Header.Set("x-internal-token", "internal")
+	req.Header.Set(UserEmailHeader, "spoofed@example.com")
+	req = req.WithContext(context.WithValue(req.Context(), "user", &authstructs.Token{UserID: 7, Email: "admin@example.com", Name: "Admin"}))
+
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Content-Type", "application/json")
+	proxy.ServeHTTP(rec, req)
+
+	m.T().Run("Test credentials are stripped", func(t *testing.T) {
+		assert.Empty(t, received.Get("x-access-token"))
+		assert.Empty(t, received.Get("x-internal-token"))
+	})
+
+	m.T().Run("Test identity is injected and signed", func(t *testing.T) {
+		assert.Equal(t, "7", received.Get(UserIDHeader))
+		assert.Equal(t, "admin@example.com", received.Get(UserEmailHeader))
+		expected := SignIdentity(ModuleSigningKey("test-key-0123456789abcdef0123456", "fp-test"), "7", "admin@example.com", "Admin", received.Get(TimestampHeader))
+		assert.Equal(t, expected, received.Get(SignatureHeader))
+	})
+
+	m.T().Run("Test a module cannot sign for another module", func(t *testing.T) {
+		other := SignIdentity(ModuleSigningKey("test-key-0123456789abcdef0123456", "fp-other"), "7", "admin@example.com", "Admin", received.Get(TimestampHeader))
+		assert.NotEqual(t, other, received.Get(SignatureHeader))
+	})
+
+	m.T().Run("Test forwarded headers are set", func(t *testing.T) {
+		assert.Equal(t, "/api/fptest", received.Get("X-Forwarded-Prefix"))
+		assert.Equal(t, "http", received.Get("X-Forwarded-Proto"))
+		assert.NotEmpty(t, received.Get("X-Forwarded-For"))
+	})
+
+	m.T().Run("Test module content type is kept", func(t *testing.T) {
+		assert.Equal(t, []string{"text/plain"}, rec.Header()["Content-Type"])
+	})
+}
+
+func (m *ModuleProxyTestSuite) TestModuleProxy_NoSigningKey() {
+	var received http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/fptest/config", nil)
+	req.Header.Set(UserIDHeader, "1")
+	req.Header.Set(SignatureHeader, "spoofed")
+	req = req.WithContext(context.WithValue(req.Context(), "user", &authstructs.Token{UserID: 7, Email: "admin@example.com"}))
+	newTestProxy(backend.URL, DefaultProxyConfig()).ServeHTTP(httptest.NewRecorder(), req)
+
+	m.T().Run("Test the identity is not signed without a key", func(t *testing.T) {
+		assert.Empty(t, received.Get(UserIDHeader))
+		assert.Empty(t, received.Get(SignatureHeader))
+	})
+
+	m.T().Run("Test the signing key is required", func(t *testing.T) {
+		assert.Equal(t, ErrNoSigningKey, DefaultProxyConfig().Validate())
+		cfg := DefaultProxyConfig()
+		cfg.SigningKey = "short"
+		assert.NotNil(t, cfg.Validate())
+		cfg.SigningKey = "test-key-0123456789abcdef0123456"
+		assert.Nil(t, cfg.Validate())
+	})
+}
+
+func (m *ModuleProxyTestSuite) TestModuleProxy_TrustedProxies() {
+	var received http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	cfg := DefaultProxyConfig()
+	cfg.TrustedProxies = []*net.IPNet{parseNetwork("10.0.0.0/8")}
+	forwarded := func(remoteAddr string) {
+		req := httptest.NewRequest(http.MethodGet, "/api/fptest/config", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Set("X-Forwarded-Host", "dim.example.com")
+		newTestProxy(backend.URL, cfg).ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	m.T().Run("Test forwarded headers from a client are replaced", func(t *testing.T) {
+		forwarded("192.0.2.1:1234")
+		assert.Equal(t, "http", received.Get("X-Forwarded-Proto"))
+		assert.Equal(t, "example.com", received.Get("X-Forwarded-Host"))
+	})
+
+	m.T().Run("Test forwarded headers from a trusted proxy are kept", func(t *testing.T) {
+		forwarded("10.1.2.3:1234")
+		assert.Equal(t, "https", received.Get("X-Forwarded-Proto"))
+		assert.Equal(t, "dim.example.com", received.Get("X-Forwarded-Host"))
+	})
+
+	m.T().Run("Test trusted proxies are parsed from addresses and CIDRs", func(t *testing.T) {
+		assert.True(t, parseNetwork("10.0.0.5").Contains(net.ParseIP("10.0.0.5")))
+		assert.False(t, parseNetwork("10.0.0.5").Contains(net.ParseIP("10.0.0.6")))
+		assert.True(t, parseNetwork("172.18.0.0/16").Contains(net.ParseIP("172.18.3.4")))
+		assert.Nil(t, parseNetwork("proxy"))
+	})
+}
+
+func (m *ModuleProxyTestSuite) TestModuleProxy_Errors() {
+	m.T().Run("Test unreachable module returns JSON 502", func(t *testing.T) {
+		backend := httptest.NewServer(http.NotFoundHandler())
+		backend.Close()
+
+		rec := httptest.NewRecorder()
+		newTestProxy(backend.URL, DefaultProxyConfig()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/fptest/config", nil))
+
+		resp := util.HttpResponse{}
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&resp))
+		assert.Equal(t, http.StatusBadGateway, rec.Code)
+		assert.Equal(t, http.StatusBadGateway, resp.Status)
+	})
+
+	m.T().Run("Test slow module returns 504", func(t *testing.T) {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+		}))
+		defer backend.Close()
+
+		cfg := DefaultProxyConfig()
+		cfg.ModuleTimeouts["fp-test"] = 20 * time.Millisecond
+
+		rec := httptest.NewRecorder()
+		newTestProxy(backend.URL, cfg).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/fptest/config", nil))
+		assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	})
+
+	m.T().Run("Test breaker opens after repeated failures", func(t *testing.T) {
+		calls := 0
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer backend.Close()
+
+		cfg := DefaultProxyConfig()
+		cfg.FailureThreshold = 2
+		proxy := newTestProxy(backend.URL, cfg)
+
+		rec := httptest.NewRecorder()
+		for i := 0; i < 4; i++ {
+			rec = httptest.NewRecorder()
+			proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/fptest/config", nil))
+		}
+		assert.Equal(t, 2, calls)
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, "30", rec.Header().Get("Retry-After"))
+	})
+}
+
+func (m *ModuleProxyTestSuite) TestModuleProxy_Tracing() {
+	exporter := tracetest.NewInMemoryExporter()
+	global.SetTracerProvider(tracing.NewProvider(tracing.DefaultConfig(), sdktrace.WithSyncer(exporter)))
+	defer global.SetTracerProvider(trace.NoopTracerProvider())
+	_, err := tracing.Init(tracing.DefaultConfig())
+	m.Nil(err)
+
+	var traceparent string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer backend.Close()
+
+	newTestProxy(backend.URL, DefaultProxyConfig()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/fptest/config", nil))
+
+	spans := exporter.GetSpans()
+	m.Len(spans, 1)
+
+	m.T().Run("Test the trace context is sent to the module", func(t *testing.T) {
+		assert.Contains(t, traceparent, spans[0].SpanContext.TraceID.String())
+		assert.Contains(t, traceparent, spans[0].SpanContext.SpanID.String())
+	})
+
+	m.T().Run("Test the module and status are recorded", func(t *testing.T) {
+		assert.Equal(t, "proxy fp-test", spans[0].Name)
+		assert.Contains(t, spans[0].Attributes, tracing.Module("fp-test"))
+		assert.Contains(t, spans[0].Attributes, label.Int("http.status_code", http.StatusNotFound))
+	})
+}
+
+func (m *ModuleProxyTestSuite) TestCircuitBreaker() {
+	now := time.Now()
+	breaker := NewCircuitBreaker(2, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	breaker.Failure()
+	assert.True(m.T(), breaker.Allow())
+	breaker.Failure()
+	assert.Equal(m.T(), Open, breaker.State())
+	assert.False(m.T(), breaker.Allow())
+	assert.Equal(m.T(), time.Minute, breaker.RetryAfter())
+
+	now = now.Add(40*time.Second + 500*time.Millisecond)
+	assert.Equal(m.T(), "20", retryAfter(breaker.RetryAfter()))
+
+	now = now.Add(2 * time.Minute)
+	assert.Equal(m.T(), time.Duration(0), breaker.RetryAfter())
+	assert.True(m.T(), breaker.Allow())
+	assert.Equal(m.T(), HalfOpen, breaker.State())
+	assert.Equal(m.T(), "1", retryAfter(breaker.RetryAfter()))
+	assert.False(m.T(), breaker.Allow())
+
+	breaker.Failure()
+	assert.Equal(m.T(), Open, breaker.State())
+
+	now = now.Add(2 * time.Minute)
+	assert.True(m.T(), breaker.Allow())
+	breaker.Success()
+	assert.Equal(m.T(), Closed, breaker.State())
+}
@@ -0,0 +1,215 @@
+package routing
+
+import (
+	"fmt"
+	"fp-dynamic-elements-manager-controller/api/util"
+	"fp-dynamic-elements-manager-controller/internal/auth"
+	authstructs "fp-dynamic-elements-manager-controller/internal/auth/structs"
+	"fp-dynamic-elements-manager-controller/internal/metrics"
+	"fp-dynamic-elements-manager-controller/internal/modules/structs"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// RouteTable is the interface used by anything which needs to change the routes of a module without
+// caring how they are served, e.g. the docker command handler removing a module
+type RouteTable interface {
+	Upsert(structs.ModuleMetadata)
+	Remove(string) bool
+}
+
+// ProxyFactory builds the handler that forwards requests for a single module endpoint
+type ProxyFactory func(target *url.URL, module structs.ModuleMetadata, endpoint structs.ModuleEndpoint) http.Handler
+
+type ModuleRoute struct {
+	ModuleServiceName string               `json:"module_service_name"`
+	InboundRoute      string               `json:"inbound_route"`
+	Path              string               `json:"path"`
+	Target            string               `json:"target"`
+	Secure            bool                 `json:"secure"`
+	Methods           []structs.HttpMethod `json:"methods"`
+	RequiredRole      authstructs.Role     `json:"required_role"`
+}
+
+// routeSet is an immutable snapshot of the module routes, a new one is built on every change and swapped in
+type routeSet struct {
+	secure  *mux.Router
+	ingress *mux.Router
+	routes  []ModuleRoute
+}
+
+// ModuleRouter holds the proxy routes for every registered module. Modules can be added, updated and removed at
+// runtime, each change rebuilds the whole table and atomically swaps it so in-flight requests are never affected
+type ModuleRouter struct {
+	mu            sync.Mutex
+	modules       map[string]structs.ModuleMetadata
+	current       atomic.Value
+	securePrefix  string
+	ingressPrefix string
+	newProxy      ProxyFactory
+}
+
+func NewModuleRouter(securePrefix, ingressPrefix string, proxyFactory ProxyFactory) *ModuleRouter {
+	if proxyFactory == nil {
+		proxyFactory = NewProxyFactory(DefaultProxyConfig())
+	}
+	m := &ModuleRouter{
+		modules:       make(map[string]structs.ModuleMetadata),
+		securePrefix:  securePrefix,
+		ingressPrefix: ingressPrefix,
+		newProxy:      proxyFactory,
+	}
+	m.current.Store(m.build())
+	return m
+}
+
+// Upsert adds the routes for a module, replacing any routes the module previously had
+func (m *ModuleRouter) Upsert(module structs.ModuleMetadata) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.modules[module.ModuleServiceName] = module
+	m.current.Store(m.build())
+}
+
+// Remove deletes all the routes for a module, it returns false if the module had no routes
+func (m *ModuleRouter) Remove(serviceName string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.modules[serviceName]; !ok {
+		return false
+	}
+	delete(m.modules, serviceName)
+	m.current.Store(m.build())
+	return true
+}
+
+// Reconcile replaces the whole route table with the given modules, this is used on startup to load the modules
+// from persistence and drops any routes for modules that no longer exist
+func (m *ModuleRouter) Reconcile(modules []structs.ModuleMetadata) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.modules = make(map[string]structs.ModuleMetadata, len(modules))
+	for _, module := range modules {
+		m.modules[module.ModuleServiceName] = module
+	}
+	m.current.Store(m.build())
+}
+
+// Routes returns the currently active module routes
+func (m *ModuleRouter) Routes() []ModuleRoute {
+	routes := m.load().routes
+	out := make([]ModuleRoute, len(routes))
+	copy(out, routes)
+	return out
+}
+
+// SecureHandler serves the module routes which require an authenticated user
+func (m *ModuleRouter) SecureHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.load().secure.ServeHTTP(w, r)
+	})
+}
+
+// IngressHandler serves the module routes which are open to unauthenticated callers
+func (m *ModuleRouter) IngressHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.load().ingress.ServeHTTP(w, r)
+	})
+}
+
+func (m *ModuleRouter) load() *routeSet {
+	return m.current.Load().(*routeSet)
+}
+
+func (m *ModuleRouter) build() *routeSet {
+	set := &routeSet{
+		secure:  newRouter(),
+		ingress: newRouter(),
+		routes:  []ModuleRoute{},
+	}
+
+	// Sort the modules so that the route table is built the same way every time
+	names := make([]string, 0, len(m.modules))
+	for name := range m.modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		module := m.modules[name]
+		for _, ep := range module.ModuleEndpoints {
+			target, err := url.Parse(fmt.Sprintf("http://%s:%s%s", module.ModuleServiceName, module.InternalPort, ep.Endpoint))
+			if err != nil {
+				continue
+			}
+
+			router, prefix := set.ingress, m.ingressPrefix
+			if ep.Secure {
+				router, prefix = set.secure, m.securePrefix
+			}
+
+			path := prefix + module.InboundRoute + ep.Endpoint
+			handler := instrument(module.ModuleServiceName, path, requireRole(ep, m.newProxy(target, module, ep)))
+			route := router.Handle(path, handler)
+			if len(ep.HttpMethods) > 0 {
+				route.Methods(ep.HttpMethods.Names()...)
+			}
+
+			set.routes = append(set.routes, ModuleRoute{
+				ModuleServiceName: module.ModuleServiceName,
+				InboundRoute:      module.InboundRoute,
+				Path:              path,
+				Target:            target.String(),
+				Secure:            ep.Secure,
+				Methods:           ep.HttpMethods,
+				RequiredRole:      ep.RequiredRole,
+			})
+		}
+	}
+
+	return set
+}
+
+func newRouter() *mux.Router {
+	router := mux.NewRouter()
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		util.ReturnHTTPStatus(w, http.StatusNotFound, "no route found")
+	})
+	router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		util.ReturnHTTPStatus(w, http.StatusMethodNotAllowed, fmt.Sprintf("method %s not allowed", r.Method))
+	})
+	return router
+}
+
+// requireRole rejects requests from users who do not hold the role the endpoint requires for the request method.
+// Endpoints without a role are passed straight through, this includes every ingress endpoint
+func requireRole(ep structs.ModuleEndpoint, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role := ep.RoleFor(r.Method)
+		if role == authstructs.AnyRole {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, ok := auth.UserFromContext(r.Context())
+		if !ok || !user.Allows(role) {
+			util.ReturnHTTPStatus(w, http.StatusForbidden, fmt.Sprintf("%s role required", role))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// instrument records the count and latency of requests to a module route
+func instrument(serviceName, path string, next http.Handler) http.Handler {
+	labels := prometheus.Labels{"module": serviceName, "route": path}
+	return promhttp.InstrumentHandlerCounter(metrics.ProxyRequests.MustCurryWith(labels),
+		promhttp.InstrumentHandlerDuration(metrics.ProxyDuration.MustCurryWith(labels), next))
+}
@@ -0,0 +1,153 @@
+package routing
+
+import (
+	"context"
+	authstructs "fp-dynamic-elements-manager-controller/internal/auth/structs"
+	"fp-dynamic-elements-manager-controller/internal/metrics"
+	"fp-dynamic-elements-manager-controller/internal/modules/structs"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+type ModuleRouterTestSuite struct {
+	suite.Suite
+}
+
+func TestModuleRouter(t *testing.T) {
+	suite.Run(t, new(ModuleRouterTestSuite))
+}
+
+// recordingProxy stands in for the reverse proxy and writes the target it would have forwarded to
+func recordingProxy(target *url.URL, _ structs.ModuleMetadata, _ structs.ModuleEndpoint) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(target.String()))
+	})
+}
+
+func testModule(name, route string, endpoints ...structs.ModuleEndpoint) structs.ModuleMetadata {
+	return structs.ModuleMetadata{
+		ModuleServiceName: name,
+		InboundRoute:      route,
+		InternalPort:      "8080",
+		ModuleEndpoints:   endpoints,
+	}
+}
+
+func serve(h http.Handler, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func (m *ModuleRouterTestSuite) TestModuleRouter_Upsert() {
+	router := NewModuleRouter("/api", "/ingress", recordingProxy)
+	router.Upsert(testModule("fp-dep", "/fpdep",
+		structs.ModuleEndpoint{Endpoint: "/config", Secure: true},
+		structs.ModuleEndpoint{Endpoint: "/run"}))
+
+	m.T().Run("Test secure route is served", func(t *testing.T) {
+		rec := serve(router.SecureHandler(), "/api/fpdep/config")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "http://fp-dep:8080/config", rec.Body.String())
+	})
+
+	m.T().Run("Test proxied requests are counted", func(t *testing.T) {
+		counter := metrics.ProxyRequests.WithLabelValues("fp-dep", "/api/fpdep/config", "get", "200")
+		before := testutil.ToFloat64(counter)
+		serve(router.SecureHandler(), "/api/fpdep/config")
+		assert.Equal(t, before+1, testutil.ToFloat64(counter))
+	})
+
+	m.T().Run("Test insecure route is only served on ingress", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(router.IngressHandler(), "/ingress/fpdep/run").Code)
+		assert.Equal(t, http.StatusNotFound, serve(router.SecureHandler(), "/api/fpdep/run").Code)
+	})
+
+	m.T().Run("Test re-registration replaces old routes", func(t *testing.T) {
+		router.Upsert(testModule("fp-dep", "/fpdep", structs.ModuleEndpoint{Endpoint: "/icon", Secure: true}))
+		assert.Equal(t, http.StatusNotFound, serve(router.SecureHandler(), "/api/fpdep/config").Code)
+		assert.Equal(t, http.StatusOK, serve(router.SecureHandler(), "/api/fpdep/icon").Code)
+		assert.Len(t, router.Routes(), 1)
+	})
+}
+
+func (m *ModuleRouterTestSuite) TestModuleRouter_Remove() {
+	router := NewModuleRouter("/api", "/ingress", recordingProxy)
+	router.Upsert(testModule("fp-dep", "/fpdep", structs.ModuleEndpoint{Endpoint: "/config", Secure: true}))
+	router.Upsert(testModule("fp-ngfw", "/fpngfw", structs.ModuleEndpoint{Endpoint: "/config", Secure: true}))
+
+	assert.True(m.T(), router.Remove("fp-dep"))
+	assert.False(m.T(), router.Remove("fp-dep"))
+	assert.Equal(m.T(), http.StatusNotFound, serve(router.SecureHandler(), "/api/fpdep/config").Code)
+	assert.Equal(m.T(), http.StatusOK, serve(router.SecureHandler(), "/api/fpngfw/config").Code)
+}
+
+func (m *ModuleRouterTestSuite) TestModuleRouter_Reconcile() {
+	router := NewModuleRouter("/api", "/ingress", recordingProxy)
+	router.Upsert(testModule("stale", "/stale", structs.ModuleEndpoint{Endpoint: "/config", Secure: true}))
+
+	router.Reconcile([]structs.ModuleMetadata{
+		testModule("fp-dep", "/fpdep", structs.ModuleEndpoint{Endpoint: "/config", Secure: true}),
+	})
+
+	routes := router.Routes()
+	assert.Len(m.T(), routes, 1)
+	assert.Equal(m.T(), "/api/fpdep/config", routes[0].Path)
+	assert.Equal(m.T(), http.StatusNotFound, serve(router.SecureHandler(), "/api/stale/config").Code)
+}
+
+func (m *ModuleRouterTestSuite) TestModuleRouter_MethodsAndRoles() {
+	router := NewModuleRouter("/api", "/ingress", recordingProxy)
+	router.Upsert(testModule("fp-dep", "/fpdep",
+		structs.ModuleEndpoint{Endpoint: "/config", Secure: true, HttpMethods: structs.HttpMethods{
+			{Method: http.MethodGet},
+			{Method: http.MethodPost, Role: authstructs.Admin},
+		}},
+		structs.ModuleEndpoint{Endpoint: "/restart", Secure: true, RequiredRole: authstructs.Admin}))
+
+	as := func(method, path string, user *authstructs.Token) int {
+		req := httptest.NewRequest(method, path, nil)
+		if user != nil {
+			req = req.WithContext(context.WithValue(req.Context(), "user", user))
+		}
+		rec := httptest.NewRecorder()
+		router.SecureHandler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+	viewer := &authstructs.Token{UserID: 2}
+	admin := &authstructs.Token{UserID: 1, Admin: true}
+
+	m.T().Run("Test unregistered method is rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusMethodNotAllowed, as(http.MethodDelete, "/api/fpdep/config", admin))
+	})
+
+	m.T().Run("Test method role is enforced", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, as(http.MethodGet, "/api/fpdep/config", viewer))
+		assert.Equal(t, http.StatusForbidden, as(http.MethodPost, "/api/fpdep/config", viewer))
+		assert.Equal(t, http.StatusOK, as(http.MethodPost, "/api/fpdep/config", admin))
+	})
+
+	m.T().Run("Test endpoint role applies to every method", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, as(http.MethodPut, "/api/fpdep/restart", viewer))
+		assert.Equal(t, http.StatusForbidden, as(http.MethodGet, "/api/fpdep/restart", nil))
+		assert.Equal(t, http.StatusOK, as(http.MethodPut, "/api/fpdep/restart", admin))
+	})
+
+	m.T().Run("Test routes report methods and roles", func(t *testing.T) {
+		routes := router.Routes()
+		if assert.Len(t, routes, 2) {
+			assert.Equal(t, "/api/fpdep/config", routes[0].Path)
+			assert.Equal(t, []structs.HttpMethod{{Method: http.MethodGet}, {Method: http.MethodPost, Role: authstructs.Admin}}, routes[0].Methods)
+			assert.Empty(t, routes[0].RequiredRole)
+			assert.Equal(t, "/api/fpdep/restart", routes[1].Path)
+			assert.Empty(t, routes[1].Methods)
+			assert.Equal(t, authstructs.Admin, routes[1].RequiredRole)
+		}
+	})
+}
@@ -0,0 +1,152 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+const keySize = 32
+
+var (
+	// ErrNoMasterKey is returned when a secret is stored or resolved without a master key configured
+	ErrNoMasterKey = errors.New("no secrets master key is configured")
+	// ErrWrongMasterKey is returned for a secret whose data key was encrypted with another master key
+	ErrWrongMasterKey = errors.New("the secret was encrypted with another master key")
+)
+
+// Keyring holds the master key. Each secret is encrypted with a data key of its own, AES-256-GCM, and the data key is
+// encrypted with the master key, so the master key never encrypts a value itself and is the only key kept outside the DB
+type Keyring struct {
+	key []byte
+	id  string
+}
+
+// NewKeyring returns a keyring for a 32 byte master key
+func NewKeyring(key []byte) (*Keyring, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("the secrets master key must be %d bytes, it is %d", keySize, len(key))
+	}
+	sum := sha256.Sum256(key)
+	return &Keyring{key: append([]byte{}, key...), id: hex.EncodeToString(sum[:8])}, nil
+}
+
+// KeyringFromEnv reads the master key from SECRETS_MASTER_KEY, base64 encoded, or from the file named by
+// SECRETS_MASTER_KEY_FILE, which holds the key base64 encoded or as raw bytes. It returns nil if neither is set
+func KeyringFromEnv() (*Keyring, error) {
+	if value := os.Getenv("SECRETS_MASTER_KEY"); value != "" {
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("SECRETS_MASTER_KEY is not base64: %w", err)
+		}
+		return NewKeyring(key)
+	}
+	if file := os.Getenv("SECRETS_MASTER_KEY_FILE"); file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == keySize {
+			return NewKeyring(data)
+		}
+		key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+		if err != nil {
+			return nil, fmt.Errorf("%s is neither a %d byte key nor base64: %w", file, keySize, err)
+		}
+		return NewKeyring(key)
+	}
+	return nil, nil
+}
+
+// ID identifies the master key without giving anything away about it, it is stored with each secret
+func (k *Keyring) ID() string {
+	return k.id
+}
+
+// Seal encrypts the value with a new data key and returns the ciphertext and the encrypted data key. The name of the
+// secret is authenticated with both so a ciphertext cannot be moved to another secret
+func (k *Keyring) Seal(name string, value []byte) (ciphertext, dataKey []byte, err error) {
+	key, err := NewDataKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	if ciphertext, err = seal(key, value, []byte(name)); err != nil {
+		return nil, nil, err
+	}
+	if dataKey, err = k.WrapKey(name, key); err != nil {
+		return nil, nil, err
+	}
+	return ciphertext, dataKey, nil
+}
+
+// Open decrypts the data key with the master key and the value with the data key
+func (k *Keyring) Open(name, keyID string, ciphertext, dataKey []byte) ([]byte, error) {
+	key, err := k.UnwrapKey(name, keyID, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return open(key, ciphertext, []byte(name))
+}
+
+// NewDataKey returns a random key for AES-256
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// WrapKey encrypts a data key with the master key, the label names what the key encrypts and must be given to unwrap it
+func (k *Keyring) WrapKey(label string, key []byte) ([]byte, error) {
+	return seal(k.key, key, []byte(label+"\x00"+k.id))
+}
+
+// UnwrapKey decrypts a data key wrapped with WrapKey
+func (k *Keyring) UnwrapKey(label, keyID string, wrapped []byte) ([]byte, error) {
+	if keyID != k.id {
+		return nil, ErrWrongMasterKey
+	}
+	return open(k.key, wrapped, []byte(label+"\x00"+k.id))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the plaintext and prefixes the result with the nonce
+func seal(key, plaintext, additional []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func open(key, sealed, additional []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("the ciphertext is too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], additional)
+}
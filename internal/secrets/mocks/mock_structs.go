@@ -0,0 +1,25 @@
+package mocks
+
+import (
+	"fp-dynamic-elements-manager-controller/internal/secrets/structs"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockSecretStore struct {
+	mock.Mock
+}
+
+func (m *MockSecretStore) UpsertSecret(secret structs.Secret) error {
+	args := m.Called(secret)
+	return args.Error(0)
+}
+
+func (m *MockSecretStore) GetSecrets() ([]structs.Secret, error) {
+	args := m.Called()
+	return args.Get(0).([]structs.Secret), args.Error(1)
+}
+
+func (m *MockSecretStore) DeleteSecret(name string) error {
+	args := m.Called(name)
+	return args.Error(0)
+}
@@ -0,0 +1,310 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"fp-dynamic-elements-manager-controller/internal/db/persistence"
+	"fp-dynamic-elements-manager-controller/internal/docker/utils"
+	"fp-dynamic-elements-manager-controller/internal/secrets/structs"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxValueBytes is the largest value a secret can hold
+	MaxValueBytes = 4096
+	// minRedactLength is the shortest value redacted inside longer text, shorter values are only replaced where they
+	// are the whole value, otherwise every log line would be littered with redactions
+	minRedactLength = 4
+)
+
+var (
+	ErrSecretNotFound = errors.New("secret not found")
+	ErrInvalidName    = errors.New("a secret name must be 1 to 128 letters, digits, '.', '_' or '-'")
+	ErrInvalidValue   = fmt.Errorf("a secret value must be 1 to %d bytes", MaxValueBytes)
+)
+
+var (
+	validName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,128}$`)
+	// reference matches a reference to a secret in an env var or a config value, e.g. ${secret:ngfw-api-key}
+	reference = regexp.MustCompile(`\$\{secret:([A-Za-z0-9_.-]{1,128})\}`)
+)
+
+// Reference returns the reference to the secret which is resolved to its value
+func Reference(name string) string {
+	return "${secret:" + name + "}"
+}
+
+type known struct {
+	name  string
+	value string
+}
+
+// Service stores secrets and resolves references to them. The values are decrypted once when they are loaded or
+// stored and kept in memory, so references are resolved, and values redacted, without reading the DB
+type Service struct {
+	keyring *Keyring
+	repo    persistence.SecretStore
+
+	mu      sync.RWMutex
+	secrets map[string]structs.Secret
+	values  map[string]string
+	// known holds the values longest first, so a value is redacted before any shorter value inside it
+	known []known
+}
+
+// NewService returns a service which stores its secrets in repo, without a keyring secrets can neither be stored nor
+// resolved
+func NewService(keyring *Keyring, repo persistence.SecretStore) *Service {
+	return &Service{
+		keyring: keyring,
+		repo:    repo,
+		secrets: make(map[string]structs.Secret),
+		values:  make(map[string]string),
+	}
+}
+
+// Load decrypts every stored secret, it fails if a secret cannot be decrypted with the master key
+func (s *Service) Load() error {
+	stored, err := s.repo.GetSecrets()
+	if err != nil {
+		return err
+	}
+	if len(stored) > 0 && s.keyring == nil {
+		return fmt.Errorf("%d secrets are stored: %w", len(stored), ErrNoMasterKey)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, secret := range stored {
+		value, err := s.keyring.Open(secret.Name, secret.KeyID, secret.Ciphertext, secret.DataKey)
+		if err != nil {
+			return fmt.Errorf("secret %s could not be decrypted: %w", secret.Name, err)
+		}
+		s.secrets[secret.Name] = secret
+		s.values[secret.Name] = string(value)
+	}
+	s.index()
+	return nil
+}
+
+// index rebuilds the list of values used to conceal and redact them, the lock must be held
+func (s *Service) index() {
+	s.known = s.known[:0]
+	for name, value := range s.values {
+		s.known = append(s.known, known{name: name, value: value})
+	}
+	sort.Slice(s.known, func(i, j int) bool {
+		if len(s.known[i].value) != len(s.known[j].value) {
+			return len(s.known[i].value) > len(s.known[j].value)
+		}
+		return s.known[i].name < s.known[j].name
+	})
+}
+
+// List returns every secret sorted by name, without its value
+func (s *Service) List() []structs.Secret {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]structs.Secret, 0, len(s.secrets))
+	for _, secret := range s.secrets {
+		list = append(list, secret)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Put encrypts and stores the secret, replacing the value of an existing secret with the same name
+func (s *Service) Put(name, value, updatedBy string) (structs.Secret, error) {
+	if !validName.MatchString(name) {
+		return structs.Secret{}, ErrInvalidName
+	}
+	if len(value) == 0 || len(value) > MaxValueBytes {
+		return structs.Secret{}, ErrInvalidValue
+	}
+	if s.keyring == nil {
+		return structs.Secret{}, ErrNoMasterKey
+	}
+	ciphertext, dataKey, err := s.keyring.Seal(name, []byte(value))
+	if err != nil {
+		return structs.Secret{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	secret := structs.Secret{
+		Name:       name,
+		Ciphertext: ciphertext,
+		DataKey:    dataKey,
+		KeyID:      s.keyring.ID(),
+		UpdatedBy:  updatedBy,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if existing, ok := s.secrets[name]; ok {
+		secret.CreatedAt = existing.CreatedAt
+	}
+	if err := s.repo.UpsertSecret(secret); err != nil {
+		return structs.Secret{}, err
+	}
+	s.secrets[name] = secret
+	s.values[name] = value
+	s.index()
+	return secret, nil
+}
+
+// Delete deletes the secret, references to it no longer resolve
+func (s *Service) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.secrets[name]; !ok {
+		return ErrSecretNotFound
+	}
+	if err := s.repo.DeleteSecret(name); err != nil {
+		return err
+	}
+	delete(s.secrets, name)
+	delete(s.values, name)
+	s.index()
+	return nil
+}
+
+// Resolve replaces every reference in the value with the secret's value
+func (s *Service) Resolve(value string) (string, error) {
+	if !strings.Contains(value, "${secret:") {
+		return value, nil
+	}
+	if s.keyring == nil {
+		return "", ErrNoMasterKey
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var err error
+	resolved := reference.ReplaceAllStringFunc(value, func(ref string) string {
+		name := reference.FindStringSubmatch(ref)[1]
+		secret, ok := s.values[name]
+		if !ok && err == nil {
+			err = fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+		}
+		return secret
+	})
+	if err != nil {
+		return "", err
+	}
+	return resolved, nil
+}
+
+// ResolveEnv returns a copy of the NAME=value environment with every reference resolved
+func (s *Service) ResolveEnv(env []string) ([]string, error) {
+	resolved := make([]string, len(env))
+	for i, v := range env {
+		var err error
+		if resolved[i], err = s.Resolve(v); err != nil {
+			return nil, fmt.Errorf("env var %s: %w", strings.SplitN(v, "=", 2)[0], err)
+		}
+	}
+	return resolved, nil
+}
+
+// ResolveJSON resolves the references held in the string values of a JSON document, a document without references is
+// returned as it is
+func (s *Service) ResolveJSON(data []byte) ([]byte, error) {
+	if !bytes.Contains(data, []byte("${secret:")) {
+		return data, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+	resolved, err := walk(document, s.Resolve)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(resolved)
+}
+
+// Conceal replaces the value of every secret in the text with a reference to the secret, the reverse of Resolve
+func (s *Service) Conceal(text string) string {
+	return s.replace(text, Reference)
+}
+
+// ConcealJSON conceals the string values of a decoded JSON document
+func (s *Service) ConcealJSON(document interface{}) interface{} {
+	concealed, _ := walk(document, func(value string) (string, error) {
+		return s.Conceal(value), nil
+	})
+	return concealed
+}
+
+// Redact replaces the value of every secret in the text, it is used on anything written to a response or a log
+func (s *Service) Redact(text string) string {
+	return s.replace(text, func(string) string { return utils.Redacted })
+}
+
+// RedactEnv returns a copy of the NAME=value environment with the value of every secret redacted
+func (s *Service) RedactEnv(env []string) []string {
+	redacted := make([]string, len(env))
+	for i, v := range env {
+		if kv := strings.SplitN(v, "=", 2); len(kv) == 2 {
+			redacted[i] = kv[0] + "=" + s.Redact(kv[1])
+		} else {
+			redacted[i] = s.Redact(v)
+		}
+	}
+	return redacted
+}
+
+func (s *Service) replace(text string, with func(name string) string) string {
+	if text == "" {
+		return text
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, k := range s.known {
+		if text == k.value {
+			return with(k.name)
+		}
+		if len(k.value) >= minRedactLength && strings.Contains(text, k.value) {
+			text = strings.Replace(text, k.value, with(k.name), -1)
+		}
+	}
+	return text
+}
+
+// walk applies fn to every string value of a decoded JSON document
+func walk(document interface{}, fn func(string) (string, error)) (interface{}, error) {
+	switch v := document.(type) {
+	case string:
+		return fn(v)
+	case map[string]interface{}:
+		walked := make(map[string]interface{}, len(v))
+		for name, value := range v {
+			w, err := walk(value, fn)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			walked[name] = w
+		}
+		return walked, nil
+	case []interface{}:
+		walked := make([]interface{}, len(v))
+		for i, value := range v {
+			w, err := walk(value, fn)
+			if err != nil {
+				return nil, err
+			}
+			walked[i] = w
+		}
+		return walked, nil
+	}
+	return document, nil
+}
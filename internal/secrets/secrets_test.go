@@ -0,0 +1,166 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fp-dynamic-elements-manager-controller/internal/docker/utils"
+	"fp-dynamic-elements-manager-controller/internal/secrets/mocks"
+	"fp-dynamic-elements-manager-controller/internal/secrets/structs"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+type SecretsTestSuite struct {
+	suite.Suite
+	keyring *Keyring
+	repo    *mocks.MockSecretStore
+	service *Service
+	stored  []structs.Secret
+}
+
+func TestSecrets(t *testing.T) {
+	suite.Run(t, new(SecretsTestSuite))
+}
+
+func (s *SecretsTestSuite) SetupTest() {
+	var err error
+	s.keyring, err = NewKeyring(bytes.Repeat([]byte{7}, keySize))
+	s.Require().Nil(err)
+
+	s.stored = nil
+	s.repo = new(mocks.MockSecretStore)
+	s.repo.On("UpsertSecret", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		s.stored = append(s.stored, args.Get(0).(structs.Secret))
+	})
+	s.repo.On("DeleteSecret", mock.Anything).Return(nil)
+	s.service = NewService(s.keyring, s.repo)
+
+	_, err = s.service.Put("ngfw-api-key", "s3cr3t-api-key", "admin@example.com")
+	s.Require().Nil(err)
+	_, err = s.service.Put("pin", "42", "admin@example.com")
+	s.Require().Nil(err)
+}
+
+func (s *SecretsTestSuite) TestKeyring() {
+	ciphertext, dataKey, err := s.keyring.Seal("name", []byte("value"))
+	s.Require().Nil(err)
+	s.NotContains(string(ciphertext), "value")
+
+	value, err := s.keyring.Open("name", s.keyring.ID(), ciphertext, dataKey)
+	s.Nil(err)
+	s.Equal("value", string(value))
+
+	_, err = s.keyring.Open("other", s.keyring.ID(), ciphertext, dataKey)
+	s.NotNil(err, "the ciphertext belongs to another secret")
+
+	other, _ := NewKeyring(bytes.Repeat([]byte{8}, keySize))
+	_, err = other.Open("name", s.keyring.ID(), ciphertext, dataKey)
+	s.Equal(ErrWrongMasterKey, err)
+
+	_, err = NewKeyring([]byte("short"))
+	s.NotNil(err)
+}
+
+func (s *SecretsTestSuite) TestKeyringFromEnv() {
+	keyring, err := KeyringFromEnv()
+	s.Nil(keyring)
+	s.Nil(err)
+
+	os.Setenv("SECRETS_MASTER_KEY", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, keySize)))
+	keyring, err = KeyringFromEnv()
+	os.Unsetenv("SECRETS_MASTER_KEY")
+	s.Require().Nil(err)
+	s.Equal(s.keyring.ID(), keyring.ID())
+
+	file, err := ioutil.TempFile("", "master-key")
+	s.Require().Nil(err)
+	defer os.Remove(file.Name())
+	file.Write(bytes.Repeat([]byte{7}, keySize))
+	file.Close()
+
+	os.Setenv("SECRETS_MASTER_KEY_FILE", file.Name())
+	keyring, err = KeyringFromEnv()
+	os.Unsetenv("SECRETS_MASTER_KEY_FILE")
+	s.Require().Nil(err)
+	s.Equal(s.keyring.ID(), keyring.ID())
+}
+
+func (s *SecretsTestSuite) TestLoad() {
+	s.Require().Len(s.stored, 2)
+	s.Equal(s.keyring.ID(), s.stored[0].KeyID)
+	s.NotContains(string(s.stored[0].Ciphertext), "s3cr3t-api-key")
+
+	repo := new(mocks.MockSecretStore)
+	repo.On("GetSecrets").Return(s.stored, nil)
+	service := NewService(s.keyring, repo)
+	s.Nil(service.Load())
+	value, err := service.Resolve("${secret:ngfw-api-key}")
+	s.Nil(err)
+	s.Equal("s3cr3t-api-key", value)
+	s.Len(service.List(), 2)
+
+	other, _ := NewKeyring(bytes.Repeat([]byte{8}, keySize))
+	s.True(errors.Is(NewService(other, repo).Load(), ErrWrongMasterKey))
+	s.True(errors.Is(NewService(nil, repo).Load(), ErrNoMasterKey))
+}
+
+func (s *SecretsTestSuite) TestPut() {
+	_, err := s.service.Put("bad name", "value", "")
+	s.Equal(ErrInvalidName, err)
+	_, err = s.service.Put("empty", "", "")
+	s.Equal(ErrInvalidValue, err)
+	_, err = NewService(nil, s.repo).Put("name", "value", "")
+	s.Equal(ErrNoMasterKey, err)
+
+	first := s.service.List()[0]
+	updated, err := s.service.Put("ngfw-api-key", "rotated-api-key", "other@example.com")
+	s.Nil(err)
+	s.Equal(first.CreatedAt, updated.CreatedAt)
+	s.Equal("other@example.com", updated.UpdatedBy)
+
+	s.Nil(s.service.Delete("pin"))
+	s.Equal(ErrSecretNotFound, s.service.Delete("pin"))
+	_, err = s.service.Resolve("${secret:pin}")
+	s.True(errors.Is(err, ErrSecretNotFound))
+}
+
+func (s *SecretsTestSuite) TestResolve() {
+	env, err := s.service.ResolveEnv([]string{"API_KEY=${secret:ngfw-api-key}", "URL=https://user:${secret:pin}@ngfw", "PORT=443"})
+	s.Nil(err)
+	s.Equal([]string{"API_KEY=s3cr3t-api-key", "URL=https://user:42@ngfw", "PORT=443"}, env)
+
+	_, err = s.service.ResolveEnv([]string{"API_KEY=${secret:missing}"})
+	s.True(errors.Is(err, ErrSecretNotFound))
+	s.Contains(err.Error(), "API_KEY")
+
+	config, err := s.service.ResolveJSON([]byte(`{"api":{"key":"${secret:ngfw-api-key}","port":443},"pins":["${secret:pin}"]}`))
+	s.Nil(err)
+	s.JSONEq(`{"api":{"key":"s3cr3t-api-key","port":443},"pins":["42"]}`, string(config))
+
+	unchanged := []byte(`{"host": "ngfw"}`)
+	config, err = s.service.ResolveJSON(unchanged)
+	s.Nil(err)
+	s.Equal(unchanged, config, "a config without references is left as it is")
+
+	_, err = NewService(nil, s.repo).Resolve("${secret:pin}")
+	s.Equal(ErrNoMasterKey, err)
+}
+
+func (s *SecretsTestSuite) TestConcealAndRedact() {
+	s.Equal("${secret:ngfw-api-key}", s.service.Conceal("s3cr3t-api-key"))
+	s.Equal("${secret:pin}", s.service.Conceal("42"))
+	s.Equal("port 4242", s.service.Conceal("port 4242"), "a short value is only concealed where it is the whole value")
+
+	var document interface{}
+	json.Unmarshal([]byte(`{"api":{"key":"s3cr3t-api-key"},"hosts":["ngfw"]}`), &document)
+	concealed, _ := json.Marshal(s.service.ConcealJSON(document))
+	s.JSONEq(`{"api":{"key":"${secret:ngfw-api-key}"},"hosts":["ngfw"]}`, string(concealed))
+
+	s.Equal("login with "+utils.Redacted+" failed", s.service.Redact("login with s3cr3t-api-key failed"))
+	s.Equal([]string{"API_KEY=" + utils.Redacted, "PIN=" + utils.Redacted}, s.service.RedactEnv([]string{"API_KEY=s3cr3t-api-key", "PIN=42"}))
+}
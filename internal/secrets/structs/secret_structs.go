@@ -0,0 +1,19 @@
+package structs
+
+import "time"
+
+// Secret is a stored secret. The value is encrypted with a data key of its own and the data key is encrypted with the
+// master key, neither the value nor the keys are ever written to a response
+type Secret struct {
+	ID   int64  `json:"-" db:"id"`
+	Name string `json:"name" db:"name"`
+	// Ciphertext is the value encrypted with the data key, prefixed with its nonce
+	Ciphertext []byte `json:"-" db:"ciphertext"`
+	// DataKey is the data key encrypted with the master key, prefixed with its nonce
+	DataKey []byte `json:"-" db:"data_key"`
+	// KeyID identifies the master key the data key was encrypted with
+	KeyID     string    `json:"key_id" db:"key_id"`
+	UpdatedBy string    `json:"updated_by" db:"updated_by"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
@@ -0,0 +1,197 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/propagators"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const instrumentationName = "fp-dynamic-elements-manager-controller"
+
+type Config struct {
+	// Endpoint is the host:port of the OTLP collector, tracing is disabled when it is empty
+	Endpoint string
+	// Insecure sends spans to the collector without TLS
+	Insecure bool
+	// Headers are sent to the collector with every export, e.g. for authentication
+	Headers map[string]string
+	// SampleRatio is the fraction of traces started by the controller which are recorded, traces started by a
+	// module are recorded if the module sampled them
+	SampleRatio float64
+	// ServiceName identifies the controller in the collector
+	ServiceName string
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Headers:     map[string]string{},
+		SampleRatio: 1,
+		ServiceName: "dynamic-elements-manager-controller",
+	}
+}
+
+// ConfigFromEnv reads the OTEL_* settings and TRACE_SAMPLE_RATIO.
+// OTEL_EXPORTER_OTLP_HEADERS takes a comma separated list of key=value pairs, e.g. api-key=secret,tenant=soc
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+
+	cfg.Endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+
+	if b, err := strconv.ParseBool(os.Getenv("OTEL_EXPORTER_OTLP_INSECURE")); err == nil {
+		cfg.Insecure = b
+	}
+
+	for _, pair := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		cfg.Headers[kv[0]] = kv[1]
+	}
+
+	if f, err := strconv.ParseFloat(os.Getenv("TRACE_SAMPLE_RATIO"), 64); err == nil && f >= 0 && f <= 1 {
+		cfg.SampleRatio = f
+	}
+
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		cfg.ServiceName = name
+	}
+
+	return cfg
+}
+
+// Init sets up the global propagator and, if an endpoint is configured, the OTLP exporter. The returned func
+// flushes any buffered spans and must be called before the controller exits
+func Init(cfg Config) (func(context.Context) error, error) {
+	global.SetTextMapPropagator(otel.NewCompositeTextMapPropagator(propagators.TraceContext{}, propagators.Baggage{}))
+
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []otlp.ExporterOption{otlp.WithAddress(cfg.Endpoint), otlp.WithHeaders(cfg.Headers)}
+	if cfg.Insecure {
+		opts = append(opts, otlp.WithInsecure())
+	}
+
+	exporter, err := otlp.NewExporter(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := NewProvider(cfg, sdktrace.WithBatcher(exporter))
+	global.SetTracerProvider(provider)
+
+	return exporter.Shutdown, nil
+}
+
+// NewProvider returns a tracer provider which hands spans to the given processor, tests use this with a syncer
+// and an in memory exporter
+func NewProvider(cfg Config, processor sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		processor,
+		sdktrace.WithConfig(sdktrace.Config{
+			DefaultSampler: sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio)),
+		}),
+		sdktrace.WithResource(resource.New(semconv.ServiceNameKey.String(cfg.ServiceName))),
+	)
+}
+
+// Start starts a span as a child of any span in ctx
+func Start(ctx context.Context, name string, opts ...trace.SpanOption) (context.Context, trace.Span) {
+	return global.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// End marks the span as failed if err is not nil then ends it
+func End(ctx context.Context, span trace.Span, err error) {
+	Fail(ctx, span, err)
+	span.End()
+}
+
+// Fail records err on the span and marks it as failed, nil errors are ignored
+func Fail(ctx context.Context, span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(ctx, err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Detach returns a context holding the values of ctx, e.g. its span and request ID, which is never cancelled.
+// This is used for work which carries on after a request has been answered so that it stays in the request's trace
+// without being cancelled along with it
+func Detach(ctx context.Context) context.Context {
+	return detached{parent: ctx}
+}
+
+type detached struct {
+	parent context.Context
+}
+
+func (d detached) Deadline() (time.Time, bool)       { return time.Time{}, false }
+func (d detached) Done() <-chan struct{}             { return nil }
+func (d detached) Err() error                        { return nil }
+func (d detached) Value(key interface{}) interface{} { return d.parent.Value(key) }
+
+// Inject adds the trace context of ctx to the headers of an outgoing request
+func Inject(ctx context.Context, header http.Header) {
+	global.TextMapPropagator().Inject(ctx, header)
+}
+
+// Middleware starts a server span for each request, continuing the trace of the caller if it sent trace headers
+func Middleware(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := global.TextMapPropagator().Extract(r.Context(), r.Header)
+		ctx, span := Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest(instrumentationName, "", r)...),
+		)
+		defer span.End()
+		if id := applog.RequestID(ctx); id != "" {
+			span.SetAttributes(label.String("dem.request_id", id))
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		SetHTTPStatus(span, rec.status)
+	})
+}
+
+// SetHTTPStatus records the status code of a response on the span, server errors mark the span as failed
+func SetHTTPStatus(span trace.Span, code int) {
+	span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(code)...)
+	if code >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, fmt.Sprintf("%d %s", code, http.StatusText(code)))
+	}
+}
+
+// Module returns the attribute identifying the module a span relates to
+func Module(serviceName string) label.KeyValue {
+	return label.String("dem.module", serviceName)
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,120 @@
+package tracing
+
+import (
+	"context"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/export/trace/tracetest"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+type TracingTestSuite struct {
+	suite.Suite
+	exporter *tracetest.InMemoryExporter
+}
+
+func TestTracing(t *testing.T) {
+	suite.Run(t, new(TracingTestSuite))
+}
+
+func (m *TracingTestSuite) SetupSuite() {
+	_, err := Init(DefaultConfig())
+	m.Nil(err)
+	m.exporter = tracetest.NewInMemoryExporter()
+	global.SetTracerProvider(NewProvider(DefaultConfig(), sdktrace.WithSyncer(m.exporter)))
+}
+
+func (m *TracingTestSuite) SetupTest() {
+	m.exporter.Reset()
+}
+
+func (m *TracingTestSuite) TestMiddleware() {
+	var handlerSpan trace.SpanContext
+	handler := Middleware("queue.ingest", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanFromContext(r.Context()).SpanContext()
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/internal/queue", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := m.exporter.GetSpans()
+	m.Len(spans, 1)
+
+	m.T().Run("Test the caller's trace is continued", func(t *testing.T) {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext.TraceID.String())
+		assert.Equal(t, "00f067aa0ba902b7", spans[0].ParentSpanID.String())
+		assert.True(t, spans[0].HasRemoteParent)
+		assert.Equal(t, spans[0].SpanContext.SpanID, handlerSpan.SpanID)
+	})
+
+	m.T().Run("Test the response status is recorded", func(t *testing.T) {
+		assert.Equal(t, "queue.ingest", spans[0].Name)
+		assert.Equal(t, trace.SpanKindServer, spans[0].SpanKind)
+		assert.Equal(t, codes.Error, spans[0].StatusCode)
+	})
+}
+
+func (m *TracingTestSuite) TestDetachAndInject() {
+	ctx, cancel := context.WithCancel(applog.ContextWithRequestID(context.Background(), "req-1"))
+	ctx, span := Start(ctx, "parent")
+	detached := Detach(ctx)
+	cancel()
+	span.End()
+
+	m.Nil(detached.Err())
+	m.Equal("req-1", applog.RequestID(detached))
+
+	_, child := Start(detached, "child")
+	header := http.Header{}
+	Inject(trace.ContextWithSpan(context.Background(), child), header)
+	child.End()
+
+	spans := m.exporter.GetSpans()
+	m.Len(spans, 2)
+	m.Equal(spans[0].SpanContext.SpanID, spans[1].ParentSpanID)
+	m.Contains(header.Get("traceparent"), spans[1].SpanContext.SpanID.String())
+}
+
+func (m *TracingTestSuite) TestConfigFromEnv() {
+	os.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "collector:4317")
+	os.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "api-key=secret, tenant=soc")
+	os.Setenv("TRACE_SAMPLE_RATIO", "2")
+	defer os.Unsetenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	defer os.Unsetenv("OTEL_EXPORTER_OTLP_HEADERS")
+	defer os.Unsetenv("TRACE_SAMPLE_RATIO")
+
+	cfg := ConfigFromEnv()
+	m.Equal("collector:4317", cfg.Endpoint)
+	m.Equal(map[string]string{"api-key": "secret", "tenant": "soc"}, cfg.Headers)
+	m.Equal(float64(1), cfg.SampleRatio)
+}
+
+func (m *TracingTestSuite) TestSampling() {
+	cfg := DefaultConfig()
+	cfg.SampleRatio = 0
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := NewProvider(cfg, sdktrace.WithSyncer(exporter)).Tracer(instrumentationName)
+
+	_, span := tracer.Start(context.Background(), "dropped")
+	span.End()
+	m.Empty(exporter.GetSpans())
+
+	parent := trace.SpanContext{
+		TraceID:    trace.ID{1},
+		SpanID:     trace.SpanID{1},
+		TraceFlags: trace.FlagsSampled,
+	}
+	_, span = tracer.Start(trace.ContextWithRemoteSpanContext(context.Background(), parent), "kept")
+	span.End()
+	m.Len(exporter.GetSpans(), 1)
+}
@@ -4,10 +4,10 @@ import (
 	"errors"
 	"fp-dynamic-elements-manager-controller/internal/auth/structs"
 	"fp-dynamic-elements-manager-controller/internal/db/persistence"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
 	structs2 "fp-dynamic-elements-manager-controller/internal/logging/structs"
 	notificationfuncs "fp-dynamic-elements-manager-controller/internal/notification"
 	"fp-dynamic-elements-manager-controller/internal/util"
-	"github.com/rs/zerolog/log"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -60,7 +60,7 @@ func UpdateUserPassword(user structs.User, userRepo *persistence.UserRepo, logge
 	}
 	pass, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 	if err != nil {
-		log.Error().Err(err).Msg("Error encoding password for update bcrypt")
+		applog.System().Error(err, "Error encoding password for update bcrypt")
 		return err
 	}
 	dbUser, err := userRepo.GetByEmail(user.Email)
@@ -1,18 +1,29 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"fp-dynamic-elements-manager-controller/api"
 	"fp-dynamic-elements-manager-controller/internal/backup"
+	"fp-dynamic-elements-manager-controller/internal/catalog"
 	"fp-dynamic-elements-manager-controller/internal/config"
 	"fp-dynamic-elements-manager-controller/internal/db"
 	"fp-dynamic-elements-manager-controller/internal/db/migration"
 	"fp-dynamic-elements-manager-controller/internal/db/persistence"
 	docker2 "fp-dynamic-elements-manager-controller/internal/docker"
+	"fp-dynamic-elements-manager-controller/internal/health"
 	"fp-dynamic-elements-manager-controller/internal/logging"
+	"fp-dynamic-elements-manager-controller/internal/logging/applog"
 	"fp-dynamic-elements-manager-controller/internal/logging/structs"
+	"fp-dynamic-elements-manager-controller/internal/metrics"
+	"fp-dynamic-elements-manager-controller/internal/moduleconfig"
 	"fp-dynamic-elements-manager-controller/internal/notification"
+	"fp-dynamic-elements-manager-controller/internal/orchestrator"
 	"fp-dynamic-elements-manager-controller/internal/queue"
+	"fp-dynamic-elements-manager-controller/internal/routing"
+	"fp-dynamic-elements-manager-controller/internal/secrets"
+	"fp-dynamic-elements-manager-controller/internal/tracing"
 	"github.com/sirupsen/logrus"
 	"os"
 )
@@ -44,38 +55,175 @@ func main() {
 	// On subsequent runs it can alter tables if changes are defined in the .sql files in the /db folder
 	migration.NewDBMigrator(database.SqlDatabase.DB, logger).RunMigration()
 
+	// Set up tracing of ingestion, pushes and proxied module calls, spans are exported over OTLP when an endpoint is set
+	shutdownTracing, err := tracing.Init(tracing.ConfigFromEnv())
+	if err != nil {
+		logger.SystemLogger.Error(err, "error setting up tracing")
+	} else {
+		defer shutdownTracing(context.Background())
+	}
+
 	// Set up the DAO, this is a holder for pointers to each of the separate entity repositories
-	dao := persistence.NewDataAccessObject(database.SqlDatabase, logger)
+	dao := persistence.NewDataAccessObject(database.SqlDatabase, database.ImportDatabase, logger)
+
+	// Register the metrics which are read from the DB and the notification hub when /metrics is scraped
+	metrics.RegisterCollectors(database.SqlDatabase.DB, dao.ListElementRepo, notificationService.Hub())
 
 	// Set up the hook for logrus which watches the UserLogger for events above a certain threshold and
-	// writes them to the DB
-	logrus.AddHook(logging.NewDatabaseHook(dao.LogEntryRepo))
+	// writes them to the DB, entries are buffered and written in the background so logging never waits on the DB.
+	// Every entry is also published to the tail which streams live logs to the UI
+	retention := logging.RetentionConfigFromEnv()
+	logTail := logging.NewTail(logging.TailHistoryFromEnv())
+	logWriter := logging.NewLogWriter(dao.LogEntryRepo, retention.BufferSize, logTail)
+	logWriter.Start()
+	defer logWriter.Stop()
+	logrus.AddHook(logging.NewDatabaseHook(logWriter))
+
+	// Set up and start the job which archives and deletes log entries once they are past their retention limits
+	purger := logging.NewPurger(retention, dao.LogEntryRepo)
+	purger.Start()
+	defer purger.Stop()
 
 	// Set up the pushing mechanism which pushes list elements to all egress modules
 	pusher := queue.NewDataPusher(dao, logger)
 
-	// Set up the connection to the docker socket on the host machine using the Docker CLI
-	docker, err := docker2.NewDocker(
-		os.Getenv("DOCKER_USER"),
-		os.Getenv("DOCKER_PASSWORD"),
-		fmt.Sprintf("https://%s", os.Getenv("DOCKER_REGISTRY")),
-		logger,
+	// Set up the orchestrator which runs the module containers. With docker this is the connection to the docker socket
+	// on the host machine, docker is left nil with other orchestrators so the docker only features are turned off
+	orchestratorConfig := orchestrator.ConfigFromEnv()
+	var orch orchestrator.Orchestrator
+	var docker docker2.Dockers
+	if orchestratorConfig.Backend == orchestrator.Kubernetes {
+		orch, err = orchestrator.NewKubernetes(orchestratorConfig)
+		if err != nil {
+			logger.SystemLogger.Fatal(err, "error connecting to kubernetes")
+		}
+	} else {
+		dockerClient, err := docker2.NewDocker(
+			os.Getenv("DOCKER_USER"),
+			os.Getenv("DOCKER_PASSWORD"),
+			fmt.Sprintf("https://%s", os.Getenv("DOCKER_REGISTRY")),
+			logger,
+		)
+
+		if err != nil {
+			logger.SystemLogger.Error(err, "error creating new docker")
+		}
+		orch, docker = dockerClient, dockerClient
+	}
+
+	// Set up the secrets which env vars and module configs can reference, they are encrypted under the master key and
+	// loaded once the DB is ready. Their values are redacted from every log entry from here on
+	keyring, err := secrets.KeyringFromEnv()
+	if err != nil {
+		logger.SystemLogger.Fatal(err, "error loading the secrets master key")
+	}
+	secretService := secrets.NewService(keyring, dao.SecretRepo)
+	applog.SetRedactor(secretService)
+
+	// Set up the store of module configs, configs posted to a module through its proxy route are versioned and sent to
+	// the module again when it registers
+	configStoreConfig := moduleconfig.ConfigFromEnv()
+	if err := configStoreConfig.Validate(); err != nil {
+		logger.SystemLogger.Fatal(err, "error in the module config store config")
+	}
+	configStore := moduleconfig.NewStore(configStoreConfig, dao.ModuleConfigRepo, notificationService, secretService)
+
+	// Set up the table of reverse proxy routes for registered modules, routes can be added and removed at runtime. The
+	// identity of the user is signed on every request with a key of the module's own
+	proxyConfig := routing.ProxyConfigFromEnv()
+	if err := proxyConfig.Validate(); err != nil {
+		logger.SystemLogger.Fatal(err, "error in the module proxy config")
+	}
+	moduleRouter := routing.NewModuleRouter(
+		api.AuthPathPrefix,
+		api.IngressPathPrefix,
+		configStore.Intercept(routing.NewProxyFactory(proxyConfig)),
 	)
 
+	// Set up the verifier which pins module images to their digest and checks their signatures against the trusted keys
+	verifier, err := docker2.NewImageVerifier(docker2.VerifyConfigFromEnv())
 	if err != nil {
-		logger.SystemLogger.Error(err, "error creating new docker")
+		logger.SystemLogger.Fatal(err, "error loading the trusted image keys")
 	}
+	// On Kubernetes the digests are read from the registry, without one every image would be refused
+	if orchestratorConfig.Backend == orchestrator.Kubernetes && verifier.Enabled() && orchestratorConfig.RegistryURL == "" {
+		logger.SystemLogger.Fatal(errors.New("DOCKER_REGISTRY is not set"), "image verification on kubernetes needs the module registry")
+	}
+
+	// Set up the upgrader which recreates module containers from a new image and rolls them back if they are not healthy
+	upgrader := docker2.NewUpgrader(
+		docker2.UpgradeConfigFromEnv(),
+		docker,
+		dao.ModuleMetadataRepo,
+		dao.ModuleUpgradeRepo,
+		nil,
+		verifier,
+	)
 
-	// Set up the handler for incoming docker commands from the client
-	handler := docker2.NewCommandHandler(docker, dao.ModuleMetadataRepo)
+	// Set up the catalog of the modules available in the registry, it is read when first asked for then cached
+	moduleCatalog := catalog.NewCatalog(catalog.ConfigFromEnv())
 
-	// Set up the Backup/Restore provider
+	// Set up the handler for incoming docker commands from the client, created modules are limited by the default
+	// runtime profile unless they override it
+	handler := docker2.NewCommandHandler(
+		orch,
+		dao.ModuleMetadataRepo,
+		moduleRouter,
+		dao.DockerJobRepo,
+		notificationService,
+		upgrader,
+		verifier,
+		docker2.RuntimeProfileFromEnv(),
+		secretService,
+	)
+
+	// Set up the Backup/Restore provider, tables are dumped over the DB connection and aes-gcm backups are encrypted
+	// with data keys wrapped by the secrets master key
+	backupConfig := backup.ConfigFromEnv()
+	if err := backupConfig.Validate(keyring); err != nil {
+		logger.SystemLogger.Fatal(err, "error in the backup config")
+	}
+	targetConfigs, err := backup.TargetsFromEnv()
+	if err != nil {
+		logger.SystemLogger.Fatal(err, "error reading the backup targets")
+	}
+	targets, err := backup.NewTargets(targetConfigs, secretService.Resolve)
+	if err != nil {
+		logger.SystemLogger.Fatal(err, "error in the backup targets")
+	}
 	provider := backup.NewDatabaseBackupProvider(
-		docker,
+		backupConfig,
+		orch,
+		orchestratorConfig.DatabaseService,
 		backup.NewGitController(logger),
+		keyring,
 		logger,
-		dao.ListElementRepo)
+		dao.DatabaseInfoRepo,
+		targets)
+
+	// Set up the background health monitor, this is started once the DB is ready and caches the health of each module
+	monitor := health.NewMonitor(
+		health.MonitorConfigFromEnv(),
+		dao.ModuleMetadataRepo,
+		dao.ModuleHealthRepo,
+		notificationService,
+		nil,
+	)
+
+	// Set up the background sampler of module container stats, this is started once the DB is ready
+	sampler := docker2.NewStatsSampler(
+		docker2.StatsConfigFromEnv(),
+		docker,
+		dao.ModuleMetadataRepo,
+		notificationService,
+	)
 
-	// Set up and start our server
-	api.NewServer(logger, dbReadyChan, dao, pusher, handler, provider).StartServer()
+	// Set up and start our server, on a shutdown the deferred stops above flush the buffered log entries
+	err = api.NewServer(logger, dbReadyChan, dao, pusher, handler, upgrader, moduleCatalog, provider, moduleRouter, monitor, sampler, logWriter, logTail, configStore, secretService).StartServer()
+	if err != nil {
+		// Fatal exits without running the deferred stops, so the log entries are flushed first
+		purger.Stop()
+		logWriter.Stop()
+		logger.SystemLogger.Fatal(err, "error running server")
+	}
 }